@@ -312,18 +312,56 @@ func openBucket(ctx context.Context, pipeline pipeline.Pipeline, accountName Acc
 	}, nil
 }
 
+// errNotImplemented is returned for unsupported features, like preconditions.
+var errNotImplemented = errors.New("not implemented")
+
 // Close implements driver.Close.
 func (b *bucket) Close() error {
 	return nil
 }
 
+// escapeMetadata returns the Azure metadata for md.
+func escapeMetadata(md map[string]string) (azblob.Metadata, error) {
+	amd := make(azblob.Metadata, len(md))
+	for k, v := range md {
+		// See the package comments for more details on escaping of metadata
+		// keys & values.
+		e := escape.HexEscape(k, func(runes []rune, i int) bool {
+			c := runes[i]
+			switch {
+			case i == 0 && c >= '0' && c <= '9':
+				return true
+			case escape.IsASCIIAlphanumeric(c):
+				return false
+			case c == '_':
+				return false
+			}
+			return true
+		})
+		if _, ok := amd[e]; ok {
+			return nil, fmt.Errorf("duplicate keys after escaping: %q => %q", k, e)
+		}
+		amd[e] = escape.URLEscape(v)
+	}
+	return amd, nil
+}
+
 // Copy implements driver.Copy.
 func (b *bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.CopyOptions) error {
+	if opts.IfMatch != "" || opts.IfNoneMatch != "" {
+		return errNotImplemented
+	}
 	dstKey = escapeKey(dstKey, false)
 	dstBlobURL := b.containerURL.NewBlobURL(dstKey)
 	srcKey = escapeKey(srcKey, false)
 	srcURL := b.containerURL.NewBlobURL(srcKey).URL()
 	md := azblob.Metadata{}
+	if opts.Attributes != nil {
+		var err error
+		if md, err = escapeMetadata(opts.Attributes.Metadata); err != nil {
+			return err
+		}
+	}
 	mac := azblob.ModifiedAccessConditions{}
 	bac := azblob.BlobAccessConditions{}
 	if opts.BeforeCopy != nil {
@@ -368,11 +406,56 @@ func (b *bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.C
 	if copyStatus != azblob.CopyStatusSuccess {
 		return fmt.Errorf("Copy failed with status: %s", copyStatus)
 	}
+	if opts.Attributes != nil {
+		// The copy has the metadata from opts.Attributes, but the headers of
+		// the source blob.
+		return setHTTPHeaders(ctx, dstBlobURL, opts.Attributes)
+	}
 	return nil
 }
 
+// SetAttributes implements driver.AttributesSetter.
+func (b *bucket) SetAttributes(ctx context.Context, key string, attrs *driver.WritableAttributes) error {
+	md, err := escapeMetadata(attrs.Metadata)
+	if err != nil {
+		return err
+	}
+	key = escapeKey(key, false)
+	blobURL := b.containerURL.NewBlobURL(key)
+	if err := setHTTPHeaders(ctx, blobURL, attrs); err != nil {
+		return err
+	}
+	_, err = blobURL.SetMetadata(ctx, md, azblob.BlobAccessConditions{})
+	return err
+}
+
+// setHTTPHeaders sets the headers of the blob at blobURL from attrs. The
+// blob's Content-MD5 is kept; Azure would remove it otherwise.
+func setHTTPHeaders(ctx context.Context, blobURL azblob.BlobURL, attrs *driver.WritableAttributes) error {
+	props, err := blobURL.GetProperties(ctx, azblob.BlobAccessConditions{})
+	if err != nil {
+		return err
+	}
+	h := azblob.BlobHTTPHeaders{
+		CacheControl:       attrs.CacheControl,
+		ContentDisposition: attrs.ContentDisposition,
+		ContentEncoding:    attrs.ContentEncoding,
+		ContentLanguage:    attrs.ContentLanguage,
+		ContentMD5:         props.ContentMD5(),
+		ContentType:        attrs.ContentType,
+	}
+	// Fail rather than attach the MD5 to different content if the blob
+	// changed since it was read.
+	ac := azblob.BlobAccessConditions{ModifiedAccessConditions: azblob.ModifiedAccessConditions{IfMatch: props.ETag()}}
+	_, err = blobURL.SetHTTPHeaders(ctx, h, ac)
+	return err
+}
+
 // Delete implements driver.Delete.
-func (b *bucket) Delete(ctx context.Context, key string) error {
+func (b *bucket) Delete(ctx context.Context, key string, opts *driver.DeleteOptions) error {
+	if opts.IfMatch != "" || opts.IfNoneMatch != "" {
+		return errNotImplemented
+	}
 	key = escapeKey(key, false)
 	blockBlobURL := b.containerURL.NewBlockBlobURL(key)
 	_, err := blockBlobURL.Delete(ctx, azblob.DeleteSnapshotsOptionInclude, azblob.BlobAccessConditions{})
@@ -406,6 +489,9 @@ func (r *reader) As(i interface{}) bool {
 
 // NewRangeReader implements driver.NewRangeReader.
 func (b *bucket) NewRangeReader(ctx context.Context, key string, offset, length int64, opts *driver.ReaderOptions) (driver.Reader, error) {
+	if opts.IfMatch != "" || opts.IfNoneMatch != "" {
+		return nil, errNotImplemented
+	}
 	key = escapeKey(key, false)
 	blockBlobURL := b.containerURL.NewBlockBlobURL(key)
 
@@ -422,6 +508,8 @@ func (b *bucket) NewRangeReader(ctx context.Context, key string, offset, length
 		ContentType: blobDownloadResponse.ContentType(),
 		Size:        getSize(blobDownloadResponse.ContentLength(), blobDownloadResponse.ContentRange()),
 		ModTime:     blobDownloadResponse.LastModified(),
+
+		ContentEncoding: blobDownloadResponse.ContentEncoding(),
 	}
 	var body io.ReadCloser
 	if length == 0 {
@@ -476,6 +564,9 @@ func (b *bucket) ErrorAs(err error, i interface{}) bool {
 }
 
 func (b *bucket) ErrorCode(err error) gcerrors.ErrorCode {
+	if err == errNotImplemented {
+		return gcerrors.Unimplemented
+	}
 	serr, ok := err.(azblob.StorageError)
 	switch {
 	case !ok:
@@ -512,6 +603,7 @@ func (b *bucket) Attributes(ctx context.Context, key string) (*driver.Attributes
 		ContentType:        blobPropertiesResponse.ContentType(),
 		Size:               blobPropertiesResponse.ContentLength(),
 		MD5:                blobPropertiesResponse.ContentMD5(),
+		ETag:               string(blobPropertiesResponse.ETag()),
 		ModTime:            blobPropertiesResponse.LastModified(),
 		Metadata:           md,
 		AsFunc: func(i interface{}) bool {
@@ -584,6 +676,7 @@ func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driv
 			ModTime: blobInfo.Properties.LastModified,
 			Size:    *blobInfo.Properties.ContentLength,
 			MD5:     blobInfo.Properties.ContentMD5,
+			ETag:    string(blobInfo.Properties.Etag),
 			IsDir:   false,
 			AsFunc: func(i interface{}) bool {
 				p, ok := i.(*azblob.BlobItem)
@@ -614,6 +707,22 @@ func (b *bucket) SignedURL(ctx context.Context, key string, opts *driver.SignedU
 	if b.opts.Credential == nil {
 		return "", errors.New("to use SignedURL, you must call OpenBucket with a non-nil Options.Credential")
 	}
+	// Azure can't restrict the Content-Type of uploads.
+	if opts.ContentType != "" {
+		return "", errNotImplemented
+	}
+	var perms azblob.BlobSASPermissions
+	switch opts.Method {
+	case http.MethodGet:
+		perms.Read = true
+	case http.MethodPut:
+		perms.Create = true
+		perms.Write = true
+	case http.MethodDelete:
+		perms.Delete = true
+	default:
+		return "", fmt.Errorf("unsupported Method %q", opts.Method)
+	}
 	key = escapeKey(key, false)
 	blockBlobURL := b.containerURL.NewBlockBlobURL(key)
 	srcBlobParts := azblob.NewBlobURLParts(blockBlobURL.URL())
@@ -624,7 +733,7 @@ func (b *bucket) SignedURL(ctx context.Context, key string, opts *driver.SignedU
 		ExpiryTime:    time.Now().UTC().Add(opts.Expiry),
 		ContainerName: b.name,
 		BlobName:      srcBlobParts.BlobName,
-		Permissions:   azblob.BlobSASPermissions{Read: true}.String(),
+		Permissions:   perms.String(),
 	}.NewSASQueryParameters(b.opts.Credential)
 	if err != nil {
 		return "", err
@@ -674,36 +783,25 @@ func unescapeKey(key string) string {
 
 // NewTypedWriter implements driver.NewTypedWriter.
 func (b *bucket) NewTypedWriter(ctx context.Context, key string, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
+	if opts.IfMatch != "" || opts.IfNoneMatch != "" {
+		return nil, errNotImplemented
+	}
 	key = escapeKey(key, false)
 	blockBlobURL := b.containerURL.NewBlockBlobURL(key)
 	if opts.BufferSize == 0 {
 		opts.BufferSize = defaultUploadBlockSize
 	}
+	if opts.MaxConcurrency == 0 {
+		opts.MaxConcurrency = defaultUploadBuffers
+	}
 
-	md := make(map[string]string, len(opts.Metadata))
-	for k, v := range opts.Metadata {
-		// See the package comments for more details on escaping of metadata
-		// keys & values.
-		e := escape.HexEscape(k, func(runes []rune, i int) bool {
-			c := runes[i]
-			switch {
-			case i == 0 && c >= '0' && c <= '9':
-				return true
-			case escape.IsASCIIAlphanumeric(c):
-				return false
-			case c == '_':
-				return false
-			}
-			return true
-		})
-		if _, ok := md[e]; ok {
-			return nil, fmt.Errorf("duplicate keys after escaping: %q => %q", k, e)
-		}
-		md[e] = escape.URLEscape(v)
+	md, err := escapeMetadata(opts.Metadata)
+	if err != nil {
+		return nil, err
 	}
 	uploadOpts := &azblob.UploadStreamToBlockBlobOptions{
 		BufferSize: opts.BufferSize,
-		MaxBuffers: defaultUploadBuffers,
+		MaxBuffers: opts.MaxConcurrency,
 		Metadata:   md,
 		BlobHTTPHeaders: azblob.BlobHTTPHeaders{
 			CacheControl:       opts.CacheControl,
@@ -63,9 +63,16 @@
 //  - Attributes
 //  - Copy
 //  - Delete
+//  - DeleteMany
+//  - DeletePrefix
+//  - DeleteVersion
+//  - ListVersions
+//  - Move
 //  - NewRangeReader, from creation until the call to Close. (NewReader and ReadAll
 //    are included because they call NewRangeReader.)
 //  - NewWriter, from creation until the call to Close.
+//  - SetAttributes
+//  - Watch
 // All trace and metric names begin with the package import path.
 // The traces add the method name.
 // For example, "gocloud.dev/blob/Attributes".
@@ -89,13 +96,14 @@ import (
 	"context"
 	"crypto/md5"
 	"fmt"
-	"hash"
 	"io"
 	"io/ioutil"
 	"log"
 	"mime"
 	"net/http"
 	"net/url"
+	"path"
+	"regexp"
 	"runtime"
 	"strings"
 	"sync"
@@ -106,6 +114,7 @@ import (
 	"go.opencensus.io/stats/view"
 	"go.opencensus.io/tag"
 	"gocloud.dev/blob/driver"
+	"gocloud.dev/blob/internal/provider"
 	"gocloud.dev/gcerrors"
 	"gocloud.dev/internal/gcerr"
 	"gocloud.dev/internal/oc"
@@ -114,26 +123,95 @@ import (
 
 // Reader reads bytes from a blob.
 // It implements io.ReadCloser, and must be closed after
-// reads are finished.
+// reads are finished. Unless it is decompressing the blob, it also
+// implements io.Seeker and io.ReaderAt.
 type Reader struct {
 	b        driver.Bucket
 	r        driver.Reader
-	end      func(error) // called at Close to finish trace and metric collection
-	provider string      // for metric collection
+	dr       *decompressor // if not nil, reads decompressed content from r
+	end      func(error)   // called at Close to finish trace and metric collection
+	provider string        // for metric collection
 	closed   bool
+
+	// If verify is not nil, the whole blob is being read, and its content is
+	// checked against verify.want when r reaches io.EOF.
+	verify *checksum
+	nread  int64
+
+	// These fields are used by Seek and ReadAt to read other parts of the
+	// blob. Offsets are in the blob, and the Reader's range is [base, limit).
+	open  func(offset, length int64) (driver.Reader, error)
+	base  int64
+	limit int64
+	off   int64 // offset of the next byte returned by Read
+	roff  int64 // offset of the next byte returned by r
+	ra    readAhead
+
+	// progress and limiter implement ReaderOptions.Progress and
+	// ReaderOptions.RateLimiter. limitCtx is used to wait for limiter.
+	progress func(int64)
+	limiter  *RateLimiter
+	limitCtx context.Context
 }
 
 // Read implements io.Reader (https://golang.org/pkg/io/#Reader).
-func (r *Reader) Read(p []byte) (int, error) {
+func (r *Reader) Read(p []byte) (n int, err error) {
+	if r.progress != nil {
+		defer func() {
+			if n > 0 {
+				r.progress(int64(n))
+			}
+		}()
+	}
+	if r.dr != nil {
+		n, err := r.dr.Read(p)
+		return n, wrapCompressionError(err)
+	}
+	if r.off != r.roff {
+		// Seek was called.
+		if err := r.reposition(); err != nil {
+			return 0, err
+		}
+	}
+	n, err = r.read(p)
+	r.off += int64(n)
+	r.roff += int64(n)
+	return n, err
+}
+
+// read reads from the driver reader.
+func (r *Reader) read(p []byte) (int, error) {
+	if r.limiter != nil && len(p) > r.limiter.chunk {
+		p = p[:r.limiter.chunk]
+	}
 	n, err := r.r.Read(p)
 	stats.RecordWithTags(context.Background(), []tag.Mutator{tag.Upsert(oc.ProviderKey, r.provider)},
 		bytesReadMeasure.M(int64(n)))
+	if r.limiter != nil {
+		if werr := r.limiter.wait(r.limitCtx, n); werr != nil && (err == nil || err == io.EOF) {
+			return n, werr
+		}
+	}
+	if r.verify != nil {
+		r.verify.h.Write(p[:n])
+		r.nread += int64(n)
+		// Only verify the content if it has the expected size; some
+		// providers return fewer bytes than Size for transformed content.
+		if err == io.EOF && r.nread == r.Size() {
+			if ok, sum := r.verify.matches(); !ok {
+				return n, gcerr.Newf(gcerr.DataLoss, nil, "blob: the %s of the content read (%X) does not match the blob's (%X)", r.verify.name, sum, r.verify.want)
+			}
+		}
+	}
 	return n, wrapError(r.b, err)
 }
 
 // Close implements io.Closer (https://golang.org/pkg/io/#Closer).
 func (r *Reader) Close() error {
 	r.closed = true
+	if r.dr != nil {
+		_ = r.dr.Close()
+	}
 	err := wrapError(r.b, r.r.Close())
 	r.end(err)
 	return err
@@ -150,10 +228,25 @@ func (r *Reader) ModTime() time.Time {
 }
 
 // Size returns the size of the blob content in bytes.
+// If the Reader is decompressing the blob, Size returns the size of the
+// stored, compressed content; see ReaderOptions.Decompress.
 func (r *Reader) Size() int64 {
 	return r.r.Attributes().Size
 }
 
+// ContentEncoding returns the encoding of the blob content as returned by the
+// provider, if any. If the Reader is decompressing the blob, ContentEncoding
+// returns the encoding that was removed; see ReaderOptions.Decompress.
+func (r *Reader) ContentEncoding() string {
+	return r.r.Attributes().ContentEncoding
+}
+
+// ETag returns the ETag of the blob being read, or "" if the provider
+// doesn't report it when reading.
+func (r *Reader) ETag() string {
+	return r.r.Attributes().ETag
+}
+
 // As converts i to provider-specific types.
 // See https://godoc.org/gocloud.dev#hdr-As for background information, the "As"
 // examples in this package for examples, and the provider-specific package
@@ -194,6 +287,20 @@ type Attributes struct {
 	Size int64
 	// MD5 is an MD5 hash of the blob contents or nil if not available.
 	MD5 []byte
+	// CRC32C is the CRC32C (Castagnoli) checksum of the blob contents, in
+	// big-endian byte order, or nil if not available.
+	CRC32C []byte
+	// SHA256 is a SHA-256 hash of the blob contents or nil if not available.
+	SHA256 []byte
+	// ETag is an opaque identifier for the current contents of the blob, or
+	// "" if not available. It changes whenever the blob is overwritten, and
+	// can be passed as IfMatch or IfNoneMatch in ReaderOptions, WriterOptions,
+	// CopyOptions and DeleteOptions for optimistic concurrency control.
+	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/ETag
+	ETag string
+	// ExpiresAt is the time at which the blob expires, as set by
+	// WriterOptions.ExpiresAt, or zero if it doesn't expire.
+	ExpiresAt time.Time
 
 	asFunc func(interface{}) bool
 }
@@ -216,13 +323,28 @@ func (a *Attributes) As(i interface{}) bool {
 type Writer struct {
 	b          driver.Bucket
 	w          driver.Writer
-	end        func(error) // called at Close to finish trace and metric collection
-	cancel     func()      // cancels the ctx provided to NewTypedWriter if contentMD5 verification fails
-	contentMD5 []byte
-	md5hash    hash.Hash
-	provider   string // for metric collection
+	end        func(error)    // called at Close to finish trace and metric collection
+	cancel     func()         // cancels the ctx provided to NewTypedWriter if content verification fails
+	contents   []*checksum    // checksums of the data written, from the ContentXXX options
+	compressor Compressor     // if not nil, data is compressed using zw
+	zw         io.WriteCloser // created along with w
+	provider   string         // for metric collection
 	closed     bool
 
+	// If stored is not nil, it computes the checksums of the data written to
+	// w, which are compared against those reported by the provider at Close
+	// (see WriterOptions.VerifyChecksums). storedCtx and storedKey are used
+	// to retrieve the blob's attributes.
+	stored    *checksums
+	storedCtx context.Context
+	storedKey string
+
+	// progress and limiter implement WriterOptions.Progress and
+	// WriterOptions.RateLimiter. limitCtx is used to wait for limiter.
+	progress func(int64)
+	limiter  *RateLimiter
+	limitCtx context.Context
+
 	// These fields exist only when w is not yet created.
 	//
 	// A ctx is stored in the Writer since we need to pass it into NewTypedWriter
@@ -245,8 +367,15 @@ const sniffLen = 512
 // even if the actual write eventually fails. The write is only guaranteed to
 // have succeeded if Close returns no error.
 func (w *Writer) Write(p []byte) (n int, err error) {
-	if len(w.contentMD5) > 0 {
-		if _, err := w.md5hash.Write(p); err != nil {
+	if w.progress != nil {
+		defer func() {
+			if n > 0 {
+				w.progress(int64(n))
+			}
+		}()
+	}
+	for _, c := range w.contents {
+		if _, err := c.h.Write(p); err != nil {
 			return 0, err
 		}
 	}
@@ -266,7 +395,10 @@ func (w *Writer) Write(p []byte) (n int, err error) {
 	// w.buf is at least 512 bytes.
 	w.buf.Write(p)
 	if w.buf.Len() >= sniffLen {
-		return w.open(w.buf.Bytes())
+		// Report the bytes of p, not of the buffer, as written.
+		if _, err := w.open(w.buf.Bytes()); err != nil {
+			return 0, err
+		}
 	}
 	return len(p), nil
 }
@@ -278,29 +410,47 @@ func (w *Writer) Write(p []byte) (n int, err error) {
 func (w *Writer) Close() (err error) {
 	w.closed = true
 	defer func() { w.end(err) }()
-	if len(w.contentMD5) > 0 {
-		// Verify the MD5 hash of what was written matches the ContentMD5 provided
-		// by the user.
-		md5sum := w.md5hash.Sum(nil)
-		if !bytes.Equal(md5sum, w.contentMD5) {
+	for _, c := range w.contents {
+		// Verify the hash of what was written matches the one provided by the
+		// user.
+		if ok, sum := c.matches(); !ok {
 			// No match! Return an error, but first cancel the context and call the
 			// driver's Close function to ensure the write is aborted.
 			w.cancel()
 			if w.w != nil {
 				_ = w.w.Close()
 			}
-			return gcerr.Newf(gcerr.FailedPrecondition, nil, "blob: the WriterOptions.ContentMD5 you specified (%X) did not match what was written (%X)", w.contentMD5, md5sum)
+			return gcerr.Newf(gcerr.FailedPrecondition, nil, "blob: the WriterOptions.Content%s you specified (%X) did not match what was written (%X)", c.name, c.want, sum)
 		}
 	}
 
 	defer w.cancel()
-	if w.w != nil {
-		return wrapError(w.b, w.w.Close())
+	if w.w == nil {
+		if _, err := w.open(w.buf.Bytes()); err != nil {
+			return err
+		}
 	}
-	if _, err := w.open(w.buf.Bytes()); err != nil {
-		return err
+	if w.zw != nil {
+		// Flush the compressed data to the driver Writer.
+		if err := w.zw.Close(); err != nil {
+			w.cancel()
+			_ = w.w.Close()
+			return wrapCompressionError(err)
+		}
+	}
+	if err := w.w.Close(); err != nil {
+		return wrapError(w.b, err)
+	}
+	if w.stored != nil {
+		// The driver doesn't report the ETag of the blob it wrote, so this
+		// may see a concurrent overwrite; see WriterOptions.VerifyChecksums.
+		a, err := w.b.Attributes(w.storedCtx, w.storedKey)
+		if err != nil {
+			return wrapError(w.b, err)
+		}
+		return w.stored.verify(a)
 	}
-	return wrapError(w.b, w.w.Close())
+	return nil
 }
 
 // open tries to detect the MIME type of p and write it to the blob.
@@ -311,6 +461,9 @@ func (w *Writer) open(p []byte) (int, error) {
 	if w.w, err = w.b.NewTypedWriter(w.ctx, w.key, ct, w.opts); err != nil {
 		return 0, wrapError(w.b, err)
 	}
+	if err := w.startCompression(); err != nil {
+		return 0, err
+	}
 	w.buf = nil
 	w.ctx = nil
 	w.key = ""
@@ -318,8 +471,43 @@ func (w *Writer) open(p []byte) (int, error) {
 	return w.write(p)
 }
 
+// startCompression creates zw once w is created, if compressing.
+// The error it returns is wrapped.
+func (w *Writer) startCompression() error {
+	if w.compressor == nil {
+		return nil
+	}
+	zw, err := w.compressor.NewWriter(writerFunc(w.writeDriver))
+	if err != nil {
+		return wrapCompressionError(err)
+	}
+	w.zw = zw
+	return nil
+}
+
 func (w *Writer) write(p []byte) (int, error) {
+	if w.zw != nil {
+		n, err := w.zw.Write(p)
+		return n, wrapCompressionError(err)
+	}
+	return w.writeDriver(p)
+}
+
+// writeDriver writes to the driver Writer, at the rate allowed by w.limiter
+// if set.
+func (w *Writer) writeDriver(p []byte) (int, error) {
+	if w.limiter != nil {
+		return w.limiter.write(w.limitCtx, p, w.writeChunk)
+	}
+	return w.writeChunk(p)
+}
+
+// writeChunk writes p to the driver Writer.
+func (w *Writer) writeChunk(p []byte) (int, error) {
 	n, err := w.w.Write(p)
+	if w.stored != nil {
+		w.stored.Write(p[:n])
+	}
 	stats.RecordWithTags(context.Background(), []tag.Mutator{tag.Upsert(oc.ProviderKey, w.provider)},
 		bytesWrittenMeasure.M(int64(n)))
 	return n, wrapError(w.b, err)
@@ -345,6 +533,33 @@ type ListOptions struct {
 	// in a "directory" are returned as a single result.
 	Delimiter string
 
+	// StartAfter, if not empty, indicates that only results with a key that
+	// sorts after StartAfter should be returned; for example, the key of the
+	// last result of a previous listing, to resume it. With a Delimiter, a
+	// "directory" is only returned if its key, including the trailing
+	// Delimiter, sorts after StartAfter.
+	StartAfter string
+
+	// Match, if not empty, is a pattern that the keys of the returned blobs
+	// must match, using the syntax of path.Match; for example,
+	// "logs/2019-10-16/*.parquet". "*" doesn't match "/". It doesn't apply
+	// to "directories".
+	Match string
+	// MatchRegexp, if not nil, is a regular expression that must match
+	// the keys of the returned blobs. It doesn't apply to "directories".
+	MatchRegexp *regexp.Regexp
+
+	// MinModTime and MaxModTime, if not zero, indicate that only blobs
+	// modified at or after MinModTime, and before MaxModTime, should be
+	// returned. They don't apply to "directories".
+	MinModTime time.Time
+	MaxModTime time.Time
+	// MinSize and MaxSize indicate that only blobs of at least MinSize
+	// bytes, and, if MaxSize is positive, of at most MaxSize bytes, should
+	// be returned. They don't apply to "directories".
+	MinSize int64
+	MaxSize int64
+
 	// BeforeList is a callback that will be called before each call to the
 	// the underlying provider's list functionality.
 	// asFunc converts its argument to provider-specific types.
@@ -358,25 +573,25 @@ type ListIterator struct {
 	opts    *driver.ListOptions
 	page    *driver.ListPage
 	nextIdx int
+	// err is set if opts is invalid.
+	err error
 }
 
 // Next returns a *ListObject for the next blob. It returns (nil, io.EOF) if
 // there are no more.
 func (i *ListIterator) Next(ctx context.Context) (*ListObject, error) {
+	if i.err != nil {
+		return nil, i.err
+	}
 	if i.page != nil {
 		// We've already got a page of results.
-		if i.nextIdx < len(i.page.Objects) {
-			// Next object is in the page; return it.
+		for i.nextIdx < len(i.page.Objects) {
 			dobj := i.page.Objects[i.nextIdx]
 			i.nextIdx++
-			return &ListObject{
-				Key:     dobj.Key,
-				ModTime: dobj.ModTime,
-				Size:    dobj.Size,
-				MD5:     dobj.MD5,
-				IsDir:   dobj.IsDir,
-				asFunc:  dobj.AsFunc,
-			}, nil
+			// The driver may not have applied the filters.
+			if i.opts.Includes(dobj) {
+				return newListObject(dobj), nil
+			}
 		}
 		if len(i.page.NextPageToken) == 0 {
 			// Done with current page, and there are no more; return io.EOF.
@@ -400,6 +615,21 @@ func (i *ListIterator) Next(ctx context.Context) (*ListObject, error) {
 	return i.Next(ctx)
 }
 
+// newListObject converts a driver.ListObject to a ListObject.
+func newListObject(dobj *driver.ListObject) *ListObject {
+	return &ListObject{
+		Key:     dobj.Key,
+		ModTime: dobj.ModTime,
+		Size:    dobj.Size,
+		MD5:     dobj.MD5,
+		CRC32C:  dobj.CRC32C,
+		SHA256:  dobj.SHA256,
+		ETag:    dobj.ETag,
+		IsDir:   dobj.IsDir,
+		asFunc:  dobj.AsFunc,
+	}
+}
+
 // ListObject represents a single blob returned from List.
 type ListObject struct {
 	// Key is the key for this blob.
@@ -410,6 +640,13 @@ type ListObject struct {
 	Size int64
 	// MD5 is an MD5 hash of the blob contents or nil if not available.
 	MD5 []byte
+	// CRC32C and SHA256 are checksums of the blob contents or nil if not
+	// available. See Attributes.CRC32C and Attributes.SHA256.
+	CRC32C []byte
+	SHA256 []byte
+	// ETag is an opaque identifier for the current contents of the blob, or
+	// "" if not available. See Attributes.ETag.
+	ETag string
 	// IsDir indicates that this result represents a "directory" in the
 	// hierarchical namespace, ending in ListOptions.Delimiter. Key can be
 	// passed as ListOptions.Prefix to list items in the "directory".
@@ -474,6 +711,10 @@ var (
 		})
 )
 
+func init() {
+	provider.Name = func(b interface{}) string { return b.(*Bucket).tracer.Provider }
+}
+
 // NewBucket is intended for use by provider implementations.
 var NewBucket = newBucket
 
@@ -535,15 +776,101 @@ func (b *Bucket) ReadAll(ctx context.Context, key string) (_ []byte, err error)
 // List is not guaranteed to include all recently-written blobs;
 // some providers are only eventually consistent.
 func (b *Bucket) List(opts *ListOptions) *ListIterator {
+	dopts, err := newDriverListOptions(opts)
+	return &ListIterator{b: b, opts: dopts, err: err}
+}
+
+// newDriverListOptions converts opts to driver.ListOptions. If there is a
+// Match pattern and no Delimiter, the Prefix is extended with the pattern's
+// literal prefix, so that the provider doesn't list blobs that can't match.
+func newDriverListOptions(opts *ListOptions) (*driver.ListOptions, error) {
 	if opts == nil {
 		opts = &ListOptions{}
 	}
 	dopts := &driver.ListOptions{
-		Prefix:     opts.Prefix,
-		Delimiter:  opts.Delimiter,
-		BeforeList: opts.BeforeList,
+		Prefix:      opts.Prefix,
+		Delimiter:   opts.Delimiter,
+		StartAfter:  opts.StartAfter,
+		Match:       opts.Match,
+		MatchRegexp: opts.MatchRegexp,
+		MinModTime:  opts.MinModTime,
+		MaxModTime:  opts.MaxModTime,
+		MinSize:     opts.MinSize,
+		MaxSize:     opts.MaxSize,
+		BeforeList:  opts.BeforeList,
+	}
+	if opts.Match != "" {
+		if _, err := path.Match(opts.Match, ""); err != nil {
+			return nil, gcerr.Newf(gcerr.InvalidArgument, err, "blob: ListOptions.Match is not a valid pattern: %q", opts.Match)
+		}
+		// With a Delimiter, a longer Prefix would change which keys are
+		// collapsed into "directories".
+		if opts.Delimiter == "" {
+			lit := opts.Match
+			if i := strings.IndexAny(lit, `*?[\`); i >= 0 {
+				lit = lit[:i]
+			}
+			if len(lit) > len(dopts.Prefix) && strings.HasPrefix(lit, dopts.Prefix) {
+				dopts.Prefix = lit
+			}
+		}
 	}
-	return &ListIterator{b: b, opts: dopts}
+	return dopts, nil
+}
+
+// FirstPageToken is the pageToken to pass to ListPage to retrieve the first
+// page of results.
+var FirstPageToken = []byte("first page")
+
+// ListPage returns a page of ListObject results for blobs in a bucket, in
+// lexicographical order by key, along with a token for retrieving the next
+// page.
+//
+// Use FirstPageToken as pageToken to retrieve the first page; use the
+// returned nextPageToken to retrieve subsequent pages. nextPageToken is
+// empty when there are no more pages. pageSize is the maximum number of
+// results to return; if it is 0 or less, the provider implementation will
+// choose a reasonable default.
+//
+// ListPage is useful for paging through results across requests, for
+// example in a web server. Use List to simply iterate over all results.
+//
+// If opts has filters that the provider doesn't support, they are applied
+// to the page after it's listed, so a page may have fewer than pageSize
+// results, or none, even if nextPageToken isn't empty.
+func (b *Bucket) ListPage(ctx context.Context, pageToken []byte, pageSize int, opts *ListOptions) (retval []*ListObject, nextPageToken []byte, err error) {
+	if len(pageToken) == 0 {
+		return nil, nil, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: ListPage pageToken must not be empty; use FirstPageToken for the first page")
+	}
+	if bytes.Equal(pageToken, FirstPageToken) {
+		pageToken = nil
+	}
+	if pageSize < 0 {
+		pageSize = 0
+	}
+	dopts, err := newDriverListOptions(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	dopts.PageToken = pageToken
+	dopts.PageSize = pageSize
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return nil, nil, errClosed
+	}
+	p, err := b.b.ListPaged(ctx, dopts)
+	if err != nil {
+		return nil, nil, wrapError(b.b, err)
+	}
+	objs := make([]*ListObject, 0, len(p.Objects))
+	for _, dobj := range p.Objects {
+		// The driver may not have applied the filters.
+		if dopts.Includes(dobj) {
+			objs = append(objs, newListObject(dobj))
+		}
+	}
+	return objs, p.NextPageToken, nil
 }
 
 // Exists returns true if a blob exists at key, false if it does not exist, or
@@ -602,13 +929,17 @@ func (b *Bucket) Attributes(ctx context.Context, key string) (_ *Attributes, err
 		ModTime:            a.ModTime,
 		Size:               a.Size,
 		MD5:                a.MD5,
+		CRC32C:             a.CRC32C,
+		SHA256:             a.SHA256,
+		ETag:               a.ETag,
+		ExpiresAt:          expiresAt(a),
 		asFunc:             a.AsFunc,
 	}, nil
 }
 
 // NewReader is a shortcut for NewRangedReader with offset=0 and length=-1.
 func (b *Bucket) NewReader(ctx context.Context, key string, opts *ReaderOptions) (*Reader, error) {
-	return b.newRangeReader(ctx, key, 0, -1, opts)
+	return b.newRangeReader(ctx, key, "", 0, -1, opts)
 }
 
 // NewRangeReader returns a Reader to read content from the blob stored at key.
@@ -619,14 +950,19 @@ func (b *Bucket) NewReader(ctx context.Context, key string, opts *ReaderOptions)
 // gcerrors.Code will return gcerrors.NotFound. Exists is a lighter-weight way
 // to check for existence.
 //
+// If a precondition in opts is not met, NewRangeReader returns an error for
+// which gcerrors.Code will return gcerrors.FailedPrecondition.
+//
 // A nil ReaderOptions is treated the same as the zero value.
 //
 // The caller must call Close on the returned Reader when done reading.
 func (b *Bucket) NewRangeReader(ctx context.Context, key string, offset, length int64, opts *ReaderOptions) (_ *Reader, err error) {
-	return b.newRangeReader(ctx, key, offset, length, opts)
+	return b.newRangeReader(ctx, key, "", offset, length, opts)
 }
 
-func (b *Bucket) newRangeReader(ctx context.Context, key string, offset, length int64, opts *ReaderOptions) (_ *Reader, err error) {
+// newRangeReader creates a Reader for key. If versionID is not empty, it reads
+// that version of the blob using driver.Versioner.
+func (b *Bucket) newRangeReader(ctx context.Context, key, versionID string, offset, length int64, opts *ReaderOptions) (_ *Reader, err error) {
 	b.mu.RLock()
 	defer b.mu.RUnlock()
 	if b.closed {
@@ -641,7 +977,10 @@ func (b *Bucket) newRangeReader(ctx context.Context, key string, offset, length
 	if opts == nil {
 		opts = &ReaderOptions{}
 	}
-	dopts := &driver.ReaderOptions{}
+	dopts := &driver.ReaderOptions{
+		IfMatch:     opts.IfMatch,
+		IfNoneMatch: opts.IfNoneMatch,
+	}
 	tctx := b.tracer.Start(ctx, "NewRangeReader")
 	defer func() {
 		// If err == nil, we handed the end closure off to the returned *Writer; it
@@ -650,12 +989,62 @@ func (b *Bucket) newRangeReader(ctx context.Context, key string, offset, length
 			b.tracer.End(tctx, err)
 		}
 	}()
-	dr, err := b.b.NewRangeReader(ctx, key, offset, length, dopts)
+	open := func(offset, length int64) (driver.Reader, error) {
+		if versionID == "" {
+			return b.b.NewRangeReader(ctx, key, offset, length, dopts)
+		}
+		if v, ok := b.b.(driver.Versioner); ok {
+			return v.NewVersionRangeReader(ctx, key, versionID, offset, length, dopts)
+		}
+		return nil, errVersioningNotSupported
+	}
+	dr, err := open(offset, length)
 	if err != nil {
 		return nil, wrapError(b.b, err)
 	}
-	end := func(err error) { b.tracer.End(tctx, err) }
-	r := &Reader{b: b.b, r: dr, end: end, provider: b.tracer.Provider}
+	if etag := dr.Attributes().ETag; etag != "" && versionID == "" {
+		// Later range reads must read the same version of the blob, or fail.
+		dopts = &driver.ReaderOptions{IfMatch: etag}
+	}
+	r := &Reader{b: b.b, r: dr, provider: b.tracer.Provider, open: open, base: offset, off: offset, roff: offset}
+	r.progress = opts.Progress
+	r.limiter = opts.RateLimiter
+	r.limitCtx = ctx
+	r.limit = dr.Attributes().Size
+	if length >= 0 && offset+length < r.limit {
+		r.limit = offset + length
+	}
+	if r.limit < offset {
+		r.limit = offset
+	}
+	var c Compressor
+	if opts.Decompress && length != 0 {
+		c = compressorFor(dr.Attributes().ContentEncoding)
+	}
+	if c != nil {
+		if offset != 0 || length > 0 {
+			// The range applies to the decompressed content, so we need to
+			// read the compressed content from the start.
+			_ = dr.Close()
+			if r.r, err = open(0, -1); err != nil {
+				return nil, wrapError(b.b, err)
+			}
+		}
+		zr, err := c.NewReader(readerFunc(r.read))
+		if err != nil {
+			_ = r.r.Close()
+			return nil, wrapCompressionError(err)
+		}
+		r.dr = &decompressor{zr: zr, skip: offset, remaining: length}
+		if offset != 0 || length > 0 {
+			// The whole blob is read.
+			offset, length = 0, -1
+		}
+	}
+	if a := r.r.Attributes(); offset == 0 && (length < 0 || length >= a.Size) {
+		r.verify = readChecksum(a)
+	}
+	r.end = func(err error) { b.tracer.End(tctx, err) }
 	_, file, lineno, ok := runtime.Caller(2)
 	runtime.SetFinalizer(r, func(r *Reader) {
 		if !r.closed {
@@ -721,32 +1110,49 @@ func (b *Bucket) NewWriter(ctx context.Context, key string, opts *WriterOptions)
 		ContentEncoding:    opts.ContentEncoding,
 		ContentLanguage:    opts.ContentLanguage,
 		ContentMD5:         opts.ContentMD5,
+		ContentCRC32C:      opts.ContentCRC32C,
+		ContentSHA256:      opts.ContentSHA256,
 		BufferSize:         opts.BufferSize,
+		MaxConcurrency:     opts.MaxConcurrency,
+		IfMatch:            opts.IfMatch,
+		IfNoneMatch:        opts.IfNoneMatch,
 		BeforeWrite:        opts.BeforeWrite,
 	}
+	var compressor Compressor
+	if opts.Compression != "" {
+		if compressor = compressorFor(opts.Compression); compressor == nil {
+			return nil, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: WriterOptions.Compression %q has no registered Compressor", opts.Compression)
+		}
+		if opts.ContentEncoding != "" && !strings.EqualFold(opts.ContentEncoding, opts.Compression) {
+			return nil, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: WriterOptions.ContentEncoding %q conflicts with Compression %q", opts.ContentEncoding, opts.Compression)
+		}
+		dopts.ContentEncoding = opts.Compression
+		// The ContentXXX checksums are for the uncompressed data, so the
+		// provider can't verify them; Writer does so instead.
+		dopts.ContentMD5 = nil
+		dopts.ContentCRC32C = nil
+		dopts.ContentSHA256 = nil
+	}
 	if len(opts.Metadata) > 0 {
-		// Providers are inconsistent, but at least some treat keys
-		// as case-insensitive. To make the behavior consistent, we
-		// force-lowercase them when writing and reading.
-		md := make(map[string]string, len(opts.Metadata))
-		for k, v := range opts.Metadata {
-			if k == "" {
-				return nil, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: WriterOptions.Metadata keys may not be empty strings")
-			}
-			if !utf8.ValidString(k) {
-				return nil, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: WriterOptions.Metadata keys must be valid UTF-8 strings: %q", k)
-			}
-			if !utf8.ValidString(v) {
-				return nil, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: WriterOptions.Metadata values must be valid UTF-8 strings: %q", v)
-			}
-			lowerK := strings.ToLower(k)
-			if _, found := md[lowerK]; found {
-				return nil, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: WriterOptions.Metadata has a duplicate case-insensitive metadata key: %q", lowerK)
-			}
-			md[lowerK] = v
+		md, err := lowercaseMetadata(opts.Metadata, "WriterOptions.Metadata")
+		if err != nil {
+			return nil, err
 		}
 		dopts.Metadata = md
 	}
+	if !opts.ExpiresAt.IsZero() {
+		// Only Expirers get the expiration time: a driver wrapping another
+		// bucket could pass it on to one that enforces it, and the expired
+		// blob would then be hidden from PurgeExpired.
+		if _, ok := b.b.(driver.Expirer); ok {
+			dopts.ExpiresAt = opts.ExpiresAt
+		} else {
+			if dopts.Metadata == nil {
+				dopts.Metadata = map[string]string{}
+			}
+			dopts.Metadata[ExpiresAtMetadataKey] = opts.ExpiresAt.UTC().Format(time.RFC3339Nano)
+		}
+	}
 	b.mu.RLock()
 	defer b.mu.RUnlock()
 	if b.closed {
@@ -768,9 +1174,28 @@ func (b *Bucket) NewWriter(ctx context.Context, key string, opts *WriterOptions)
 		key:        key,
 		opts:       dopts,
 		buf:        bytes.NewBuffer([]byte{}),
-		contentMD5: opts.ContentMD5,
-		md5hash:    md5.New(),
+		compressor: compressor,
 		provider:   b.tracer.Provider,
+		progress:   opts.Progress,
+		limiter:    opts.RateLimiter,
+		limitCtx:   ctx,
+	}
+	for _, c := range []struct {
+		name string
+		want []byte
+	}{
+		{"MD5", opts.ContentMD5},
+		{"CRC32C", opts.ContentCRC32C},
+		{"SHA256", opts.ContentSHA256},
+	} {
+		if len(c.want) > 0 {
+			w.contents = append(w.contents, newChecksum(c.name, c.want))
+		}
+	}
+	if opts.VerifyChecksums {
+		w.stored = newChecksums()
+		w.storedCtx = ctx
+		w.storedKey = key
 	}
 	if opts.ContentType != "" {
 		t, p, err := mime.ParseMediaType(opts.ContentType)
@@ -785,6 +1210,11 @@ func (b *Bucket) NewWriter(ctx context.Context, key string, opts *WriterOptions)
 			return nil, wrapError(b.b, err)
 		}
 		w.w = dw
+		if err := w.startCompression(); err != nil {
+			cancel()
+			_ = dw.Close()
+			return nil, err
+		}
 	} else {
 		// Save the fields needed to called NewTypedWriter later, once we've gotten
 		// sniffLen bytes.
@@ -806,6 +1236,32 @@ func (b *Bucket) NewWriter(ctx context.Context, key string, opts *WriterOptions)
 	return w, nil
 }
 
+// lowercaseMetadata validates md, which is described by field in errors, and
+// returns a copy with lowercased keys.
+func lowercaseMetadata(md map[string]string, field string) (map[string]string, error) {
+	// Providers are inconsistent, but at least some treat keys
+	// as case-insensitive. To make the behavior consistent, we
+	// force-lowercase them when writing and reading.
+	lower := make(map[string]string, len(md))
+	for k, v := range md {
+		if k == "" {
+			return nil, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: %s keys may not be empty strings", field)
+		}
+		if !utf8.ValidString(k) {
+			return nil, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: %s keys must be valid UTF-8 strings: %q", field, k)
+		}
+		if !utf8.ValidString(v) {
+			return nil, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: %s values must be valid UTF-8 strings: %q", field, v)
+		}
+		lowerK := strings.ToLower(k)
+		if _, found := lower[lowerK]; found {
+			return nil, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: %s has a duplicate case-insensitive metadata key: %q", field, lowerK)
+		}
+		lower[lowerK] = v
+	}
+	return lower, nil
+}
+
 // Copy the blob stored at srcKey to dstKey.
 // A nil CopyOptions is treated the same as the zero value.
 //
@@ -813,6 +1269,9 @@ func (b *Bucket) NewWriter(ctx context.Context, key string, opts *WriterOptions)
 // gcerrors.Code will return gcerrors.NotFound.
 //
 // If the destination blob already exists, it is overwritten.
+//
+// If a precondition in opts is not met, Copy returns an error for which
+// gcerrors.Code will return gcerrors.FailedPrecondition.
 func (b *Bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *CopyOptions) (err error) {
 	if !utf8.ValidString(srcKey) {
 		return gcerr.Newf(gcerr.InvalidArgument, nil, "blob: Copy srcKey must be a valid UTF-8 string: %q", srcKey)
@@ -824,7 +1283,9 @@ func (b *Bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *CopyOpti
 		opts = &CopyOptions{}
 	}
 	dopts := &driver.CopyOptions{
-		BeforeCopy: opts.BeforeCopy,
+		IfMatch:     opts.IfMatch,
+		IfNoneMatch: opts.IfNoneMatch,
+		BeforeCopy:  opts.BeforeCopy,
 	}
 	b.mu.RLock()
 	defer b.mu.RUnlock()
@@ -833,17 +1294,77 @@ func (b *Bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *CopyOpti
 	}
 	ctx = b.tracer.Start(ctx, "Copy")
 	defer func() { b.tracer.End(ctx, err) }()
+	if opts.Attributes != nil {
+		a, err := b.b.Attributes(ctx, srcKey)
+		if err != nil {
+			return wrapError(b.b, err)
+		}
+		if dopts.Attributes, err = opts.Attributes.apply(a); err != nil {
+			return err
+		}
+	}
 	return wrapError(b.b, b.b.Copy(ctx, dstKey, srcKey, dopts))
 }
 
-// Delete deletes the blob stored at key.
+// SetAttributes changes the attributes of the blob stored at key, as
+// described by update, without changing its content.
+//
+// Providers that can't change the attributes of a blob in place copy the
+// blob to itself with the new attributes instead. The copy is done by the
+// provider, but it changes the blob's ModTime and ETag, and for some
+// providers its cost depends on the size of the blob.
+//
+// If the blob does not exist, SetAttributes returns an error for which
+// gcerrors.Code will return gcerrors.NotFound.
+func (b *Bucket) SetAttributes(ctx context.Context, key string, update AttributesUpdate) (err error) {
+	if !utf8.ValidString(key) {
+		return gcerr.Newf(gcerr.InvalidArgument, nil, "blob: SetAttributes key must be a valid UTF-8 string: %q", key)
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return errClosed
+	}
+	ctx = b.tracer.Start(ctx, "SetAttributes")
+	defer func() { b.tracer.End(ctx, err) }()
+	a, err := b.b.Attributes(ctx, key)
+	if err != nil {
+		return wrapError(b.b, err)
+	}
+	attrs, err := update.apply(a)
+	if err != nil {
+		return err
+	}
+	if s, ok := b.b.(driver.AttributesSetter); ok {
+		return wrapError(b.b, s.SetAttributes(ctx, key, attrs))
+	}
+	return wrapError(b.b, b.b.Copy(ctx, key, key, &driver.CopyOptions{Attributes: attrs}))
+}
+
+// Delete is a shortcut for DeleteWithOptions with nil DeleteOptions.
+func (b *Bucket) Delete(ctx context.Context, key string) error {
+	return b.DeleteWithOptions(ctx, key, nil)
+}
+
+// DeleteWithOptions deletes the blob stored at key.
+// A nil DeleteOptions is treated the same as the zero value.
 //
-// If the blob does not exist, Delete returns an error for which
+// If the blob does not exist, DeleteWithOptions returns an error for which
 // gcerrors.Code will return gcerrors.NotFound.
-func (b *Bucket) Delete(ctx context.Context, key string) (err error) {
+//
+// If a precondition in opts is not met, DeleteWithOptions returns an error
+// for which gcerrors.Code will return gcerrors.FailedPrecondition.
+func (b *Bucket) DeleteWithOptions(ctx context.Context, key string, opts *DeleteOptions) (err error) {
 	if !utf8.ValidString(key) {
 		return gcerr.Newf(gcerr.InvalidArgument, nil, "blob: Delete key must be a valid UTF-8 string: %q", key)
 	}
+	if opts == nil {
+		opts = &DeleteOptions{}
+	}
+	dopts := &driver.DeleteOptions{
+		IfMatch:     opts.IfMatch,
+		IfNoneMatch: opts.IfNoneMatch,
+	}
 	b.mu.RLock()
 	defer b.mu.RUnlock()
 	if b.closed {
@@ -851,11 +1372,125 @@ func (b *Bucket) Delete(ctx context.Context, key string) (err error) {
 	}
 	ctx = b.tracer.Start(ctx, "Delete")
 	defer func() { b.tracer.End(ctx, err) }()
-	return wrapError(b.b, b.b.Delete(ctx, key))
+	return wrapError(b.b, b.b.Delete(ctx, key, dopts))
+}
+
+// Version describes a single version of a blob.
+type Version struct {
+	// ID identifies the version, for use with NewVersionReader and
+	// DeleteVersion. It is opaque and provider-specific.
+	ID string
+	// ModTime is the time this version was written.
+	ModTime time.Time
+	// Size is the size of this version in bytes.
+	Size int64
+	// MD5 is an MD5 hash of the version contents or nil if not available.
+	MD5 []byte
+	// ETag is the ETag of the version, or "" if not available.
+	ETag string
+	// IsLatest is true for the current version of the blob; that is, the one
+	// read by NewReader.
+	IsLatest bool
+}
+
+// ListVersions returns the versions of the blob stored at key, newest first.
+// Versions of deleted blobs may still be listed if the provider retains
+// them. If there are no versions, ListVersions returns an empty slice.
+//
+// If the provider implementation does not support versioning, ListVersions
+// returns an error for which gcerrors.Code will return gcerrors.Unimplemented.
+func (b *Bucket) ListVersions(ctx context.Context, key string) (_ []*Version, err error) {
+	if !utf8.ValidString(key) {
+		return nil, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: ListVersions key must be a valid UTF-8 string: %q", key)
+	}
+	v, ok := b.b.(driver.Versioner)
+	if !ok {
+		return nil, errVersioningNotSupported
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return nil, errClosed
+	}
+	ctx = b.tracer.Start(ctx, "ListVersions")
+	defer func() { b.tracer.End(ctx, err) }()
+
+	dvs, err := v.ListVersions(ctx, key)
+	if err != nil {
+		return nil, wrapError(b.b, err)
+	}
+	vs := make([]*Version, len(dvs))
+	for i, dv := range dvs {
+		vs[i] = &Version{
+			ID:       dv.VersionID,
+			ModTime:  dv.ModTime,
+			Size:     dv.Size,
+			MD5:      dv.MD5,
+			ETag:     dv.ETag,
+			IsLatest: dv.IsLatest,
+		}
+	}
+	return vs, nil
+}
+
+// NewVersionReader is a shortcut for NewVersionRangeReader with offset=0 and
+// length=-1.
+func (b *Bucket) NewVersionReader(ctx context.Context, key, versionID string, opts *ReaderOptions) (*Reader, error) {
+	return b.newRangeReader(ctx, key, versionID, 0, -1, opts)
+}
+
+// NewVersionRangeReader is like NewRangeReader, but reads the version of the
+// blob identified by versionID, as returned by ListVersions.
+//
+// If the version does not exist, NewVersionRangeReader returns an error for
+// which gcerrors.Code will return gcerrors.NotFound.
+//
+// If the provider implementation does not support versioning,
+// NewVersionRangeReader returns an error for which gcerrors.Code will return
+// gcerrors.Unimplemented.
+//
+// The caller must call Close on the returned Reader when done reading.
+func (b *Bucket) NewVersionRangeReader(ctx context.Context, key, versionID string, offset, length int64, opts *ReaderOptions) (*Reader, error) {
+	if versionID == "" {
+		return nil, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: NewVersionRangeReader versionID must not be empty")
+	}
+	return b.newRangeReader(ctx, key, versionID, offset, length, opts)
 }
 
-// SignedURL returns a URL that can be used to GET the blob for the duration
-// specified in opts.Expiry.
+// DeleteVersion permanently deletes a single version of the blob stored at
+// key. If it is the latest version, the next newest version, if any, becomes
+// the latest.
+//
+// If the version does not exist, DeleteVersion returns an error for which
+// gcerrors.Code will return gcerrors.NotFound.
+//
+// If the provider implementation does not support versioning, DeleteVersion
+// returns an error for which gcerrors.Code will return gcerrors.Unimplemented.
+func (b *Bucket) DeleteVersion(ctx context.Context, key, versionID string) (err error) {
+	if !utf8.ValidString(key) {
+		return gcerr.Newf(gcerr.InvalidArgument, nil, "blob: DeleteVersion key must be a valid UTF-8 string: %q", key)
+	}
+	if versionID == "" {
+		return gcerr.Newf(gcerr.InvalidArgument, nil, "blob: DeleteVersion versionID must not be empty")
+	}
+	v, ok := b.b.(driver.Versioner)
+	if !ok {
+		return errVersioningNotSupported
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return errClosed
+	}
+	ctx = b.tracer.Start(ctx, "DeleteVersion")
+	defer func() { b.tracer.End(ctx, err) }()
+	return wrapError(b.b, v.DeleteVersion(ctx, key, versionID))
+}
+
+// SignedURL returns a URL that can be used to access the blob for the
+// duration specified in opts.Expiry, using the HTTP method in opts.Method.
+// By default, the URL can be used to GET the blob; with Method "PUT" it can be
+// used to upload the blob, and with "DELETE" to delete it.
 //
 // A nil SignedURLOptions is treated the same as the zero value.
 //
@@ -876,8 +1511,21 @@ func (b *Bucket) SignedURL(ctx context.Context, key string, opts *SignedURLOptio
 	if opts.Expiry == 0 {
 		opts.Expiry = DefaultSignedURLExpiry
 	}
+	method := opts.Method
+	switch method {
+	case "":
+		method = http.MethodGet
+	case http.MethodGet, http.MethodPut, http.MethodDelete:
+	default:
+		return "", gcerr.Newf(gcerr.InvalidArgument, nil, "blob: SignedURLOptions.Method must be one of GET, PUT or DELETE (%q)", opts.Method)
+	}
+	if opts.ContentType != "" && method != http.MethodPut {
+		return "", gcerr.Newf(gcerr.InvalidArgument, nil, "blob: SignedURLOptions.ContentType must be empty for %s URLs", method)
+	}
 	dopts := driver.SignedURLOptions{
-		Expiry: opts.Expiry,
+		Expiry:      opts.Expiry,
+		Method:      method,
+		ContentType: opts.ContentType,
 	}
 	b.mu.RLock()
 	defer b.mu.RUnlock()
@@ -908,11 +1556,61 @@ type SignedURLOptions struct {
 	// Expiry sets how long the returned URL is valid for.
 	// Defaults to DefaultSignedURLExpiry.
 	Expiry time.Duration
+
+	// Method is the HTTP method that can be used with the URL; one of "GET",
+	// "PUT" or "DELETE". Defaults to "GET".
+	Method string
+
+	// ContentType is the Content-Type that a PUT request using the URL must
+	// have. It may only be set if Method is "PUT". If empty, the Content-Type
+	// of the request is not restricted.
+	//
+	// Some provider implementations can't restrict the Content-Type; for them,
+	// setting ContentType results in an error with code
+	// gcerrors.Unimplemented.
+	ContentType string
 }
 
 // ReaderOptions sets options for NewReader and NewRangedReader.
-// It is provided for future extensibility.
-type ReaderOptions struct{}
+type ReaderOptions struct {
+	// IfMatch, if not empty, makes the read fail with gcerrors.FailedPrecondition
+	// unless the blob's current ETag matches it. "*" matches any ETag.
+	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/If-Match
+	//
+	// Some provider implementations do not support preconditions; for them,
+	// setting IfMatch results in an error with code gcerrors.Unimplemented.
+	IfMatch string
+
+	// IfNoneMatch, if not empty, makes the read fail with
+	// gcerrors.FailedPrecondition if the blob's current ETag matches it.
+	// "*" matches any ETag.
+	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/If-None-Match
+	//
+	// Some provider implementations do not support preconditions; for them,
+	// setting IfNoneMatch results in an error with code gcerrors.Unimplemented.
+	IfNoneMatch string
+
+	// Decompress, if true, makes the Reader decompress the blob's content if
+	// it is returned with a content encoding that has a registered Compressor,
+	// like blobs written with WriterOptions.Compression. The offset and length
+	// passed to NewRangeReader then apply to the decompressed content, but
+	// the whole blob is read from the provider. Reader.Size and
+	// Reader.ContentEncoding still describe the stored content.
+	//
+	// Some providers decompress content with a "gzip" encoding themselves
+	// when it is read; Decompress has no effect for such blobs.
+	Decompress bool
+
+	// Progress, if not nil, is called after each call to Read that returns
+	// data, with the number of bytes returned. It is called synchronously,
+	// so it should return quickly. Reads done by ReadAt aren't reported.
+	Progress func(n int64)
+
+	// RateLimiter, if not nil, limits the rate at which the Reader reads the
+	// blob from the provider. It may be shared with other Readers and
+	// Writers to limit their combined rate.
+	RateLimiter *RateLimiter
+}
 
 // WriterOptions sets options for NewWriter.
 type WriterOptions struct {
@@ -928,6 +1626,14 @@ type WriterOptions struct {
 	// smaller BufferSize may reduce memory usage.
 	BufferSize int
 
+	// MaxConcurrency changes the default maximum number of chunks that Writer
+	// will upload concurrently.
+	//
+	// This option may be ignored by some provider implementations.
+	//
+	// If 0, the provider implementation will choose a reasonable default.
+	MaxConcurrency int
+
 	// CacheControl specifies caching attributes that providers may use
 	// when serving the blob.
 	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Cache-Control
@@ -942,6 +1648,17 @@ type WriterOptions struct {
 	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Content-Encoding
 	ContentEncoding string
 
+	// Compression, if not empty, is a content encoding with a registered
+	// Compressor, like "gzip". The Writer compresses the data written to it
+	// using that Compressor, and ContentEncoding is set to Compression; it is
+	// an error to set both to different values. Use ReaderOptions.Decompress
+	// to read the blob's content back decompressed.
+	//
+	// ContentType is detected and ContentMD5, ContentCRC32C and ContentSHA256
+	// are verified using the uncompressed data. The blob's Size and checksum
+	// attributes describe the compressed data.
+	Compression string
+
 	// ContentLanguage specifies the language used in the blob's content, if any.
 	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Content-Language
 	ContentLanguage string
@@ -958,12 +1675,66 @@ type WriterOptions struct {
 	// https://tools.ietf.org/html/rfc1864
 	ContentMD5 []byte
 
+	// ContentCRC32C and ContentSHA256 are like ContentMD5, for the CRC32C
+	// (Castagnoli) checksum, in big-endian byte order, and the SHA-256 hash
+	// of the bytes written.
+	ContentCRC32C []byte
+	ContentSHA256 []byte
+
+	// VerifyChecksums, if true, makes the Writer compute the checksums of the
+	// bytes it stores, and compare them against the checksums the provider
+	// reports for the blob once it is written. If they don't match, Close
+	// returns an error for which gcerrors.Code will return
+	// gcerrors.DataLoss. Checksums that the provider doesn't report aren't
+	// compared.
+	//
+	// Verifying the checksums requires an extra request to retrieve the
+	// blob's attributes, after the write has completed. If the blob is
+	// overwritten in between, the checksums of the new contents are the ones
+	// compared, so Close may return DataLoss although the write succeeded.
+	// When Close returns DataLoss, the blob is not deleted; it is left as the
+	// provider stored it, and the caller should rewrite or delete it.
+	VerifyChecksums bool
+
 	// Metadata holds key/value strings to be associated with the blob, or nil.
 	// Keys may not be empty, and are lowercased before being written.
 	// Duplicate case-insensitive keys (e.g., "foo" and "FOO") will result in
 	// an error.
 	Metadata map[string]string
 
+	// IfMatch, if not empty, makes the write fail unless a blob already exists
+	// at the key and its ETag matches IfMatch. "*" matches any existing blob.
+	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/If-Match
+	//
+	// The precondition is checked when the Writer is closed; if it is not
+	// met, Close returns an error for which gcerrors.Code will return
+	// gcerrors.FailedPrecondition, and the write has no effect.
+	//
+	// Some provider implementations do not support preconditions; for them,
+	// setting IfMatch makes NewWriter return an error with code
+	// gcerrors.Unimplemented.
+	IfMatch string
+
+	// IfNoneMatch, if not empty, makes the write fail if a blob already exists
+	// at the key and its ETag matches IfNoneMatch. "*" matches any existing
+	// blob, so use "*" to only create a blob if it doesn't exist yet.
+	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/If-None-Match
+	//
+	// Failures are reported as for IfMatch.
+	IfNoneMatch string
+
+	// ExpiresAt, if not zero, is the time at which the blob expires. Copies
+	// of the blob expire at the same time.
+	//
+	// Providers that support expiration natively, like memblob and fileblob,
+	// treat the blob as deleted once it expires. For other providers,
+	// including buckets that wrap another one like those returned by
+	// PrefixedBucket, the time is recorded in the blob's Metadata under
+	// ExpiresAtMetadataKey, and
+	// the blob remains visible until PurgeExpired deletes it. Either way,
+	// Attributes.ExpiresAt reports it.
+	ExpiresAt time.Time
+
 	// BeforeWrite is a callback that will be called exactly once, before
 	// any data is written (unless NewWriter returns an error, in which case
 	// it will not be called at all). Note that this is not necessarily during
@@ -973,10 +1744,30 @@ type WriterOptions struct {
 	// asFunc converts its argument to provider-specific types.
 	// See https://godoc.org/gocloud.dev#hdr-As for background information.
 	BeforeWrite func(asFunc func(interface{}) bool) error
+
+	// Progress, if not nil, is called after each call to Write that accepts
+	// data, with the number of bytes accepted. It is called synchronously,
+	// so it should return quickly. Data may be buffered before it is sent to
+	// the provider, so the write is only complete when Close succeeds.
+	Progress func(n int64)
+
+	// RateLimiter, if not nil, limits the rate at which the Writer sends
+	// data to the provider, after any compression. It may be shared with
+	// other Readers and Writers to limit their combined rate.
+	RateLimiter *RateLimiter
 }
 
 // CopyOptions sets options for Copy.
 type CopyOptions struct {
+	// IfMatch and IfNoneMatch are preconditions on the destination blob,
+	// with the same semantics as the fields of the same name in WriterOptions.
+	IfMatch     string
+	IfNoneMatch string
+
+	// Attributes, if not nil, changes the attributes of the destination blob.
+	// By default, they are the same as those of the source blob.
+	Attributes *AttributesUpdate
+
 	// BeforeCopy is a callback that will be called before the copy is
 	// initiated.
 	//
@@ -985,6 +1776,106 @@ type CopyOptions struct {
 	BeforeCopy func(asFunc func(interface{}) bool) error
 }
 
+// AttributesUpdate describes changes to the attributes of a blob, for
+// SetAttributes and CopyOptions. Fields with zero values leave the
+// corresponding attributes unchanged.
+type AttributesUpdate struct {
+	// CacheControl specifies caching attributes that providers may use
+	// when serving the blob.
+	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Cache-Control
+	CacheControl string
+
+	// ContentDisposition specifies whether the blob content is expected to be
+	// displayed inline or as an attachment.
+	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Content-Disposition
+	ContentDisposition string
+
+	// ContentLanguage specifies the language used in the blob's content.
+	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Content-Language
+	ContentLanguage string
+
+	// ContentType specifies the MIME type of the blob.
+	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Content-Type
+	ContentType string
+
+	// Metadata, if not nil, replaces the key/value strings associated with
+	// the blob. Use an empty, non-nil map to remove all of them. Keys are
+	// validated and lowercased as for WriterOptions.Metadata.
+	Metadata map[string]string
+}
+
+// apply returns the attributes a changed by u.
+func (u *AttributesUpdate) apply(a *driver.Attributes) (*driver.WritableAttributes, error) {
+	attrs := &driver.WritableAttributes{
+		CacheControl:       a.CacheControl,
+		ContentDisposition: a.ContentDisposition,
+		ContentEncoding:    a.ContentEncoding,
+		ContentLanguage:    a.ContentLanguage,
+		ContentType:        a.ContentType,
+	}
+	if len(a.Metadata) > 0 {
+		attrs.Metadata = make(map[string]string, len(a.Metadata))
+		for k, v := range a.Metadata {
+			attrs.Metadata[strings.ToLower(k)] = v
+		}
+	}
+	if u.CacheControl != "" {
+		attrs.CacheControl = u.CacheControl
+	}
+	if u.ContentDisposition != "" {
+		attrs.ContentDisposition = u.ContentDisposition
+	}
+	if u.ContentLanguage != "" {
+		attrs.ContentLanguage = u.ContentLanguage
+	}
+	if u.ContentType != "" {
+		t, p, err := mime.ParseMediaType(u.ContentType)
+		if err != nil {
+			return nil, gcerr.Newf(gcerr.InvalidArgument, err, "blob: AttributesUpdate.ContentType %q is invalid", u.ContentType)
+		}
+		attrs.ContentType = mime.FormatMediaType(t, p)
+	}
+	if u.Metadata != nil {
+		md, err := lowercaseMetadata(u.Metadata, "AttributesUpdate.Metadata")
+		if err != nil {
+			return nil, err
+		}
+		// Keep the expiration time recorded by NewWriter.
+		if v, ok := attrs.Metadata[ExpiresAtMetadataKey]; ok {
+			if _, ok := md[ExpiresAtMetadataKey]; !ok {
+				md[ExpiresAtMetadataKey] = v
+			}
+		}
+		attrs.Metadata = md
+	}
+	if attrs.ContentType == "" {
+		attrs.ContentType = "application/octet-stream"
+	}
+	if len(attrs.Metadata) == 0 {
+		attrs.Metadata = nil
+	}
+	return attrs, nil
+}
+
+// DeleteOptions sets options for DeleteWithOptions.
+type DeleteOptions struct {
+	// IfMatch, if not empty, makes the delete fail with
+	// gcerrors.FailedPrecondition unless the blob's current ETag matches it.
+	// "*" matches any ETag.
+	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/If-Match
+	//
+	// Some provider implementations do not support preconditions; for them,
+	// setting IfMatch results in an error with code gcerrors.Unimplemented.
+	IfMatch string
+
+	// IfNoneMatch, if not empty, makes the delete fail with
+	// gcerrors.FailedPrecondition if the blob's current ETag matches it.
+	//
+	// Some provider implementations do not support preconditions; for them,
+	// setting IfNoneMatch results in an error with code gcerrors.Unimplemented.
+	IfNoneMatch string
+}
+
 // BucketURLOpener represents types that can open buckets based on a URL.
 // The opener must not modify the URL argument. OpenBucketURL must be safe to
 // call from multiple goroutines.
@@ -994,6 +1885,24 @@ type BucketURLOpener interface {
 	OpenBucketURL(ctx context.Context, u *url.URL) (*Bucket, error)
 }
 
+// BucketURLWrapper represents types that can open Buckets that wrap other
+// Buckets, based on a URL. The opener must not modify the URL argument.
+// WrapBucketURL must be safe to call from multiple goroutines.
+//
+// URLMux dispatches URLs with schemes of the form "<wrapper>+<scheme>" to the
+// BucketURLWrapper registered for <wrapper>, with the "<wrapper>+" prefix
+// removed from the URL's scheme. Wrappers can be nested; for example,
+// "a+b+mem://" opens a Bucket wrapped by "b", wrapped by "a".
+//
+// This interface is generally implemented by types in packages providing
+// wrapper Buckets.
+type BucketURLWrapper interface {
+	// WrapBucketURL opens a Bucket that wraps the Bucket returned by
+	// openInner. WrapBucketURL should consume the query parameters it
+	// understands, and pass a URL with the remaining ones to openInner.
+	WrapBucketURL(ctx context.Context, u *url.URL, openInner func(context.Context, *url.URL) (*Bucket, error)) (*Bucket, error)
+}
+
 // URLMux is a URL opener multiplexer. It matches the scheme of the URLs
 // against a set of registered schemes and calls the opener that matches the
 // URL's scheme.
@@ -1001,7 +1910,8 @@ type BucketURLOpener interface {
 //
 // The zero value is a multiplexer with no registered schemes.
 type URLMux struct {
-	schemes openurl.SchemeMap
+	schemes  openurl.SchemeMap
+	wrappers map[string]BucketURLWrapper
 }
 
 // BucketSchemes returns a sorted slice of the registered Bucket schemes.
@@ -1016,19 +1926,58 @@ func (mux *URLMux) RegisterBucket(scheme string, opener BucketURLOpener) {
 	mux.schemes.Register("blob", "Bucket", scheme, opener)
 }
 
+// RegisterBucketWrapper registers the wrapper with the given name; see
+// BucketURLWrapper. If a wrapper already exists for the name, or name contains
+// "+", RegisterBucketWrapper panics.
+func (mux *URLMux) RegisterBucketWrapper(name string, wrapper BucketURLWrapper) {
+	if strings.Contains(name, "+") {
+		panic(fmt.Errorf("bucket wrapper name %q must not contain \"+\"", name))
+	}
+	if _, exists := mux.wrappers[name]; exists {
+		panic(fmt.Errorf("bucket wrapper %q already registered", name))
+	}
+	if mux.wrappers == nil {
+		mux.wrappers = map[string]BucketURLWrapper{}
+	}
+	mux.wrappers[name] = wrapper
+}
+
 // OpenBucket calls OpenBucketURL with the URL parsed from urlstr.
 // OpenBucket is safe to call from multiple goroutines.
 func (mux *URLMux) OpenBucket(ctx context.Context, urlstr string) (*Bucket, error) {
-	opener, u, err := mux.schemes.FromString("Bucket", urlstr)
+	u, err := url.Parse(urlstr)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("open blob.Bucket: %v", err)
 	}
-	return opener.(BucketURLOpener).OpenBucketURL(ctx, u)
+	return mux.OpenBucketURL(ctx, u)
 }
 
 // OpenBucketURL dispatches the URL to the opener that is registered with the
 // URL's scheme. OpenBucketURL is safe to call from multiple goroutines.
+//
+// If the URL has a "prefix" query parameter, it is removed before dispatching
+// the URL, and the opened bucket is wrapped with PrefixedBucket using its
+// value. For example, "mem://?prefix=a/" opens a view of the blobs with keys
+// starting with "a/" in an in-memory bucket.
 func (mux *URLMux) OpenBucketURL(ctx context.Context, u *url.URL) (*Bucket, error) {
+	if q := u.Query(); q.Get("prefix") != "" {
+		prefix := q.Get("prefix")
+		q.Del("prefix")
+		inner := *u
+		inner.RawQuery = q.Encode()
+		b, err := mux.OpenBucketURL(ctx, &inner)
+		if err != nil {
+			return nil, err
+		}
+		return newPrefixedBucket(b, prefix, true), nil
+	}
+	if i := strings.Index(u.Scheme, "+"); i > 0 {
+		if wrapper, ok := mux.wrappers[u.Scheme[:i]]; ok {
+			inner := *u
+			inner.Scheme = u.Scheme[i+1:]
+			return wrapper.WrapBucketURL(ctx, &inner, mux.OpenBucketURL)
+		}
+	}
 	opener, err := mux.schemes.FromURL("Bucket", u)
 	if err != nil {
 		return nil, err
@@ -1063,4 +2012,8 @@ func wrapError(b driver.Bucket, err error) error {
 	return gcerr.New(b.ErrorCode(err), err, 2, "blob")
 }
 
+var errMoveNotSupported = gcerr.Newf(gcerr.Unimplemented, nil, "blob: atomic Move is not supported by this provider")
+
+var errVersioningNotSupported = gcerr.Newf(gcerr.Unimplemented, nil, "blob: versioning is not supported by this provider")
+
 var errClosed = gcerr.Newf(gcerr.FailedPrecondition, nil, "blob: Bucket has been closed")
@@ -20,6 +20,7 @@ import (
 	"errors"
 	"io"
 	"net/url"
+	"regexp"
 	"strings"
 	"sync"
 	"testing"
@@ -128,14 +129,56 @@ func TestListIterator(t *testing.T) {
 	}
 }
 
+// Verify that ListIterator applies the filters if driver.ListPaged doesn't.
+func TestListIteratorFilters(t *testing.T) {
+	ctx := context.Background()
+	db := &fakeLister{pages: [][]string{
+		{"a.txt", "logs/a.parquet", "logs/b.csv"},
+		{"logs/c.parquet", "logs/sub/d.parquet", "z.parquet"},
+	}}
+	b := NewBucket(db)
+	iter := b.List(&ListOptions{
+		StartAfter:  "logs/a.parquet",
+		Match:       "logs/*.parquet",
+		MatchRegexp: regexp.MustCompile(`^logs/`),
+	})
+	var got []string
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, obj.Key)
+	}
+	if want := []string{"logs/c.parquet"}; !cmp.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// The literal prefix of the pattern is used as the Prefix.
+	if got, want := db.opts.Prefix, "logs/"; got != want {
+		t.Errorf("got driver Prefix %q, want %q", got, want)
+	}
+
+	// An invalid pattern is reported by Next.
+	iter = b.List(&ListOptions{Match: "logs/[a-"})
+	if _, err := iter.Next(ctx); gcerrors.Code(err) != gcerrors.InvalidArgument {
+		t.Errorf("got error %v, want InvalidArgument", err)
+	}
+}
+
 // fakeLister implements driver.Bucket. Only ListPaged is implemented,
 // returning static data from pages.
 type fakeLister struct {
 	driver.Bucket
 	pages [][]string
+	// opts is the last ListOptions passed to ListPaged.
+	opts *driver.ListOptions
 }
 
 func (b *fakeLister) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driver.ListPage, error) {
+	b.opts = opts
 	if len(b.pages) == 0 {
 		return &driver.ListPage{}, nil
 	}
@@ -169,6 +212,10 @@ func (r *erroringReader) Close() error {
 	return errFake
 }
 
+func (r *erroringReader) Attributes() *driver.ReaderAttributes {
+	return &driver.ReaderAttributes{}
+}
+
 type erroringWriter struct {
 	driver.Writer
 }
@@ -207,7 +254,7 @@ func (b *erroringBucket) Copy(ctx context.Context, dstKey, srcKey string, opts *
 	return errFake
 }
 
-func (b *erroringBucket) Delete(ctx context.Context, key string) error {
+func (b *erroringBucket) Delete(ctx context.Context, key string, opts *driver.DeleteOptions) error {
 	return errFake
 }
 
@@ -340,9 +387,21 @@ func TestBucketIsClosed(t *testing.T) {
 	if err := bucket.Copy(ctx, "", "", nil); err != errClosed {
 		t.Error(err)
 	}
+	if _, err := bucket.Move(ctx, "", ""); err != errClosed {
+		t.Error(err)
+	}
+	if err := bucket.SetAttributes(ctx, "", AttributesUpdate{}); err != errClosed {
+		t.Error(err)
+	}
 	if err := bucket.Delete(ctx, ""); err != errClosed {
 		t.Error(err)
 	}
+	if err := bucket.DeleteMany(ctx, []string{""}); err != errClosed {
+		t.Error(err)
+	}
+	if err := bucket.DeletePrefix(ctx, ""); err != errClosed {
+		t.Error(err)
+	}
 	if _, err := bucket.SignedURL(ctx, "", nil); err != errClosed {
 		t.Error(err)
 	}
@@ -465,3 +524,72 @@ func (o *fakeOpener) OpenBucketURL(ctx context.Context, u *url.URL) (*Bucket, er
 	o.u = u
 	return nil, nil
 }
+
+func TestURLMuxWrappers(t *testing.T) {
+	ctx := context.Background()
+
+	mux := new(URLMux)
+	fake := &fakeOpener{}
+	mux.RegisterBucket("foo", fake)
+	mux.RegisterBucketWrapper("wrap", &fakeWrapper{param: "w"})
+	mux.RegisterBucketWrapper("wrap2", &fakeWrapper{param: "w2"})
+
+	for _, tc := range []struct {
+		name    string
+		url     string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "wrapper",
+			url:  "wrap+foo://mybucket?w=1&x=2",
+			want: "foo://mybucket?x=2",
+		},
+		{
+			name: "nested wrappers",
+			url:  "wrap2+wrap+foo://mybucket?w=1&w2=1",
+			want: "foo://mybucket",
+		},
+		{
+			name: "wrapper with api scheme prefix",
+			url:  "wrap+blob+foo://mybucket",
+			want: "blob+foo://mybucket",
+		},
+		{
+			name:    "unregistered wrapper",
+			url:     "nope+foo://mybucket",
+			wantErr: true,
+		},
+		{
+			name:    "wrapper of unregistered scheme",
+			url:     "wrap+bar://mybucket",
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, gotErr := mux.OpenBucket(ctx, tc.url)
+			if (gotErr != nil) != tc.wantErr {
+				t.Fatalf("got err %v, want error %v", gotErr, tc.wantErr)
+			}
+			if gotErr != nil {
+				return
+			}
+			if got := fake.u.String(); got != tc.want {
+				t.Errorf("got %q want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// fakeWrapper is a BucketURLWrapper that consumes the query parameter param.
+type fakeWrapper struct {
+	param string
+}
+
+func (w *fakeWrapper) WrapBucketURL(ctx context.Context, u *url.URL, openInner func(context.Context, *url.URL) (*Bucket, error)) (*Bucket, error) {
+	q := u.Query()
+	q.Del(w.param)
+	inner := *u
+	inner.RawQuery = q.Encode()
+	return openInner(ctx, &inner)
+}
@@ -0,0 +1,324 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package blobarchive converts between the blobs under a prefix of a
+// *blob.Bucket and tar or zip archives.
+//
+// Each blob is stored as a regular file named after its key, without the
+// prefix. The blob's attributes are stored along with it, so that they are
+// restored when the archive is imported:
+//  - in tar archives, as PAX records whose names begin with "GOCLOUD.blob."
+//    (see the Record constants);
+//  - in zip archives, as a JSON object holding the same records, in the
+//    file's comment.
+// Archives created by other tools can be imported; their files get the
+// default attributes.
+//
+// See gocloud.dev/blob/zipblob for a read-only bucket backed by a zip file.
+package blobarchive // import "gocloud.dev/blob/blobarchive"
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/internal/gcerr"
+)
+
+// The names of the records that hold blob attributes. Metadata is stored
+// with one record per key, named RecordMetadataPrefix followed by the
+// query-escaped key. Checksums are hex-encoded.
+const (
+	RecordPrefix             = "GOCLOUD.blob."
+	RecordCacheControl       = RecordPrefix + "CacheControl"
+	RecordContentDisposition = RecordPrefix + "ContentDisposition"
+	RecordContentEncoding    = RecordPrefix + "ContentEncoding"
+	RecordContentLanguage    = RecordPrefix + "ContentLanguage"
+	RecordContentType        = RecordPrefix + "ContentType"
+	RecordMD5                = RecordPrefix + "MD5"
+	RecordCRC32C             = RecordPrefix + "CRC32C"
+	RecordSHA256             = RecordPrefix + "SHA256"
+	RecordMetadataPrefix     = RecordPrefix + "Metadata."
+)
+
+// ExportTar writes the blobs whose keys start with prefix to w as a tar
+// archive. The archive is not closed if ExportTar fails.
+func ExportTar(ctx context.Context, w io.Writer, b *blob.Bucket, prefix string) error {
+	tw := tar.NewWriter(w)
+	err := export(ctx, b, prefix, func(name string, attrs *blob.Attributes, r io.Reader) error {
+		hdr := &tar.Header{
+			Typeflag:   tar.TypeReg,
+			Name:       name,
+			Mode:       0644,
+			Size:       attrs.Size,
+			ModTime:    attrs.ModTime,
+			Format:     tar.FormatPAX,
+			PAXRecords: Records(attrs),
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := io.Copy(tw, r)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// ExportZip writes the blobs whose keys start with prefix to w as a zip
+// archive. Blobs with a ContentEncoding are stored as they are; others are
+// compressed. The archive is not closed if ExportZip fails.
+func ExportZip(ctx context.Context, w io.Writer, b *blob.Bucket, prefix string) error {
+	zw := zip.NewWriter(w)
+	err := export(ctx, b, prefix, func(name string, attrs *blob.Attributes, r io.Reader) error {
+		comment, err := json.Marshal(Records(attrs))
+		if err != nil {
+			return err
+		}
+		hdr := &zip.FileHeader{
+			Name:     name,
+			Method:   zip.Deflate,
+			Modified: attrs.ModTime,
+			Comment:  string(comment),
+		}
+		if attrs.ContentEncoding != "" {
+			hdr.Method = zip.Store
+		}
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(fw, r)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// export calls add for each blob under prefix, with its name in the archive,
+// its attributes and its content.
+func export(ctx context.Context, b *blob.Bucket, prefix string, add func(name string, attrs *blob.Attributes, r io.Reader) error) error {
+	iter := b.List(&blob.ListOptions{Prefix: prefix})
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := strings.TrimPrefix(obj.Key, prefix)
+		if name == "" {
+			return gcerr.Newf(gcerr.InvalidArgument, nil, "blobarchive: blob %q has no name under prefix %q", obj.Key, prefix)
+		}
+		if err := exportBlob(ctx, b, obj.Key, name, add); err != nil {
+			return err
+		}
+	}
+}
+
+func exportBlob(ctx context.Context, b *blob.Bucket, key, name string, add func(name string, attrs *blob.Attributes, r io.Reader) error) error {
+	attrs, err := b.Attributes(ctx, key)
+	if err != nil {
+		return err
+	}
+	r, err := b.NewReader(ctx, key, nil)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	// Archive headers include the size, so it must not change; this also
+	// ensures that the recorded checksums apply to the content.
+	if r.Size() != attrs.Size {
+		return gcerr.Newf(gcerr.FailedPrecondition, nil, "blobarchive: %q was modified during export", key)
+	}
+	return add(name, attrs, r)
+}
+
+// Records returns the records that hold attrs, for storing attributes
+// alongside blob content in other formats.
+func Records(attrs *blob.Attributes) map[string]string {
+	recs := map[string]string{}
+	set := func(name, value string) {
+		if value != "" {
+			recs[name] = value
+		}
+	}
+	set(RecordCacheControl, attrs.CacheControl)
+	set(RecordContentDisposition, attrs.ContentDisposition)
+	set(RecordContentEncoding, attrs.ContentEncoding)
+	set(RecordContentLanguage, attrs.ContentLanguage)
+	set(RecordContentType, attrs.ContentType)
+	set(RecordMD5, hex.EncodeToString(attrs.MD5))
+	set(RecordCRC32C, hex.EncodeToString(attrs.CRC32C))
+	set(RecordSHA256, hex.EncodeToString(attrs.SHA256))
+	for k, v := range attrs.Metadata {
+		recs[RecordMetadataPrefix+url.QueryEscape(k)] = v
+	}
+	return recs
+}
+
+// WriterOptions returns the WriterOptions that restore the attributes in
+// recs, as returned by Records. Records that don't hold blob attributes are
+// ignored.
+func WriterOptions(recs map[string]string) (*blob.WriterOptions, error) {
+	opts := &blob.WriterOptions{}
+	for name, value := range recs {
+		var err error
+		switch name {
+		case RecordCacheControl:
+			opts.CacheControl = value
+		case RecordContentDisposition:
+			opts.ContentDisposition = value
+		case RecordContentEncoding:
+			opts.ContentEncoding = value
+		case RecordContentLanguage:
+			opts.ContentLanguage = value
+		case RecordContentType:
+			opts.ContentType = value
+		case RecordMD5:
+			opts.ContentMD5, err = hex.DecodeString(value)
+		case RecordCRC32C:
+			opts.ContentCRC32C, err = hex.DecodeString(value)
+		case RecordSHA256:
+			opts.ContentSHA256, err = hex.DecodeString(value)
+		default:
+			if !strings.HasPrefix(name, RecordMetadataPrefix) {
+				continue
+			}
+			var k string
+			if k, err = url.QueryUnescape(name[len(RecordMetadataPrefix):]); err == nil {
+				if opts.Metadata == nil {
+					opts.Metadata = map[string]string{}
+				}
+				opts.Metadata[k] = value
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("blobarchive: invalid record %s=%q: %v", name, value, err)
+		}
+	}
+	return opts, nil
+}
+
+// TarWriterOptions returns the WriterOptions that restore the attributes
+// stored in hdr by ExportTar.
+func TarWriterOptions(hdr *tar.Header) (*blob.WriterOptions, error) {
+	return WriterOptions(hdr.PAXRecords)
+}
+
+// ZipWriterOptions returns the WriterOptions that restore the attributes
+// stored in hdr by ExportZip.
+func ZipWriterOptions(hdr *zip.FileHeader) (*blob.WriterOptions, error) {
+	if !strings.HasPrefix(hdr.Comment, "{") {
+		// Not written by ExportZip.
+		return &blob.WriterOptions{}, nil
+	}
+	var recs map[string]string
+	if err := json.Unmarshal([]byte(hdr.Comment), &recs); err != nil {
+		return nil, fmt.Errorf("blobarchive: invalid comment for %q: %v", hdr.Name, err)
+	}
+	return WriterOptions(recs)
+}
+
+// ImportTar writes each regular file in the tar archive read from r to b, as
+// a blob whose key is prefix followed by the file's name. Existing blobs are
+// overwritten. Directories and other special files are skipped.
+func ImportTar(ctx context.Context, b *blob.Bucket, prefix string, r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		opts, err := TarWriterOptions(hdr)
+		if err != nil {
+			return err
+		}
+		if err := importFile(ctx, b, prefix, hdr.Name, tr, opts); err != nil {
+			return err
+		}
+	}
+}
+
+// ImportZip writes each file in the zip archive read from r, which holds
+// size bytes, to b, as a blob whose key is prefix followed by the file's
+// name. Existing blobs are overwritten. Directories are skipped.
+//
+// A *blob.Reader can be passed as r to import an archive stored in a
+// bucket.
+func ImportZip(ctx context.Context, b *blob.Bucket, prefix string, r io.ReaderAt, size int64) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+	for _, f := range zr.File {
+		if strings.HasSuffix(f.Name, "/") {
+			continue
+		}
+		opts, err := ZipWriterOptions(&f.FileHeader)
+		if err != nil {
+			return err
+		}
+		fr, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = importFile(ctx, b, prefix, f.Name, fr, opts)
+		fr.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importFile writes the content of the archived file name to b.
+func importFile(ctx context.Context, b *blob.Bucket, prefix, name string, r io.Reader, opts *blob.WriterOptions) error {
+	// Archives created from a directory often have names like "./a/b".
+	name = strings.TrimLeft(strings.TrimPrefix(name, "./"), "/")
+	if name == "" {
+		return nil
+	}
+	// Create a cancelable context so we can abort the write if reading fails.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w, err := b.NewWriter(ctx, prefix+name, opts)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		cancel() // cancel before Close cancels the write
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
@@ -0,0 +1,218 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blobarchive_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/blobarchive"
+	"gocloud.dev/blob/memblob"
+	"gocloud.dev/gcerrors"
+)
+
+// testBlobs are written under "src/" by newTestBucket.
+var testBlobs = []struct {
+	key     string
+	content string
+	opts    *blob.WriterOptions
+}{
+	{"a.txt", "hello", nil},
+	{"dir/b.html", "<p>hi</p>", &blob.WriterOptions{
+		ContentType:     "text/html",
+		CacheControl:    "no-cache",
+		ContentLanguage: "en",
+		Metadata:        map[string]string{"owner": "me", "a=b": "c d"},
+	}},
+	{"dir/c.gz", "compressed", &blob.WriterOptions{Compression: "gzip"}},
+}
+
+func newTestBucket(t *testing.T) *blob.Bucket {
+	ctx := context.Background()
+	b := memblob.OpenBucket(nil)
+	for _, tb := range testBlobs {
+		if err := b.WriteAll(ctx, "src/"+tb.key, []byte(tb.content), tb.opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Outside the exported prefix.
+	if err := b.WriteAll(ctx, "other", []byte("other"), nil); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// checkCopies checks that the blobs under "src/" in src were copied under
+// "dst/" in dst.
+func checkCopies(t *testing.T, dst, src *blob.Bucket) {
+	t.Helper()
+	ctx := context.Background()
+	var keys []string
+	iter := dst.List(nil)
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, obj.Key)
+	}
+	if diff := cmp.Diff(keys, []string{"dst/a.txt", "dst/dir/b.html", "dst/dir/c.gz"}); diff != "" {
+		t.Errorf("got keys diff (-got +want):\n%s", diff)
+	}
+	for _, tb := range testBlobs {
+		want, err := src.ReadAll(ctx, "src/"+tb.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := dst.ReadAll(ctx, "dst/"+tb.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: got content %q want %q", tb.key, got, want)
+		}
+		wantAttrs, err := src.Attributes(ctx, "src/"+tb.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gotAttrs, err := dst.Attributes(ctx, "dst/"+tb.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(gotAttrs, wantAttrs, cmp.AllowUnexported(blob.Attributes{}), ignoreFields); diff != "" {
+			t.Errorf("%s: got attributes diff (-got +want):\n%s", tb.key, diff)
+		}
+	}
+}
+
+// ignoreFields ignores the attributes that are not preserved.
+var ignoreFields = cmp.FilterPath(func(p cmp.Path) bool {
+	switch p.Last().String() {
+	case ".ModTime", ".ETag", ".asFunc":
+		return true
+	}
+	return false
+}, cmp.Ignore())
+
+func TestTar(t *testing.T) {
+	ctx := context.Background()
+	src := newTestBucket(t)
+	defer src.Close()
+
+	var buf bytes.Buffer
+	if err := blobarchive.ExportTar(ctx, &buf, src, "src/"); err != nil {
+		t.Fatal(err)
+	}
+	dst := memblob.OpenBucket(nil)
+	defer dst.Close()
+	if err := blobarchive.ImportTar(ctx, dst, "dst/", &buf); err != nil {
+		t.Fatal(err)
+	}
+	checkCopies(t, dst, src)
+}
+
+func TestZip(t *testing.T) {
+	ctx := context.Background()
+	src := newTestBucket(t)
+	defer src.Close()
+
+	var buf bytes.Buffer
+	if err := blobarchive.ExportZip(ctx, &buf, src, "src/"); err != nil {
+		t.Fatal(err)
+	}
+	// Import the archive from a bucket.
+	if err := src.WriteAll(ctx, "archive.zip", buf.Bytes(), nil); err != nil {
+		t.Fatal(err)
+	}
+	r, err := src.NewReader(ctx, "archive.zip", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	dst := memblob.OpenBucket(nil)
+	defer dst.Close()
+	if err := blobarchive.ImportZip(ctx, dst, "dst/", r, r.Size()); err != nil {
+		t.Fatal(err)
+	}
+	checkCopies(t, dst, src)
+}
+
+func TestImportPlainTar(t *testing.T) {
+	ctx := context.Background()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range []*tar.Header{
+		{Typeflag: tar.TypeDir, Name: "./"},
+		{Typeflag: tar.TypeDir, Name: "./d/"},
+		{Typeflag: tar.TypeReg, Name: "./d/file.txt", Size: 5},
+		{Typeflag: tar.TypeSymlink, Name: "./link", Linkname: "d/file.txt"},
+	} {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Size > 0 {
+			if _, err := tw.Write([]byte("hello")); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b := memblob.OpenBucket(nil)
+	defer b.Close()
+	if err := blobarchive.ImportTar(ctx, b, "x/", &buf); err != nil {
+		t.Fatal(err)
+	}
+	got, err := b.ReadAll(ctx, "x/d/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello" {
+		t.Errorf("got %q want %q", got, "hello")
+	}
+	attrs, err := b.Attributes(ctx, "x/d/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "text/plain; charset=utf-8"; attrs.ContentType != want {
+		t.Errorf("got ContentType %q want %q", attrs.ContentType, want)
+	}
+	if exists, _ := b.Exists(ctx, "x/link"); exists {
+		t.Error("symbolic link was imported")
+	}
+}
+
+func TestExportKeyIsPrefix(t *testing.T) {
+	ctx := context.Background()
+	b := memblob.OpenBucket(nil)
+	defer b.Close()
+	if err := b.WriteAll(ctx, "backup", []byte("x"), nil); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := blobarchive.ExportTar(ctx, &buf, b, "backup"); gcerrors.Code(err) != gcerrors.InvalidArgument {
+		t.Errorf("got error %v want InvalidArgument", err)
+	}
+}
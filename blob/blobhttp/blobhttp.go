@@ -0,0 +1,374 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package blobhttp provides an http.Handler that serves the blobs in a
+// *blob.Bucket over HTTP, similar to http.FileServer.
+//
+// The blob for a request is found by using the request's URL path, without
+// its leading "/", as the key; use http.StripPrefix to serve a bucket under
+// a path prefix. GET and HEAD requests are supported, including Range
+// requests and conditional requests using the blob's ETag and modification
+// time. The Content-Type, Cache-Control, Content-Encoding, Content-Language
+// and Content-Disposition response headers are set from the blob's
+// attributes.
+//
+// The content sent always belongs to the blob described by the response
+// headers: if the blob is replaced before any of it is sent, the request is
+// served again from the new blob, and if it is replaced later, the response
+// is cut short. This relies on the provider supporting ReaderOptions.IfMatch.
+//
+// If Options.DirectoryListing is set, requests for paths ending in "/" are
+// answered with an HTML listing of the blobs and "directories" under that
+// prefix.
+package blobhttp // import "gocloud.dev/blob/blobhttp"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+// Options sets options for NewHandler.
+type Options struct {
+	// DirectoryListing enables serving listings of the blobs under a prefix
+	// for requests whose path is empty or ends in "/". If it is false, such
+	// requests get a 404 Not Found response.
+	DirectoryListing bool
+}
+
+// NewHandler returns an http.Handler that serves the blobs in bucket.
+// See the package documentation for details.
+func NewHandler(bucket *blob.Bucket, opts *Options) http.Handler {
+	if opts == nil {
+		opts = &Options{}
+	}
+	return &handler{bucket: bucket, opts: opts}
+}
+
+type handler struct {
+	bucket *blob.Bucket
+	opts   *Options
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	if key == "" || strings.HasSuffix(key, "/") {
+		h.serveList(w, r, key)
+		return
+	}
+	ctx := r.Context()
+	for attempt := 1; ; attempt++ {
+		attrs, err := h.bucket.Attributes(ctx, key)
+		if err != nil {
+			// If key names a "directory", redirect to its listing, like
+			// http.FileServer.
+			if gcerrors.Code(err) == gcerrors.NotFound && h.opts.DirectoryListing && h.isDir(ctx, key+"/") {
+				redirectToDir(w, r)
+				return
+			}
+			httpError(w, err)
+			return
+		}
+		err = h.serveBlob(w, r, key, attrs)
+		if err == nil {
+			return
+		}
+		// The blob was replaced before any of it was sent; serve the new
+		// blob instead, unless it keeps changing.
+		if attempt == maxServeAttempts {
+			httpError(w, err)
+			return
+		}
+	}
+}
+
+// maxServeAttempts is the number of times ServeHTTP tries to serve a blob
+// that is replaced while it is being served.
+const maxServeAttempts = 3
+
+// serveBlob serves the blob for key, whose attributes are attrs. If the blob
+// is replaced before any of its content is sent, serveBlob writes nothing,
+// leaves w's header as it was, and returns a FailedPrecondition error.
+func (h *handler) serveBlob(w http.ResponseWriter, r *http.Request, key string, attrs *blob.Attributes) error {
+	hdr := w.Header()
+	orig := http.Header{}
+	for name, values := range hdr {
+		orig[name] = values
+	}
+	hdr.Set("Content-Type", attrs.ContentType)
+	if attrs.ETag != "" {
+		hdr.Set("Etag", quoteETag(attrs.ETag))
+	}
+	for name, value := range map[string]string{
+		"Cache-Control":       attrs.CacheControl,
+		"Content-Encoding":    attrs.ContentEncoding,
+		"Content-Language":    attrs.ContentLanguage,
+		"Content-Disposition": attrs.ContentDisposition,
+	} {
+		if value != "" {
+			hdr.Set(name, value)
+		}
+	}
+	// http.ServeContent handles Range requests and conditional requests,
+	// using the Etag header set above and the modification time. It only
+	// reads the parts of the blob that it sends. The reads are pinned to
+	// attrs.ETag, so that the content always matches the headers.
+	rs := &readSeeker{ctx: r.Context(), bucket: h.bucket, key: key, size: attrs.Size, etag: attrs.ETag}
+	defer rs.Close()
+	dw := &deferredWriter{ResponseWriter: w, rs: rs}
+	http.ServeContent(dw, r, key, attrs.ModTime, rs)
+	if rs.changed != nil && !dw.wroteHeader {
+		for name := range hdr {
+			delete(hdr, name)
+		}
+		for name, values := range orig {
+			hdr[name] = values
+		}
+		return rs.changed
+	}
+	// A blob that changes after part of it has been sent can only be
+	// reported by cutting the response short, which ServeContent does.
+	dw.flush()
+	return nil
+}
+
+// deferredWriter is an http.ResponseWriter that holds back the status code
+// until the first write of the body. Once the blob being served has
+// changed, it discards the response if nothing has been sent yet, so that
+// the request can be retried.
+type deferredWriter struct {
+	http.ResponseWriter
+	rs *readSeeker
+
+	code        int
+	wroteHeader bool
+}
+
+func (dw *deferredWriter) WriteHeader(code int) {
+	if dw.code == 0 {
+		dw.code = code
+	}
+}
+
+func (dw *deferredWriter) Write(p []byte) (int, error) {
+	if dw.rs.changed != nil && !dw.wroteHeader {
+		return len(p), nil
+	}
+	dw.flush()
+	return dw.ResponseWriter.Write(p)
+}
+
+// flush writes the status code held back by WriteHeader, if any.
+func (dw *deferredWriter) flush() {
+	if dw.wroteHeader {
+		return
+	}
+	dw.wroteHeader = true
+	if dw.code != 0 {
+		dw.ResponseWriter.WriteHeader(dw.code)
+	}
+}
+
+// quoteETag returns etag as an HTTP entity tag, adding quotes if needed.
+// Some providers return ETags with quotes, and others without.
+func quoteETag(etag string) string {
+	if strings.HasPrefix(etag, `"`) || strings.HasPrefix(etag, `W/"`) {
+		return etag
+	}
+	return `"` + etag + `"`
+}
+
+// isDir reports whether there are any blobs under prefix.
+func (h *handler) isDir(ctx context.Context, prefix string) bool {
+	iter := h.bucket.List(&blob.ListOptions{Prefix: prefix, Delimiter: "/"})
+	_, err := iter.Next(ctx)
+	return err == nil
+}
+
+// redirectToDir redirects to the request's path with a trailing "/".
+func redirectToDir(w http.ResponseWriter, r *http.Request) {
+	target := lastElemURL(r.URL.Path) + "/"
+	if q := r.URL.RawQuery; q != "" {
+		target += "?" + q
+	}
+	// http.Redirect would make target absolute using r.URL.Path, which
+	// doesn't include a prefix stripped by http.StripPrefix.
+	w.Header().Set("Location", target)
+	w.WriteHeader(http.StatusMovedPermanently)
+}
+
+// lastElemURL returns the last element of p, escaped for use as a relative
+// URL.
+func lastElemURL(p string) string {
+	if i := strings.LastIndex(p, "/"); i >= 0 {
+		p = p[i+1:]
+	}
+	return (&url.URL{Path: "./" + p}).String()
+}
+
+// serveList serves a listing of the blobs and "directories" under prefix.
+func (h *handler) serveList(w http.ResponseWriter, r *http.Request, prefix string) {
+	if !h.opts.DirectoryListing {
+		http.NotFound(w, r)
+		return
+	}
+	ctx := r.Context()
+	var names []string
+	iter := h.bucket.List(&blob.ListOptions{Prefix: prefix, Delimiter: "/"})
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		names = append(names, strings.TrimPrefix(obj.Key, prefix))
+	}
+	// An empty listing means that the "directory" doesn't exist, except for
+	// the root.
+	if len(names) == 0 && prefix != "" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == http.MethodHead {
+		return
+	}
+	fmt.Fprintf(w, "<pre>\n")
+	for _, name := range names {
+		// name may contain characters that are special in URLs, like ":".
+		u := url.URL{Path: "./" + name}
+		fmt.Fprintf(w, "<a href=\"%s\">%s</a>\n", html.EscapeString(u.String()), html.EscapeString(name))
+	}
+	fmt.Fprintf(w, "</pre>\n")
+}
+
+// httpError writes an error response for err.
+func httpError(w http.ResponseWriter, err error) {
+	switch gcerrors.Code(err) {
+	case gcerrors.NotFound:
+		http.Error(w, "404 page not found", http.StatusNotFound)
+	case gcerrors.InvalidArgument:
+		http.Error(w, "400 bad request", http.StatusBadRequest)
+	case gcerrors.PermissionDenied:
+		http.Error(w, "403 forbidden", http.StatusForbidden)
+	case gcerrors.FailedPrecondition:
+		http.Error(w, "412 precondition failed", http.StatusPreconditionFailed)
+	default:
+		http.Error(w, "500 internal server error", http.StatusInternalServerError)
+	}
+}
+
+// readSeeker implements io.ReadSeeker for a blob, opening a range reader
+// starting at the current offset when reading after a seek. If etag is set,
+// the reads fail if the blob no longer has that ETag.
+type readSeeker struct {
+	ctx    context.Context
+	bucket *blob.Bucket
+	key    string
+	size   int64
+	etag   string
+
+	offset int64
+	r      *blob.Reader
+	// changed is the FailedPrecondition error from a read of a blob that
+	// no longer has etag.
+	changed error
+}
+
+func (rs *readSeeker) Read(p []byte) (int, error) {
+	if rs.offset >= rs.size {
+		return 0, io.EOF
+	}
+	if rs.r == nil {
+		r, err := rs.newReader()
+		if err != nil {
+			rs.checkChanged(err)
+			return 0, err
+		}
+		rs.r = r
+	}
+	n, err := rs.r.Read(p)
+	rs.offset += int64(n)
+	rs.checkChanged(err)
+	return n, err
+}
+
+// newReader opens a range reader at the current offset.
+func (rs *readSeeker) newReader() (*blob.Reader, error) {
+	if rs.etag == "" {
+		return rs.bucket.NewRangeReader(rs.ctx, rs.key, rs.offset, -1, nil)
+	}
+	r, err := rs.bucket.NewRangeReader(rs.ctx, rs.key, rs.offset, -1, &blob.ReaderOptions{IfMatch: rs.etag})
+	if gcerrors.Code(err) == gcerrors.Unimplemented {
+		// The provider doesn't support conditional reads; read whatever is
+		// there.
+		rs.etag = ""
+		return rs.bucket.NewRangeReader(rs.ctx, rs.key, rs.offset, -1, nil)
+	}
+	return r, err
+}
+
+// checkChanged records err in rs.changed if it reports that the blob no
+// longer has rs.etag.
+func (rs *readSeeker) checkChanged(err error) {
+	if err != nil && err != io.EOF && gcerrors.Code(err) == gcerrors.FailedPrecondition {
+		rs.changed = err
+	}
+}
+
+func (rs *readSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += rs.offset
+	case io.SeekEnd:
+		offset += rs.size
+	default:
+		return 0, errors.New("blobhttp: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("blobhttp: negative position")
+	}
+	if offset != rs.offset {
+		rs.Close()
+		rs.offset = offset
+	}
+	return offset, nil
+}
+
+// Close closes the current range reader, if any.
+func (rs *readSeeker) Close() error {
+	if rs.r == nil {
+		return nil
+	}
+	err := rs.r.Close()
+	rs.r = nil
+	return err
+}
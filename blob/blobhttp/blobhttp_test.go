@@ -0,0 +1,330 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blobhttp
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/driver"
+	"gocloud.dev/blob/memblob"
+	"gocloud.dev/gcerrors"
+)
+
+func TestHandler(t *testing.T) {
+	ctx := context.Background()
+	b := memblob.OpenBucket(nil)
+	defer b.Close()
+	opts := &blob.WriterOptions{
+		ContentType:     "text/plain",
+		CacheControl:    "max-age=60",
+		ContentEncoding: "identity",
+	}
+	if err := b.WriteAll(ctx, "dir/a.txt", []byte("hello world"), opts); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.WriteAll(ctx, "dir/sub/b.txt", []byte("b"), nil); err != nil {
+		t.Fatal(err)
+	}
+	attrs, err := b.Attributes(ctx, "dir/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	etag := quoteETag(attrs.ETag)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		header     map[string]string
+		listing    bool
+		wantStatus int
+		wantHeader map[string]string
+		wantBody   string
+	}{
+		{
+			name:       "Get",
+			path:       "/dir/a.txt",
+			wantStatus: http.StatusOK,
+			wantHeader: map[string]string{
+				"Content-Type":     "text/plain",
+				"Cache-Control":    "max-age=60",
+				"Content-Encoding": "identity",
+				"Etag":             etag,
+				"Last-Modified":    attrs.ModTime.UTC().Format(http.TimeFormat),
+				"Accept-Ranges":    "bytes",
+			},
+			wantBody: "hello world",
+		},
+		{
+			name:       "Head",
+			method:     http.MethodHead,
+			path:       "/dir/sub/b.txt",
+			wantStatus: http.StatusOK,
+			wantHeader: map[string]string{"Content-Length": "1"},
+		},
+		{
+			name:       "Range",
+			path:       "/dir/a.txt",
+			header:     map[string]string{"Range": "bytes=6-8"},
+			wantStatus: http.StatusPartialContent,
+			wantHeader: map[string]string{"Content-Range": "bytes 6-8/11"},
+			wantBody:   "wor",
+		},
+		{
+			name:       "SuffixRange",
+			path:       "/dir/a.txt",
+			header:     map[string]string{"Range": "bytes=-5"},
+			wantStatus: http.StatusPartialContent,
+			wantBody:   "world",
+		},
+		{
+			name:       "InvalidRange",
+			path:       "/dir/a.txt",
+			header:     map[string]string{"Range": "bytes=20-30"},
+			wantStatus: http.StatusRequestedRangeNotSatisfiable,
+		},
+		{
+			name:       "IfNoneMatch",
+			path:       "/dir/a.txt",
+			header:     map[string]string{"If-None-Match": etag},
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:       "IfNoneMatchChanged",
+			path:       "/dir/a.txt",
+			header:     map[string]string{"If-None-Match": `"other"`},
+			wantStatus: http.StatusOK,
+			wantBody:   "hello world",
+		},
+		{
+			name:       "IfModifiedSince",
+			path:       "/dir/a.txt",
+			header:     map[string]string{"If-Modified-Since": attrs.ModTime.Add(time.Second).UTC().Format(http.TimeFormat)},
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:       "IfMatchFailed",
+			path:       "/dir/a.txt",
+			header:     map[string]string{"If-Match": `"other"`},
+			wantStatus: http.StatusPreconditionFailed,
+		},
+		{
+			name:       "NotFound",
+			path:       "/dir/missing.txt",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "MethodNotAllowed",
+			method:     http.MethodPut,
+			path:       "/dir/a.txt",
+			wantStatus: http.StatusMethodNotAllowed,
+			wantHeader: map[string]string{"Allow": "GET, HEAD"},
+		},
+		{
+			name:       "ListingDisabled",
+			path:       "/dir/",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "Listing",
+			path:       "/dir/",
+			listing:    true,
+			wantStatus: http.StatusOK,
+			wantHeader: map[string]string{"Content-Type": "text/html; charset=utf-8"},
+			wantBody:   "<pre>\n<a href=\"./a.txt\">a.txt</a>\n<a href=\"./sub/\">sub/</a>\n</pre>\n",
+		},
+		{
+			name:       "ListingRoot",
+			path:       "/",
+			listing:    true,
+			wantStatus: http.StatusOK,
+			wantBody:   "<pre>\n<a href=\"./dir/\">dir/</a>\n</pre>\n",
+		},
+		{
+			name:       "ListingNotFound",
+			path:       "/missing/",
+			listing:    true,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "DirectoryRedirect",
+			path:       "/dir/sub",
+			listing:    true,
+			wantStatus: http.StatusMovedPermanently,
+			wantHeader: map[string]string{"Location": "./sub/"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			method := test.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, test.path, nil)
+			for k, v := range test.header {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			NewHandler(b, &Options{DirectoryListing: test.listing}).ServeHTTP(rec, req)
+			if rec.Code != test.wantStatus {
+				t.Errorf("got status %d want %d", rec.Code, test.wantStatus)
+			}
+			for k, want := range test.wantHeader {
+				if got := rec.Header().Get(k); got != want {
+					t.Errorf("header %s: got %q want %q", k, got, want)
+				}
+			}
+			if test.wantBody != "" {
+				if got := rec.Body.String(); got != test.wantBody {
+					t.Errorf("got body %q want %q", got, test.wantBody)
+				}
+			}
+		})
+	}
+}
+
+func TestStripPrefix(t *testing.T) {
+	ctx := context.Background()
+	b := memblob.OpenBucket(nil)
+	defer b.Close()
+	if err := b.WriteAll(ctx, "a.txt", []byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.StripPrefix("/static", NewHandler(b, nil)))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/static/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	got, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || string(got) != "hello" {
+		t.Errorf("got status %d and body %q, want 200 and %q", resp.StatusCode, got, "hello")
+	}
+}
+
+// overwritingBucket implements driver.Bucket for reads from base, calling
+// overwrite after it returns the attributes of a blob.
+type overwritingBucket struct {
+	driver.Bucket
+	base      *blob.Bucket
+	overwrite func()
+}
+
+func (b *overwritingBucket) Attributes(ctx context.Context, key string) (*driver.Attributes, error) {
+	a, err := b.base.Attributes(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	b.overwrite()
+	return &driver.Attributes{
+		ContentType: a.ContentType,
+		ModTime:     a.ModTime,
+		Size:        a.Size,
+		ETag:        a.ETag,
+	}, nil
+}
+
+func (b *overwritingBucket) NewRangeReader(ctx context.Context, key string, offset, length int64, opts *driver.ReaderOptions) (driver.Reader, error) {
+	r, err := b.base.NewRangeReader(ctx, key, offset, length, &blob.ReaderOptions{IfMatch: opts.IfMatch})
+	if err != nil {
+		return nil, err
+	}
+	return &baseReader{r: r, attrs: driver.ReaderAttributes{Size: r.Size(), ETag: r.ETag()}}, nil
+}
+
+func (b *overwritingBucket) ErrorCode(err error) gcerrors.ErrorCode { return gcerrors.Code(err) }
+
+func (b *overwritingBucket) Close() error { return nil }
+
+type baseReader struct {
+	r     *blob.Reader
+	attrs driver.ReaderAttributes
+}
+
+func (r *baseReader) Read(p []byte) (int, error)           { return r.r.Read(p) }
+func (r *baseReader) Close() error                         { return r.r.Close() }
+func (r *baseReader) Attributes() *driver.ReaderAttributes { return &r.attrs }
+func (r *baseReader) As(i interface{}) bool                { return false }
+
+func TestConcurrentWrite(t *testing.T) {
+	ctx := context.Background()
+	base := memblob.OpenBucket(nil)
+	defer base.Close()
+	if err := base.WriteAll(ctx, "a.txt", []byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name       string
+		writes     int
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "ChangedOnce", writes: 1, wantStatus: http.StatusOK, wantBody: "hello world 1"},
+		{name: "KeepsChanging", writes: maxServeAttempts, wantStatus: http.StatusPreconditionFailed},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			// The blob is replaced after its attributes are read, the given
+			// number of times.
+			n := 0
+			b := blob.NewBucket(&overwritingBucket{base: base, overwrite: func() {
+				if n == test.writes {
+					return
+				}
+				n++
+				if err := base.WriteAll(ctx, "a.txt", []byte(fmt.Sprintf("hello world %d", n)), nil); err != nil {
+					t.Fatal(err)
+				}
+			}})
+			defer b.Close()
+			if err := base.WriteAll(ctx, "a.txt", []byte("hello"), nil); err != nil {
+				t.Fatal(err)
+			}
+
+			rec := httptest.NewRecorder()
+			NewHandler(b, nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/a.txt", nil))
+			if rec.Code != test.wantStatus {
+				t.Errorf("got status %d want %d", rec.Code, test.wantStatus)
+			}
+			if test.wantBody == "" {
+				return
+			}
+			if got := rec.Body.String(); got != test.wantBody {
+				t.Errorf("got body %q want %q", got, test.wantBody)
+			}
+			attrs, err := base.Attributes(ctx, "a.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := rec.Header().Get("Etag"), quoteETag(attrs.ETag); got != want {
+				t.Errorf("got Etag %q want %q", got, want)
+			}
+			if got, want := rec.Header().Get("Content-Length"), fmt.Sprint(len(test.wantBody)); got != want {
+				t.Errorf("got Content-Length %q want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blobhttp_test
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/blobhttp"
+	_ "gocloud.dev/blob/fileblob"
+)
+
+func Example() {
+	// Open a bucket holding static assets.
+	bucket, err := blob.OpenBucket(context.Background(), "file:///path/to/assets")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer bucket.Close()
+
+	// Serve the blobs in the bucket under "/static/"; for example, a request
+	// for "/static/css/site.css" serves the blob "css/site.css".
+	http.Handle("/static/", http.StripPrefix("/static/", blobhttp.NewHandler(bucket, nil)))
+	log.Fatal(http.ListenAndServe(":8080", nil))
+}
@@ -0,0 +1,693 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cacheblob provides a blob implementation that wraps a remote
+// *blob.Bucket, caching blobs read from it in a local *blob.Bucket, such as
+// one from fileblob or memblob. Use OpenBucket to construct a *blob.Bucket.
+//
+// Reads are served from the local bucket when possible. The first read of a
+// blob downloads all of it into the local bucket. Cached blobs are used
+// without contacting the remote bucket for Options.TTL; after that, they are
+// revalidated by comparing their ETag (or size and modification time) with the
+// remote blob's, and downloaded again if they changed. When the cached blobs
+// exceed Options.MaxSize, the least recently used ones are deleted from the
+// local bucket.
+//
+// All other operations go to the remote bucket. Writes, copies and deletes
+// through the caching bucket invalidate the cached copy of the affected blob,
+// but changes made to the remote bucket by others are only noticed when a
+// cached blob is revalidated.
+//
+// Blobs already in the local bucket that were cached by a previous caching
+// bucket, for example in a fileblob directory, are reused when first read.
+// The local bucket should not be used for anything else.
+//
+// URLs
+//
+// For blob.OpenBucket, cacheblob registers as a wrapper named "cache"; see
+// blob.BucketURLWrapper. For example,
+// "cache+s3://mybucket?local=file%3A%2F%2F%2Fvar%2Fcache" opens an S3 bucket
+// whose blobs are cached in the fileblob directory /var/cache. To customize
+// the URL opener, or for more details on the URL format, see URLOpener.
+// See https://godoc.org/gocloud.dev#hdr-URLs for background information.
+//
+// OpenCensus Integration
+//
+// In addition to the metrics collected by package blob, cacheblob records
+// cache hits, misses and evictions; see OpenCensusViews.
+//
+// As
+//
+// cacheblob does not support any types for As, except that the As functions
+// of attributes and list results are forwarded to the remote bucket's.
+package cacheblob // import "gocloud.dev/blob/cacheblob"
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/driver"
+	"gocloud.dev/blob/internal/provider"
+	"gocloud.dev/gcerrors"
+	"gocloud.dev/internal/oc"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// Metadata keys used to store information about the remote blob in the
+	// cached copy.
+	mdETag    = "cacheblob_etag"
+	mdModTime = "cacheblob_modtime"
+)
+
+var (
+	errPreconditionFailed = errors.New("precondition failed")
+	errModified           = errors.New("blob was modified while it was being cached")
+)
+
+const pkgName = "gocloud.dev/blob/cacheblob"
+
+var (
+	hitsMeasure      = stats.Int64(pkgName+"/hits", "Reads served from the local bucket", stats.UnitDimensionless)
+	missesMeasure    = stats.Int64(pkgName+"/misses", "Reads served from the remote bucket", stats.UnitDimensionless)
+	evictionsMeasure = stats.Int64(pkgName+"/evictions", "Blobs evicted from the local bucket", stats.UnitDimensionless)
+
+	// OpenCensusViews are predefined views for OpenCensus metrics.
+	// The views include counts of cache hits, misses and evictions, by the
+	// provider of the remote bucket.
+	// See the example at https://godoc.org/go.opencensus.io/stats/view for usage.
+	OpenCensusViews = []*view.View{
+		oc.CountView(pkgName, "hits", "Count of reads served from the local bucket, by provider.", hitsMeasure),
+		oc.CountView(pkgName, "misses", "Count of reads that had to read the blob from the remote bucket, by provider.", missesMeasure),
+		oc.CountView(pkgName, "evictions", "Count of blobs evicted from the local bucket, by provider.", evictionsMeasure),
+	}
+)
+
+// record records a measurement of m, tagged with the remote provider.
+func (b *bucket) record(ctx context.Context, m *stats.Int64Measure) {
+	stats.RecordWithTags(ctx, []tag.Mutator{tag.Upsert(oc.ProviderKey, b.provider)}, m.M(1))
+}
+
+func init() {
+	blob.DefaultURLMux().RegisterBucketWrapper(Scheme, &URLOpener{})
+}
+
+// Scheme is the name cacheblob registers its URLOpener under on
+// blob.DefaultMux, as a blob.BucketURLWrapper.
+const Scheme = "cache"
+
+// URLOpener opens URLs like "cache+s3://mybucket?local=mem%3A%2F%2F".
+//
+// The following query parameters are supported, and removed from the URL
+// passed to the remote bucket's opener:
+//
+//   - local: the URL of the local bucket, opened with the same blob.URLMux.
+//     It must be query-escaped. Required.
+//   - maxsize: overrides Options.MaxSize, in bytes.
+//   - ttl: overrides Options.TTL, as a duration like "5m"; see
+//     time.ParseDuration.
+//
+// The remote and local buckets are closed when the returned bucket is closed.
+type URLOpener struct {
+	// Options specifies the default options to pass to OpenBucket.
+	Options Options
+}
+
+// WrapBucketURL implements blob.BucketURLWrapper.
+func (o *URLOpener) WrapBucketURL(ctx context.Context, u *url.URL, openInner func(context.Context, *url.URL) (*blob.Bucket, error)) (*blob.Bucket, error) {
+	opts := o.Options
+	q := u.Query()
+	localURL := q.Get("local")
+	if localURL == "" {
+		return nil, fmt.Errorf("open bucket %v: query parameter \"local\" is required", u)
+	}
+	if s := q.Get("maxsize"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("open bucket %v: invalid maxsize %q", u, s)
+		}
+		opts.MaxSize = n
+	}
+	if s := q.Get("ttl"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("open bucket %v: invalid ttl %q", u, s)
+		}
+		opts.TTL = d
+	}
+	for _, param := range []string{"local", "maxsize", "ttl"} {
+		q.Del(param)
+	}
+	lu, err := url.Parse(localURL)
+	if err != nil {
+		return nil, fmt.Errorf("open bucket %v: invalid local URL: %v", u, err)
+	}
+	local, err := openInner(ctx, lu)
+	if err != nil {
+		return nil, err
+	}
+	remoteURL := *u
+	remoteURL.RawQuery = q.Encode()
+	remote, err := openInner(ctx, &remoteURL)
+	if err != nil {
+		local.Close()
+		return nil, err
+	}
+	b := openBucket(remote, local, &opts)
+	b.owned = true
+	return blob.NewBucket(b), nil
+}
+
+// Options sets options for constructing a *blob.Bucket backed by cacheblob.
+type Options struct {
+	// MaxSize is the maximum total size in bytes of the blobs kept in the
+	// local bucket. When it is exceeded, the least recently used blobs are
+	// deleted from the local bucket. Blobs larger than MaxSize are read
+	// directly from the remote bucket. If 0, there is no limit.
+	MaxSize int64
+
+	// TTL is how long a cached blob is used without revalidating it against
+	// the remote bucket. If 0, cached blobs are revalidated on every read,
+	// which still saves downloading blobs that haven't changed.
+	TTL time.Duration
+}
+
+type bucket struct {
+	remote *blob.Bucket
+	local  *blob.Bucket
+	opts   *Options
+	// provider is the provider of remote, for metrics.
+	provider string
+	// owned is true if remote and local should be closed with the bucket.
+	owned bool
+
+	// fetches makes concurrent reads of an uncached blob download it once.
+	fetches singleflight.Group
+
+	mu      sync.Mutex
+	entries map[string]*list.Element // values are *entry
+	lru     *list.List               // most recently used first
+	size    int64                    // total size of entries
+}
+
+// entry describes a blob cached in the local bucket.
+type entry struct {
+	key         string
+	size        int64
+	etag        string    // of the remote blob
+	modTime     time.Time // of the remote blob
+	contentType string
+	validated   time.Time // when the cached copy was last known to be current
+}
+
+// matches reports whether e is a copy of the remote blob with attrs.
+func (e *entry) matches(attrs *blob.Attributes) bool {
+	if e.etag != "" || attrs.ETag != "" {
+		return e.etag == attrs.ETag
+	}
+	return e.size == attrs.Size && e.modTime.Equal(attrs.ModTime)
+}
+
+// OpenBucket creates a *blob.Bucket that reads blobs from remote, caching
+// them in local.
+// Closing the returned bucket doesn't close remote or local.
+func OpenBucket(remote, local *blob.Bucket, opts *Options) *blob.Bucket {
+	return blob.NewBucket(openBucket(remote, local, opts))
+}
+
+func openBucket(remote, local *blob.Bucket, opts *Options) *bucket {
+	if opts == nil {
+		opts = &Options{}
+	}
+	return &bucket{
+		remote:   remote,
+		local:    local,
+		opts:     opts,
+		provider: provider.Name(remote),
+		entries:  map[string]*list.Element{},
+		lru:      list.New(),
+	}
+}
+
+// Close implements driver.Close.
+func (b *bucket) Close() error {
+	if !b.owned {
+		return nil
+	}
+	err := b.remote.Close()
+	if lerr := b.local.Close(); err == nil {
+		err = lerr
+	}
+	return err
+}
+
+// ErrorCode implements driver.ErrorCode.
+func (b *bucket) ErrorCode(err error) gcerrors.ErrorCode {
+	switch err {
+	case errPreconditionFailed, errModified:
+		return gcerrors.FailedPrecondition
+	}
+	return gcerrors.Code(err)
+}
+
+// As implements driver.As.
+func (b *bucket) As(i interface{}) bool { return false }
+
+// ErrorAs implements driver.ErrorAs.
+func (b *bucket) ErrorAs(err error, i interface{}) bool {
+	return b.remote.ErrorAs(err, i) || b.local.ErrorAs(err, i)
+}
+
+// ListPaged implements driver.ListPaged.
+func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driver.ListPage, error) {
+	pageToken := opts.PageToken
+	if len(pageToken) == 0 {
+		pageToken = blob.FirstPageToken
+	}
+	objs, next, err := b.remote.ListPage(ctx, pageToken, opts.PageSize, &blob.ListOptions{
+		Prefix:      opts.Prefix,
+		Delimiter:   opts.Delimiter,
+		StartAfter:  opts.StartAfter,
+		Match:       opts.Match,
+		MatchRegexp: opts.MatchRegexp,
+		MinModTime:  opts.MinModTime,
+		MaxModTime:  opts.MaxModTime,
+		MinSize:     opts.MinSize,
+		MaxSize:     opts.MaxSize,
+		BeforeList:  opts.BeforeList,
+	})
+	if err != nil {
+		return nil, err
+	}
+	page := &driver.ListPage{NextPageToken: next}
+	for _, obj := range objs {
+		page.Objects = append(page.Objects, &driver.ListObject{
+			Key:     obj.Key,
+			ModTime: obj.ModTime,
+			Size:    obj.Size,
+			MD5:     obj.MD5,
+			CRC32C:  obj.CRC32C,
+			SHA256:  obj.SHA256,
+			ETag:    obj.ETag,
+			IsDir:   obj.IsDir,
+			AsFunc:  obj.As,
+		})
+	}
+	return page, nil
+}
+
+// Attributes implements driver.Attributes.
+func (b *bucket) Attributes(ctx context.Context, key string) (*driver.Attributes, error) {
+	attrs, err := b.remote.Attributes(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return &driver.Attributes{
+		CacheControl:       attrs.CacheControl,
+		ContentDisposition: attrs.ContentDisposition,
+		ContentEncoding:    attrs.ContentEncoding,
+		ContentLanguage:    attrs.ContentLanguage,
+		ContentType:        attrs.ContentType,
+		Metadata:           attrs.Metadata,
+		ModTime:            attrs.ModTime,
+		Size:               attrs.Size,
+		MD5:                attrs.MD5,
+		CRC32C:             attrs.CRC32C,
+		SHA256:             attrs.SHA256,
+		ETag:               attrs.ETag,
+		ExpiresAt:          attrs.ExpiresAt,
+		AsFunc:             attrs.As,
+	}, nil
+}
+
+// NewRangeReader implements driver.NewRangeReader.
+func (b *bucket) NewRangeReader(ctx context.Context, key string, offset, length int64, opts *driver.ReaderOptions) (driver.Reader, error) {
+	// Preconditions are checked against the current remote ETag.
+	revalidate := opts.IfMatch != "" || opts.IfNoneMatch != ""
+	for retry := false; ; retry = true {
+		e, err := b.lookup(ctx, key, revalidate)
+		if err != nil {
+			return nil, err
+		}
+		if e == nil || (revalidate && e.etag == "") {
+			// Not cached, or we can't check the preconditions ourselves.
+			r, err := b.remote.NewRangeReader(ctx, key, offset, length, &blob.ReaderOptions{
+				IfMatch:     opts.IfMatch,
+				IfNoneMatch: opts.IfNoneMatch,
+			})
+			if err != nil {
+				return nil, err
+			}
+			return newReader(r, r.ContentType(), r.ModTime(), r.ETag()), nil
+		}
+		if opts.IfMatch != "" && opts.IfMatch != "*" && opts.IfMatch != e.etag {
+			return nil, errPreconditionFailed
+		}
+		if opts.IfNoneMatch != "" && (opts.IfNoneMatch == "*" || opts.IfNoneMatch == e.etag) {
+			return nil, errPreconditionFailed
+		}
+		r, err := b.local.NewRangeReader(ctx, key, offset, length, nil)
+		if gcerrors.Code(err) == gcerrors.NotFound && !retry {
+			// The cached copy was deleted since lookup; fetch it again.
+			b.forget(key)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return newReader(r, e.contentType, e.modTime, e.etag), nil
+	}
+}
+
+// lookup returns the cache entry for key, downloading the blob into the local
+// bucket or revalidating the cached copy as needed. It returns a nil entry if
+// the blob is too large to cache.
+func (b *bucket) lookup(ctx context.Context, key string, revalidate bool) (*entry, error) {
+	e := b.get(key)
+	if e == nil {
+		e = b.adopt(ctx, key)
+	}
+	if e != nil && !revalidate && b.opts.TTL > 0 && time.Since(e.validated) < b.opts.TTL {
+		b.record(ctx, hitsMeasure)
+		return e, nil
+	}
+	attrs, err := b.remote.Attributes(ctx, key)
+	if err != nil {
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			b.invalidate(key)
+		}
+		return nil, err
+	}
+	if e != nil && e.matches(attrs) {
+		b.mu.Lock()
+		e.validated = time.Now()
+		b.mu.Unlock()
+		b.record(ctx, hitsMeasure)
+		return e, nil
+	}
+	if b.opts.MaxSize > 0 && attrs.Size > b.opts.MaxSize {
+		b.record(ctx, missesMeasure)
+		return nil, nil
+	}
+	v, err, _ := b.fetches.Do(key, func() (interface{}, error) {
+		return b.fetch(ctx, key, attrs)
+	})
+	if err != nil {
+		return nil, err
+	}
+	b.record(ctx, missesMeasure)
+	return v.(*entry), nil
+}
+
+// get returns the entry for key, marking it as recently used, or nil.
+func (b *bucket) get(key string) *entry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	elem := b.entries[key]
+	if elem == nil {
+		return nil
+	}
+	b.lru.MoveToFront(elem)
+	return elem.Value.(*entry)
+}
+
+// adopt adds an entry for a copy of key cached in the local bucket by a
+// previous caching bucket, if there is one.
+func (b *bucket) adopt(ctx context.Context, key string) *entry {
+	attrs, err := b.local.Attributes(ctx, key)
+	if err != nil {
+		return nil
+	}
+	modTime, err := time.Parse(time.RFC3339Nano, attrs.Metadata[mdModTime])
+	if err != nil {
+		return nil
+	}
+	e := &entry{
+		key:         key,
+		size:        attrs.Size,
+		etag:        attrs.Metadata[mdETag],
+		modTime:     modTime,
+		contentType: attrs.ContentType,
+		validated:   attrs.ModTime,
+	}
+	b.add(e)
+	return e
+}
+
+// fetch downloads the blob at key, which has attrs, into the local bucket.
+func (b *bucket) fetch(ctx context.Context, key string, attrs *blob.Attributes) (*entry, error) {
+	r, err := b.remote.NewReader(ctx, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	if r.Size() != attrs.Size || (!attrs.ModTime.IsZero() && !r.ModTime().IsZero() && !r.ModTime().Equal(attrs.ModTime)) {
+		return nil, errModified
+	}
+	e := &entry{
+		key:         key,
+		size:        attrs.Size,
+		etag:        attrs.ETag,
+		modTime:     attrs.ModTime,
+		contentType: attrs.ContentType,
+		validated:   time.Now(),
+	}
+	// Create a cancelable context so we can abort the write if reading fails.
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w, err := b.local.NewWriter(wctx, key, &blob.WriterOptions{
+		ContentType:     attrs.ContentType,
+		ContentEncoding: r.ContentEncoding(),
+		Metadata: map[string]string{
+			mdETag:    attrs.ETag,
+			mdModTime: attrs.ModTime.Format(time.RFC3339Nano),
+		},
+		ExpiresAt: attrs.ExpiresAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		cancel()
+		_ = w.Close()
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	b.add(e)
+	return e, nil
+}
+
+// add adds e to the cache, replacing any previous entry for its key, and
+// evicts the least recently used entries if the cache is too large.
+func (b *bucket) add(e *entry) {
+	b.mu.Lock()
+	if elem := b.entries[e.key]; elem != nil {
+		b.size -= elem.Value.(*entry).size
+		b.lru.Remove(elem)
+	}
+	b.entries[e.key] = b.lru.PushFront(e)
+	b.size += e.size
+	var evicted []string
+	for b.opts.MaxSize > 0 && b.size > b.opts.MaxSize {
+		old := b.lru.Back().Value.(*entry)
+		b.remove(old.key)
+		evicted = append(evicted, old.key)
+	}
+	b.mu.Unlock()
+
+	for _, key := range evicted {
+		b.record(context.Background(), evictionsMeasure)
+		_ = b.local.Delete(context.Background(), key)
+	}
+}
+
+// remove removes the entry for key. b.mu must be held.
+func (b *bucket) remove(key string) {
+	if elem := b.entries[key]; elem != nil {
+		b.size -= elem.Value.(*entry).size
+		b.lru.Remove(elem)
+		delete(b.entries, key)
+	}
+}
+
+// forget removes the entry for key, leaving the local bucket as is.
+func (b *bucket) forget(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.remove(key)
+}
+
+// invalidate removes the cached copy of key, if any.
+func (b *bucket) invalidate(key string) {
+	b.forget(key)
+	_ = b.local.Delete(context.Background(), key)
+}
+
+// reader reads a blob from the local or the remote bucket.
+type reader struct {
+	r     *blob.Reader
+	attrs driver.ReaderAttributes
+}
+
+func newReader(r *blob.Reader, contentType string, modTime time.Time, etag string) *reader {
+	return &reader{
+		r: r,
+		attrs: driver.ReaderAttributes{
+			ContentType:     contentType,
+			ModTime:         modTime,
+			Size:            r.Size(),
+			ContentEncoding: r.ContentEncoding(),
+			ETag:            etag,
+		},
+	}
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	return r.r.Read(p)
+}
+
+func (r *reader) Close() error {
+	return r.r.Close()
+}
+
+func (r *reader) Attributes() *driver.ReaderAttributes {
+	return &r.attrs
+}
+
+func (r *reader) As(i interface{}) bool { return false }
+
+// NewTypedWriter implements driver.NewTypedWriter.
+func (b *bucket) NewTypedWriter(ctx context.Context, key string, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
+	w, err := b.remote.NewWriter(ctx, key, &blob.WriterOptions{
+		BufferSize:         opts.BufferSize,
+		MaxConcurrency:     opts.MaxConcurrency,
+		CacheControl:       opts.CacheControl,
+		ContentDisposition: opts.ContentDisposition,
+		ContentEncoding:    opts.ContentEncoding,
+		ContentLanguage:    opts.ContentLanguage,
+		ContentType:        contentType,
+		ContentMD5:         opts.ContentMD5,
+		ContentCRC32C:      opts.ContentCRC32C,
+		ContentSHA256:      opts.ContentSHA256,
+		Metadata:           opts.Metadata,
+		IfMatch:            opts.IfMatch,
+		IfNoneMatch:        opts.IfNoneMatch,
+		BeforeWrite:        opts.BeforeWrite,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &writer{w: w, b: b, key: key}, nil
+}
+
+// writer writes to the remote bucket, and invalidates the cached copy of the
+// blob when done.
+type writer struct {
+	w   *blob.Writer
+	b   *bucket
+	key string
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	return w.w.Write(p)
+}
+
+func (w *writer) Close() error {
+	if err := w.w.Close(); err != nil {
+		return err
+	}
+	w.b.invalidate(w.key)
+	return nil
+}
+
+// Copy implements driver.Copy.
+func (b *bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.CopyOptions) error {
+	err := b.remote.Copy(ctx, dstKey, srcKey, &blob.CopyOptions{
+		IfMatch:     opts.IfMatch,
+		IfNoneMatch: opts.IfNoneMatch,
+		Attributes:  attributesUpdate(opts.Attributes),
+		BeforeCopy:  opts.BeforeCopy,
+	})
+	if err != nil {
+		return err
+	}
+	b.invalidate(dstKey)
+	return nil
+}
+
+// SetAttributes implements driver.AttributesSetter.
+func (b *bucket) SetAttributes(ctx context.Context, key string, attrs *driver.WritableAttributes) error {
+	if err := b.remote.SetAttributes(ctx, key, *attributesUpdate(attrs)); err != nil {
+		return err
+	}
+	b.invalidate(key)
+	return nil
+}
+
+// attributesUpdate returns a blob.AttributesUpdate that replaces the
+// attributes of a blob with attrs.
+func attributesUpdate(attrs *driver.WritableAttributes) *blob.AttributesUpdate {
+	if attrs == nil {
+		return nil
+	}
+	md := attrs.Metadata
+	if md == nil {
+		// A nil Metadata would leave the metadata unchanged.
+		md = map[string]string{}
+	}
+	return &blob.AttributesUpdate{
+		CacheControl:       attrs.CacheControl,
+		ContentDisposition: attrs.ContentDisposition,
+		ContentLanguage:    attrs.ContentLanguage,
+		ContentType:        attrs.ContentType,
+		Metadata:           md,
+	}
+}
+
+// Delete implements driver.Delete.
+func (b *bucket) Delete(ctx context.Context, key string, opts *driver.DeleteOptions) error {
+	err := b.remote.DeleteWithOptions(ctx, key, &blob.DeleteOptions{
+		IfMatch:     opts.IfMatch,
+		IfNoneMatch: opts.IfNoneMatch,
+	})
+	if err != nil {
+		return err
+	}
+	b.invalidate(key)
+	return nil
+}
+
+// SignedURL implements driver.SignedURL.
+func (b *bucket) SignedURL(ctx context.Context, key string, opts *driver.SignedURLOptions) (string, error) {
+	// Changes made with PUT and DELETE URLs bypass the cache, like other
+	// changes to the remote bucket; see Options.TTL.
+	return b.remote.SignedURL(ctx, key, &blob.SignedURLOptions{
+		Expiry:      opts.Expiry,
+		Method:      opts.Method,
+		ContentType: opts.ContentType,
+	})
+}
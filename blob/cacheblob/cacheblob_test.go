@@ -0,0 +1,405 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cacheblob
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opencensus.io/stats/view"
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/driver"
+	"gocloud.dev/blob/drivertest"
+	"gocloud.dev/blob/fileblob"
+	"gocloud.dev/blob/memblob"
+	"gocloud.dev/internal/oc"
+)
+
+type harness struct {
+	remote, local *blob.Bucket
+	dir           string
+	server        *httptest.Server
+}
+
+// newHarness uses a fileblob remote bucket, so that SignedURL is tested.
+func newHarness(ctx context.Context, t *testing.T) (drivertest.Harness, error) {
+	dir, err := ioutil.TempDir("", "go-cloud-cacheblob")
+	if err != nil {
+		return nil, err
+	}
+	server := httptest.NewUnstartedServer(nil)
+	u, err := url.Parse("http://" + server.Listener.Addr().String())
+	if err != nil {
+		return nil, err
+	}
+	signer := fileblob.NewURLSignerHMAC(u, []byte("secret"))
+	remote, err := fileblob.OpenBucket(dir, &fileblob.Options{URLSigner: signer})
+	if err != nil {
+		return nil, err
+	}
+	server.Config.Handler = fileblob.NewSignedURLHandler(remote, signer)
+	server.Start()
+	return &harness{remote: remote, local: memblob.OpenBucket(nil), dir: dir, server: server}, nil
+}
+
+func (h *harness) HTTPClient() *http.Client {
+	return h.server.Client()
+}
+
+func (h *harness) MakeDriver(ctx context.Context) (driver.Bucket, error) {
+	return openBucket(h.remote, h.local, &Options{TTL: time.Hour}), nil
+}
+
+func (h *harness) Close() {
+	h.server.Close()
+	h.remote.Close()
+	h.local.Close()
+	_ = os.RemoveAll(h.dir)
+}
+
+func TestConformance(t *testing.T) {
+	drivertest.RunConformanceTests(t, newHarness, nil)
+}
+
+// counts returns the current values of the hits, misses and evictions views.
+func counts(t *testing.T) [3]int64 {
+	var got [3]int64
+	for i, v := range OpenCensusViews {
+		rows, err := view.RetrieveData(v.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, row := range rows {
+			// The tests' remote buckets are memblob buckets.
+			if len(row.Tags) != 1 || row.Tags[0].Key != oc.ProviderKey || row.Tags[0].Value != "gocloud.dev/blob/memblob" {
+				t.Errorf("%s: got tags %v, want the memblob provider", v.Name, row.Tags)
+			}
+			got[i] += int64(row.Data.(*view.SumData).Value)
+		}
+	}
+	return got
+}
+
+// read reads key from b, and checks that its content is want.
+func read(t *testing.T, b *blob.Bucket, key, want string) {
+	t.Helper()
+	got, err := b.ReadAll(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("%s: got %q want %q", key, got, want)
+	}
+}
+
+// checkCounts checks that the hits, misses and evictions counted since start
+// are want.
+func checkCounts(t *testing.T, start [3]int64, want [3]int64) {
+	t.Helper()
+	got := counts(t)
+	for i := range got {
+		got[i] -= start[i]
+	}
+	if got != want {
+		t.Errorf("got hits, misses, evictions %v want %v", got, want)
+	}
+}
+
+func TestCache(t *testing.T) {
+	if err := view.Register(OpenCensusViews...); err != nil {
+		t.Fatal(err)
+	}
+	defer view.Unregister(OpenCensusViews...)
+	ctx := context.Background()
+
+	t.Run("TTL", func(t *testing.T) {
+		remote, local := memblob.OpenBucket(nil), memblob.OpenBucket(nil)
+		b := OpenBucket(remote, local, &Options{TTL: time.Hour})
+		defer b.Close()
+		if err := remote.WriteAll(ctx, "key", []byte("v1"), nil); err != nil {
+			t.Fatal(err)
+		}
+		start := counts(t)
+		read(t, b, "key", "v1")
+		read(t, b, "key", "v1")
+		checkCounts(t, start, [3]int64{1, 1, 0})
+		read(t, local, "key", "v1")
+
+		// Changes to the remote bucket aren't noticed until the TTL expires.
+		if err := remote.WriteAll(ctx, "key", []byte("v2"), nil); err != nil {
+			t.Fatal(err)
+		}
+		read(t, b, "key", "v1")
+		// Writes through the caching bucket invalidate the cached copy.
+		if err := b.WriteAll(ctx, "key", []byte("v3"), nil); err != nil {
+			t.Fatal(err)
+		}
+		read(t, b, "key", "v3")
+		if err := b.Delete(ctx, "key"); err != nil {
+			t.Fatal(err)
+		}
+		if ok, _ := local.Exists(ctx, "key"); ok {
+			t.Error("cached copy wasn't deleted")
+		}
+	})
+
+	t.Run("Revalidation", func(t *testing.T) {
+		remote, local := memblob.OpenBucket(nil), memblob.OpenBucket(nil)
+		b := OpenBucket(remote, local, nil)
+		defer b.Close()
+		if err := remote.WriteAll(ctx, "key", []byte("v1"), nil); err != nil {
+			t.Fatal(err)
+		}
+		start := counts(t)
+		read(t, b, "key", "v1")
+		read(t, b, "key", "v1")
+		if err := remote.WriteAll(ctx, "key", []byte("v2"), nil); err != nil {
+			t.Fatal(err)
+		}
+		read(t, b, "key", "v2")
+		checkCounts(t, start, [3]int64{1, 2, 0})
+
+		// Deleting the remote blob removes the cached copy on the next read.
+		if err := remote.Delete(ctx, "key"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := b.ReadAll(ctx, "key"); err == nil {
+			t.Error("got nil error reading deleted blob")
+		}
+		if ok, _ := local.Exists(ctx, "key"); ok {
+			t.Error("cached copy wasn't deleted")
+		}
+	})
+
+	t.Run("Eviction", func(t *testing.T) {
+		remote, local := memblob.OpenBucket(nil), memblob.OpenBucket(nil)
+		b := OpenBucket(remote, local, &Options{MaxSize: 10, TTL: time.Hour})
+		defer b.Close()
+		for key, content := range map[string]string{"a": "aaaa", "b": "bbbb", "c": "cccc", "big": "0123456789a"} {
+			if err := remote.WriteAll(ctx, key, []byte(content), nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		start := counts(t)
+		read(t, b, "a", "aaaa")
+		read(t, b, "b", "bbbb")
+		read(t, b, "a", "aaaa")
+		// Reading c evicts b, the least recently used.
+		read(t, b, "c", "cccc")
+		// Blobs larger than MaxSize are not cached.
+		read(t, b, "big", "0123456789a")
+		checkCounts(t, start, [3]int64{1, 4, 1})
+		for key, want := range map[string]bool{"a": true, "b": false, "c": true, "big": false} {
+			if got, _ := local.Exists(ctx, key); got != want {
+				t.Errorf("%s: got cached %v want %v", key, got, want)
+			}
+		}
+	})
+
+	t.Run("Ranges", func(t *testing.T) {
+		remote, local := memblob.OpenBucket(nil), memblob.OpenBucket(nil)
+		b := OpenBucket(remote, local, &Options{TTL: time.Hour})
+		defer b.Close()
+		if err := remote.WriteAll(ctx, "key", []byte("hello world"), &blob.WriterOptions{ContentType: "text/x-test"}); err != nil {
+			t.Fatal(err)
+		}
+		attrs, err := remote.Attributes(ctx, "key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := b.NewRangeReader(ctx, "key", 6, 3, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != "wor" {
+			t.Errorf("got %q want %q", got, "wor")
+		}
+		if r.Size() != 11 || r.ContentType() != "text/x-test" || !r.ModTime().Equal(attrs.ModTime) {
+			t.Errorf("got size %d, content type %q, mod time %v; want the remote blob's", r.Size(), r.ContentType(), r.ModTime())
+		}
+	})
+}
+
+// noETagBucket is a driver.Bucket whose blobs have no ETag.
+type noETagBucket struct {
+	driver.Bucket
+}
+
+func (b *noETagBucket) Attributes(ctx context.Context, key string) (*driver.Attributes, error) {
+	a, err := b.Bucket.Attributes(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	a.ETag = ""
+	return a, nil
+}
+
+// TestNoETag checks that blobs without an ETag are served from the cache.
+func TestNoETag(t *testing.T) {
+	ctx := context.Background()
+	mem := memblob.OpenBucket(nil)
+	defer mem.Close()
+	if err := mem.WriteAll(ctx, "key", []byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+	// A caching bucket with an empty cache just reads from mem.
+	remote := blob.NewBucket(&noETagBucket{openBucket(mem, memblob.OpenBucket(nil), &Options{MaxSize: 1})})
+	defer remote.Close()
+	b := OpenBucket(remote, memblob.OpenBucket(nil), &Options{TTL: time.Hour})
+	defer b.Close()
+	read(t, b, "key", "hello")
+	// The second read is served from the cache.
+	read(t, b, "key", "hello")
+}
+
+// TestReuse checks that blobs cached in a fileblob directory are reused by
+// another caching bucket.
+func TestReuse(t *testing.T) {
+	if err := view.Register(OpenCensusViews...); err != nil {
+		t.Fatal(err)
+	}
+	defer view.Unregister(OpenCensusViews...)
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "go-cloud-cacheblob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	remote := memblob.OpenBucket(nil)
+	defer remote.Close()
+	if err := remote.WriteAll(ctx, "key", []byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		local, err := fileblob.OpenBucket(dir, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := OpenBucket(remote, local, nil)
+		start := counts(t)
+		read(t, b, "key", "hello")
+		want := [3]int64{1, 0, 0}
+		if i == 0 {
+			want = [3]int64{0, 1, 0}
+		}
+		checkCounts(t, start, want)
+		b.Close()
+		local.Close()
+	}
+}
+
+func TestSignedURLMethods(t *testing.T) {
+	ctx := context.Background()
+	hh, err := newHarness(ctx, t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := hh.(*harness)
+	defer h.Close()
+	drv, err := h.MakeDriver(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := blob.NewBucket(drv)
+	defer b.Close()
+
+	// A PUT URL writes the remote blob, with the signed content type.
+	surl, err := b.SignedURL(ctx, "key", &blob.SignedURLOptions{Method: http.MethodPut, ContentType: "text/plain"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPut, surl, strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	resp, err := h.HTTPClient().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("PUT: got status %v", resp.Status)
+	}
+	read(t, h.remote, "key", "hello")
+
+	// A DELETE URL deletes it.
+	surl, err = b.SignedURL(ctx, "key", &blob.SignedURLOptions{Method: http.MethodDelete})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err = http.NewRequest(http.MethodDelete, surl, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = h.HTTPClient().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("DELETE: got status %v", resp.Status)
+	}
+	if exists, err := h.remote.Exists(ctx, "key"); err != nil || exists {
+		t.Errorf("after DELETE: got exists %v, error %v", exists, err)
+	}
+}
+
+func TestOpenBucketFromURL(t *testing.T) {
+	local := url.QueryEscape("mem://")
+	tests := []struct {
+		URL     string
+		WantErr bool
+	}{
+		// OK.
+		{"cache+mem://?local=" + local, false},
+		// OK, setting maxsize and ttl.
+		{"cache+mem://?local=" + local + "&maxsize=1000&ttl=1m", false},
+		// Missing local.
+		{"cache+mem://", true},
+		// Invalid local.
+		{"cache+mem://?local=foo%3A%2F%2F", true},
+		// Invalid maxsize.
+		{"cache+mem://?local=" + local + "&maxsize=big", true},
+		// Invalid ttl.
+		{"cache+mem://?local=" + local + "&ttl=-1m", true},
+		// Invalid parameter for the remote bucket.
+		{"cache+mem://?local=" + local + "&param=value", true},
+	}
+
+	ctx := context.Background()
+	for _, test := range tests {
+		b, err := blob.OpenBucket(ctx, test.URL)
+		if (err != nil) != test.WantErr {
+			t.Errorf("%s: got error %v, want error %v", test.URL, err, test.WantErr)
+		}
+		if err == nil {
+			b.Close()
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blob
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"hash"
+	"hash/crc32"
+
+	"gocloud.dev/blob/driver"
+	"gocloud.dev/internal/gcerr"
+)
+
+// crc32cTable is the table for CRC32C checksums, which use the Castagnoli
+// polynomial.
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// checksum is a hash of a blob's content, compared against a known value.
+type checksum struct {
+	name string // the name of the Attributes field, like "MD5"
+	want []byte
+	h    hash.Hash
+}
+
+// newChecksum returns a checksum of the given kind, which must be "MD5",
+// "CRC32C" or "SHA256".
+func newChecksum(name string, want []byte) *checksum {
+	c := &checksum{name: name, want: want}
+	switch name {
+	case "MD5":
+		c.h = md5.New()
+	case "CRC32C":
+		c.h = crc32.New(crc32cTable)
+	case "SHA256":
+		c.h = sha256.New()
+	default:
+		panic("blob: unknown checksum " + name)
+	}
+	return c
+}
+
+// matches reports whether the hash of the bytes written to c matches
+// c.want, and returns the hash.
+func (c *checksum) matches() (bool, []byte) {
+	sum := c.h.Sum(nil)
+	return bytes.Equal(sum, c.want), sum
+}
+
+// checksums computes the MD5, CRC32C and SHA-256 hashes of the bytes written
+// to it, so that they can be compared against those reported by a provider.
+type checksums struct {
+	md5, crc32c, sha256 hash.Hash
+}
+
+func newChecksums() *checksums {
+	return &checksums{md5: md5.New(), crc32c: crc32.New(crc32cTable), sha256: sha256.New()}
+}
+
+func (c *checksums) Write(p []byte) (int, error) {
+	c.md5.Write(p)
+	c.crc32c.Write(p)
+	c.sha256.Write(p)
+	return len(p), nil
+}
+
+// verify returns a DataLoss error if any of the checksums in a don't match
+// the ones computed by c. Checksums that a doesn't have are ignored.
+func (c *checksums) verify(a *driver.Attributes) error {
+	for _, s := range []struct {
+		name string
+		got  []byte
+		h    hash.Hash
+	}{
+		{"MD5", a.MD5, c.md5},
+		{"CRC32C", a.CRC32C, c.crc32c},
+		{"SHA256", a.SHA256, c.sha256},
+	} {
+		if len(s.got) == 0 {
+			continue
+		}
+		if sum := s.h.Sum(nil); !bytes.Equal(sum, s.got) {
+			return gcerr.Newf(gcerr.DataLoss, nil, "blob: the %s of the written blob (%X) does not match what was written (%X)", s.name, s.got, sum)
+		}
+	}
+	return nil
+}
+
+// readChecksum returns the checksum used to verify the content of a blob
+// with attributes a, or nil if a has no checksums. CRC32C is preferred
+// because it is the cheapest to compute.
+func readChecksum(a *driver.ReaderAttributes) *checksum {
+	switch {
+	case len(a.CRC32C) > 0:
+		return newChecksum("CRC32C", a.CRC32C)
+	case len(a.MD5) > 0:
+		return newChecksum("MD5", a.MD5)
+	case len(a.SHA256) > 0:
+		return newChecksum("SHA256", a.SHA256)
+	}
+	return nil
+}
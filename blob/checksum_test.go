@@ -0,0 +1,153 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blob_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/driver"
+	"gocloud.dev/blob/fileblob"
+	"gocloud.dev/blob/memblob"
+	"gocloud.dev/gcerrors"
+)
+
+func crc32c(p []byte) []byte {
+	sum := make([]byte, 4)
+	binary.BigEndian.PutUint32(sum, crc32.Checksum(p, crc32.MakeTable(crc32.Castagnoli)))
+	return sum
+}
+
+func TestWriterContentChecksums(t *testing.T) {
+	ctx := context.Background()
+	b := memblob.OpenBucket(nil)
+	defer b.Close()
+
+	content := []byte("hello")
+	sha := sha256.Sum256(content)
+	for _, test := range []struct {
+		name string
+		opts *blob.WriterOptions
+		want gcerrors.ErrorCode
+	}{
+		{"CRC32C", &blob.WriterOptions{ContentCRC32C: crc32c(content)}, gcerrors.OK},
+		{"SHA256", &blob.WriterOptions{ContentSHA256: sha[:]}, gcerrors.OK},
+		{"BadCRC32C", &blob.WriterOptions{ContentCRC32C: crc32c([]byte("goodbye"))}, gcerrors.FailedPrecondition},
+		{"BadSHA256", &blob.WriterOptions{ContentSHA256: []byte("bad")}, gcerrors.FailedPrecondition},
+		{"Compressed", &blob.WriterOptions{ContentCRC32C: crc32c(content), Compression: "gzip"}, gcerrors.OK},
+		{"Verified", &blob.WriterOptions{VerifyChecksums: true}, gcerrors.OK},
+		{"VerifiedCompressed", &blob.WriterOptions{VerifyChecksums: true, Compression: "gzip"}, gcerrors.OK},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := b.WriteAll(ctx, test.name, content, test.opts)
+			if got := gcerrors.Code(err); got != test.want {
+				t.Fatalf("got error %v, want code %v", err, test.want)
+			}
+			exists, err := b.Exists(ctx, test.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := test.want == gcerrors.OK; exists != want {
+				t.Errorf("got exists %v want %v", exists, want)
+			}
+		})
+	}
+}
+
+// badChecksumBucket implements driver.Bucket with a Writer that discards
+// the data written to it, and Attributes that report a wrong CRC32C.
+type badChecksumBucket struct {
+	driver.Bucket
+}
+
+type discardWriter struct{}
+
+func (discardWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (discardWriter) Close() error                { return nil }
+
+func (b *badChecksumBucket) NewTypedWriter(ctx context.Context, key string, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
+	return discardWriter{}, nil
+}
+
+func (b *badChecksumBucket) Attributes(ctx context.Context, key string) (*driver.Attributes, error) {
+	return &driver.Attributes{CRC32C: []byte{1, 2, 3, 4}}, nil
+}
+
+func (b *badChecksumBucket) ErrorCode(err error) gcerrors.ErrorCode { return gcerrors.Unknown }
+
+func (b *badChecksumBucket) Close() error { return nil }
+
+func TestWriterVerifyChecksums(t *testing.T) {
+	ctx := context.Background()
+	b := blob.NewBucket(&badChecksumBucket{})
+	defer b.Close()
+
+	// Without VerifyChecksums, the mismatch isn't noticed.
+	if err := b.WriteAll(ctx, "key", []byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+	err := b.WriteAll(ctx, "key", []byte("hello"), &blob.WriterOptions{VerifyChecksums: true})
+	if gcerrors.Code(err) != gcerrors.DataLoss {
+		t.Errorf("got error %v, want DataLoss", err)
+	}
+}
+
+func TestReaderVerifiesChecksum(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "go-cloud-blob-checksum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	b, err := fileblob.OpenBucket(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	if err := b.WriteAll(ctx, "key", []byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.ReadAll(ctx, "key"); err != nil {
+		t.Fatal(err)
+	}
+	// Corrupt the file, without changing its size or its attributes.
+	if err := ioutil.WriteFile(filepath.Join(dir, "key"), []byte("jello"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.ReadAll(ctx, "key"); gcerrors.Code(err) != gcerrors.DataLoss {
+		t.Errorf("got error %v, want DataLoss", err)
+	}
+	// Ranges of the blob aren't verified.
+	r, err := b.NewRangeReader(ctx, "key", 1, 3, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "ell" {
+		t.Errorf("got %q want %q", got, "ell")
+	}
+}
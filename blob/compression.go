@@ -0,0 +1,151 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blob
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"gocloud.dev/internal/gcerr"
+)
+
+// A Compressor compresses and decompresses blob contents for a content
+// encoding. See WriterOptions.Compression and ReaderOptions.Decompress.
+//
+// Compressors for "gzip" and "deflate" are built in; others, like "zstd" or
+// "br", can be added using RegisterCompressor.
+type Compressor interface {
+	// NewWriter returns a WriteCloser that compresses data written to it and
+	// writes the result to w. Close must flush any buffered data to w, but
+	// must not close w.
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+
+	// NewReader returns a ReadCloser that decompresses data read from r.
+	// Close must not close r.
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+var (
+	compressorsMu sync.RWMutex
+	compressors   = map[string]Compressor{
+		"gzip":    gzipCompressor{},
+		"deflate": deflateCompressor{},
+	}
+)
+
+// RegisterCompressor registers c as the Compressor for the content encoding
+// encoding, which is case-insensitive.
+// RegisterCompressor panics if a Compressor is already registered for
+// encoding.
+func RegisterCompressor(encoding string, c Compressor) {
+	encoding = strings.ToLower(encoding)
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+	if _, exists := compressors[encoding]; exists {
+		panic(fmt.Errorf("blob: a Compressor is already registered for encoding %q", encoding))
+	}
+	compressors[encoding] = c
+}
+
+// compressorFor returns the Compressor registered for encoding, or nil.
+func compressorFor(encoding string) Compressor {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+	return compressors[strings.ToLower(encoding)]
+}
+
+// gzipCompressor implements Compressor for the "gzip" content encoding.
+type gzipCompressor struct{}
+
+func (gzipCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+func (gzipCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// deflateCompressor implements Compressor for the "deflate" content encoding,
+// which is the zlib format (RFC 1950).
+type deflateCompressor struct{}
+
+func (deflateCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return zlib.NewWriter(w), nil
+}
+
+func (deflateCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return zlib.NewReader(r)
+}
+
+// readerFunc adapts a function to io.Reader.
+type readerFunc func([]byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) { return f(p) }
+
+// writerFunc adapts a function to io.Writer.
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+// decompressor reads the decompressed content of a blob, discarding the first
+// skip bytes and returning at most remaining bytes if remaining >= 0.
+type decompressor struct {
+	zr        io.ReadCloser
+	skip      int64
+	remaining int64
+}
+
+func (d *decompressor) Read(p []byte) (int, error) {
+	if d.skip > 0 {
+		n, err := io.CopyN(ioutil.Discard, d.zr, d.skip)
+		d.skip -= n
+		if err != nil {
+			return 0, err
+		}
+	}
+	if d.remaining == 0 {
+		return 0, io.EOF
+	}
+	if d.remaining > 0 && int64(len(p)) > d.remaining {
+		p = p[:d.remaining]
+	}
+	n, err := d.zr.Read(p)
+	if d.remaining > 0 {
+		d.remaining -= int64(n)
+	}
+	return n, err
+}
+
+func (d *decompressor) Close() error {
+	return d.zr.Close()
+}
+
+// wrapCompressionError wraps an error returned by a Compressor's reader or
+// writer. Errors from the underlying driver have already been wrapped; any
+// other errors indicate invalid compressed data.
+func wrapCompressionError(err error) error {
+	if err == nil || gcerr.DoNotWrap(err) {
+		return err
+	}
+	if _, ok := err.(*gcerr.Error); ok {
+		return err
+	}
+	return gcerr.New(gcerr.Internal, err, 2, "blob")
+}
@@ -0,0 +1,198 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blob_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/fileblob"
+	"gocloud.dev/blob/memblob"
+	"gocloud.dev/gcerrors"
+)
+
+// invertCompressor is a test Compressor that inverts the bits of each byte.
+type invertCompressor struct{}
+
+type invertWriter struct{ w io.Writer }
+
+func (w invertWriter) Write(p []byte) (int, error) { return w.w.Write(invertBits(p)) }
+func (w invertWriter) Close() error                { return nil }
+
+type invertReader struct{ r io.Reader }
+
+func (r invertReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	copy(p, invertBits(p[:n]))
+	return n, err
+}
+func (r invertReader) Close() error { return nil }
+
+func invertBits(p []byte) []byte {
+	q := make([]byte, len(p))
+	for i, c := range p {
+		q[i] = ^c
+	}
+	return q
+}
+
+func (invertCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) { return invertWriter{w}, nil }
+func (invertCompressor) NewReader(r io.Reader) (io.ReadCloser, error)  { return invertReader{r}, nil }
+
+func init() {
+	blob.RegisterCompressor("x-test-invert", invertCompressor{})
+}
+
+func TestCompression(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "go-cloud-compression")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fb, err := fileblob.OpenBucket(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fb.Close()
+	mb := memblob.OpenBucket(nil)
+	defer mb.Close()
+
+	content := []byte(strings.Repeat("<html>hello compressed world</html>\n", 1000))
+	for _, bkt := range []struct {
+		name string
+		b    *blob.Bucket
+	}{{"memblob", mb}, {"fileblob", fb}} {
+		for _, enc := range []string{"gzip", "deflate", "x-test-invert"} {
+			t.Run(bkt.name+"/"+enc, func(t *testing.T) {
+				b := bkt.b
+				const key = "blob-for-compression"
+				if err := b.WriteAll(ctx, key, content, &blob.WriterOptions{Compression: enc}); err != nil {
+					t.Fatal(err)
+				}
+				attrs, err := b.Attributes(ctx, key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if attrs.ContentEncoding != enc {
+					t.Errorf("got ContentEncoding %q want %q", attrs.ContentEncoding, enc)
+				}
+				// The content type is detected from the uncompressed data.
+				if want := "text/html; charset=utf-8"; attrs.ContentType != want {
+					t.Errorf("got ContentType %q want %q", attrs.ContentType, want)
+				}
+				if enc != "x-test-invert" && attrs.Size >= int64(len(content)) {
+					t.Errorf("got compressed size %d, want less than %d", attrs.Size, len(content))
+				}
+
+				// Without Decompress, the stored content is returned.
+				stored, err := b.ReadAll(ctx, key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if bytes.Equal(stored, content) {
+					t.Error("stored content is not compressed")
+				}
+				if enc == "gzip" {
+					zr, err := gzip.NewReader(bytes.NewReader(stored))
+					if err != nil {
+						t.Fatal(err)
+					}
+					if got, err := ioutil.ReadAll(zr); err != nil || !bytes.Equal(got, content) {
+						t.Errorf("stored content doesn't decompress with compress/gzip: %v", err)
+					}
+				}
+
+				for _, r := range [][2]int64{{0, -1}, {0, 10}, {100, 50}, {100, -1}, {int64(len(content)) - 3, 10}, {5, 0}} {
+					offset, length := r[0], r[1]
+					rd, err := b.NewRangeReader(ctx, key, offset, length, &blob.ReaderOptions{Decompress: true})
+					if err != nil {
+						t.Fatal(err)
+					}
+					got, err := ioutil.ReadAll(rd)
+					rd.Close()
+					if err != nil {
+						t.Fatalf("range %v: %v", r, err)
+					}
+					end := int64(len(content))
+					if length >= 0 && offset+length < end {
+						end = offset + length
+					}
+					if !bytes.Equal(got, content[offset:end]) {
+						t.Errorf("range %v: got %q want %q", r, got, content[offset:end])
+					}
+					if rd.ContentEncoding() != enc {
+						t.Errorf("range %v: got reader ContentEncoding %q want %q", r, rd.ContentEncoding(), enc)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestCompressionErrors(t *testing.T) {
+	ctx := context.Background()
+	b := memblob.OpenBucket(nil)
+	defer b.Close()
+
+	// Unknown encodings and conflicting ContentEncodings are rejected.
+	for _, opts := range []*blob.WriterOptions{
+		{Compression: "unknown"},
+		{Compression: "gzip", ContentEncoding: "deflate"},
+	} {
+		if _, err := b.NewWriter(ctx, "key", opts); gcerrors.Code(err) != gcerrors.InvalidArgument {
+			t.Errorf("%+v: got %v want InvalidArgument error", opts, err)
+		}
+	}
+
+	// ContentMD5 is verified against the uncompressed data.
+	err := b.WriteAll(ctx, "key", []byte("hello"), &blob.WriterOptions{Compression: "gzip", ContentMD5: []byte("wrong md5")})
+	if gcerrors.Code(err) != gcerrors.FailedPrecondition {
+		t.Errorf("got %v want FailedPrecondition error", err)
+	}
+
+	// Invalid compressed data results in an Internal error.
+	if err := b.WriteAll(ctx, "key", []byte("not gzip"), &blob.WriterOptions{ContentEncoding: "gzip"}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := b.NewReader(ctx, "key", &blob.ReaderOptions{Decompress: true})
+	if err == nil {
+		_, err = ioutil.ReadAll(r)
+		r.Close()
+	}
+	if gcerrors.Code(err) != gcerrors.Internal {
+		t.Errorf("got %v want Internal error", err)
+	}
+
+	// Blobs without a known encoding are returned as-is.
+	if err := b.WriteAll(ctx, "key", []byte("hello"), &blob.WriterOptions{ContentEncoding: "identity"}); err != nil {
+		t.Fatal(err)
+	}
+	r, err = b.NewRangeReader(ctx, "key", 1, 3, &blob.ReaderOptions{Decompress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if got, err := ioutil.ReadAll(r); err != nil || string(got) != "ell" {
+		t.Errorf("got %q, %v want %q", got, err, "ell")
+	}
+}
@@ -0,0 +1,220 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blob
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"gocloud.dev/blob/driver"
+	"gocloud.dev/gcerrors"
+	"gocloud.dev/internal/gcerr"
+)
+
+const (
+	// deleteManyConcurrency is the number of blobs that DeleteMany deletes
+	// concurrently for providers that don't support batch deletes.
+	deleteManyConcurrency = 16
+	// deletePrefixBatchSize is the maximum number of keys that DeletePrefix
+	// passes to each call to DeleteMany.
+	deletePrefixBatchSize = 1000
+)
+
+// A DeleteManyError is returned by DeleteMany and DeletePrefix. It contains
+// the errors for the blobs that could not be deleted, and their keys.
+type DeleteManyError []DeleteManyFailure
+
+// A DeleteManyFailure is the error for a single blob in a DeleteManyError.
+type DeleteManyFailure struct {
+	// Key is the key of the blob that could not be deleted.
+	Key string
+	// Err is the reason it could not be deleted.
+	Err error
+}
+
+func (e DeleteManyError) Error() string {
+	var s []string
+	for _, x := range e {
+		s = append(s, fmt.Sprintf("%q: %v", x.Key, x.Err))
+	}
+	return "blob: failed to delete blobs: " + strings.Join(s, "; ")
+}
+
+// Unwrap returns the error in e, if there is exactly one. If there is more
+// than one error, Unwrap returns nil, since there is no way to determine
+// which should be returned.
+func (e DeleteManyError) Unwrap() error {
+	if len(e) == 1 {
+		return e[0].Err
+	}
+	return nil
+}
+
+// DeleteMany deletes the blobs stored at keys. Unlike Delete, it is not an
+// error for a blob not to exist.
+//
+// If the provider supports deleting many blobs at once, DeleteMany uses it;
+// otherwise, it deletes the blobs concurrently.
+//
+// If any blobs can't be deleted, DeleteMany returns a DeleteManyError with
+// the key and error for each of them; other blobs may have been deleted. As
+// a special case, no blobs are deleted if any key is invalid.
+func (b *Bucket) DeleteMany(ctx context.Context, keys []string) (err error) {
+	var dmerr DeleteManyError
+	seen := map[string]bool{}
+	var dkeys []string
+	for _, key := range keys {
+		if !utf8.ValidString(key) {
+			dmerr = append(dmerr, DeleteManyFailure{Key: key, Err: gcerr.Newf(gcerr.InvalidArgument, nil, "blob: DeleteMany key must be a valid UTF-8 string: %q", key)})
+			continue
+		}
+		if !seen[key] {
+			seen[key] = true
+			dkeys = append(dkeys, key)
+		}
+	}
+	if len(dmerr) > 0 {
+		return dmerr
+	}
+	if len(dkeys) == 0 {
+		return nil
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return errClosed
+	}
+	ctx = b.tracer.Start(ctx, "DeleteMany")
+	defer func() { b.tracer.End(ctx, err) }()
+
+	var derr driver.DeleteManyError
+	if bd, ok := b.b.(driver.BatchDeleter); ok {
+		derr = bd.DeleteMany(ctx, dkeys)
+	} else {
+		derr = deleteManyConcurrently(ctx, b.b, dkeys)
+	}
+	for _, e := range derr {
+		dmerr = append(dmerr, DeleteManyFailure{Key: dkeys[e.Index], Err: wrapError(b.b, e.Err)})
+	}
+	if len(dmerr) > 0 {
+		return dmerr
+	}
+	return nil
+}
+
+// deleteManyConcurrently implements DeleteMany for drivers that don't
+// implement driver.BatchDeleter, by calling Delete for each key. If ctx is
+// done, it stops starting new deletes and reports ctx.Err() for the keys that
+// were not attempted.
+func deleteManyConcurrently(ctx context.Context, b driver.Bucket, keys []string) driver.DeleteManyError {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs driver.DeleteManyError
+	)
+	indexes := make(chan int)
+	for i := 0; i < deleteManyConcurrency && i < len(keys); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				err := b.Delete(ctx, keys[i], &driver.DeleteOptions{})
+				if err != nil && b.ErrorCode(err) != gcerrors.NotFound {
+					mu.Lock()
+					errs = append(errs, driver.DeleteManyFailure{Index: i, Err: err})
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	next := 0
+feed:
+	for ; next < len(keys); next++ {
+		select {
+		case indexes <- next:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(indexes)
+	wg.Wait()
+	for i := next; i < len(keys); i++ {
+		errs = append(errs, driver.DeleteManyFailure{Index: i, Err: ctx.Err()})
+	}
+	return errs
+}
+
+// DeletePrefix deletes all the blobs whose keys start with prefix. If prefix
+// is empty, it deletes every blob in the bucket. The blobs are deleted in
+// batches using DeleteMany as they are listed, so blobs written while
+// DeletePrefix is running may or may not be deleted.
+//
+// If any blobs can't be deleted, DeletePrefix returns a DeleteManyError with
+// the key and error for each of them, after attempting to delete the rest.
+// If listing the blobs fails, DeletePrefix returns that error.
+func (b *Bucket) DeletePrefix(ctx context.Context, prefix string) (err error) {
+	if !utf8.ValidString(prefix) {
+		return gcerr.Newf(gcerr.InvalidArgument, nil, "blob: DeletePrefix prefix must be a valid UTF-8 string: %q", prefix)
+	}
+	b.mu.RLock()
+	closed := b.closed
+	b.mu.RUnlock()
+	if closed {
+		return errClosed
+	}
+	ctx = b.tracer.Start(ctx, "DeletePrefix")
+	defer func() { b.tracer.End(ctx, err) }()
+
+	var dmerr DeleteManyError
+	deleteBatch := func(keys []string) error {
+		err := b.DeleteMany(ctx, keys)
+		if e, ok := err.(DeleteManyError); ok {
+			dmerr = append(dmerr, e...)
+			return nil
+		}
+		return err
+	}
+	var keys []string
+	iter := b.List(&ListOptions{Prefix: prefix})
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		keys = append(keys, obj.Key)
+		if len(keys) == deletePrefixBatchSize {
+			if err := deleteBatch(keys); err != nil {
+				return err
+			}
+			keys = nil
+		}
+	}
+	if len(keys) > 0 {
+		if err := deleteBatch(keys); err != nil {
+			return err
+		}
+	}
+	if len(dmerr) > 0 {
+		return dmerr
+	}
+	return nil
+}
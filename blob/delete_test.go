@@ -0,0 +1,210 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blob_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/driver"
+	"gocloud.dev/blob/fileblob"
+	"gocloud.dev/blob/memblob"
+	"gocloud.dev/gcerrors"
+)
+
+func TestDeleteMany(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "go-cloud-blob-delete")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, test := range []struct {
+		name string
+		open func(t *testing.T, name string) *blob.Bucket
+	}{
+		// memblob implements driver.BatchDeleter.
+		{"memblob", func(t *testing.T, name string) *blob.Bucket { return memblob.OpenBucket(nil) }},
+		// fileblob doesn't, so DeleteMany deletes the blobs concurrently.
+		{"fileblob", func(t *testing.T, name string) *blob.Bucket {
+			if err := os.Mkdir(dir+"/"+name, 0777); err != nil {
+				t.Fatal(err)
+			}
+			b, err := fileblob.OpenBucket(dir+"/"+name, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return b
+		}},
+	} {
+		for _, prefixed := range []bool{false, true} {
+			name := test.name
+			if prefixed {
+				name += "Prefixed"
+			}
+			t.Run(name, func(t *testing.T) {
+				b := test.open(t, name)
+				defer b.Close()
+				if prefixed {
+					if err := b.WriteAll(ctx, "other", nil, nil); err != nil {
+						t.Fatal(err)
+					}
+					b = blob.PrefixedBucket(b, "p/")
+				}
+				for _, key := range []string{"a", "b", "c", "dir/d", "dir/sub/e"} {
+					if err := b.WriteAll(ctx, key, []byte(key), nil); err != nil {
+						t.Fatal(err)
+					}
+				}
+				// Blobs that don't exist and duplicate keys are not errors.
+				if err := b.DeleteMany(ctx, []string{"a", "b", "missing", "a"}); err != nil {
+					t.Fatal(err)
+				}
+				if got, want := listKeys(t, b, nil), []string{"c", "dir/d", "dir/sub/e"}; !cmp.Equal(got, want) {
+					t.Errorf("after DeleteMany, got %v want %v", got, want)
+				}
+				if err := b.DeletePrefix(ctx, "dir/"); err != nil {
+					t.Fatal(err)
+				}
+				if got, want := listKeys(t, b, nil), []string{"c"}; !cmp.Equal(got, want) {
+					t.Errorf("after DeletePrefix, got %v want %v", got, want)
+				}
+				if err := b.DeletePrefix(ctx, ""); err != nil {
+					t.Fatal(err)
+				}
+				if got := listKeys(t, b, nil); len(got) != 0 {
+					t.Errorf("after DeletePrefix of everything, got %v want none", got)
+				}
+			})
+		}
+	}
+}
+
+var errDeleteFailed = errors.New("delete failed")
+
+// fakeDeleteBucket implements driver.Bucket with Delete, which fails for
+// keys starting with "fail" and reports keys starting with "missing" as not
+// found.
+type fakeDeleteBucket struct {
+	driver.Bucket
+
+	mu      sync.Mutex
+	deleted []string
+}
+
+func (b *fakeDeleteBucket) Delete(ctx context.Context, key string, opts *driver.DeleteOptions) error {
+	switch {
+	case strings.HasPrefix(key, "fail"):
+		return errDeleteFailed
+	case strings.HasPrefix(key, "missing"):
+		return os.ErrNotExist
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.deleted = append(b.deleted, key)
+	return nil
+}
+
+func (b *fakeDeleteBucket) ErrorCode(err error) gcerrors.ErrorCode {
+	if err == os.ErrNotExist {
+		return gcerrors.NotFound
+	}
+	return gcerrors.Internal
+}
+
+func (b *fakeDeleteBucket) ErrorAs(err error, i interface{}) bool { return false }
+
+func (b *fakeDeleteBucket) Close() error { return nil }
+
+func TestDeleteManyErrors(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeDeleteBucket{}
+	b := blob.NewBucket(fake)
+	defer b.Close()
+
+	err := b.DeleteMany(ctx, []string{"ok1", "fail1", "missing", "ok2", "fail2"})
+	dmerr, ok := err.(blob.DeleteManyError)
+	if !ok {
+		t.Fatalf("got error %v, want a DeleteManyError", err)
+	}
+	var failed []string
+	for _, e := range dmerr {
+		failed = append(failed, e.Key)
+		if gcerrors.Code(e.Err) != gcerrors.Internal {
+			t.Errorf("%s: got error code %v want %v", e.Key, gcerrors.Code(e.Err), gcerrors.Internal)
+		}
+	}
+	sort.Strings(failed)
+	if want := []string{"fail1", "fail2"}; !cmp.Equal(failed, want) {
+		t.Errorf("got failed keys %v want %v", failed, want)
+	}
+	sort.Strings(fake.deleted)
+	if want := []string{"ok1", "ok2"}; !cmp.Equal(fake.deleted, want) {
+		t.Errorf("got deleted keys %v want %v", fake.deleted, want)
+	}
+
+	// With a single failure, the error can be unwrapped.
+	err = b.DeleteMany(ctx, []string{"fail1"})
+	if gcerrors.Code(err) != gcerrors.Internal {
+		t.Errorf("got error code %v want %v", gcerrors.Code(err), gcerrors.Internal)
+	}
+
+	// Nothing is deleted if a key is invalid.
+	fake.deleted = nil
+	err = b.DeleteMany(ctx, []string{"ok1", "\xF4\x90\x80\x80"})
+	if gcerrors.Code(err) != gcerrors.InvalidArgument {
+		t.Errorf("got error code %v want %v", gcerrors.Code(err), gcerrors.InvalidArgument)
+	}
+	if len(fake.deleted) != 0 {
+		t.Errorf("got deleted keys %v want none", fake.deleted)
+	}
+}
+
+func TestDeleteManyCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	fake := &fakeDeleteBucket{}
+	b := blob.NewBucket(fake)
+	defer b.Close()
+
+	var keys []string
+	for i := 0; i < 1000; i++ {
+		keys = append(keys, fmt.Sprintf("ok%03d", i))
+	}
+	err := b.DeleteMany(ctx, keys)
+	dmerr, ok := err.(blob.DeleteManyError)
+	if !ok {
+		t.Fatalf("got error %v, want a DeleteManyError", err)
+	}
+	for _, e := range dmerr {
+		if gcerrors.Code(e.Err) != gcerrors.Canceled {
+			t.Errorf("%s: got error code %v want %v", e.Key, gcerrors.Code(e.Err), gcerrors.Canceled)
+		}
+	}
+	// Every key is either deleted or reported as failed.
+	if got := len(fake.deleted) + len(dmerr); got != len(keys) {
+		t.Errorf("got %d deleted and %d failed keys, want %d in total", len(fake.deleted), len(dmerr), len(keys))
+	}
+}
@@ -19,14 +19,26 @@ package driver // import "gocloud.dev/blob/driver"
 import (
 	"context"
 	"io"
+	"path"
+	"regexp"
 	"time"
 
 	"gocloud.dev/gcerrors"
 )
 
 // ReaderOptions controls Reader behaviors.
-// It is provided for future extensibility.
-type ReaderOptions struct{}
+type ReaderOptions struct {
+	// IfMatch, if not empty, means the read must fail unless the blob's
+	// current ETag matches it. "*" matches any ETag.
+	// If the precondition fails, NewRangeReader must return an error for
+	// which ErrorCode returns gcerrors.FailedPrecondition.
+	IfMatch string
+	// IfNoneMatch, if not empty, means the read must fail if the blob's
+	// current ETag matches it. "*" matches any ETag.
+	// If the precondition fails, NewRangeReader must return an error for
+	// which ErrorCode returns gcerrors.FailedPrecondition.
+	IfNoneMatch string
+}
 
 // Reader reads an object from the blob.
 type Reader interface {
@@ -52,6 +64,9 @@ type WriterOptions struct {
 	// write in a single request, if supported. Larger objects will be split into
 	// multiple requests.
 	BufferSize int
+	// MaxConcurrency changes the default maximum number of parts Writer
+	// uploads concurrently, if supported. If 0, the driver chooses a default.
+	MaxConcurrency int
 	// CacheControl specifies caching attributes that providers may use
 	// when serving the blob.
 	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Cache-Control
@@ -73,9 +88,32 @@ type WriterOptions struct {
 	// underlying network service to guarantee the integrity of the bytes in
 	// transit.
 	ContentMD5 []byte
+	// ContentCRC32C and ContentSHA256 are like ContentMD5, for the CRC32C
+	// (Castagnoli) checksum, in big-endian byte order, and the SHA-256 hash
+	// of the bytes written.
+	ContentCRC32C []byte
+	ContentSHA256 []byte
 	// Metadata holds key/value strings to be associated with the blob.
 	// Keys are guaranteed to be non-empty and lowercased.
 	Metadata map[string]string
+	// IfMatch, if not empty, means the write must fail unless a blob with
+	// key already exists and its ETag matches IfMatch. "*" matches any
+	// existing blob.
+	// The precondition must be evaluated atomically with the write, so it
+	// is typically checked during Close. If it fails, the write must have no
+	// effect and the error returned must be one for which ErrorCode returns
+	// gcerrors.FailedPrecondition.
+	IfMatch string
+	// IfNoneMatch, if not empty, means the write must fail if a blob with
+	// key already exists and its ETag matches IfNoneMatch. "*" matches any
+	// existing blob, so IfNoneMatch: "*" means "only create".
+	// Failures are reported as for IfMatch.
+	IfNoneMatch string
+	// ExpiresAt, if not zero, is the time at which the blob expires. It is
+	// only set for drivers that implement Expirer, which must stop returning
+	// the blob at that time; for others, the portable type records it in
+	// Metadata instead.
+	ExpiresAt time.Time
 	// BeforeWrite is a callback that must be called exactly once before
 	// any data is written, unless NewTypedWriter returns an error, in
 	// which case it should not be called.
@@ -86,12 +124,49 @@ type WriterOptions struct {
 
 // CopyOptions controls options for Copy.
 type CopyOptions struct {
+	// IfMatch and IfNoneMatch are preconditions on the destination blob;
+	// they have the same semantics as in WriterOptions.
+	IfMatch     string
+	IfNoneMatch string
+	// Attributes, if not nil, holds the attributes of the destination blob,
+	// replacing those of the source blob. Driver implementations must
+	// support it for copies from a blob to itself, which the portable type
+	// uses to update the attributes of a blob.
+	Attributes *WritableAttributes
 	// BeforeCopy is a callback that must be called before initiating the Copy.
 	// asFunc allows providers to expose provider-specific types;
 	// see Bucket.As for more details.
 	BeforeCopy func(asFunc func(interface{}) bool) error
 }
 
+// WritableAttributes holds the attributes of a blob that are set when it is
+// written. They have the same semantics as the fields of the same name in
+// WriterOptions and Attributes.
+type WritableAttributes struct {
+	CacheControl       string
+	ContentDisposition string
+	ContentEncoding    string
+	ContentLanguage    string
+	// ContentType is guaranteed to be non-empty.
+	ContentType string
+	// Metadata keys are guaranteed to be non-empty and lowercased.
+	Metadata map[string]string
+}
+
+// DeleteOptions controls options for Delete.
+type DeleteOptions struct {
+	// IfMatch, if not empty, means the delete must fail unless the blob's
+	// current ETag matches it. "*" matches any ETag.
+	// If the precondition fails, Delete must return an error for which
+	// ErrorCode returns gcerrors.FailedPrecondition.
+	IfMatch string
+	// IfNoneMatch, if not empty, means the delete must fail if the blob's
+	// current ETag matches it.
+	// If the precondition fails, Delete must return an error for which
+	// ErrorCode returns gcerrors.FailedPrecondition.
+	IfNoneMatch string
+}
+
 // ReaderAttributes contains a subset of attributes about a blob that are
 // accessible from Reader.
 type ReaderAttributes struct {
@@ -102,6 +177,23 @@ type ReaderAttributes struct {
 	ModTime time.Time
 	// Size is the size of the object in bytes.
 	Size int64
+	// ContentEncoding is the encoding of the bytes returned by the reader,
+	// if any. It is usually the blob's ContentEncoding, but it may be empty if
+	// the provider decoded the content before returning it.
+	// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Content-Encoding
+	ContentEncoding string
+	// MD5, CRC32C and SHA256 are checksums of the blob's content, as in
+	// Attributes, or nil if not available. The portable type uses them to
+	// verify the content when the whole blob is read, so they must be nil
+	// if the reader returns content that differs from the stored content.
+	MD5    []byte
+	CRC32C []byte
+	SHA256 []byte
+	// ETag is the ETag of the blob being read, as in Attributes, or "" if
+	// not available. The portable type passes it as ReaderOptions.IfMatch
+	// when it reads other ranges of the blob, so it must be empty if
+	// NewRangeReader doesn't support IfMatch.
+	ETag string
 }
 
 // Attributes contains attributes about a blob.
@@ -135,6 +227,20 @@ type Attributes struct {
 	Size int64
 	// MD5 is an MD5 hash of the blob contents or nil if not available.
 	MD5 []byte
+	// CRC32C is the CRC32C (Castagnoli) checksum of the blob contents, in
+	// big-endian byte order, or nil if not available.
+	CRC32C []byte
+	// SHA256 is a SHA-256 hash of the blob contents or nil if not available.
+	SHA256 []byte
+	// ETag is an opaque identifier for the current contents of the blob,
+	// or "" if not available. It must change whenever the blob is
+	// overwritten, and is the value compared against the IfMatch and
+	// IfNoneMatch preconditions.
+	ETag string
+	// ExpiresAt is the time at which the blob expires, as set by
+	// WriterOptions.ExpiresAt, or zero if it doesn't. Drivers that don't
+	// implement Expirer may leave it zero.
+	ExpiresAt time.Time
 	// AsFunc allows providers to expose provider-specific types;
 	// see Bucket.As for more details.
 	// If not set, no provider-specific types are supported.
@@ -165,6 +271,23 @@ type ListOptions struct {
 	// PageToken may be filled in with the NextPageToken from a previous
 	// ListPaged call.
 	PageToken []byte
+	// StartAfter, if not empty, indicates that only results with a key that
+	// sorts after StartAfter should be returned. This applies to
+	// "directories" too, using their key including the Delimiter.
+	StartAfter string
+	// Match, MatchRegexp, MinModTime, MaxModTime, MinSize and MaxSize
+	// restrict the blobs that should be returned; they don't apply to
+	// "directories". See Includes for their exact meaning.
+	//
+	// The portable type applies StartAfter and these filters to the results
+	// of ListPaged, so drivers may ignore them; they should use them to skip
+	// blobs early where the provider makes that cheap.
+	Match       string
+	MatchRegexp *regexp.Regexp
+	MinModTime  time.Time
+	MaxModTime  time.Time
+	MinSize     int64
+	MaxSize     int64
 	// BeforeList is a callback that must be called exactly once during ListPaged,
 	// before the underlying provider's list is executed.
 	// asFunc allows providers to expose provider-specific types;
@@ -172,6 +295,42 @@ type ListOptions struct {
 	BeforeList func(asFunc func(interface{}) bool) error
 }
 
+// Includes reports whether obj passes StartAfter and the filters in o:
+//  - its key sorts after StartAfter;
+//  - for blobs, the key matches the Match pattern, as for path.Match, and the
+//    MatchRegexp regular expression;
+//  - for blobs, the modification time is at or after MinModTime and before
+//    MaxModTime, and the size is at least MinSize and, if MaxSize is
+//    positive, at most MaxSize.
+// Zero values mean no restriction. A Match pattern that is malformed matches
+// nothing.
+func (o *ListOptions) Includes(obj *ListObject) bool {
+	if o.StartAfter != "" && obj.Key <= o.StartAfter {
+		return false
+	}
+	if obj.IsDir {
+		return true
+	}
+	if o.Match != "" {
+		if ok, _ := path.Match(o.Match, obj.Key); !ok {
+			return false
+		}
+	}
+	if o.MatchRegexp != nil && !o.MatchRegexp.MatchString(obj.Key) {
+		return false
+	}
+	if !o.MinModTime.IsZero() && obj.ModTime.Before(o.MinModTime) {
+		return false
+	}
+	if !o.MaxModTime.IsZero() && !obj.ModTime.Before(o.MaxModTime) {
+		return false
+	}
+	if obj.Size < o.MinSize || (o.MaxSize > 0 && obj.Size > o.MaxSize) {
+		return false
+	}
+	return true
+}
+
 // ListObject represents a specific blob object returned from ListPaged.
 type ListObject struct {
 	// Key is the key for this blob.
@@ -182,6 +341,13 @@ type ListObject struct {
 	Size int64
 	// MD5 is an MD5 hash of the blob contents or nil if not available.
 	MD5 []byte
+	// CRC32C and SHA256 are checksums of the blob contents or nil if not
+	// available. See Attributes.CRC32C and Attributes.SHA256.
+	CRC32C []byte
+	SHA256 []byte
+	// ETag is an opaque identifier for the current contents of the blob,
+	// or "" if not available. See Attributes.ETag.
+	ETag string
 	// IsDir indicates that this result represents a "directory" in the
 	// hierarchical namespace, ending in ListOptions.Delimiter. Key can be
 	// passed as ListOptions.Prefix to list items in the "directory".
@@ -246,6 +412,9 @@ type Bucket interface {
 	// will read until the end of the object. If the specified object does not
 	// exist, NewRangeReader must return an error for which ErrorCode returns
 	// gcerrors.NotFound.
+	// If opts has preconditions that the provider does not support,
+	// NewRangeReader must return an error for which ErrorCode returns
+	// gcerrors.Unimplemented.
 	// opts is guaranteed to be non-nil.
 	NewRangeReader(ctx context.Context, key string, offset, length int64, opts *ReaderOptions) (Reader, error)
 
@@ -259,6 +428,10 @@ type Bucket interface {
 	// contentType sets the MIME type of the object to be written. It must not be
 	// empty. opts is guaranteed to be non-nil.
 	//
+	// If opts has preconditions that the provider does not support,
+	// NewTypedWriter must return an error for which ErrorCode returns
+	// gcerrors.Unimplemented.
+	//
 	// The caller must call Close on the returned Writer when done writing.
 	//
 	// Implementations should abort an ongoing write if ctx is later canceled,
@@ -272,16 +445,25 @@ type Bucket interface {
 	//
 	// If the destination object already exists, it should be overwritten.
 	//
+	// If opts has preconditions that the provider does not support, Copy must
+	// return an error for which ErrorCode returns gcerrors.Unimplemented.
+	//
 	// opts is guaranteed to be non-nil.
 	Copy(ctx context.Context, dstKey, srcKey string, opts *CopyOptions) error
 
 	// Delete deletes the object associated with key. If the specified object does
 	// not exist, Delete must return an error for which ErrorCode returns
 	// gcerrors.NotFound.
-	Delete(ctx context.Context, key string) error
+	//
+	// If opts has preconditions that the provider does not support, Delete
+	// must return an error for which ErrorCode returns gcerrors.Unimplemented.
+	//
+	// opts is guaranteed to be non-nil.
+	Delete(ctx context.Context, key string, opts *DeleteOptions) error
 
-	// SignedURL returns a URL that can be used to GET the blob for the duration
-	// specified in opts.Expiry. opts is guaranteed to be non-nil.
+	// SignedURL returns a URL that can be used to access the blob using
+	// opts.Method for the duration specified in opts.Expiry. opts is
+	// guaranteed to be non-nil.
 	// If not supported, return an error for which ErrorCode returns
 	// gcerrors.Unimplemented.
 	SignedURL(ctx context.Context, key string, opts *SignedURLOptions) (string, error)
@@ -293,8 +475,165 @@ type Bucket interface {
 	Close() error
 }
 
+// VersionInfo describes a single version of a blob.
+type VersionInfo struct {
+	// VersionID identifies the version. It is opaque and provider-specific.
+	VersionID string
+	// ModTime is the time this version was written.
+	ModTime time.Time
+	// Size is the size of this version in bytes.
+	Size int64
+	// MD5 is an MD5 hash of the version contents or nil if not available.
+	MD5 []byte
+	// ETag is the ETag of the version, or "" if not available.
+	ETag string
+	// IsLatest is true for the version that NewRangeReader would read.
+	IsLatest bool
+}
+
+// Versioner is an optional interface that a Bucket may implement to provide
+// access to previous versions of blobs. Implementations that only support
+// versioning when it has been enabled should return an error for which
+// ErrorCode returns gcerrors.Unimplemented when it has not.
+type Versioner interface {
+	// ListVersions returns the versions of the blob stored at key, newest
+	// first. If there are none, it returns an empty slice.
+	ListVersions(ctx context.Context, key string) ([]*VersionInfo, error)
+
+	// NewVersionRangeReader is like Bucket.NewRangeReader, but reads the
+	// version of the blob identified by versionID.
+	// If the version does not exist, NewVersionRangeReader must return an
+	// error for which ErrorCode returns gcerrors.NotFound.
+	//
+	// opts is guaranteed to be non-nil.
+	NewVersionRangeReader(ctx context.Context, key, versionID string, offset, length int64, opts *ReaderOptions) (Reader, error)
+
+	// DeleteVersion permanently deletes a single version of a blob. If it is
+	// the latest version, the next newest version, if any, becomes the latest.
+	// If the version does not exist, DeleteVersion must return an error for
+	// which ErrorCode returns gcerrors.NotFound.
+	DeleteVersion(ctx context.Context, key, versionID string) error
+}
+
+// WatchEventType is the type of change reported by a WatchEvent.
+type WatchEventType int
+
+const (
+	// WatchCreated means that a blob was created.
+	WatchCreated WatchEventType = iota + 1
+	// WatchUpdated means that an existing blob was overwritten.
+	WatchUpdated
+	// WatchDeleted means that a blob was deleted.
+	WatchDeleted
+)
+
+// WatchEvent describes a change to a blob.
+type WatchEvent struct {
+	// Key is the key of the blob that changed.
+	Key string
+	// Type is the type of the change.
+	Type WatchEventType
+}
+
+// WatchIterator reports changes to the blobs in a bucket.
+type WatchIterator interface {
+	// Next blocks until a change is available and returns it, or returns an
+	// error. If ctx is done, Next must return ctx.Err().
+	// Errors other than ctx.Err() are not retried; the caller is expected to
+	// Close the iterator.
+	Next(ctx context.Context) (*WatchEvent, error)
+
+	// Close stops watching for changes and releases any resources used by the
+	// iterator. It is called at most once, and Next is not called after it.
+	Close() error
+}
+
+// Watcher is an optional interface that a Bucket may implement to report
+// changes to its blobs.
+type Watcher interface {
+	// Watch returns a WatchIterator that reports changes to blobs whose keys
+	// start with prefix, starting at some time before Watch returns.
+	// Changes made before Watch was called should not be reported.
+	Watch(ctx context.Context, prefix string) (WatchIterator, error)
+}
+
+// A DeleteManyError contains the errors encountered from a call to
+// DeleteMany, and the positions of the corresponding keys.
+type DeleteManyError []DeleteManyFailure
+
+// A DeleteManyFailure is the error for a single key in a DeleteManyError.
+type DeleteManyFailure struct {
+	// Index is the position of the key in the keys passed to DeleteMany.
+	Index int
+	// Err is the error deleting the blob for the key.
+	Err error
+}
+
+// BatchDeleter is an optional interface that a Bucket may implement to
+// delete many blobs with fewer requests than calling Delete for each.
+type BatchDeleter interface {
+	// DeleteMany deletes the blobs stored at keys, as Delete does with
+	// empty DeleteOptions. Blobs that don't exist must not be reported as
+	// errors. If a key can't be deleted, DeleteMany must include an error
+	// for its index in the returned DeleteManyError; a failure that affects
+	// the whole batch should be reported for every key in it.
+	//
+	// keys is guaranteed to be non-empty, with no duplicates.
+	DeleteMany(ctx context.Context, keys []string) DeleteManyError
+}
+
 // SignedURLOptions sets options for SignedURL.
 type SignedURLOptions struct {
 	// Expiry sets how long the returned URL is valid for. It is guaranteed to be > 0.
 	Expiry time.Duration
+
+	// Method is the HTTP method that can be used with the URL. It is
+	// guaranteed to be one of "GET", "PUT" or "DELETE".
+	Method string
+
+	// ContentType, if not empty, is the Content-Type that a PUT request using
+	// the URL must have. It is guaranteed to be empty unless Method is "PUT".
+	// If the provider can't enforce it, SignedURL should return an error for
+	// which ErrorCode returns gcerrors.Unimplemented.
+	ContentType string
+}
+
+// AttributesSetter is an optional interface that a Bucket may implement to
+// change the attributes of a blob without copying it.
+type AttributesSetter interface {
+	// SetAttributes replaces the attributes of the blob stored at key with
+	// attrs, without changing its content. If the blob does not exist,
+	// SetAttributes must return an error for which ErrorCode returns
+	// gcerrors.NotFound.
+	SetAttributes(ctx context.Context, key string, attrs *WritableAttributes) error
+}
+
+// Mover is an optional interface that a Bucket may implement to rename blobs
+// atomically.
+type Mover interface {
+	// Move atomically renames the blob stored at srcKey to dstKey, replacing
+	// any blob stored at dstKey. Its content and attributes are unchanged.
+	// If the source blob does not exist, Move must return an error for which
+	// ErrorCode returns gcerrors.NotFound.
+	//
+	// If the blob can't be moved atomically, Move must return an error for
+	// which ErrorCode returns gcerrors.Unimplemented without changing
+	// anything; the portable type then copies the blob and deletes the
+	// source instead.
+	Move(ctx context.Context, dstKey, srcKey string) error
+}
+
+// Expirer is an optional interface that a Bucket may implement to enforce
+// WriterOptions.ExpiresAt natively. Expired blobs must behave as if they had
+// been deleted: reads, Attributes, List, Copy and Delete must treat them as
+// missing, and they must not be kept as previous versions. They may be
+// removed from storage lazily, or only by PurgeExpired.
+//
+// For drivers that don't implement Expirer, the portable type records the
+// expiration time in the blob's metadata, and PurgeExpired lists the blobs to
+// find the expired ones.
+type Expirer interface {
+	// PurgeExpired removes the blobs that have expired from storage, and
+	// returns how many it removed.
+	PurgeExpired(ctx context.Context) (int, error)
 }
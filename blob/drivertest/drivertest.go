@@ -20,8 +20,11 @@ import (
 	"bytes"
 	"context"
 	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"io/ioutil"
 	"log"
@@ -207,6 +210,15 @@ func RunConformanceTests(t *testing.T, newHarness HarnessMaker, asTests []AsTest
 	t.Run("TestDelete", func(t *testing.T) {
 		testDelete(t, newHarness)
 	})
+	t.Run("TestConditions", func(t *testing.T) {
+		testConditions(t, newHarness)
+	})
+	t.Run("TestVersions", func(t *testing.T) {
+		testVersions(t, newHarness)
+	})
+	t.Run("TestWatch", func(t *testing.T) {
+		testWatch(t, newHarness)
+	})
 	t.Run("TestKeys", func(t *testing.T) {
 		testKeys(t, newHarness)
 	})
@@ -1564,7 +1576,7 @@ func testMetadata(t *testing.T, newHarness HarnessMaker) {
 	}
 }
 
-// testMD5 tests reading MD5 hashes via List and Attributes.
+// testMD5 tests reading MD5 hashes and other checksums via List and Attributes.
 func testMD5(t *testing.T, newHarness HarnessMaker) {
 	ctx := context.Background()
 
@@ -1575,6 +1587,20 @@ func testMD5(t *testing.T, newHarness HarnessMaker) {
 	aMD5 := md5.Sum(aContent)
 	bMD5 := md5.Sum(bContent)
 
+	// checkSums checks the CRC32C and SHA256 checksums reported for content.
+	// Like MD5, it's always legal to return nil for them.
+	checkSums := func(crc, sha []byte, content []byte) {
+		t.Helper()
+		wantCRC := make([]byte, 4)
+		binary.BigEndian.PutUint32(wantCRC, crc32.Checksum(content, crc32.MakeTable(crc32.Castagnoli)))
+		if crc != nil && !bytes.Equal(crc, wantCRC) {
+			t.Errorf("got CRC32C %x want %x", crc, wantCRC)
+		}
+		if wantSHA := sha256.Sum256(content); sha != nil && !bytes.Equal(sha, wantSHA[:]) {
+			t.Errorf("got SHA256 %x want %x", sha, wantSHA)
+		}
+	}
+
 	h, err := newHarness(ctx, t)
 	if err != nil {
 		t.Fatal(err)
@@ -1606,6 +1632,7 @@ func testMD5(t *testing.T, newHarness HarnessMaker) {
 	if aAttr.MD5 != nil && !bytes.Equal(aAttr.MD5, aMD5[:]) {
 		t.Errorf("got MD5\n%x\nwant\n%x", aAttr.MD5, aMD5)
 	}
+	checkSums(aAttr.CRC32C, aAttr.SHA256, aContent)
 
 	bAttr, err := b.Attributes(ctx, bKey)
 	if err != nil {
@@ -1614,6 +1641,7 @@ func testMD5(t *testing.T, newHarness HarnessMaker) {
 	if bAttr.MD5 != nil && !bytes.Equal(bAttr.MD5, bMD5[:]) {
 		t.Errorf("got MD5\n%x\nwant\n%x", bAttr.MD5, bMD5)
 	}
+	checkSums(bAttr.CRC32C, bAttr.SHA256, bContent)
 
 	// Check the MD5 we get through List. Note that it's always legal to
 	// return a nil MD5.
@@ -1628,6 +1656,7 @@ func testMD5(t *testing.T, newHarness HarnessMaker) {
 	if obj.MD5 != nil && !bytes.Equal(obj.MD5, aMD5[:]) {
 		t.Errorf("got MD5\n%x\nwant\n%x", obj.MD5, aMD5)
 	}
+	checkSums(obj.CRC32C, obj.SHA256, aContent)
 	obj, err = iter.Next(ctx)
 	if err != nil {
 		t.Fatal(err)
@@ -1638,6 +1667,7 @@ func testMD5(t *testing.T, newHarness HarnessMaker) {
 	if obj.MD5 != nil && !bytes.Equal(obj.MD5, bMD5[:]) {
 		t.Errorf("got MD5\n%x\nwant\n%x", obj.MD5, bMD5)
 	}
+	checkSums(obj.CRC32C, obj.SHA256, bContent)
 }
 
 // testCopy tests the functionality of Copy.
@@ -1708,6 +1738,7 @@ func testCopy(t *testing.T, newHarness HarnessMaker) {
 			t.Fatal(err)
 		}
 		wantAttr.ModTime = time.Time{} // don't compare this field
+		wantAttr.ETag = ""             // or this one, the copy is a different blob
 
 		// Create another blob that we're going to overwrite.
 		if err := b.WriteAll(ctx, dstKeyExists, []byte("clobber me"), nil); err != nil {
@@ -1732,6 +1763,7 @@ func testCopy(t *testing.T, newHarness HarnessMaker) {
 			t.Fatal(err)
 		}
 		gotAttr.ModTime = time.Time{} // don't compare this field
+		gotAttr.ETag = ""
 		if diff := cmp.Diff(gotAttr, wantAttr, cmpopts.IgnoreUnexported(blob.Attributes{})); diff != "" {
 			t.Errorf("got %v want %v diff %s", gotAttr, wantAttr, diff)
 		}
@@ -1755,6 +1787,7 @@ func testCopy(t *testing.T, newHarness HarnessMaker) {
 			t.Fatal(err)
 		}
 		gotAttr.ModTime = time.Time{} // don't compare this field
+		gotAttr.ETag = ""
 		if diff := cmp.Diff(gotAttr, wantAttr, cmpopts.IgnoreUnexported(blob.Attributes{})); diff != "" {
 			t.Errorf("got %v want %v diff %s", gotAttr, wantAttr, diff)
 		}
@@ -1825,6 +1858,249 @@ func testDelete(t *testing.T, newHarness HarnessMaker) {
 	})
 }
 
+// testConditions tests the IfMatch and IfNoneMatch preconditions.
+func testConditions(t *testing.T, newHarness HarnessMaker) {
+	const (
+		key     = "blob-for-conditions"
+		copyKey = "blob-for-conditions-copy"
+	)
+	ctx := context.Background()
+	h, err := newHarness(ctx, t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	drv, err := h.MakeDriver(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := blob.NewBucket(drv)
+	defer b.Close()
+
+	wantCode := func(op string, err error, want gcerrors.ErrorCode) {
+		t.Helper()
+		if got := gcerrors.Code(err); got != want {
+			t.Errorf("%s: got error %v (code %v), want code %v", op, err, got, want)
+		}
+	}
+
+	// Create-only write; drivers that don't support preconditions skip.
+	err = b.WriteAll(ctx, key, []byte("v1"), &blob.WriterOptions{ContentType: "text/plain", IfNoneMatch: "*"})
+	if gcerrors.Code(err) == gcerrors.Unimplemented {
+		t.Skip("preconditions not supported")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = b.Delete(ctx, key) }()
+	attrs, err := b.Attributes(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	etag1 := attrs.ETag
+	if etag1 == "" {
+		t.Fatal("got empty ETag")
+	}
+
+	// The ETag from List matches the one from Attributes.
+	iter := b.List(&blob.ListOptions{Prefix: key})
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if obj.Key == key && obj.ETag != etag1 {
+			t.Errorf("List: got ETag %q want %q", obj.ETag, etag1)
+		}
+	}
+
+	// Create-only fails now that the blob exists, and doesn't modify it.
+	err = b.WriteAll(ctx, key, []byte("nope"), &blob.WriterOptions{ContentType: "text/plain", IfNoneMatch: "*"})
+	wantCode("create-only write of existing blob", err, gcerrors.FailedPrecondition)
+	if got, _ := b.ReadAll(ctx, key); string(got) != "v1" {
+		t.Errorf("failed write modified the blob: got %q want %q", got, "v1")
+	}
+	// IfMatch on a missing blob fails.
+	err = b.WriteAll(ctx, "does-not-exist", []byte("nope"), &blob.WriterOptions{ContentType: "text/plain", IfMatch: "*"})
+	wantCode("IfMatch write of missing blob", err, gcerrors.FailedPrecondition)
+
+	// Overwrite with a matching ETag.
+	if err := b.WriteAll(ctx, key, []byte("v2"), &blob.WriterOptions{ContentType: "text/plain", IfMatch: etag1}); err != nil {
+		t.Fatalf("IfMatch write: %v", err)
+	}
+	attrs, err = b.Attributes(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	etag2 := attrs.ETag
+	if etag2 == etag1 {
+		t.Errorf("ETag didn't change after overwrite: %q", etag2)
+	}
+	// Overwrite with a stale ETag fails.
+	err = b.WriteAll(ctx, key, []byte("v3"), &blob.WriterOptions{ContentType: "text/plain", IfMatch: etag1})
+	wantCode("write with stale ETag", err, gcerrors.FailedPrecondition)
+
+	// Reads.
+	if got, err := readAllWithOptions(ctx, b, key, &blob.ReaderOptions{IfMatch: etag2}); err != nil || string(got) != "v2" {
+		t.Errorf("IfMatch read: got %q, %v want %q", got, err, "v2")
+	}
+	_, err = readAllWithOptions(ctx, b, key, &blob.ReaderOptions{IfMatch: etag1})
+	wantCode("read with stale IfMatch", err, gcerrors.FailedPrecondition)
+	_, err = readAllWithOptions(ctx, b, key, &blob.ReaderOptions{IfNoneMatch: etag2})
+	wantCode("read with current IfNoneMatch", err, gcerrors.FailedPrecondition)
+	if _, err := readAllWithOptions(ctx, b, key, &blob.ReaderOptions{IfNoneMatch: etag1}); err != nil {
+		t.Errorf("read with stale IfNoneMatch: %v", err)
+	}
+
+	// Copy to a new destination only if it doesn't exist.
+	if err := b.Copy(ctx, copyKey, key, &blob.CopyOptions{IfNoneMatch: "*"}); err != nil {
+		t.Fatalf("create-only Copy: %v", err)
+	}
+	defer func() { _ = b.Delete(ctx, copyKey) }()
+	err = b.Copy(ctx, copyKey, key, &blob.CopyOptions{IfNoneMatch: "*"})
+	wantCode("create-only Copy to existing blob", err, gcerrors.FailedPrecondition)
+
+	// Delete with a stale ETag fails; with the current one it succeeds.
+	err = b.DeleteWithOptions(ctx, key, &blob.DeleteOptions{IfMatch: etag1})
+	wantCode("Delete with stale ETag", err, gcerrors.FailedPrecondition)
+	if err := b.DeleteWithOptions(ctx, key, &blob.DeleteOptions{IfMatch: etag2}); err != nil {
+		t.Errorf("Delete with current ETag: %v", err)
+	}
+	if exists, _ := b.Exists(ctx, key); exists {
+		t.Errorf("blob still exists after Delete")
+	}
+}
+
+// readAllWithOptions is like Bucket.ReadAll, but accepts ReaderOptions.
+func readAllWithOptions(ctx context.Context, b *blob.Bucket, key string, opts *blob.ReaderOptions) ([]byte, error) {
+	r, err := b.NewReader(ctx, key, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// testVersions tests the functionality of ListVersions, NewVersionReader and
+// DeleteVersion.
+func testVersions(t *testing.T, newHarness HarnessMaker) {
+	const key = "blob-for-versions"
+
+	ctx := context.Background()
+	h, err := newHarness(ctx, t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	drv, err := h.MakeDriver(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := blob.NewBucket(drv)
+	defer b.Close()
+
+	vs, err := b.ListVersions(ctx, key)
+	if gcerrors.Code(err) == gcerrors.Unimplemented {
+		t.Skip("versioning not supported")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vs) != 0 {
+		t.Fatalf("got %d versions for a blob that was never written, want 0", len(vs))
+	}
+	defer func() {
+		vs, _ := b.ListVersions(ctx, key)
+		for _, v := range vs {
+			_ = b.DeleteVersion(ctx, key, v.ID)
+		}
+	}()
+
+	// listContents returns the contents of each version, newest first, and
+	// checks that only the first one (if any) is the latest.
+	listContents := func(wantLatest bool) ([]*blob.Version, []string) {
+		t.Helper()
+		vs, err := b.ListVersions(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for i, v := range vs {
+			if want := wantLatest && i == 0; v.IsLatest != want {
+				t.Errorf("version %d: got IsLatest %v want %v", i, v.IsLatest, want)
+			}
+			r, err := b.NewVersionReader(ctx, key, v.ID, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := ioutil.ReadAll(r)
+			r.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if int64(len(data)) != v.Size {
+				t.Errorf("version %d: got Size %d, read %d bytes", i, v.Size, len(data))
+			}
+			got = append(got, string(data))
+		}
+		return vs, got
+	}
+
+	for _, content := range []string{"v1", "v2", "v3"} {
+		if err := b.WriteAll(ctx, key, []byte(content), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	vs, got := listContents(true)
+	if want := []string{"v3", "v2", "v1"}; !cmp.Equal(got, want) {
+		t.Fatalf("got versions %v want %v", got, want)
+	}
+
+	// Ranged reads of a previous version.
+	r, err := b.NewVersionRangeReader(ctx, key, vs[2].ID, 1, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil || string(data) != "1" {
+		t.Errorf("ranged version read: got %q, %v want %q", data, err, "1")
+	}
+
+	// Versions that don't exist are NotFound.
+	if _, err := b.NewVersionReader(ctx, key, "does-not-exist", nil); gcerrors.Code(err) != gcerrors.NotFound {
+		t.Errorf("reading a missing version: got %v want NotFound error", err)
+	}
+	if err := b.DeleteVersion(ctx, key, "does-not-exist"); gcerrors.Code(err) != gcerrors.NotFound {
+		t.Errorf("deleting a missing version: got %v want NotFound error", err)
+	}
+
+	// Deleting a previous version doesn't affect the others.
+	if err := b.DeleteVersion(ctx, key, vs[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, got := listContents(true); !cmp.Equal(got, []string{"v3", "v1"}) {
+		t.Errorf("after deleting v2: got versions %v want [v3 v1]", got)
+	}
+
+	// Deleting the latest version makes the next newest one the latest.
+	if err := b.DeleteVersion(ctx, key, vs[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := b.ReadAll(ctx, key); err != nil || string(got) != "v1" {
+		t.Errorf("after deleting v3: got %q, %v want %q", got, err, "v1")
+	}
+
+	// After a Delete, no version is the latest.
+	if err := b.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	listContents(false)
+}
+
 // testConcurrentWriteAndRead tests that concurrent writing to multiple blob
 // keys and concurrent reading from multiple blob keys works.
 func testConcurrentWriteAndRead(t *testing.T, newHarness HarnessMaker) {
@@ -1891,6 +2167,77 @@ func testConcurrentWriteAndRead(t *testing.T, newHarness HarnessMaker) {
 	wg.Wait()
 }
 
+// testWatch tests the functionality of Watch.
+func testWatch(t *testing.T, newHarness HarnessMaker) {
+	const (
+		prefix = "blob-for-watch/"
+		key    = prefix + "key"
+		// otherKey doesn't match prefix, so changes to it aren't reported.
+		otherKey = "blob-for-watch-other"
+	)
+
+	ctx := context.Background()
+	h, err := newHarness(ctx, t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	drv, err := h.MakeDriver(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := blob.NewBucket(drv)
+	defer b.Close()
+
+	iter, err := b.Watch(ctx, prefix)
+	if gcerrors.Code(err) == gcerrors.Unimplemented {
+		t.Skip("watching not supported")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iter.Close()
+
+	// expect waits for the next event and checks that it is want.
+	expect := func(want blob.WatchEventType) {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		e, err := iter.Next(ctx)
+		if err != nil {
+			t.Fatalf("waiting for %v event: %v", want, err)
+		}
+		if e.Key != key || e.Type != want {
+			t.Errorf("got %v event for %q, want %v event for %q", e.Type, e.Key, want, key)
+		}
+	}
+
+	if err := b.WriteAll(ctx, otherKey, []byte("other"), nil); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = b.Delete(ctx, otherKey) }()
+	if err := b.WriteAll(ctx, key, []byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = b.Delete(ctx, key) }()
+	expect(blob.WatchCreated)
+	if err := b.WriteAll(ctx, key, []byte("hello world"), nil); err != nil {
+		t.Fatal(err)
+	}
+	expect(blob.WatchUpdated)
+	if err := b.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	expect(blob.WatchDeleted)
+
+	// Next returns ctx.Err() when ctx is done.
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := iter.Next(cctx); err != context.Canceled {
+		t.Errorf("got error %v from Next with a canceled context, want %v", err, context.Canceled)
+	}
+}
+
 // testKeys tests a variety of weird keys.
 func testKeys(t *testing.T, newHarness HarnessMaker) {
 	const keyPrefix = "weird-keys"
@@ -2012,6 +2359,14 @@ func testSignedURL(t *testing.T, newHarness HarnessMaker) {
 	if err == nil {
 		t.Error("got nil error, expected error for negative SignedURLOptions.Expiry")
 	}
+	// Similarly, verify that an invalid Method, or a ContentType for a
+	// method other than PUT, gives an error.
+	if _, err := b.SignedURL(ctx, key, &blob.SignedURLOptions{Method: "POST"}); err == nil {
+		t.Error("got nil error, expected error for SignedURLOptions.Method POST")
+	}
+	if _, err := b.SignedURL(ctx, key, &blob.SignedURLOptions{ContentType: "text/plain"}); err == nil {
+		t.Error("got nil error, expected error for SignedURLOptions.ContentType with GET")
+	}
 
 	// Try to generate a real signed URL.
 	url, err := b.SignedURL(ctx, key, nil)
@@ -2047,6 +2402,23 @@ func testSignedURL(t *testing.T, newHarness HarnessMaker) {
 	if !bytes.Equal(got, contents) {
 		t.Errorf("got body %q, want %q", string(got), string(contents))
 	}
+
+	// Verify that URLs can be generated for the other methods.
+	for _, opts := range []*blob.SignedURLOptions{
+		{Method: http.MethodPut},
+		{Method: http.MethodPut, ContentType: "text/plain"},
+		{Method: http.MethodDelete},
+	} {
+		url, err := b.SignedURL(ctx, key, opts)
+		if gcerrors.Code(err) == gcerrors.Unimplemented {
+			continue
+		}
+		if err != nil {
+			t.Errorf("%+v: %v", opts, err)
+		} else if url == "" {
+			t.Errorf("%+v: got empty url", opts)
+		}
+	}
 }
 
 // testAs tests the various As functions, using AsTest.
@@ -0,0 +1,691 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package encryptedblob provides a blob implementation that wraps another
+// *blob.Bucket, encrypting blob contents on the client before they are written
+// to it. Use OpenBucket to construct a *blob.Bucket.
+//
+// Each blob is encrypted with its own randomly generated data key, using
+// AES-256-GCM over chunks of 64 KiB so that ranged reads only need to read and
+// decrypt the chunks that overlap the range. The data key is encrypted
+// ("wrapped") with a *secrets.Keeper and stored in the blob's metadata; the
+// underlying bucket never sees plaintext contents or data keys.
+//
+// Blob attributes like ContentType and user metadata are stored unencrypted.
+// ContentEncoding is stored in metadata so that the underlying provider
+// doesn't try to decode the encrypted contents. MD5 hashes are not reported,
+// since the underlying provider only knows the hash of the encrypted contents.
+//
+// URLs
+//
+// For blob.OpenBucket, encryptedblob registers as a wrapper named
+// "encrypted"; see blob.BucketURLWrapper. For example,
+// "encrypted+mem://?keeper=base64key://..." opens an in-memory bucket whose
+// contents are encrypted using the keeper opened by the (query-escaped) URL in
+// the "keeper" query parameter. To customize the URL opener, or for more
+// details on the URL format, see URLOpener.
+// See https://godoc.org/gocloud.dev#hdr-URLs for background information.
+//
+// As
+//
+// encryptedblob does not support any types for As, except that the As
+// functions of attributes and list results are forwarded to the underlying
+// bucket's.
+package encryptedblob // import "gocloud.dev/blob/encryptedblob"
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/driver"
+	"gocloud.dev/gcerrors"
+	"gocloud.dev/internal/gcerr"
+	"gocloud.dev/secrets"
+)
+
+const (
+	// chunkSize is the size of the plaintext chunks that are encrypted
+	// separately. Changing it would make existing blobs unreadable.
+	chunkSize = 64 * 1024
+	// tagSize is the size of the GCM authentication tag added to each chunk.
+	tagSize = 16
+	// sealedChunkSize is the size of an encrypted chunk.
+	sealedChunkSize = chunkSize + tagSize
+	// dataKeySize is the size of the per-blob AES-256 data key.
+	dataKeySize = 32
+
+	// Metadata keys used to store encryption information in the underlying
+	// blob. They are lowercase since some providers lowercase metadata keys.
+	mdPrefix          = "encryptedblob_"
+	mdWrappedKey      = mdPrefix + "key"
+	mdContentEncoding = mdPrefix + "content_encoding"
+)
+
+var (
+	errNotImplemented = errors.New("not implemented")
+	errNotEncrypted   = errors.New("blob was not written by encryptedblob (no wrapped data key in metadata)")
+	errCorrupted      = errors.New("encrypted blob contents are corrupted")
+)
+
+func init() {
+	blob.DefaultURLMux().RegisterBucketWrapper(Scheme, &URLOpener{})
+}
+
+// Scheme is the name encryptedblob registers its URLOpener under on
+// blob.DefaultMux, as a blob.BucketURLWrapper.
+const Scheme = "encrypted"
+
+// URLOpener opens URLs like "encrypted+mem://?keeper=base64key://...".
+//
+// The following query parameters are supported, and removed from the URL
+// passed to the underlying bucket's opener:
+//
+//   - keeper: the URL of the secrets.Keeper used to wrap data keys, opened
+//     with KeeperMux. It must be query-escaped. Required.
+//
+// The underlying bucket and the keeper are closed when the returned bucket is
+// closed.
+type URLOpener struct {
+	// KeeperMux is used to open the keeper. If nil, secrets.DefaultURLMux()
+	// is used.
+	KeeperMux *secrets.URLMux
+
+	// Options specifies the options to pass to OpenBucket.
+	Options Options
+}
+
+// WrapBucketURL implements blob.BucketURLWrapper.
+func (o *URLOpener) WrapBucketURL(ctx context.Context, u *url.URL, openInner func(context.Context, *url.URL) (*blob.Bucket, error)) (*blob.Bucket, error) {
+	q := u.Query()
+	keeperURL := q.Get("keeper")
+	if keeperURL == "" {
+		return nil, fmt.Errorf("open bucket %v: query parameter \"keeper\" is required", u)
+	}
+	q.Del("keeper")
+	mux := o.KeeperMux
+	if mux == nil {
+		mux = secrets.DefaultURLMux()
+	}
+	keeper, err := mux.OpenKeeper(ctx, keeperURL)
+	if err != nil {
+		return nil, fmt.Errorf("open bucket %v: %v", u, err)
+	}
+	innerURL := *u
+	innerURL.RawQuery = q.Encode()
+	inner, err := openInner(ctx, &innerURL)
+	if err != nil {
+		keeper.Close()
+		return nil, err
+	}
+	return blob.NewBucket(&bucket{b: inner, keeper: keeper, opts: &o.Options, owned: true}), nil
+}
+
+// Options sets options for constructing a *blob.Bucket backed by encryptedblob.
+type Options struct{}
+
+type bucket struct {
+	b      *blob.Bucket
+	keeper *secrets.Keeper
+	opts   *Options
+	// owned is true if b and keeper should be closed with the bucket.
+	owned bool
+}
+
+// OpenBucket creates a *blob.Bucket that encrypts the contents of blobs
+// written to b, using data keys wrapped by keeper.
+// Closing the returned bucket doesn't close b or keeper.
+func OpenBucket(b *blob.Bucket, keeper *secrets.Keeper, opts *Options) *blob.Bucket {
+	return blob.NewBucket(openBucket(b, keeper, opts))
+}
+
+func openBucket(b *blob.Bucket, keeper *secrets.Keeper, opts *Options) *bucket {
+	if opts == nil {
+		opts = &Options{}
+	}
+	return &bucket{b: b, keeper: keeper, opts: opts}
+}
+
+// Close implements driver.Close.
+func (b *bucket) Close() error {
+	if !b.owned {
+		return nil
+	}
+	err := b.b.Close()
+	if kerr := b.keeper.Close(); err == nil {
+		err = kerr
+	}
+	return err
+}
+
+// ErrorCode implements driver.ErrorCode.
+func (b *bucket) ErrorCode(err error) gcerrors.ErrorCode {
+	switch err {
+	case errNotImplemented:
+		return gcerrors.Unimplemented
+	case errNotEncrypted, errCorrupted:
+		return gcerrors.Internal
+	}
+	return gcerrors.Code(err)
+}
+
+// As implements driver.As.
+func (b *bucket) As(i interface{}) bool { return false }
+
+// ErrorAs implements driver.ErrorAs.
+func (b *bucket) ErrorAs(err error, i interface{}) bool {
+	return b.b.ErrorAs(err, i)
+}
+
+// plaintextSize returns the size of the plaintext for an encrypted blob of
+// the given size.
+func plaintextSize(size int64) (int64, error) {
+	chunks := (size + sealedChunkSize - 1) / sealedChunkSize
+	if size == 0 || size-(chunks-1)*sealedChunkSize < tagSize {
+		return 0, errCorrupted
+	}
+	return size - chunks*tagSize, nil
+}
+
+// ListPaged implements driver.ListPaged.
+func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driver.ListPage, error) {
+	pageToken := opts.PageToken
+	if len(pageToken) == 0 {
+		pageToken = blob.FirstPageToken
+	}
+	objs, next, err := b.b.ListPage(ctx, pageToken, opts.PageSize, &blob.ListOptions{
+		Prefix:      opts.Prefix,
+		Delimiter:   opts.Delimiter,
+		StartAfter:  opts.StartAfter,
+		Match:       opts.Match,
+		MatchRegexp: opts.MatchRegexp,
+		MinModTime:  opts.MinModTime,
+		MaxModTime:  opts.MaxModTime,
+		// The sizes of the blobs in b are those of the encrypted contents,
+		// so the size filters are applied by the portable type.
+		BeforeList: opts.BeforeList,
+	})
+	if err != nil {
+		return nil, err
+	}
+	page := &driver.ListPage{NextPageToken: next}
+	for _, obj := range objs {
+		dobj := &driver.ListObject{
+			Key:    obj.Key,
+			IsDir:  obj.IsDir,
+			AsFunc: obj.As,
+		}
+		if !obj.IsDir {
+			dobj.ModTime = obj.ModTime
+			dobj.ETag = obj.ETag
+			// Blobs that weren't written by us report a size of 0.
+			dobj.Size, _ = plaintextSize(obj.Size)
+		}
+		page.Objects = append(page.Objects, dobj)
+	}
+	return page, nil
+}
+
+// Attributes implements driver.Attributes.
+func (b *bucket) Attributes(ctx context.Context, key string) (*driver.Attributes, error) {
+	attrs, err := b.b.Attributes(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	size, err := plaintextSize(attrs.Size)
+	if err != nil {
+		return nil, err
+	}
+	md := map[string]string{}
+	for k, v := range attrs.Metadata {
+		if !strings.HasPrefix(k, mdPrefix) {
+			md[k] = v
+		}
+	}
+	return &driver.Attributes{
+		CacheControl:       attrs.CacheControl,
+		ContentDisposition: attrs.ContentDisposition,
+		ContentEncoding:    attrs.Metadata[mdContentEncoding],
+		ContentLanguage:    attrs.ContentLanguage,
+		ContentType:        attrs.ContentType,
+		Metadata:           md,
+		ModTime:            attrs.ModTime,
+		Size:               size,
+		ETag:               attrs.ETag,
+		ExpiresAt:          attrs.ExpiresAt,
+		AsFunc:             attrs.As,
+	}, nil
+}
+
+// newAEAD returns the AEAD for a data key.
+func newAEAD(dataKey []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce returns the nonce for chunk i. Since every blob has its own data
+// key, the chunk index is a unique nonce.
+func chunkNonce(nonce []byte, i int64) []byte {
+	for j := range nonce[:4] {
+		nonce[j] = 0
+	}
+	binary.BigEndian.PutUint64(nonce[4:], uint64(i))
+	return nonce
+}
+
+// chunkAD returns the additional data for a chunk. Marking the last chunk
+// detects truncation of the blob.
+func chunkAD(last bool) []byte {
+	if last {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// NewRangeReader implements driver.NewRangeReader.
+func (b *bucket) NewRangeReader(ctx context.Context, key string, offset, length int64, opts *driver.ReaderOptions) (driver.Reader, error) {
+	attrs, err := b.b.Attributes(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	size, err := plaintextSize(attrs.Size)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(attrs.Metadata[mdWrappedKey])
+	if err != nil || len(wrapped) == 0 {
+		return nil, errNotEncrypted
+	}
+	dataKey, err := b.keeper.Decrypt(ctx, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	end := size
+	if length >= 0 && offset+length < end {
+		end = offset + length
+	}
+	r := &reader{
+		aead:      aead,
+		nonce:     make([]byte, aead.NonceSize()),
+		lastChunk: (size - 1) / chunkSize,
+		attrs: driver.ReaderAttributes{
+			ContentType: attrs.ContentType,
+			ModTime:     attrs.ModTime,
+			Size:        size,
+
+			ContentEncoding: attrs.Metadata[mdContentEncoding],
+		},
+	}
+	if offset >= end {
+		// Nothing to read.
+		r.r = http.NoBody
+		return r, nil
+	}
+	// Read the chunks overlapping [offset, end).
+	r.chunk = offset / chunkSize
+	r.skip = offset - r.chunk*chunkSize
+	r.remaining = end - offset
+	lastChunk := (end - 1) / chunkSize
+	innerOffset := r.chunk * sealedChunkSize
+	innerEnd := (lastChunk + 1) * sealedChunkSize
+	if innerEnd > attrs.Size {
+		innerEnd = attrs.Size
+	}
+	ropts := &blob.ReaderOptions{IfMatch: opts.IfMatch, IfNoneMatch: opts.IfNoneMatch}
+	if attrs.ETag != "" && (opts.IfMatch == "" || opts.IfMatch == "*") {
+		// Read the version of the blob whose data key and size are used
+		// above; if it was overwritten since, the read fails with
+		// FailedPrecondition instead of returning corrupted data.
+		ropts.IfMatch = attrs.ETag
+	}
+	inner, err := b.b.NewRangeReader(ctx, key, innerOffset, innerEnd-innerOffset, ropts)
+	if gcerrors.Code(err) == gcerrors.Unimplemented && ropts.IfMatch != opts.IfMatch {
+		// The underlying bucket doesn't support preconditions on reads.
+		ropts.IfMatch = opts.IfMatch
+		inner, err = b.b.NewRangeReader(ctx, key, innerOffset, innerEnd-innerOffset, ropts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.r = inner
+	r.attrs.ETag = inner.ETag()
+	r.buf = make([]byte, sealedChunkSize)
+	return r, nil
+}
+
+// reader decrypts a range of an encrypted blob.
+type reader struct {
+	r         io.ReadCloser
+	aead      cipher.AEAD
+	nonce     []byte
+	attrs     driver.ReaderAttributes
+	lastChunk int64 // index of the last chunk in the blob
+
+	chunk     int64  // index of the next chunk to read
+	skip      int64  // bytes to skip at the start of the next chunk
+	remaining int64  // plaintext bytes left to return
+	buf       []byte // buffer for reading sealed chunks
+	plain     []byte // decrypted data not yet returned
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if len(r.plain) == 0 {
+		if r.remaining <= 0 {
+			return 0, io.EOF
+		}
+		if err := r.readChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+// readChunk reads and decrypts the next chunk into r.plain.
+func (r *reader) readChunk() error {
+	n := sealedChunkSize
+	if r.chunk == r.lastChunk {
+		n = len(r.buf)
+	}
+	got, err := io.ReadFull(r.r, r.buf[:n])
+	if err == io.ErrUnexpectedEOF && r.chunk == r.lastChunk {
+		err = nil
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errCorrupted
+	}
+	if err != nil {
+		return err
+	}
+	plain, err := r.aead.Open(r.buf[:0], chunkNonce(r.nonce, r.chunk), r.buf[:got], chunkAD(r.chunk == r.lastChunk))
+	if err != nil {
+		return errCorrupted
+	}
+	plain = plain[r.skip:]
+	if int64(len(plain)) > r.remaining {
+		plain = plain[:r.remaining]
+	}
+	r.remaining -= int64(len(plain))
+	r.plain = plain
+	r.chunk++
+	r.skip = 0
+	return nil
+}
+
+func (r *reader) Close() error {
+	return r.r.Close()
+}
+
+func (r *reader) Attributes() *driver.ReaderAttributes {
+	return &r.attrs
+}
+
+func (r *reader) As(i interface{}) bool { return false }
+
+// NewTypedWriter implements driver.NewTypedWriter.
+func (b *bucket) NewTypedWriter(ctx context.Context, key string, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
+	if err := checkMetadata(opts.Metadata); err != nil {
+		return nil, err
+	}
+	md := map[string]string{}
+	for k, v := range opts.Metadata {
+		md[k] = v
+	}
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := b.keeper.Encrypt(ctx, dataKey)
+	if err != nil {
+		return nil, err
+	}
+	md[mdWrappedKey] = base64.StdEncoding.EncodeToString(wrapped)
+	if opts.ContentEncoding != "" {
+		md[mdContentEncoding] = opts.ContentEncoding
+	}
+
+	// Use a cancelable context so that the write can be aborted if the MD5
+	// doesn't match.
+	ctx, cancel := context.WithCancel(ctx)
+	w, err := b.b.NewWriter(ctx, key, &blob.WriterOptions{
+		BufferSize:         opts.BufferSize,
+		MaxConcurrency:     opts.MaxConcurrency,
+		CacheControl:       opts.CacheControl,
+		ContentDisposition: opts.ContentDisposition,
+		ContentLanguage:    opts.ContentLanguage,
+		ContentType:        contentType,
+		Metadata:           md,
+		IfMatch:            opts.IfMatch,
+		IfNoneMatch:        opts.IfNoneMatch,
+		BeforeWrite:        opts.BeforeWrite,
+	})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &writer{
+		w:          w,
+		cancel:     cancel,
+		aead:       aead,
+		nonce:      make([]byte, aead.NonceSize()),
+		buf:        make([]byte, 0, sealedChunkSize),
+		contentMD5: opts.ContentMD5,
+		md5hash:    md5.New(),
+	}, nil
+}
+
+// writer encrypts data in chunks and writes it to the underlying bucket.
+type writer struct {
+	w      *blob.Writer
+	cancel func()
+	aead   cipher.AEAD
+	nonce  []byte
+	chunk  int64  // index of the next chunk to write
+	buf    []byte // plaintext of the current chunk
+
+	contentMD5 []byte
+	md5hash    hash.Hash
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	w.md5hash.Write(p)
+	n := len(p)
+	for len(p) > 0 {
+		// Only seal a full chunk once we know it isn't the last one.
+		if len(w.buf) == chunkSize {
+			if err := w.seal(false); err != nil {
+				return 0, err
+			}
+		}
+		m := copy(w.buf[len(w.buf):chunkSize], p)
+		w.buf = w.buf[:len(w.buf)+m]
+		p = p[m:]
+	}
+	return n, nil
+}
+
+// seal encrypts the current chunk and writes it.
+func (w *writer) seal(last bool) error {
+	sealed := w.aead.Seal(w.buf[:0], chunkNonce(w.nonce, w.chunk), w.buf, chunkAD(last))
+	if _, err := w.w.Write(sealed); err != nil {
+		return err
+	}
+	w.chunk++
+	w.buf = w.buf[:0]
+	return nil
+}
+
+func (w *writer) Close() error {
+	defer w.cancel()
+	if len(w.contentMD5) > 0 {
+		if sum := w.md5hash.Sum(nil); string(sum) != string(w.contentMD5) {
+			w.cancel()
+			w.w.Close()
+			return gcerr.Newf(gcerr.FailedPrecondition, nil, "encryptedblob: the WriterOptions.ContentMD5 you specified (%X) did not match what was written (%X)", w.contentMD5, sum)
+		}
+	}
+	if err := w.seal(true); err != nil {
+		w.cancel()
+		w.w.Close()
+		return err
+	}
+	return w.w.Close()
+}
+
+// Copy implements driver.Copy.
+// The wrapped data key is stored in metadata, so it is copied along with the
+// encrypted contents. If opts.Attributes is set, the data key must still be
+// kept, so the attributes are updated with a second, conditional copy of
+// dstKey to itself; see updateAttributes.
+func (b *bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.CopyOptions) error {
+	if opts.Attributes != nil {
+		if err := checkMetadata(opts.Attributes.Metadata); err != nil {
+			return err
+		}
+	}
+	if opts.Attributes != nil && dstKey == srcKey {
+		return b.updateAttributes(ctx, dstKey, opts)
+	}
+	err := b.b.Copy(ctx, dstKey, srcKey, &blob.CopyOptions{
+		IfMatch:     opts.IfMatch,
+		IfNoneMatch: opts.IfNoneMatch,
+		BeforeCopy:  opts.BeforeCopy,
+	})
+	if err != nil || opts.Attributes == nil {
+		return err
+	}
+	return b.updateAttributes(ctx, dstKey, &driver.CopyOptions{Attributes: opts.Attributes})
+}
+
+// SetAttributes implements driver.AttributesSetter.
+func (b *bucket) SetAttributes(ctx context.Context, key string, attrs *driver.WritableAttributes) error {
+	if err := checkMetadata(attrs.Metadata); err != nil {
+		return err
+	}
+	return b.updateAttributes(ctx, key, &driver.CopyOptions{Attributes: attrs})
+}
+
+// updateAttempts is the number of times updateAttributes tries to update the
+// attributes of a blob that is being overwritten concurrently.
+const updateAttempts = 3
+
+// updateAttributes replaces the attributes of the underlying blob for key
+// with opts.Attributes, keeping its wrapped data key, by copying the blob to
+// itself. The copy is conditional on the ETag of the blob whose data key was
+// read, so that a concurrent overwrite doesn't end up with the wrong data key;
+// if that precondition fails and opts has none of its own, the update is
+// retried. If the underlying bucket doesn't support preconditions on Copy,
+// the update is made unconditionally.
+func (b *bucket) updateAttributes(ctx context.Context, key string, opts *driver.CopyOptions) error {
+	for attempt := 1; ; attempt++ {
+		cur, err := b.b.Attributes(ctx, key)
+		if err != nil {
+			return err
+		}
+		copts := &blob.CopyOptions{
+			IfMatch:     opts.IfMatch,
+			IfNoneMatch: opts.IfNoneMatch,
+			Attributes:  attributesUpdate(cur, opts.Attributes),
+			BeforeCopy:  opts.BeforeCopy,
+		}
+		if cur.ETag != "" && (opts.IfMatch == "" || opts.IfMatch == "*") {
+			copts.IfMatch = cur.ETag
+		}
+		err = b.b.Copy(ctx, key, key, copts)
+		switch gcerrors.Code(err) {
+		case gcerrors.Unimplemented:
+			if copts.IfMatch != opts.IfMatch {
+				copts.IfMatch = opts.IfMatch
+				return b.b.Copy(ctx, key, key, copts)
+			}
+		case gcerrors.FailedPrecondition:
+			if opts.IfMatch == "" && opts.IfNoneMatch == "" && attempt < updateAttempts {
+				// The blob was overwritten since its data key was read.
+				continue
+			}
+		}
+		return err
+	}
+}
+
+// checkMetadata returns an error if md uses a key reserved by encryptedblob.
+// Keys are compared case-insensitively, since some providers lowercase them.
+func checkMetadata(md map[string]string) error {
+	for k := range md {
+		if strings.HasPrefix(strings.ToLower(k), mdPrefix) {
+			return gcerr.Newf(gcerr.InvalidArgument, nil, "encryptedblob: metadata key %q is reserved", k)
+		}
+	}
+	return nil
+}
+
+// attributesUpdate returns a blob.AttributesUpdate that replaces the
+// attributes cur of an underlying blob with attrs, keeping the wrapped data
+// key. The metadata in attrs must have been checked with checkMetadata.
+func attributesUpdate(cur *blob.Attributes, attrs *driver.WritableAttributes) *blob.AttributesUpdate {
+	md := map[string]string{}
+	for k, v := range attrs.Metadata {
+		md[k] = v
+	}
+	md[mdWrappedKey] = cur.Metadata[mdWrappedKey]
+	if attrs.ContentEncoding != "" {
+		md[mdContentEncoding] = attrs.ContentEncoding
+	}
+	return &blob.AttributesUpdate{
+		CacheControl:       attrs.CacheControl,
+		ContentDisposition: attrs.ContentDisposition,
+		ContentLanguage:    attrs.ContentLanguage,
+		ContentType:        attrs.ContentType,
+		Metadata:           md,
+	}
+}
+
+// Delete implements driver.Delete.
+func (b *bucket) Delete(ctx context.Context, key string, opts *driver.DeleteOptions) error {
+	return b.b.DeleteWithOptions(ctx, key, &blob.DeleteOptions{
+		IfMatch:     opts.IfMatch,
+		IfNoneMatch: opts.IfNoneMatch,
+	})
+}
+
+// SignedURL implements driver.SignedURL. It is not supported, since signed
+// URLs would give access to the encrypted contents.
+func (b *bucket) SignedURL(ctx context.Context, key string, opts *driver.SignedURLOptions) (string, error) {
+	return "", errNotImplemented
+}
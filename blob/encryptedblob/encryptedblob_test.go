@@ -0,0 +1,399 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encryptedblob
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"testing"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/driver"
+	"gocloud.dev/blob/drivertest"
+	"gocloud.dev/blob/fileblob"
+	"gocloud.dev/blob/memblob"
+	"gocloud.dev/gcerrors"
+	"gocloud.dev/secrets/localsecrets"
+)
+
+var testKey = localsecrets.ByteKey("encryptedblob test secret key!!!")
+
+type harness struct {
+	inner *blob.Bucket
+}
+
+func newHarness(ctx context.Context, t *testing.T) (drivertest.Harness, error) {
+	return &harness{inner: memblob.OpenBucket(nil)}, nil
+}
+
+func (h *harness) HTTPClient() *http.Client {
+	return nil
+}
+
+func (h *harness) MakeDriver(ctx context.Context) (driver.Bucket, error) {
+	return openBucket(h.inner, localsecrets.NewKeeper(testKey), nil), nil
+}
+
+func (h *harness) Close() {
+	h.inner.Close()
+}
+
+func TestConformance(t *testing.T) {
+	drivertest.RunConformanceTests(t, newHarness, nil)
+}
+
+// newTestBucket returns an encrypted bucket and the bucket it wraps.
+func newTestBucket() (b, inner *blob.Bucket) {
+	inner = memblob.OpenBucket(nil)
+	return OpenBucket(inner, localsecrets.NewKeeper(testKey), nil), inner
+}
+
+// testData returns n bytes of data that don't repeat across chunks.
+func testData(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	return data
+}
+
+func TestEncrypted(t *testing.T) {
+	ctx := context.Background()
+	b, inner := newTestBucket()
+	defer inner.Close()
+	defer b.Close()
+
+	data := testData(3*chunkSize + 100)
+	if err := b.WriteAll(ctx, "key", data, nil); err != nil {
+		t.Fatal(err)
+	}
+	ct, err := inner.ReadAll(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := len(data) + 4*tagSize; len(ct) != want {
+		t.Errorf("got ciphertext size %d want %d", len(ct), want)
+	}
+	if bytes.Contains(ct, data[:64]) {
+		t.Error("ciphertext contains plaintext")
+	}
+	got, err := b.ReadAll(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("round-tripped data doesn't match")
+	}
+	attrs, err := b.Attributes(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.Size != int64(len(data)) {
+		t.Errorf("got size %d want %d", attrs.Size, len(data))
+	}
+	if len(attrs.Metadata) != 0 {
+		t.Errorf("got metadata %v want none", attrs.Metadata)
+	}
+
+	// A different keeper can't decrypt the data key.
+	other := OpenBucket(inner, localsecrets.NewKeeper(localsecrets.ByteKey("another key")), nil)
+	defer other.Close()
+	if _, err := other.ReadAll(ctx, "key"); err == nil {
+		t.Error("got nil error reading with the wrong keeper")
+	}
+
+	// Tampering with the ciphertext is detected.
+	ct[len(ct)/2] ^= 1
+	iattrs, err := inner.Attributes(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := inner.WriteAll(ctx, "key", ct, &blob.WriterOptions{Metadata: iattrs.Metadata}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.ReadAll(ctx, "key"); gcerrors.Code(err) != gcerrors.Internal {
+		t.Errorf("got %v reading tampered blob, want Internal error", err)
+	}
+}
+
+func TestRangeReads(t *testing.T) {
+	ctx := context.Background()
+	b, inner := newTestBucket()
+	defer inner.Close()
+	defer b.Close()
+
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 2*chunkSize + 7} {
+		data := testData(size)
+		if err := b.WriteAll(ctx, "key", data, nil); err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range [][2]int64{
+			{0, -1},
+			{0, 10},
+			{chunkSize - 5, 10},
+			{chunkSize, chunkSize},
+			{chunkSize + 3, -1},
+			{int64(size) - 1, 5},
+			{int64(size), 5},
+		} {
+			offset, length := r[0], r[1]
+			if offset < 0 || offset > int64(size) {
+				continue
+			}
+			rd, err := b.NewRangeReader(ctx, "key", offset, length, nil)
+			if err != nil {
+				t.Fatalf("size %d range %v: %v", size, r, err)
+			}
+			got := new(bytes.Buffer)
+			_, err = got.ReadFrom(rd)
+			rd.Close()
+			if err != nil {
+				t.Fatalf("size %d range %v: %v", size, r, err)
+			}
+			end := int64(size)
+			if length >= 0 && offset+length < end {
+				end = offset + length
+			}
+			if !bytes.Equal(got.Bytes(), data[offset:end]) {
+				t.Errorf("size %d range %v: got %d bytes, want %d", size, r, got.Len(), end-offset)
+			}
+			if rd.Size() != int64(size) {
+				t.Errorf("size %d range %v: got reader size %d", size, r, rd.Size())
+			}
+		}
+	}
+}
+
+func TestReservedMetadata(t *testing.T) {
+	b, inner := newTestBucket()
+	defer inner.Close()
+	defer b.Close()
+	err := b.WriteAll(context.Background(), "key", []byte("x"), &blob.WriterOptions{Metadata: map[string]string{mdWrappedKey: "x"}})
+	if gcerrors.Code(err) != gcerrors.InvalidArgument {
+		t.Errorf("got %v want InvalidArgument error", err)
+	}
+
+	// Reserved keys are matched case-insensitively, since some providers
+	// lowercase metadata keys.
+	for _, k := range []string{mdWrappedKey, "Encryptedblob_key", "ENCRYPTEDBLOB_CONTENT_ENCODING"} {
+		if err := checkMetadata(map[string]string{k: "x"}); gcerrors.Code(err) != gcerrors.InvalidArgument {
+			t.Errorf("%s: got %v want InvalidArgument error", k, err)
+		}
+	}
+}
+
+func TestOpenBucketFromURL(t *testing.T) {
+	keeperURL := url.QueryEscape("base64key://" + base64.URLEncoding.EncodeToString(testKey[:]))
+	tests := []struct {
+		URL     string
+		WantErr bool
+	}{
+		// OK.
+		{"encrypted+mem://?keeper=" + keeperURL, false},
+		// OK, using the blob+ prefix.
+		{"encrypted+blob+mem://?keeper=" + keeperURL, false},
+		// Missing keeper.
+		{"encrypted+mem://", true},
+		// Invalid keeper.
+		{"encrypted+mem://?keeper=foo://", true},
+		// Invalid parameter for the underlying bucket.
+		{"encrypted+mem://?keeper=" + keeperURL + "&param=value", true},
+	}
+
+	ctx := context.Background()
+	for _, test := range tests {
+		b, err := blob.OpenBucket(ctx, test.URL)
+		if (err != nil) != test.WantErr {
+			t.Errorf("%s: got error %v, want error %v", test.URL, err, test.WantErr)
+		}
+		if err != nil {
+			continue
+		}
+		data := []byte("hello world")
+		if err := b.WriteAll(ctx, "key", data, nil); err != nil {
+			t.Fatal(err)
+		}
+		got, err := b.ReadAll(ctx, "key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("%s: got %q want %q", test.URL, got, data)
+		}
+		b.Close()
+	}
+}
+
+func TestSetAttributes(t *testing.T) {
+	ctx := context.Background()
+	b, inner := newTestBucket()
+	defer inner.Close()
+	defer b.Close()
+
+	data := testData(100)
+	if err := b.WriteAll(ctx, "key", data, &blob.WriterOptions{ContentEncoding: "identity"}); err != nil {
+		t.Fatal(err)
+	}
+	err := b.SetAttributes(ctx, "key", blob.AttributesUpdate{ContentType: "text/plain", Metadata: map[string]string{"a": "1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrs, err := b.Attributes(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.ContentType != "text/plain" || attrs.ContentEncoding != "identity" || len(attrs.Metadata) != 1 || attrs.Metadata["a"] != "1" {
+		t.Errorf("got ContentType %q, ContentEncoding %q, metadata %v", attrs.ContentType, attrs.ContentEncoding, attrs.Metadata)
+	}
+	// The blob can still be decrypted.
+	got, err := b.ReadAll(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("round-tripped data doesn't match")
+	}
+
+	err = b.SetAttributes(ctx, "key", blob.AttributesUpdate{Metadata: map[string]string{mdWrappedKey: "x"}})
+	if gcerrors.Code(err) != gcerrors.InvalidArgument {
+		t.Errorf("got %v want InvalidArgument error", err)
+	}
+}
+
+func TestCopyAttributesConcurrentWrite(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "go-cloud-encryptedblob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	inner, err := fileblob.OpenBucket(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inner.Close()
+	b := OpenBucket(inner, localsecrets.NewKeeper(testKey), nil)
+	defer b.Close()
+
+	if err := b.WriteAll(ctx, "key", testData(100), nil); err != nil {
+		t.Fatal(err)
+	}
+	// The blob is overwritten, with a new data key, after the data key of
+	// the first version is read.
+	data := testData(200)[100:]
+	overwritten := false
+	err = b.Copy(ctx, "key", "key", &blob.CopyOptions{
+		Attributes: &blob.AttributesUpdate{ContentType: "text/plain"},
+		BeforeCopy: func(func(interface{}) bool) error {
+			if overwritten {
+				return nil
+			}
+			overwritten = true
+			return b.WriteAll(ctx, "key", data, nil)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := b.ReadAll(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("got data of the overwritten blob")
+	}
+	attrs, err := b.Attributes(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.ContentType != "text/plain" {
+		t.Errorf("got ContentType %q want %q", attrs.ContentType, "text/plain")
+	}
+}
+
+// overwritingBucket implements driver.Bucket for reads from base, calling
+// overwrite after it returns the attributes of a blob.
+type overwritingBucket struct {
+	driver.Bucket
+	base      *blob.Bucket
+	overwrite func()
+}
+
+func (b *overwritingBucket) Attributes(ctx context.Context, key string) (*driver.Attributes, error) {
+	a, err := b.base.Attributes(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	b.overwrite()
+	return &driver.Attributes{
+		ContentType: a.ContentType,
+		Metadata:    a.Metadata,
+		ModTime:     a.ModTime,
+		Size:        a.Size,
+		ETag:        a.ETag,
+	}, nil
+}
+
+func (b *overwritingBucket) NewRangeReader(ctx context.Context, key string, offset, length int64, opts *driver.ReaderOptions) (driver.Reader, error) {
+	r, err := b.base.NewRangeReader(ctx, key, offset, length, &blob.ReaderOptions{IfMatch: opts.IfMatch})
+	if err != nil {
+		return nil, err
+	}
+	return &baseReader{r: r, attrs: driver.ReaderAttributes{Size: r.Size(), ETag: r.ETag()}}, nil
+}
+
+func (b *overwritingBucket) ErrorCode(err error) gcerrors.ErrorCode { return gcerrors.Code(err) }
+
+func (b *overwritingBucket) Close() error { return nil }
+
+type baseReader struct {
+	r     *blob.Reader
+	attrs driver.ReaderAttributes
+}
+
+func (r *baseReader) Read(p []byte) (int, error)           { return r.r.Read(p) }
+func (r *baseReader) Close() error                         { return r.r.Close() }
+func (r *baseReader) Attributes() *driver.ReaderAttributes { return &r.attrs }
+func (r *baseReader) As(i interface{}) bool                { return false }
+
+func TestReadConcurrentWrite(t *testing.T) {
+	ctx := context.Background()
+	base := memblob.OpenBucket(nil)
+	defer base.Close()
+	writer := OpenBucket(base, localsecrets.NewKeeper(testKey), nil)
+	defer writer.Close()
+	if err := writer.WriteAll(ctx, "key", testData(100), nil); err != nil {
+		t.Fatal(err)
+	}
+	inner := blob.NewBucket(&overwritingBucket{base: base, overwrite: func() {
+		if err := writer.WriteAll(ctx, "key", testData(200000), nil); err != nil {
+			t.Fatal(err)
+		}
+	}})
+	b := OpenBucket(inner, localsecrets.NewKeeper(testKey), nil)
+	defer b.Close()
+
+	// The blob is overwritten after its data key and size are read, so the
+	// read must fail rather than decrypt the new contents with the old key.
+	_, err := b.ReadAll(ctx, "key")
+	if gcerrors.Code(err) != gcerrors.FailedPrecondition {
+		t.Errorf("got error %v, want FailedPrecondition", err)
+	}
+}
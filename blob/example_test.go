@@ -71,6 +71,23 @@ func ExampleBucket_NewRangeReader() {
 	}
 }
 
+func ExampleBucket_Download() {
+	// Variables set up elsewhere:
+	ctx := context.Background()
+	var bucket *blob.Bucket
+
+	f, err := os.Create("model.bin")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	// Read "model.bin" into f in 16 MiB ranges, 8 at a time.
+	opts := &blob.DownloadOptions{PartSize: 16 << 20, Concurrency: 8}
+	if err := bucket.Download(ctx, "model.bin", f, opts); err != nil {
+		log.Fatal(err)
+	}
+}
+
 func ExampleBucket_NewWriter() {
 	// This example is used in https://gocloud.dev/howto/blob/data/#writing
 
@@ -94,6 +111,33 @@ func ExampleBucket_NewWriter() {
 	}
 }
 
+func ExampleBucket_NewWriter_compression() {
+	// Variables set up elsewhere:
+	ctx := context.Background()
+	var bucket *blob.Bucket
+
+	// Write "foo.txt" compressed with gzip. Its ContentEncoding is set to
+	// "gzip", so HTTP clients fetching it will decompress it too.
+	w, err := bucket.NewWriter(ctx, "foo.txt", &blob.WriterOptions{Compression: "gzip"})
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, writeErr := fmt.Fprintln(w, "Hello, World!")
+	if closeErr := w.Close(); writeErr != nil || closeErr != nil {
+		log.Fatal(writeErr, closeErr)
+	}
+
+	// Read it back, decompressed.
+	r, err := bucket.NewReader(ctx, "foo.txt", &blob.ReaderOptions{Decompress: true})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := io.Copy(os.Stdout, r); err != nil {
+		log.Fatal(err)
+	}
+}
+
 func ExampleBucket_NewWriter_cancel() {
 	// This example is used in https://gocloud.dev/howto/blob/data/#writing
 
@@ -0,0 +1,124 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blob
+
+import (
+	"context"
+	"io"
+	"strings"
+	"time"
+
+	"gocloud.dev/blob/driver"
+	"gocloud.dev/gcerrors"
+)
+
+// ExpiresAtMetadataKey is the Metadata key under which WriterOptions.ExpiresAt
+// is recorded for providers that don't support expiration natively. Its value
+// is the expiration time in RFC 3339 format.
+const ExpiresAtMetadataKey = "gocdk-expires-at"
+
+// expiresAt returns the expiration time of the blob with attributes a, or
+// the zero time if it doesn't expire.
+func expiresAt(a *driver.Attributes) time.Time {
+	if !a.ExpiresAt.IsZero() {
+		return a.ExpiresAt
+	}
+	for k, v := range a.Metadata {
+		// Some providers don't preserve the case of metadata keys.
+		if strings.ToLower(k) == ExpiresAtMetadataKey {
+			if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+				return t
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// PurgeExpired deletes the blobs that have expired (see
+// WriterOptions.ExpiresAt), and returns how many it deleted.
+//
+// Providers that support expiration natively remove expired blobs
+// efficiently. For other providers, PurgeExpired lists all the blobs in the
+// bucket, and retrieves the attributes of each to find the expired ones; if
+// the provider supports conditional deletes (see DeleteOptions.IfMatch), an
+// expired blob is only deleted if it wasn't overwritten meanwhile. Blobs
+// written while PurgeExpired is running may or may not be deleted.
+//
+// If any blobs can't be deleted, PurgeExpired returns a DeleteManyError with
+// the key and error for each of them, after attempting to delete the rest.
+// If listing the blobs fails, PurgeExpired returns that error.
+func (b *Bucket) PurgeExpired(ctx context.Context) (n int, err error) {
+	b.mu.RLock()
+	closed := b.closed
+	b.mu.RUnlock()
+	if closed {
+		return 0, errClosed
+	}
+	ctx = b.tracer.Start(ctx, "PurgeExpired")
+	defer func() { b.tracer.End(ctx, err) }()
+
+	if e, ok := b.b.(driver.Expirer); ok {
+		n, err := e.PurgeExpired(ctx)
+		return n, wrapError(b.b, err)
+	}
+	var dmerr DeleteManyError
+	now := time.Now()
+	// conditional is false once the provider has rejected a conditional
+	// delete.
+	conditional := true
+	iter := b.List(nil)
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return n, err
+		}
+		// The expiration time is only available in the blob's attributes.
+		a, err := b.Attributes(ctx, obj.Key)
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			continue
+		}
+		if err == nil && (a.ExpiresAt.IsZero() || a.ExpiresAt.After(now)) {
+			continue
+		}
+		if err == nil {
+			etag := a.ETag
+			if !conditional {
+				etag = ""
+			}
+			err = b.DeleteWithOptions(ctx, obj.Key, &DeleteOptions{IfMatch: etag})
+			if etag != "" && gcerrors.Code(err) == gcerrors.Unimplemented {
+				// The provider doesn't support conditional deletes.
+				conditional = false
+				err = b.Delete(ctx, obj.Key)
+			}
+			if code := gcerrors.Code(err); code == gcerrors.NotFound || code == gcerrors.FailedPrecondition {
+				// The blob was deleted or overwritten meanwhile.
+				continue
+			}
+		}
+		if err != nil {
+			dmerr = append(dmerr, DeleteManyFailure{Key: obj.Key, Err: err})
+			continue
+		}
+		n++
+	}
+	if len(dmerr) > 0 {
+		return n, dmerr
+	}
+	return n, nil
+}
@@ -0,0 +1,229 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blob_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/cacheblob"
+	"gocloud.dev/blob/driver"
+	"gocloud.dev/blob/fileblob"
+	"gocloud.dev/blob/memblob"
+	"gocloud.dev/blob/replicablob"
+	"gocloud.dev/gcerrors"
+)
+
+func TestPurgeExpired(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "go-cloud-blob-expire")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	openFileblob := func(t *testing.T, name string, opts *fileblob.Options) *blob.Bucket {
+		if err := os.Mkdir(dir+"/"+name, 0777); err != nil {
+			t.Fatal(err)
+		}
+		b, err := fileblob.OpenBucket(dir+"/"+name, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	for _, test := range []struct {
+		name string
+		open func(t *testing.T, name string) *blob.Bucket
+		// native is true if expired blobs are hidden before they are purged.
+		native bool
+	}{
+		{"memblob", func(*testing.T, string) *blob.Bucket { return memblob.OpenBucket(nil) }, true},
+		{"fileblob", func(t *testing.T, name string) *blob.Bucket { return openFileblob(t, name, nil) }, true},
+		{"fileblobIndex", func(t *testing.T, name string) *blob.Bucket {
+			return openFileblob(t, name, &fileblob.Options{Index: true})
+		}, true},
+		{"fileblobVersioning", func(t *testing.T, name string) *blob.Bucket {
+			return openFileblob(t, name, &fileblob.Options{Versioning: true})
+		}, true},
+		// Buckets that wrap another one don't implement driver.Expirer, so
+		// expired blobs are visible until they are purged, even if the
+		// wrapped bucket supports expiration.
+		{"prefixed", func(*testing.T, string) *blob.Bucket {
+			return blob.PrefixedBucket(memblob.OpenBucket(nil), "p/")
+		}, false},
+		{"cacheblob", func(*testing.T, string) *blob.Bucket {
+			return cacheblob.OpenBucket(memblob.OpenBucket(nil), memblob.OpenBucket(nil), nil)
+		}, false},
+		{"replicablob", func(*testing.T, string) *blob.Bucket {
+			return replicablob.OpenBucket([]*blob.Bucket{memblob.OpenBucket(nil), memblob.OpenBucket(nil)}, nil)
+		}, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			b := test.open(t, test.name)
+			defer b.Close()
+
+			now := time.Now()
+			later := now.Add(time.Hour)
+			for key, expiresAt := range map[string]time.Time{
+				"expired":     now.Add(-time.Second),
+				"dir/expired": now.Add(-time.Second),
+				"later":       later,
+				"never":       {},
+			} {
+				if err := b.WriteAll(ctx, key, []byte(key), &blob.WriterOptions{ExpiresAt: expiresAt}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if test.native {
+				if _, err := b.ReadAll(ctx, "expired"); gcerrors.Code(err) != gcerrors.NotFound {
+					t.Errorf("read expired blob: got error %v, want NotFound", err)
+				}
+				if got, want := listKeys(t, b, nil), []string{"later", "never"}; !cmp.Equal(got, want) {
+					t.Errorf("before PurgeExpired, got keys %v want %v", got, want)
+				}
+			}
+			a, err := b.Attributes(ctx, "later")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !a.ExpiresAt.Equal(later) {
+				t.Errorf("got ExpiresAt %v want %v", a.ExpiresAt, later)
+			}
+			// Copies expire at the same time.
+			if err := b.Copy(ctx, "copy", "later", nil); err != nil {
+				t.Fatal(err)
+			}
+			if a, err := b.Attributes(ctx, "copy"); err != nil {
+				t.Fatal(err)
+			} else if !a.ExpiresAt.Equal(later) {
+				t.Errorf("got copy ExpiresAt %v want %v", a.ExpiresAt, later)
+			}
+
+			n, err := b.PurgeExpired(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Native implementations may have removed expired blobs when
+			// they were looked up.
+			if n > 2 || (!test.native && n != 2) {
+				t.Errorf("got %d blobs purged, want 2", n)
+			}
+			if got, want := listKeys(t, b, nil), []string{"copy", "later", "never"}; !cmp.Equal(got, want) {
+				t.Errorf("after PurgeExpired, got keys %v want %v", got, want)
+			}
+			if _, err := b.Attributes(ctx, "dir/expired"); gcerrors.Code(err) != gcerrors.NotFound {
+				t.Errorf("attributes of purged blob: got error %v, want NotFound", err)
+			}
+			// Overwriting a blob without ExpiresAt makes it permanent.
+			if err := b.WriteAll(ctx, "later", nil, nil); err != nil {
+				t.Fatal(err)
+			}
+			if a, err := b.Attributes(ctx, "later"); err != nil {
+				t.Fatal(err)
+			} else if !a.ExpiresAt.IsZero() {
+				t.Errorf("after overwrite, got ExpiresAt %v want zero", a.ExpiresAt)
+			}
+		})
+	}
+}
+
+var errNoConditions = errors.New("conditional deletes are not supported")
+
+// noConditionsBucket implements driver.Bucket for blobs that expire at the
+// times in expiresAt. Like some cloud providers, it reports ETags but doesn't
+// support conditional deletes.
+type noConditionsBucket struct {
+	driver.Bucket
+	expiresAt map[string]time.Time
+}
+
+func (b *noConditionsBucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driver.ListPage, error) {
+	var keys []string
+	for key := range b.expiresAt {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	page := &driver.ListPage{}
+	for _, key := range keys {
+		page.Objects = append(page.Objects, &driver.ListObject{Key: key})
+	}
+	return page, nil
+}
+
+func (b *noConditionsBucket) Attributes(ctx context.Context, key string) (*driver.Attributes, error) {
+	t, ok := b.expiresAt[key]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return &driver.Attributes{ETag: "etag-" + key, ExpiresAt: t}, nil
+}
+
+func (b *noConditionsBucket) Delete(ctx context.Context, key string, opts *driver.DeleteOptions) error {
+	if opts.IfMatch != "" || opts.IfNoneMatch != "" {
+		return errNoConditions
+	}
+	if _, ok := b.expiresAt[key]; !ok {
+		return os.ErrNotExist
+	}
+	delete(b.expiresAt, key)
+	return nil
+}
+
+func (b *noConditionsBucket) ErrorCode(err error) gcerrors.ErrorCode {
+	switch err {
+	case errNoConditions:
+		return gcerrors.Unimplemented
+	case os.ErrNotExist:
+		return gcerrors.NotFound
+	}
+	return gcerrors.Unknown
+}
+
+func (b *noConditionsBucket) Close() error { return nil }
+
+func TestPurgeExpiredWithoutConditions(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	fake := &noConditionsBucket{expiresAt: map[string]time.Time{
+		"expired1": now.Add(-time.Second),
+		"expired2": now.Add(-time.Second),
+		"later":    now.Add(time.Hour),
+		"never":    {},
+	}}
+	b := blob.NewBucket(fake)
+	defer b.Close()
+
+	n, err := b.PurgeExpired(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("got %d blobs purged, want 2", n)
+	}
+	if _, ok := fake.expiresAt["expired1"]; ok {
+		t.Error("expired blob wasn't deleted")
+	}
+	if len(fake.expiresAt) != 2 {
+		t.Errorf("got %d blobs left, want 2", len(fake.expiresAt))
+	}
+}
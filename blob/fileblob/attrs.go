@@ -18,6 +18,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"time"
 )
 
 const attrsExt = ".attrs"
@@ -35,6 +36,16 @@ type xattrs struct {
 	ContentType        string            `json:"user.content_type"`
 	Metadata           map[string]string `json:"user.metadata"`
 	MD5                []byte            `json:"md5"`
+	CRC32C             []byte            `json:"crc32c,omitempty"`
+	SHA256             []byte            `json:"sha256,omitempty"`
+	ETag               string            `json:"etag"`
+	VersionID          string            `json:"version_id,omitempty"`
+	ExpiresAt          *time.Time        `json:"expires_at,omitempty"`
+}
+
+// expired reports whether the blob has expired at now.
+func (xa *xattrs) expired(now time.Time) bool {
+	return xa.ExpiresAt != nil && !now.Before(*xa.ExpiresAt)
 }
 
 // setAttrs creates a "path.attrs" file along with blob to store the attributes,
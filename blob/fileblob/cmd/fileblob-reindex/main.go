@@ -0,0 +1,53 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// fileblob-reindex rebuilds the index of fileblob buckets, for when it has
+// become inconsistent with their files. See the fileblob package
+// documentation for details.
+//
+// Usage:
+//
+//   fileblob-reindex <directory>...
+//
+// Writes to the buckets while the index is rebuilt may be missing from it,
+// so it is best run while they are not in use.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"gocloud.dev/blob/fileblob"
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: fileblob-reindex <directory>...\n")
+		flag.PrintDefaults()
+	}
+	log.SetFlags(0)
+	log.SetPrefix("fileblob-reindex: ")
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	for _, dir := range flag.Args() {
+		if err := fileblob.RebuildIndex(dir); err != nil {
+			log.Fatalf("rebuilding index of %s: %v", dir, err)
+		}
+	}
+}
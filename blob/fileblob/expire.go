@@ -0,0 +1,107 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fileblob
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// PurgeExpired implements driver.Expirer.
+func (b *bucket) PurgeExpired(ctx context.Context) (int, error) {
+	// Collect the expired keys first, so that the walk doesn't see the
+	// directory changing under it.
+	now := time.Now()
+	var keys []string
+	err := filepath.Walk(b.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				// The file was removed meanwhile.
+				return nil
+			}
+			return err
+		}
+		if path == b.dir {
+			return nil
+		}
+		rel := path[len(b.dir)+1:]
+		if rel == versionsDir || rel == indexDir {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() || strings.HasSuffix(rel, attrsExt) || isTempFile(info.Name()) {
+			return nil
+		}
+		xa, err := getAttrs(path)
+		if err != nil || !xa.expired(now) {
+			return nil
+		}
+		keys = append(keys, unescapeKey(rel))
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		removed, err := b.purge(key, now)
+		if err != nil {
+			return n, err
+		}
+		if removed {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// purge removes the blob for key if it expired at now. It reports whether
+// the blob was removed.
+func (b *bucket) purge(key string, now time.Time) (bool, error) {
+	path, err := b.path(key)
+	if err != nil {
+		return false, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// The blob may have been overwritten since it was found.
+	xa, err := getAttrs(path)
+	if err != nil || !xa.expired(now) {
+		return false, nil
+	}
+	if err := os.Remove(path); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if err := os.Remove(path + attrsExt); err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	if b.index != nil {
+		if err := b.index.remove(key); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
@@ -33,6 +33,46 @@
 //    "/" is key names are escaped in the same way.
 //    On Windows, the characters "<>:"|?*" are also escaped.
 //
+// Versioning
+//
+// If Options.Versioning is set, fileblob implements driver.Versioner, keeping
+// overwritten and deleted blobs as previous versions in a ".fileblob-versions"
+// directory under the bucket's root directory.
+//
+// Index
+//
+// Listing a directory tree requires walking all of it, and sorting the
+// results, on every call to ListPaged. If Options.Index is set, fileblob keeps
+// a sorted index of blob keys in a ".fileblob-index" directory under the
+// bucket's root directory, so that listing a page of blobs only reads the
+// part of the index that the page covers, and the files in it.
+//
+// The index is updated when blobs are written, copied, moved or deleted
+// through the bucket. It becomes inconsistent if the directory is modified
+// in other ways, including by several processes at once, or if a process
+// crashes while writing or deleting a blob; use RebuildIndex, or the
+// gocloud.dev/blob/fileblob/cmd/fileblob-reindex command, to rebuild it.
+// Listing skips indexed keys whose files no longer exist, but doesn't return
+// files that are missing from the index.
+//
+// Expiration
+//
+// fileblob implements driver.Expirer. The expiration time of blobs written
+// with blob.WriterOptions.ExpiresAt is stored with their attributes; once it
+// passes, the blob is treated as deleted, and its files are removed by
+// blob.Bucket.PurgeExpired, or when the blob is overwritten. Expired blobs
+// are not kept as previous versions.
+//
+// Watching
+//
+// fileblob implements driver.Watcher using filesystem notifications, so
+// changes made by other processes are reported as well as changes made
+// through the bucket. Changes to a blob that haven't been returned by Next
+// yet are reported as a single event. If more than 10000 blobs have changes
+// that haven't been returned, the watcher stops reporting changes, and Next
+// returns an error for which gcerrors.Code returns
+// gcerrors.ResourceExhausted.
+//
 // As
 //
 // fileblob exposes the following types for As:
@@ -43,18 +83,23 @@ import (
 	"context"
 	"crypto/hmac"
 	"crypto/md5"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"hash"
+	"hash/crc32"
 	"io"
 	"io/ioutil"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"gocloud.dev/blob"
@@ -65,6 +110,14 @@ import (
 
 const defaultPageSize = 1000
 
+// crc32cTable is used to compute the CRC32C checksums of blobs.
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+var (
+	errNotImplemented     = errors.New("not implemented")
+	errPreconditionFailed = errors.New("precondition failed")
+)
+
 func init() {
 	blob.DefaultURLMux().RegisterBucket(Scheme, &URLOpener{})
 }
@@ -80,7 +133,12 @@ const Scheme = "file"
 // If os.PathSeparator != "/", any leading "/" from the path is dropped
 // and remaining '/' characters are converted to os.PathSeparator.
 //
-// No query options are supported. Examples:
+// The following query parameters are supported:
+//
+//   - versioning: a boolean; if true, sets Options.Versioning.
+//   - index: a boolean; if true, sets Options.Index.
+//
+// Examples:
 //
 //  - file:///a/directory
 //    -> Passes "/a/directory" to OpenBucket.
@@ -94,14 +152,30 @@ type URLOpener struct{}
 
 // OpenBucketURL opens a blob.Bucket based on u.
 func (*URLOpener) OpenBucketURL(ctx context.Context, u *url.URL) (*blob.Bucket, error) {
-	for param := range u.Query() {
-		return nil, fmt.Errorf("open bucket %v: invalid query parameter %q", u, param)
+	opts := &Options{}
+	for param, values := range u.Query() {
+		switch param {
+		case "versioning":
+			v, err := strconv.ParseBool(values[0])
+			if err != nil {
+				return nil, fmt.Errorf("open bucket %v: invalid value %q for query parameter %q", u, values[0], param)
+			}
+			opts.Versioning = v
+		case "index":
+			v, err := strconv.ParseBool(values[0])
+			if err != nil {
+				return nil, fmt.Errorf("open bucket %v: invalid value %q for query parameter %q", u, values[0], param)
+			}
+			opts.Index = v
+		default:
+			return nil, fmt.Errorf("open bucket %v: invalid query parameter %q", u, param)
+		}
 	}
 	path := u.Path
 	if os.PathSeparator != '/' {
 		path = strings.TrimPrefix(path, "/")
 	}
-	return OpenBucket(filepath.FromSlash(path), nil)
+	return OpenBucket(filepath.FromSlash(path), opts)
 }
 
 // Options sets options for constructing a *blob.Bucket backed by fileblob.
@@ -111,11 +185,28 @@ type Options struct {
 	// contains a signature produced by the URLSigner.
 	// URLSigner is only required for utilizing the SignedURL API.
 	URLSigner URLSigner
+
+	// Versioning enables keeping previous versions of blobs when they are
+	// overwritten or deleted; see the package documentation.
+	Versioning bool
+
+	// Index enables keeping an index of blob keys, so that listing a page of
+	// blobs doesn't require walking the whole directory tree; see the package
+	// documentation. The index is built when the bucket is opened if it
+	// doesn't exist.
+	Index bool
 }
 
 type bucket struct {
 	dir  string
 	opts *Options
+	// index is nil unless opts.Index is set.
+	index *index
+
+	// mu serializes precondition checks with the modifications they guard.
+	// It only protects against concurrent modifications via this bucket;
+	// other processes writing to dir may still race with us.
+	mu sync.Mutex
 }
 
 // openBucket creates a driver.Bucket that reads and writes to dir.
@@ -132,7 +223,13 @@ func openBucket(dir string, opts *Options) (driver.Bucket, error) {
 	if opts == nil {
 		opts = &Options{}
 	}
-	return &bucket{dir: dir, opts: opts}, nil
+	b := &bucket{dir: dir, opts: opts}
+	if opts.Index {
+		if b.index, err = openIndex(dir); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
 }
 
 // OpenBucket creates a *blob.Bucket backed by the filesystem and rooted at
@@ -196,6 +293,12 @@ func (b *bucket) ErrorCode(err error) gcerrors.ErrorCode {
 	switch {
 	case os.IsNotExist(err):
 		return gcerrors.NotFound
+	case err == errPreconditionFailed:
+		return gcerrors.FailedPrecondition
+	case err == errNotImplemented:
+		return gcerrors.Unimplemented
+	case err == errWatchOverflow:
+		return gcerrors.ResourceExhausted
 	default:
 		return gcerrors.Unknown
 	}
@@ -207,6 +310,12 @@ func (b *bucket) path(key string) (string, error) {
 	if strings.HasSuffix(path, attrsExt) {
 		return "", errAttrsExt
 	}
+	if vroot := filepath.Join(b.dir, versionsDir); path == vroot || strings.HasPrefix(path, vroot+string(os.PathSeparator)) {
+		return "", errVersionsDir
+	}
+	if iroot := filepath.Join(b.dir, indexDir); path == iroot || strings.HasPrefix(path, iroot+string(os.PathSeparator)) {
+		return "", errIndexDir
+	}
 	return path, nil
 }
 
@@ -224,9 +333,75 @@ func (b *bucket) forKey(key string) (string, os.FileInfo, *xattrs, error) {
 	if err != nil {
 		return "", nil, nil, err
 	}
+	if xa.expired(time.Now()) {
+		return "", nil, nil, os.ErrNotExist
+	}
 	return path, info, &xa, nil
 }
 
+// etag returns the ETag for a file. Blobs written by fileblob have a random
+// ETag stored in their attributes; for other files, one is derived from the
+// modification time and size.
+func etag(info os.FileInfo, xa *xattrs) string {
+	if xa.ETag != "" {
+		return xa.ETag
+	}
+	return fmt.Sprintf("%x-%x", info.ModTime().UnixNano(), info.Size())
+}
+
+// expiresAt returns the expiration time in xa, or the zero time.
+func expiresAt(xa *xattrs) time.Time {
+	if xa.ExpiresAt == nil {
+		return time.Time{}
+	}
+	return *xa.ExpiresAt
+}
+
+// newETag returns a new random ETag.
+func newETag() (string, error) {
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf[:]), nil
+}
+
+// checkPreconditions returns errPreconditionFailed if the file at path does
+// not satisfy ifMatch and ifNoneMatch.
+func checkPreconditions(path, ifMatch, ifNoneMatch string) error {
+	if ifMatch == "" && ifNoneMatch == "" {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		if ifMatch != "" {
+			return errPreconditionFailed
+		}
+		return nil
+	}
+	xa, err := getAttrs(path)
+	if err != nil {
+		return err
+	}
+	if xa.expired(time.Now()) {
+		if ifMatch != "" {
+			return errPreconditionFailed
+		}
+		return nil
+	}
+	cur := etag(info, &xa)
+	if ifMatch != "" && ifMatch != "*" && ifMatch != cur {
+		return errPreconditionFailed
+	}
+	if ifNoneMatch != "" && (ifNoneMatch == "*" || ifNoneMatch == cur) {
+		return errPreconditionFailed
+	}
+	return nil
+}
+
 // ListPaged implements driver.ListPaged.
 func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driver.ListPage, error) {
 
@@ -234,10 +409,17 @@ func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driv
 	if len(opts.PageToken) > 0 {
 		pageToken = string(opts.PageToken)
 	}
+	// StartAfter skips keys in the same way.
+	if opts.StartAfter > pageToken {
+		pageToken = opts.StartAfter
+	}
 	pageSize := opts.PageSize
 	if pageSize == 0 {
 		pageSize = defaultPageSize
 	}
+	if b.index != nil {
+		return b.listIndexed(opts, pageToken, pageSize)
+	}
 	// If opts.Delimiter != "", lastPrefix contains the last "directory" key we
 	// added. It is used to avoid adding it again; all files in this "directory"
 	// are collapsed to the single directory entry.
@@ -254,12 +436,22 @@ func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driv
 	}
 
 	// Do a full recursive scan of the root directory.
+	now := time.Now()
+	vroot := filepath.Join(b.dir, versionsDir)
+	iroot := filepath.Join(b.dir, indexDir)
 	var result driver.ListPage
 	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			// Couldn't read this file/directory for some reason; just skip it.
 			return nil
 		}
+		// Skip previous versions of blobs, and the index.
+		if path == vroot || path == iroot {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
 		// Skip the self-generated attribute files.
 		if strings.HasSuffix(path, attrsExt) {
 			return nil
@@ -289,24 +481,29 @@ func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driv
 			if lastPrefix != "" && strings.HasPrefix(key, lastPrefix) {
 				return filepath.SkipDir
 			}
+			// Also avoid recursing into subdirectories whose keys all sort
+			// before the pageToken.
+			if key < pageToken && !strings.HasPrefix(pageToken, key) {
+				return filepath.SkipDir
+			}
 			return nil
 		}
 		// Skip files/directories that don't match the Prefix.
 		if !strings.HasPrefix(key, opts.Prefix) {
 			return nil
 		}
-		var md5 []byte
-		if xa, err := getAttrs(path); err == nil {
-			// Note: we only have the MD5 hash for blobs that we wrote.
-			// For other blobs, md5 will remain nil.
-			md5 = xa.MD5
+		if skipFile(opts, key, info) {
+			return nil
 		}
-		obj := &driver.ListObject{
-			Key:     key,
-			ModTime: info.ModTime(),
-			Size:    info.Size(),
-			MD5:     md5,
+		xa, err := getAttrs(filepath.Join(b.dir, path))
+		if err != nil {
+			// Malformed attributes; proceed without them.
+			xa = xattrs{}
+		}
+		if xa.expired(now) {
+			return nil
 		}
+		obj := listObject(key, info, &xa)
 		// If using Delimiter, collapse "directories".
 		if opts.Delimiter != "" {
 			// Strip the prefix, which may contain Delimiter.
@@ -347,6 +544,125 @@ func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driv
 	return &result, nil
 }
 
+// skipFile reports whether the file holding key can be skipped by ListPaged
+// because it doesn't pass the filters in opts. It's checked before the
+// attributes are read, so that they are only read for the files that are
+// listed. Files that are collapsed into a "directory" are never skipped.
+func skipFile(opts *driver.ListOptions, key string, info os.FileInfo) bool {
+	if opts.Delimiter != "" && strings.Contains(key[len(opts.Prefix):], opts.Delimiter) {
+		return false
+	}
+	return !opts.Includes(&driver.ListObject{Key: key, ModTime: info.ModTime(), Size: info.Size()})
+}
+
+// listObject returns the driver.ListObject for the file holding key.
+func listObject(key string, info os.FileInfo, xa *xattrs) *driver.ListObject {
+	return &driver.ListObject{
+		Key:     key,
+		ModTime: info.ModTime(),
+		Size:    info.Size(),
+		// Note: we only have the checksums for blobs that we wrote.
+		// For other blobs, they will remain nil.
+		MD5:    xa.MD5,
+		CRC32C: xa.CRC32C,
+		SHA256: xa.SHA256,
+		ETag:   etag(info, xa),
+	}
+}
+
+// listIndexed implements ListPaged using the index. It seeks to the first
+// key that can be in the page, and past the keys of each "directory" it
+// collapses, so the time it takes is proportional to the size of the page.
+func (b *bucket) listIndexed(opts *driver.ListOptions, pageToken string, pageSize int) (*driver.ListPage, error) {
+	x := b.index
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if err := x.refresh(); err != nil {
+		return nil, err
+	}
+	start := opts.Prefix
+	if pageToken > start {
+		start = pageToken
+	}
+	it, err := x.iter(start)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var result driver.ListPage
+	for {
+		key, ok, err := it.next()
+		if err != nil {
+			return nil, err
+		}
+		// Keys are sorted, so the ones after a key that doesn't match the
+		// Prefix don't match either.
+		if !ok || !strings.HasPrefix(key, opts.Prefix) {
+			break
+		}
+		path, err := b.path(key)
+		if err != nil {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() {
+			// The index is out of date; skip the key.
+			continue
+		}
+		if skipFile(opts, key, info) {
+			continue
+		}
+		xa, err := getAttrs(path)
+		if err != nil {
+			// Malformed attributes; proceed without them.
+			xa = xattrs{}
+		}
+		if xa.expired(now) {
+			continue
+		}
+		var obj *driver.ListObject
+		last := false
+		// If using Delimiter, collapse "directories".
+		if opts.Delimiter != "" {
+			keyWithoutPrefix := key[len(opts.Prefix):]
+			if idx := strings.Index(keyWithoutPrefix, opts.Delimiter); idx != -1 {
+				prefix := opts.Prefix + keyWithoutPrefix[0:idx+len(opts.Delimiter)]
+				// Skip the rest of the keys in the "directory".
+				if end := prefixEnd(prefix); end != "" {
+					if err := it.seek(end); err != nil {
+						return nil, err
+					}
+				} else {
+					last = true
+				}
+				obj = &driver.ListObject{
+					Key:   prefix,
+					IsDir: true,
+				}
+			}
+		}
+		if obj == nil {
+			obj = listObject(key, info, &xa)
+		}
+		if pageToken != "" && obj.Key <= pageToken {
+			if last {
+				break
+			}
+			continue
+		}
+		// If we've already got a full page of results, set NextPageToken and stop.
+		if len(result.Objects) == pageSize {
+			result.NextPageToken = []byte(result.Objects[pageSize-1].Key)
+			break
+		}
+		result.Objects = append(result.Objects, obj)
+		if last {
+			break
+		}
+	}
+	return &result, nil
+}
+
 // As implements driver.As.
 func (b *bucket) As(i interface{}) bool { return false }
 
@@ -377,6 +693,10 @@ func (b *bucket) Attributes(ctx context.Context, key string) (*driver.Attributes
 		ModTime:            info.ModTime(),
 		Size:               info.Size(),
 		MD5:                xa.MD5,
+		CRC32C:             xa.CRC32C,
+		SHA256:             xa.SHA256,
+		ETag:               etag(info, xa),
+		ExpiresAt:          expiresAt(xa),
 	}, nil
 }
 
@@ -386,6 +706,14 @@ func (b *bucket) NewRangeReader(ctx context.Context, key string, offset, length
 	if err != nil {
 		return nil, err
 	}
+	if err := checkPreconditions(path, opts.IfMatch, opts.IfNoneMatch); err != nil {
+		return nil, err
+	}
+	return openRange(path, info, xa, offset, length)
+}
+
+// openRange returns a reader for the given range of the file at path.
+func openRange(path string, info os.FileInfo, xa *xattrs, offset, length int64) (driver.Reader, error) {
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
@@ -406,6 +734,12 @@ func (b *bucket) NewRangeReader(ctx context.Context, key string, offset, length
 			ContentType: xa.ContentType,
 			ModTime:     info.ModTime(),
 			Size:        info.Size(),
+
+			ContentEncoding: xa.ContentEncoding,
+			MD5:             xa.MD5,
+			CRC32C:          xa.CRC32C,
+			SHA256:          xa.SHA256,
+			ETag:            etag(info, xa),
 		},
 	}, nil
 }
@@ -466,31 +800,49 @@ func (b *bucket) NewTypedWriter(ctx context.Context, key string, contentType str
 		ContentType:        contentType,
 		Metadata:           metadata,
 	}
+	if !opts.ExpiresAt.IsZero() {
+		t := opts.ExpiresAt
+		attrs.ExpiresAt = &t
+	}
 	w := &writer{
-		ctx:        ctx,
-		f:          f,
-		path:       path,
-		attrs:      attrs,
-		contentMD5: opts.ContentMD5,
-		md5hash:    md5.New(),
+		ctx:         ctx,
+		b:           b,
+		key:         key,
+		f:           f,
+		path:        path,
+		attrs:       attrs,
+		contentMD5:  opts.ContentMD5,
+		ifMatch:     opts.IfMatch,
+		ifNoneMatch: opts.IfNoneMatch,
+		md5hash:     md5.New(),
+		crc32chash:  crc32.New(crc32cTable),
+		sha256hash:  sha256.New(),
 	}
 	return w, nil
 }
 
 type writer struct {
-	ctx        context.Context
-	f          *os.File
-	path       string
-	attrs      xattrs
-	contentMD5 []byte
-	// We compute the MD5 hash so that we can store it with the file attributes,
-	// not for verification.
-	md5hash hash.Hash
+	ctx         context.Context
+	b           *bucket
+	key         string
+	f           *os.File
+	path        string
+	attrs       xattrs
+	contentMD5  []byte
+	ifMatch     string
+	ifNoneMatch string
+	// We compute the checksums so that we can store them with the file
+	// attributes, not for verification.
+	md5hash    hash.Hash
+	crc32chash hash.Hash
+	sha256hash hash.Hash
 }
 
 func (w *writer) Write(p []byte) (n int, err error) {
-	if _, err := w.md5hash.Write(p); err != nil {
-		return 0, err
+	for _, h := range []hash.Hash{w.md5hash, w.crc32chash, w.sha256hash} {
+		if _, err := h.Write(p); err != nil {
+			return 0, err
+		}
 	}
 	return w.f.Write(p)
 }
@@ -511,18 +863,39 @@ func (w *writer) Close() error {
 		return err
 	}
 
-	md5sum := w.md5hash.Sum(nil)
-	w.attrs.MD5 = md5sum
+	w.attrs.MD5 = w.md5hash.Sum(nil)
+	w.attrs.CRC32C = w.crc32chash.Sum(nil)
+	w.attrs.SHA256 = w.sha256hash.Sum(nil)
+	if w.attrs.ETag, err = newETag(); err != nil {
+		return err
+	}
 
+	w.b.mu.Lock()
+	defer w.b.mu.Unlock()
+	if err := checkPreconditions(w.path, w.ifMatch, w.ifNoneMatch); err != nil {
+		return err
+	}
+	undo := func() {}
+	if w.b.opts.Versioning {
+		w.attrs.VersionID = newVersionID()
+		if undo, err = w.b.archive(w.key, w.path); err != nil {
+			return err
+		}
+	}
 	// Write the attributes file.
 	if err := setAttrs(w.path, w.attrs); err != nil {
+		undo()
 		return err
 	}
 	// Rename the temp file to path.
 	if err := os.Rename(w.f.Name(), w.path); err != nil {
 		_ = os.Remove(w.path + attrsExt)
+		undo()
 		return err
 	}
+	if w.b.index != nil {
+		return w.b.index.add(w.key)
+	}
 	return nil
 }
 
@@ -548,13 +921,25 @@ func (b *bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.C
 		ContentEncoding:    xa.ContentEncoding,
 		ContentLanguage:    xa.ContentLanguage,
 		Metadata:           xa.Metadata,
+		IfMatch:            opts.IfMatch,
+		IfNoneMatch:        opts.IfNoneMatch,
+		ExpiresAt:          expiresAt(xa),
 		BeforeWrite:        opts.BeforeCopy,
 	}
+	contentType := xa.ContentType
+	if a := opts.Attributes; a != nil {
+		wopts.CacheControl = a.CacheControl
+		wopts.ContentDisposition = a.ContentDisposition
+		wopts.ContentEncoding = a.ContentEncoding
+		wopts.ContentLanguage = a.ContentLanguage
+		wopts.Metadata = a.Metadata
+		contentType = a.ContentType
+	}
 	// Create a cancelable context so we can cancel the write if there are
 	// problems.
 	writeCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
-	w, err := b.NewTypedWriter(writeCtx, dstKey, xa.ContentType, &wopts)
+	w, err := b.NewTypedWriter(writeCtx, dstKey, contentType, &wopts)
 	if err != nil {
 		return err
 	}
@@ -567,19 +952,103 @@ func (b *bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.C
 	return w.Close()
 }
 
+// SetAttributes implements driver.AttributesSetter.
+func (b *bucket) SetAttributes(ctx context.Context, key string, attrs *driver.WritableAttributes) error {
+	if b.opts.Versioning {
+		// Keep the previous attributes as a version of the blob.
+		return b.Copy(ctx, key, key, &driver.CopyOptions{Attributes: attrs})
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	path, _, xa, err := b.forKey(key)
+	if err != nil {
+		return err
+	}
+	xa.CacheControl = attrs.CacheControl
+	xa.ContentDisposition = attrs.ContentDisposition
+	xa.ContentEncoding = attrs.ContentEncoding
+	xa.ContentLanguage = attrs.ContentLanguage
+	xa.ContentType = attrs.ContentType
+	xa.Metadata = nil
+	if len(attrs.Metadata) > 0 {
+		xa.Metadata = attrs.Metadata
+	}
+	// The blob's content doesn't change, but its ETag does, so that
+	// preconditions and caches take the new attributes into account.
+	if xa.ETag, err = newETag(); err != nil {
+		return err
+	}
+	return setAttrs(path, *xa)
+}
+
+// Move implements driver.Mover. It is not supported with versioning, since
+// the moved blob must also remain a previous version of srcKey.
+func (b *bucket) Move(ctx context.Context, dstKey, srcKey string) error {
+	if b.opts.Versioning {
+		return errNotImplemented
+	}
+	dstPath, err := b.path(dstKey)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	srcPath, _, xa, err := b.forKey(srcKey)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dstPath), 0777); err != nil {
+		return err
+	}
+	// As for writes, the attributes file is moved first.
+	if err := setAttrs(dstPath, *xa); err != nil {
+		return err
+	}
+	if err := os.Rename(srcPath, dstPath); err != nil {
+		_ = os.Remove(dstPath + attrsExt)
+		return err
+	}
+	if err := os.Remove(srcPath + attrsExt); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if b.index != nil {
+		if err := b.index.add(dstKey); err != nil {
+			return err
+		}
+		return b.index.remove(srcKey)
+	}
+	return nil
+}
+
 // Delete implements driver.Delete.
-func (b *bucket) Delete(ctx context.Context, key string) error {
+func (b *bucket) Delete(ctx context.Context, key string, opts *driver.DeleteOptions) error {
 	path, err := b.path(key)
 	if err != nil {
 		return err
 	}
-	err = os.Remove(path)
-	if err != nil {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, _, _, err := b.forKey(key); err != nil {
 		return err
 	}
-	if err = os.Remove(path + attrsExt); err != nil && !os.IsNotExist(err) {
+	if err := checkPreconditions(path, opts.IfMatch, opts.IfNoneMatch); err != nil {
 		return err
 	}
+	if b.opts.Versioning {
+		if _, err := b.archive(key, path); err != nil {
+			return err
+		}
+	} else {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		if err := os.Remove(path + attrsExt); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if b.index != nil {
+		return b.index.remove(key)
+	}
 	return nil
 }
 
@@ -607,12 +1076,26 @@ type URLSigner interface {
 
 	// KeyFromURL must be able to validate a URL returned from URLFromKey.
 	// KeyFromURL must only return the object if if the URL is
-	// both unexpired and authentic. KeyFromURL must be safe to call from
-	// multiple goroutines. Implementations of KeyFromURL should not modify
-	// the URL argument.
+	// both unexpired and authentic, and must reject URLs that were signed
+	// for other methods than GET, since callers use it to authorize reads.
+	// KeyFromURL must be safe to call from multiple goroutines.
+	// Implementations of KeyFromURL should not modify the URL argument.
 	KeyFromURL(ctx context.Context, surl *url.URL) (string, error)
 }
 
+// URLVerifier is an optional interface that a URLSigner may implement if the
+// URLs it returns are signed for the HTTP method and content type in the
+// driver.SignedURLOptions. NewSignedURLHandler uses it to check which
+// requests a URL permits.
+type URLVerifier interface {
+	// VerifyURL validates a URL returned from URLFromKey, like KeyFromURL
+	// but accepting URLs signed for any method, and returns the object key, along with the method and content type
+	// that the URL was signed for. An empty method means GET, and an empty
+	// content type means any. VerifyURL must be safe to call from multiple
+	// goroutines, and should not modify the URL argument.
+	VerifyURL(ctx context.Context, surl *url.URL) (key, method, contentType string, err error)
+}
+
 // URLSignerHMAC signs URLs by adding the object key, expiration time, and a
 // hash-based message authentication code (HMAC) into the query parameters.
 // Values of URLSignerHMAC with the same secret key will accept URLs produced by
@@ -637,7 +1120,8 @@ func NewURLSignerHMAC(baseURL *url.URL, secretKey []byte) *URLSignerHMAC {
 }
 
 // URLFromKey creates a signed URL by copying the baseURL and appending the
-// object key, expiry, and signature as a query params.
+// object key, expiry, permitted method and content type, and signature as
+// query params.
 func (h *URLSignerHMAC) URLFromKey(ctx context.Context, key string, opts *driver.SignedURLOptions) (*url.URL, error) {
 	sURL := new(url.URL)
 	*sURL = *h.baseURL
@@ -645,6 +1129,10 @@ func (h *URLSignerHMAC) URLFromKey(ctx context.Context, key string, opts *driver
 	q := sURL.Query()
 	q.Set("obj", key)
 	q.Set("expiry", strconv.FormatInt(time.Now().Add(opts.Expiry).Unix(), 10))
+	q.Set("method", opts.Method)
+	if opts.ContentType != "" {
+		q.Set("contentType", opts.ContentType)
+	}
 	q.Set("signature", h.getMAC(q))
 	sURL.RawQuery = q.Encode()
 
@@ -655,6 +1143,8 @@ func (h *URLSignerHMAC) getMAC(q url.Values) string {
 	signedVals := url.Values{}
 	signedVals.Set("obj", q.Get("obj"))
 	signedVals.Set("expiry", q.Get("expiry"))
+	signedVals.Set("method", q.Get("method"))
+	signedVals.Set("contentType", q.Get("contentType"))
 	msg := signedVals.Encode()
 
 	hsh := hmac.New(sha256.New, h.secretKey)
@@ -663,19 +1153,35 @@ func (h *URLSignerHMAC) getMAC(q url.Values) string {
 }
 
 // KeyFromURL checks expiry and signature, and returns the object key
-// only if the signed URL is both authentic and unexpired.
+// only if the signed URL is both authentic and unexpired. Since callers of
+// KeyFromURL only know how to serve reads, it rejects URLs signed for other
+// methods than GET; use VerifyURL to accept them.
 func (h *URLSignerHMAC) KeyFromURL(ctx context.Context, sURL *url.URL) (string, error) {
+	key, method, _, err := h.VerifyURL(ctx, sURL)
+	if err != nil {
+		return "", err
+	}
+	if method != "" && method != http.MethodGet {
+		return "", fmt.Errorf("retrieving blob key from URL: URL is signed for %s, not GET", method)
+	}
+	return key, nil
+}
+
+// VerifyURL implements URLVerifier. It checks expiry and signature like
+// KeyFromURL, and returns the object key, and the method and content type
+// covered by the signature.
+func (h *URLSignerHMAC) VerifyURL(ctx context.Context, sURL *url.URL) (key, method, contentType string, err error) {
 	q := sURL.Query()
 
 	exp, err := strconv.ParseInt(q.Get("expiry"), 10, 64)
 	if err != nil || time.Now().Unix() > exp {
-		return "", errors.New("retrieving blob key from URL: key cannot be retrieved")
+		return "", "", "", errors.New("retrieving blob key from URL: key cannot be retrieved")
 	}
 
 	if !h.checkMAC(q) {
-		return "", errors.New("retrieving blob key from URL: key cannot be retrieved")
+		return "", "", "", errors.New("retrieving blob key from URL: key cannot be retrieved")
 	}
-	return q.Get("obj"), nil
+	return q.Get("obj"), q.Get("method"), q.Get("contentType"), nil
 }
 
 func (h *URLSignerHMAC) checkMAC(q url.Values) bool {
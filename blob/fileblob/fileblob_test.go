@@ -27,68 +27,66 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/google/go-cmp/cmp"
 	"gocloud.dev/blob"
 	"gocloud.dev/blob/driver"
 	"gocloud.dev/blob/drivertest"
+	"gocloud.dev/blob/memblob"
+	"gocloud.dev/gcerrors"
 )
 
 type harness struct {
 	dir       string
 	server    *httptest.Server
 	urlSigner URLSigner
+	index     bool
 	closer    func()
 }
 
 func newHarness(ctx context.Context, t *testing.T) (drivertest.Harness, error) {
+	return newHarnessWithIndex(ctx, t, false)
+}
+
+func newHarnessWithIndex(ctx context.Context, t *testing.T, index bool) (*harness, error) {
 	dir := filepath.Join(os.TempDir(), "go-cloud-fileblob")
 	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
 		return nil, err
 	}
-	h := &harness{dir: dir}
+	h := &harness{dir: dir, index: index}
 
-	localServer := httptest.NewServer(http.HandlerFunc(h.serveSignedURL))
+	// The bucket served by the handler doesn't need a URLSigner, since it
+	// doesn't create signed URLs.
+	bucket, err := OpenBucket(dir, nil)
+	if err != nil {
+		return nil, err
+	}
+	localServer := httptest.NewUnstartedServer(nil)
 	h.server = localServer
 
-	u, err := url.Parse(h.server.URL)
+	u, err := url.Parse("http://" + localServer.Listener.Addr().String())
 	if err != nil {
 		return nil, err
 	}
 	h.urlSigner = NewURLSignerHMAC(u, []byte("I'm a secret key"))
+	localServer.Config.Handler = NewSignedURLHandler(bucket, h.urlSigner)
+	localServer.Start()
 
-	h.closer = func() { _ = os.RemoveAll(dir); localServer.Close() }
+	h.closer = func() { _ = os.RemoveAll(dir); localServer.Close(); bucket.Close() }
 
 	return h, nil
 }
 
-func (h *harness) serveSignedURL(w http.ResponseWriter, r *http.Request) {
-	objKey, err := h.urlSigner.KeyFromURL(r.Context(), r.URL)
-	if err != nil {
-		w.WriteHeader(http.StatusForbidden)
-		return
-	}
-
-	bucket, err := OpenBucket(h.dir, &Options{})
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-	reader, err := bucket.NewReader(r.Context(), objKey, nil)
-	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		return
-	}
-	defer reader.Close()
-	io.Copy(w, reader)
-}
-
 func (h *harness) HTTPClient() *http.Client {
 	return &http.Client{}
 }
 
 func (h *harness) MakeDriver(ctx context.Context) (driver.Bucket, error) {
 	opts := &Options{
-		URLSigner: h.urlSigner,
+		URLSigner:  h.urlSigner,
+		Versioning: true,
+		Index:      h.index,
 	}
 	return openBucket(h.dir, opts)
 }
@@ -101,6 +99,13 @@ func TestConformance(t *testing.T) {
 	drivertest.RunConformanceTests(t, newHarness, []drivertest.AsTest{verifyPathError{}})
 }
 
+func TestConformanceWithIndex(t *testing.T) {
+	newHarness := func(ctx context.Context, t *testing.T) (drivertest.Harness, error) {
+		return newHarnessWithIndex(ctx, t, true)
+	}
+	drivertest.RunConformanceTests(t, newHarness, []drivertest.AsTest{verifyPathError{}})
+}
+
 func BenchmarkFileblob(b *testing.B) {
 	dir := filepath.Join(os.TempDir(), "go-cloud-fileblob")
 	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
@@ -192,6 +197,12 @@ func TestOpenBucketFromURL(t *testing.T) {
 		{"file://" + dirpath, "myfile.txt", false, false, "hello world"},
 		// OK, host is ignored.
 		{"file://localhost" + dirpath, "myfile.txt", false, false, "hello world"},
+		// OK, with versioning.
+		{"file://" + dirpath + "?versioning=true", "myfile.txt", false, false, "hello world"},
+		// OK, with an index.
+		{"file://" + dirpath + "?index=true", "myfile.txt", false, false, "hello world"},
+		// Invalid value for versioning.
+		{"file://" + dirpath + "?versioning=maybe", "myfile.txt", true, false, ""},
 		// Invalid query parameter.
 		{"file://" + dirpath + "?param=value", "myfile.txt", true, false, ""},
 	}
@@ -218,3 +229,624 @@ func TestOpenBucketFromURL(t *testing.T) {
 		}
 	}
 }
+
+func TestVersioning(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "fileblob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	t.Run("Disabled", func(t *testing.T) {
+		b, err := OpenBucket(dir, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer b.Close()
+		if _, err := b.ListVersions(ctx, "key"); gcerrors.Code(err) != gcerrors.Unimplemented {
+			t.Errorf("got %v want Unimplemented error", err)
+		}
+	})
+	t.Run("PreviousVersionsAreNotListed", func(t *testing.T) {
+		b, err := OpenBucket(dir, &Options{Versioning: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer b.Close()
+		for _, content := range []string{"v1", "v2"} {
+			if err := b.WriteAll(ctx, "key", []byte(content), nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		iter := b.List(nil)
+		var got []string
+		for {
+			obj, err := iter.Next(ctx)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, obj.Key)
+		}
+		if len(got) != 1 || got[0] != "key" {
+			t.Errorf("got keys %v want [key]", got)
+		}
+		if err := b.WriteAll(ctx, versionsDir+"/foo", []byte("x"), nil); err == nil {
+			t.Errorf("got nil error writing into %s, want error", versionsDir)
+		}
+	})
+}
+
+func TestChecksumsArePersisted(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "fileblob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	b, err := OpenBucket(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.WriteAll(ctx, "key", []byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+	b.Close()
+
+	xa, err := getAttrs(filepath.Join(dir, "key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(xa.MD5) == 0 || len(xa.CRC32C) != 4 || len(xa.SHA256) != 32 {
+		t.Fatalf("got MD5 %x, CRC32C %x and SHA256 %x in the attributes file, want all of them", xa.MD5, xa.CRC32C, xa.SHA256)
+	}
+
+	// A new bucket for the same directory reports the checksums.
+	b, err = OpenBucket(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	attrs, err := b.Attributes(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(attrs.CRC32C) != string(xa.CRC32C) || string(attrs.SHA256) != string(xa.SHA256) {
+		t.Errorf("got CRC32C %x and SHA256 %x, want %x and %x", attrs.CRC32C, attrs.SHA256, xa.CRC32C, xa.SHA256)
+	}
+}
+
+func TestPurgeExpiredRemovesFiles(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "fileblob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	b, err := OpenBucket(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if err := b.WriteAll(ctx, "dir/key", []byte("hello"), &blob.WriterOptions{ExpiresAt: time.Now().Add(-time.Second)}); err != nil {
+		t.Fatal(err)
+	}
+	// The expired blob is hidden, but stays on disk until it's purged.
+	path := filepath.Join(dir, "dir", "key")
+	if _, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := b.PurgeExpired(ctx); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Errorf("got %d blobs purged, want 1", n)
+	}
+	for _, p := range []string{path, path + attrsExt} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("%s: got error %v, want not exist", p, err)
+		}
+	}
+}
+
+// keySigner is a URLSigner that only signs the key, not the method.
+type keySigner struct {
+	baseURL *url.URL
+}
+
+func (s *keySigner) URLFromKey(ctx context.Context, key string, opts *driver.SignedURLOptions) (*url.URL, error) {
+	u := *s.baseURL
+	u.RawQuery = url.Values{"obj": {key}, "token": {"secret"}}.Encode()
+	return &u, nil
+}
+
+func (s *keySigner) KeyFromURL(ctx context.Context, surl *url.URL) (string, error) {
+	q := surl.Query()
+	if q.Get("token") != "secret" {
+		return "", errors.New("invalid token")
+	}
+	return q.Get("obj"), nil
+}
+
+// TestSignedURLHandlerUnsignedMethod checks that the handler doesn't trust
+// the method in URLs from signers that don't sign it.
+func TestSignedURLHandlerUnsignedMethod(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "fileblob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := httptest.NewUnstartedServer(nil)
+	u, err := url.Parse("http://" + server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := &keySigner{baseURL: u}
+	b, err := OpenBucket(dir, &Options{URLSigner: signer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	server.Config.Handler = NewSignedURLHandler(b, signer)
+	server.Start()
+	defer server.Close()
+
+	if err := b.WriteAll(ctx, "key", []byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+	surl, err := b.SignedURL(ctx, "key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+		req, err := http.NewRequest(method, surl+"&method="+method, strings.NewReader("bye"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		want := http.StatusForbidden
+		if method == http.MethodGet {
+			want = http.StatusOK
+		}
+		if resp.StatusCode != want {
+			t.Errorf("%s: got status %d want %d", method, resp.StatusCode, want)
+		}
+	}
+	if got, err := b.ReadAll(ctx, "key"); err != nil || string(got) != "hello" {
+		t.Errorf("got %q, %v; want the blob unchanged", got, err)
+	}
+}
+
+func TestKeyFromURLOnlyGet(t *testing.T) {
+	ctx := context.Background()
+	signer := NewURLSignerHMAC(&url.URL{Scheme: "http", Host: "localhost"}, []byte("secret"))
+	for _, test := range []struct {
+		method  string
+		wantErr bool
+	}{
+		{"", false},
+		{http.MethodGet, false},
+		{http.MethodPut, true},
+		{http.MethodDelete, true},
+	} {
+		u, err := signer.URLFromKey(ctx, "key", &driver.SignedURLOptions{Expiry: time.Hour, Method: test.method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, err := signer.KeyFromURL(ctx, u)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%q: got key %q, error %v, want error: %v", test.method, key, err, test.wantErr)
+		}
+		// VerifyURL accepts all of them.
+		if _, method, _, err := signer.VerifyURL(ctx, u); err != nil || method != test.method {
+			t.Errorf("%q: VerifyURL got method %q, error %v", test.method, method, err)
+		}
+	}
+}
+
+func TestSignedURLHandler(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "fileblob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := httptest.NewUnstartedServer(nil)
+	u, err := url.Parse("http://" + server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := NewURLSignerHMAC(u, []byte("secret"))
+	b, err := OpenBucket(dir, &Options{URLSigner: signer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	server.Config.Handler = NewSignedURLHandler(b, signer)
+	server.Start()
+	defer server.Close()
+
+	sign := func(opts *blob.SignedURLOptions) string {
+		t.Helper()
+		surl, err := b.SignedURL(ctx, "key", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return surl
+	}
+	do := func(method, surl, contentType, body string, wantStatus int) string {
+		t.Helper()
+		req, err := http.NewRequest(method, surl, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		got, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != wantStatus {
+			t.Errorf("%s %s: got status %d want %d", method, surl, resp.StatusCode, wantStatus)
+		}
+		return string(got)
+	}
+
+	getURL := sign(nil)
+	putURL := sign(&blob.SignedURLOptions{Method: http.MethodPut, ContentType: "text/plain"})
+	deleteURL := sign(&blob.SignedURLOptions{Method: http.MethodDelete})
+
+	do(http.MethodGet, getURL, "", "", http.StatusNotFound)
+	// Uploads must use the signed Content-Type.
+	do(http.MethodPut, putURL, "text/html", "hello", http.StatusForbidden)
+	do(http.MethodPut, putURL, "text/plain", "hello", http.StatusOK)
+	if got := do(http.MethodGet, getURL, "", "", http.StatusOK); got != "hello" {
+		t.Errorf("got %q want %q", got, "hello")
+	}
+	attrs, err := b.Attributes(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.ContentType != "text/plain" {
+		t.Errorf("got Content-Type %q want %q", attrs.ContentType, "text/plain")
+	}
+	// URLs can only be used with the method they were signed for, and the
+	// method can't be changed.
+	do(http.MethodPut, getURL, "text/plain", "bye", http.StatusForbidden)
+	do(http.MethodDelete, putURL, "", "", http.StatusForbidden)
+	do(http.MethodPut, strings.Replace(deleteURL, "method=DELETE", "method=PUT", 1), "text/plain", "bye", http.StatusForbidden)
+	do(http.MethodDelete, deleteURL, "", "", http.StatusNoContent)
+	if ok, _ := b.Exists(ctx, "key"); ok {
+		t.Error("blob still exists after DELETE")
+	}
+}
+
+// TestWatchExternalChanges checks that Watch reports changes made to the
+// directory by other means than the bucket.
+func TestWatchExternalChanges(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "fileblob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "existing"), []byte("x"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := OpenBucket(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	iter, err := b.Watch(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iter.Close()
+
+	expect := func(key string, want blob.WatchEventType) {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		e, err := iter.Next(ctx)
+		if err != nil {
+			t.Fatalf("waiting for %v event for %q: %v", want, key, err)
+		}
+		if e.Key != key || e.Type != want {
+			t.Errorf("got %v event for %q, want %v event for %q", e.Type, e.Key, want, key)
+		}
+	}
+
+	// Files in new subdirectories are reported.
+	if err := os.MkdirAll(filepath.Join(dir, "sub"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "sub", "new.tmp"), []byte("x"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(dir, "sub", "new.tmp"), filepath.Join(dir, "sub", "new")); err != nil {
+		t.Fatal(err)
+	}
+	// The temporary file may or may not have been noticed before the rename;
+	// skip any events for it.
+	for {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		e, err := iter.Next(ctx)
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Key == "sub/new.tmp" {
+			continue
+		}
+		if e.Key != "sub/new" || e.Type != blob.WatchCreated {
+			t.Errorf("got %v event for %q, want created event for %q", e.Type, e.Key, "sub/new")
+		}
+		break
+	}
+	// Blobs that existed before Watch are reported as deleted.
+	if err := os.Remove(filepath.Join(dir, "existing")); err != nil {
+		t.Fatal(err)
+	}
+	expect("existing", blob.WatchDeleted)
+}
+
+// TestWatchPending checks that a watcher coalesces the events it holds, and
+// stops holding them once there are too many.
+func TestWatchPending(t *testing.T) {
+	ctx := context.Background()
+	w := &watcher{pendingKeys: map[string]*driver.WatchEvent{}, ready: make(chan struct{}, 1)}
+	w.emit("a", driver.WatchCreated)
+	w.emit("a", driver.WatchUpdated)
+	w.emit("existing", driver.WatchDeleted)
+	w.emit("b", driver.WatchCreated)
+	w.emit("b", driver.WatchDeleted)
+	w.emit("existing", driver.WatchCreated)
+	var got []driver.WatchEvent
+	for i := 0; i < 3; i++ {
+		e, err := w.Next(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, *e)
+	}
+	want := []driver.WatchEvent{
+		{Key: "a", Type: driver.WatchCreated},
+		{Key: "existing", Type: driver.WatchUpdated},
+		{Key: "b", Type: driver.WatchDeleted},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("got events %v want %v", got, want)
+	}
+
+	for i := 0; i <= maxPendingWatchEvents; i++ {
+		w.emit(fmt.Sprintf("key%d", i), driver.WatchCreated)
+	}
+	for i := 0; i < maxPendingWatchEvents; i++ {
+		if _, err := w.Next(ctx); err != nil {
+			t.Fatalf("event %d: %v", i, err)
+		}
+	}
+	_, err := w.Next(ctx)
+	if err != errWatchOverflow {
+		t.Errorf("got error %v want %v", err, errWatchOverflow)
+	}
+	if code := (&bucket{}).ErrorCode(err); code != gcerrors.ResourceExhausted {
+		t.Errorf("got error code %v want ResourceExhausted", code)
+	}
+}
+
+// listAll returns the keys listed in b with opts, requesting pages of
+// pageSize.
+func listAll(ctx context.Context, t *testing.T, b *blob.Bucket, opts *blob.ListOptions, pageSize int) []string {
+	t.Helper()
+	var keys []string
+	token := blob.FirstPageToken
+	for token != nil {
+		var objs []*blob.ListObject
+		var err error
+		objs, token, err = b.ListPage(ctx, token, pageSize, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, obj := range objs {
+			keys = append(keys, obj.Key)
+		}
+	}
+	return keys
+}
+
+func TestIndex(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "fileblob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// A file that exists before the index is built.
+	if err := ioutil.WriteFile(filepath.Join(dir, "existing"), []byte("x"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := OpenBucket(dir, &Options{Index: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	// The listings are compared with those of memblob.
+	mb := memblob.OpenBucket(nil)
+	defer mb.Close()
+	keys := []string{"existing", "a-c", "a/b", "a/c/d", "dir/x/1", "dir/x/2", "dir/y", "dir/z/", "z"}
+	for _, key := range keys {
+		for _, bkt := range []*blob.Bucket{b, mb} {
+			if err := bkt.WriteAll(ctx, key, []byte(key), nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := b.WriteAll(ctx, indexDir+"/foo", []byte("x"), nil); err == nil {
+		t.Errorf("got nil error writing into %s, want error", indexDir)
+	}
+
+	check := func(t *testing.T) {
+		t.Helper()
+		for _, opts := range []*blob.ListOptions{
+			nil,
+			{Prefix: "a"},
+			{Prefix: "a/"},
+			{Delimiter: "/"},
+			{Prefix: "dir/", Delimiter: "/"},
+			{Prefix: "dir/x", Delimiter: "/"},
+			{Delimiter: "-"},
+			{Prefix: "missing"},
+		} {
+			want := listAll(ctx, t, mb, opts, 100)
+			for _, pageSize := range []int{1, 2, 100} {
+				got := listAll(ctx, t, b, opts, pageSize)
+				if diff := cmp.Diff(got, want); diff != "" {
+					t.Errorf("%+v, page size %d: got keys diff (-got +want):\n%s", opts, pageSize, diff)
+				}
+			}
+		}
+	}
+	t.Run("Write", check)
+
+	for _, key := range []string{"a/b", "dir/x/1"} {
+		for _, bkt := range []*blob.Bucket{b, mb} {
+			if err := bkt.Delete(ctx, key); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for _, bkt := range []*blob.Bucket{b, mb} {
+		if _, err := bkt.Move(ctx, "dir/moved", "dir/y"); err != nil {
+			t.Fatal(err)
+		}
+		if err := bkt.Copy(ctx, "copied", "z", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Run("Update", check)
+
+	// Files removed behind the bucket's back are skipped; files added are
+	// only listed once the index is rebuilt.
+	if err := os.Remove(filepath.Join(dir, "a-c")); err != nil {
+		t.Fatal(err)
+	}
+	if err := mb.Delete(ctx, "a-c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "added"), []byte("x"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	t.Run("Stale", check)
+	if err := mb.WriteAll(ctx, "added", []byte("x"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := RebuildIndex(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Run("Rebuilt", check)
+}
+
+func TestIndexChunks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fileblob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	x, err := openIndex(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 3 * maxChunkKeys
+	var want []string
+	for i := 0; i < n; i++ {
+		want = append(want, fmt.Sprintf("key%05d", i))
+	}
+	keys := func() []string {
+		x.mu.Lock()
+		defer x.mu.Unlock()
+		var keys []string
+		it, err := x.iter("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for {
+			key, ok, err := it.next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				return keys
+			}
+			keys = append(keys, key)
+		}
+	}
+	// Add the keys in an order that splits chunks in the middle and at
+	// both ends.
+	for i := 0; i < n; i += 2 {
+		if err := x.add(want[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := n - 1; i > 0; i -= 2 {
+		if err := x.add(want[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := x.add(want[0]); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(keys(), want); diff != "" {
+		t.Errorf("got keys diff (-got +want):\n%s", diff)
+	}
+	if len(x.m.Chunks) < 3 {
+		t.Errorf("got %d chunks, want at least 3", len(x.m.Chunks))
+	}
+
+	// Reopening reads the same index.
+	x2, err := openIndex(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(x2.m, x.m); diff != "" {
+		t.Errorf("got manifest diff (-got +want):\n%s", diff)
+	}
+
+	for _, key := range want {
+		if err := x.remove(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := keys(); len(got) != 0 {
+		t.Errorf("got %d keys after removing all, want 0", len(got))
+	}
+	// Only the manifest remains.
+	infos, err := ioutil.ReadDir(x.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 {
+		t.Errorf("got %d files in the index directory, want 1", len(infos))
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fileblob
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+// NewSignedURLHandler returns an http.Handler that serves requests for URLs
+// returned by bucket.SignedURL, where bucket was opened with an
+// Options.URLSigner of signer. The baseURL of signer should point at the
+// handler.
+//
+// Requests are verified using signer.VerifyURL if signer implements
+// URLVerifier, or signer.KeyFromURL otherwise. Depending on the method the
+// URL was signed for, the handler serves the blob (for GET and HEAD
+// requests), replaces it with the request body (for PUT requests), or
+// deletes it (for DELETE requests). Other requests get a 403 Forbidden
+// response.
+//
+// The method and content type a URL was signed for are only known if signer
+// implements URLVerifier, like URLSignerHMAC does; they are never taken from
+// the URL itself. For other signers, the handler only serves GET and HEAD
+// requests.
+func NewSignedURLHandler(bucket *blob.Bucket, signer URLSigner) http.Handler {
+	return &signedURLHandler{bucket: bucket, signer: signer}
+}
+
+type signedURLHandler struct {
+	bucket *blob.Bucket
+	signer URLSigner
+}
+
+func (h *signedURLHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var key, method, contentType string
+	var err error
+	if v, ok := h.signer.(URLVerifier); ok {
+		key, method, contentType, err = v.VerifyURL(r.Context(), r.URL)
+	} else {
+		key, err = h.signer.KeyFromURL(r.Context(), r.URL)
+	}
+	if err != nil {
+		http.Error(w, "invalid signed URL", http.StatusForbidden)
+		return
+	}
+	if method == "" {
+		method = http.MethodGet
+	}
+	if r.Method != method && !(method == http.MethodGet && r.Method == http.MethodHead) {
+		http.Error(w, "method not permitted by signed URL", http.StatusForbidden)
+		return
+	}
+	switch method {
+	case http.MethodGet:
+		h.serveRead(w, r, key)
+	case http.MethodPut:
+		if contentType == "" {
+			contentType = r.Header.Get("Content-Type")
+		} else if r.Header.Get("Content-Type") != contentType {
+			http.Error(w, "Content-Type not permitted by signed URL", http.StatusForbidden)
+			return
+		}
+		h.serveWrite(w, r, key, contentType)
+	case http.MethodDelete:
+		if err := h.bucket.Delete(r.Context(), key); err != nil {
+			httpError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not permitted by signed URL", http.StatusForbidden)
+	}
+}
+
+// serveRead writes the contents of the blob for key to w.
+func (h *signedURLHandler) serveRead(w http.ResponseWriter, r *http.Request, key string) {
+	reader, err := h.bucket.NewReader(r.Context(), key, nil)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	defer reader.Close()
+	hdr := w.Header()
+	hdr.Set("Content-Type", reader.ContentType())
+	hdr.Set("Content-Length", strconv.FormatInt(reader.Size(), 10))
+	hdr.Set("Last-Modified", reader.ModTime().UTC().Format(http.TimeFormat))
+	if enc := reader.ContentEncoding(); enc != "" {
+		hdr.Set("Content-Encoding", enc)
+	}
+	if r.Method == http.MethodHead {
+		return
+	}
+	_, _ = io.Copy(w, reader)
+}
+
+// serveWrite writes the request body to the blob for key.
+func (h *signedURLHandler) serveWrite(w http.ResponseWriter, r *http.Request, key, contentType string) {
+	// Cancel the write if the request body can't be read completely.
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	opts := &blob.WriterOptions{
+		ContentType:     contentType,
+		CacheControl:    r.Header.Get("Cache-Control"),
+		ContentEncoding: r.Header.Get("Content-Encoding"),
+	}
+	bw, err := h.bucket.NewWriter(ctx, key, opts)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	if _, err := io.Copy(bw, r.Body); err != nil {
+		cancel()
+		_ = bw.Close()
+		httpError(w, err)
+		return
+	}
+	if err := bw.Close(); err != nil {
+		httpError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// httpError writes an error response for err.
+func httpError(w http.ResponseWriter, err error) {
+	switch gcerrors.Code(err) {
+	case gcerrors.NotFound:
+		http.Error(w, "blob not found", http.StatusNotFound)
+	case gcerrors.InvalidArgument:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case gcerrors.FailedPrecondition:
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,405 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fileblob
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// indexDir is the directory under the bucket root that holds the index of
+// blob keys when Options.Index is set.
+//
+// The index is a sorted list of keys, split into chunk files holding up to
+// maxChunkKeys keys each. The manifest file lists the chunks in order, along
+// with a lower bound for the keys in each; every key in a chunk is less than
+// the lower bound of the next one. Files are replaced atomically by renaming
+// a temporary file, and the manifest only changes when chunks are split or
+// removed, so updating the index for a write usually rewrites one chunk.
+const (
+	indexDir      = ".fileblob-index"
+	indexManifest = "manifest"
+	chunkKeys     = 1000
+	maxChunkKeys  = 2 * chunkKeys
+)
+
+var errIndexDir = fmt.Errorf("directory %q is reserved", indexDir)
+
+type manifest struct {
+	Chunks []chunkInfo `json:"chunks"`
+}
+
+type chunkInfo struct {
+	// First is the lower bound for the keys in the chunk.
+	First string `json:"first"`
+	File  string `json:"file"`
+}
+
+// index maintains the index for a bucket.
+type index struct {
+	dir string // the index directory
+
+	mu sync.Mutex
+	m  manifest
+	// info is the os.FileInfo of the manifest file that m was read from. It is
+	// used to detect changes made by RebuildIndex or other processes.
+	info os.FileInfo
+}
+
+// openIndex opens the index of the bucket rooted at dir, building it if it
+// doesn't exist.
+func openIndex(dir string) (*index, error) {
+	x := &index{dir: filepath.Join(dir, indexDir)}
+	if _, err := os.Stat(filepath.Join(x.dir, indexManifest)); os.IsNotExist(err) {
+		if err := RebuildIndex(dir); err != nil {
+			return nil, err
+		}
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if err := x.refresh(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// RebuildIndex rebuilds the index of the fileblob bucket rooted at dir from
+// the files in it. It is needed when the index becomes inconsistent with the
+// files; for example, after the directory was modified other than through a
+// bucket opened with Options.Index, or if the process crashed while writing
+// a blob. See the package documentation for more details.
+//
+// Buckets that are open on dir pick up the new index. Blobs written or
+// deleted while RebuildIndex runs may be missing from the new index, or
+// remain in it.
+func RebuildIndex(dir string) error {
+	dir = filepath.Clean(dir)
+	var keys []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == dir {
+			return nil
+		}
+		rel := path[len(dir)+1:]
+		if rel == versionsDir || rel == indexDir {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() || strings.HasSuffix(rel, attrsExt) || isTempFile(info.Name()) {
+			return nil
+		}
+		keys = append(keys, unescapeKey(rel))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Strings(keys)
+
+	x := &index{dir: filepath.Join(dir, indexDir)}
+	if err := os.MkdirAll(x.dir, 0777); err != nil {
+		return err
+	}
+	var m manifest
+	for len(keys) > 0 {
+		n := chunkKeys
+		if n > len(keys) {
+			n = len(keys)
+		}
+		c, err := x.newChunk(keys[:n])
+		if err != nil {
+			return err
+		}
+		m.Chunks = append(m.Chunks, c)
+		keys = keys[n:]
+	}
+	if err := x.writeManifest(m); err != nil {
+		return err
+	}
+	// Remove the chunks of the previous index.
+	used := map[string]bool{indexManifest: true}
+	for _, c := range m.Chunks {
+		used[c.File] = true
+	}
+	infos, err := ioutil.ReadDir(x.dir)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if !used[info.Name()] {
+			_ = os.Remove(filepath.Join(x.dir, info.Name()))
+		}
+	}
+	return nil
+}
+
+// refresh reads the manifest if it changed since it was last read.
+// x.mu must be held.
+func (x *index) refresh() error {
+	path := filepath.Join(x.dir, indexManifest)
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if x.info != nil && info.ModTime().Equal(x.info.ModTime()) && info.Size() == x.info.Size() {
+		return nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return fmt.Errorf("invalid index manifest %s: %v", path, err)
+	}
+	x.m, x.info = m, info
+	return nil
+}
+
+// writeManifest replaces the manifest with m. x.mu must be held.
+func (x *index) writeManifest(m manifest) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(x.dir, indexManifest)
+	if err := writeFileAtomic(path, data); err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	x.m, x.info = m, info
+	return nil
+}
+
+// chunkFor returns the position in the manifest of the chunk that holds key,
+// if it is indexed. There must be at least one chunk.
+func (x *index) chunkFor(key string) int {
+	i := sort.Search(len(x.m.Chunks), func(i int) bool { return x.m.Chunks[i].First > key })
+	if i > 0 {
+		i--
+	}
+	return i
+}
+
+// readChunk returns the keys in the chunk at position i in the manifest.
+func (x *index) readChunk(i int) ([]string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(x.dir, x.m.Chunks[i].File))
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("invalid index chunk %s: %v", x.m.Chunks[i].File, err)
+	}
+	return keys, nil
+}
+
+// writeChunk replaces the keys in the chunk file named name.
+func (x *index) writeChunk(name string, keys []string) error {
+	data, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(x.dir, name), data)
+}
+
+// newChunk writes keys, which must not be empty, to a new chunk file.
+func (x *index) newChunk(keys []string) (chunkInfo, error) {
+	name, err := newETag()
+	if err != nil {
+		return chunkInfo{}, err
+	}
+	if err := x.writeChunk(name, keys); err != nil {
+		return chunkInfo{}, err
+	}
+	return chunkInfo{First: keys[0], File: name}, nil
+}
+
+// replaceChunk replaces the chunk at position i in the manifest by new
+// chunks holding each of groups, which may be empty.
+// x.mu must be held.
+func (x *index) replaceChunk(i int, groups [][]string) error {
+	var chunks []chunkInfo
+	chunks = append(chunks, x.m.Chunks[:i]...)
+	for _, keys := range groups {
+		c, err := x.newChunk(keys)
+		if err != nil {
+			return err
+		}
+		chunks = append(chunks, c)
+	}
+	chunks = append(chunks, x.m.Chunks[i+1:]...)
+	old := x.m.Chunks[i].File
+	if err := x.writeManifest(manifest{Chunks: chunks}); err != nil {
+		return err
+	}
+	return os.Remove(filepath.Join(x.dir, old))
+}
+
+// add adds key to the index.
+func (x *index) add(key string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if err := x.refresh(); err != nil {
+		return err
+	}
+	if len(x.m.Chunks) == 0 {
+		c, err := x.newChunk([]string{key})
+		if err != nil {
+			return err
+		}
+		return x.writeManifest(manifest{Chunks: []chunkInfo{c}})
+	}
+	ci := x.chunkFor(key)
+	keys, err := x.readChunk(ci)
+	if err != nil {
+		return err
+	}
+	i := sort.SearchStrings(keys, key)
+	if i < len(keys) && keys[i] == key {
+		return nil
+	}
+	keys = append(keys, "")
+	copy(keys[i+1:], keys[i:])
+	keys[i] = key
+	if len(keys) <= maxChunkKeys {
+		return x.writeChunk(x.m.Chunks[ci].File, keys)
+	}
+	return x.replaceChunk(ci, [][]string{keys[:len(keys)/2], keys[len(keys)/2:]})
+}
+
+// remove removes key from the index.
+func (x *index) remove(key string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if err := x.refresh(); err != nil {
+		return err
+	}
+	if len(x.m.Chunks) == 0 {
+		return nil
+	}
+	ci := x.chunkFor(key)
+	keys, err := x.readChunk(ci)
+	if err != nil {
+		return err
+	}
+	i := sort.SearchStrings(keys, key)
+	if i == len(keys) || keys[i] != key {
+		return nil
+	}
+	keys = append(keys[:i], keys[i+1:]...)
+	if len(keys) == 0 {
+		return x.replaceChunk(ci, nil)
+	}
+	return x.writeChunk(x.m.Chunks[ci].File, keys)
+}
+
+// indexIter iterates over the keys in the index, in order. x.mu must be held
+// while it is used.
+type indexIter struct {
+	x    *index
+	ci   int      // position of the current chunk in the manifest
+	keys []string // keys in the current chunk
+	i    int      // position of the next key in keys
+}
+
+// iter returns an iterator positioned at the first key that is greater than
+// or equal to key. x.mu must be held.
+func (x *index) iter(key string) (*indexIter, error) {
+	it := &indexIter{x: x, ci: -1}
+	if err := it.seek(key); err != nil {
+		return nil, err
+	}
+	return it, nil
+}
+
+// seek positions it at the first key that is greater than or equal to key.
+func (it *indexIter) seek(key string) error {
+	if len(it.x.m.Chunks) == 0 {
+		return nil
+	}
+	if ci := it.x.chunkFor(key); ci != it.ci {
+		keys, err := it.x.readChunk(ci)
+		if err != nil {
+			return err
+		}
+		it.ci, it.keys = ci, keys
+	}
+	it.i = sort.SearchStrings(it.keys, key)
+	return nil
+}
+
+// next returns the next key, or false if there are no more.
+func (it *indexIter) next() (string, bool, error) {
+	for it.i >= len(it.keys) {
+		if it.ci+1 >= len(it.x.m.Chunks) {
+			return "", false, nil
+		}
+		keys, err := it.x.readChunk(it.ci + 1)
+		if err != nil {
+			return "", false, err
+		}
+		it.ci, it.keys, it.i = it.ci+1, keys, 0
+	}
+	it.i++
+	return it.keys[it.i-1], true, nil
+}
+
+// prefixEnd returns the smallest string that is greater than all strings
+// starting with prefix, or "" if there is none.
+func prefixEnd(prefix string) string {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			return prefix[:i] + string([]byte{prefix[i] + 1})
+		}
+	}
+	return ""
+}
+
+// writeFileAtomic replaces the file at path with data, so that readers see
+// either the previous content or data.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		_ = os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
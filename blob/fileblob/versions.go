@@ -0,0 +1,301 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fileblob
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gocloud.dev/blob/driver"
+)
+
+// versionsDir is the directory under the bucket root that holds previous
+// versions of blobs when Options.Versioning is set. The previous versions of
+// a key are stored as files named by their version ID, with .attrs files
+// alongside, in a directory named by the escaped key plus versionsExt.
+const (
+	versionsDir = ".fileblob-versions"
+	versionsExt = ".v"
+)
+
+var errVersionsDir = fmt.Errorf("directory %q is reserved", versionsDir)
+
+// versionsPath returns the directory holding the previous versions of key.
+func (b *bucket) versionsPath(key string) string {
+	return filepath.Join(b.dir, versionsDir, escapeKey(key)+versionsExt)
+}
+
+// versionPath returns the path of a previous version of key.
+func (b *bucket) versionPath(key, id string) (string, error) {
+	// Don't let id escape the versions directory.
+	if id == "" || id == "." || id == ".." || strings.ContainsAny(id, `/\`) || strings.HasSuffix(id, attrsExt) {
+		return "", &os.PathError{Op: "open", Path: id, Err: os.ErrNotExist}
+	}
+	return filepath.Join(b.versionsPath(key), id), nil
+}
+
+// versionID returns the version ID for a file. Blobs written with versioning
+// enabled have their version ID stored in their attributes; for other files,
+// one is derived from the modification time.
+func versionID(info os.FileInfo, xa *xattrs) string {
+	if xa.VersionID != "" {
+		return xa.VersionID
+	}
+	return fmt.Sprintf("%016x", info.ModTime().UnixNano())
+}
+
+// newVersionID returns a new version ID. Version IDs sort in the order in
+// which they were created.
+func newVersionID() string {
+	var buf [4]byte
+	_, _ = rand.Read(buf[:])
+	return fmt.Sprintf("%016x-%s", time.Now().UnixNano(), hex.EncodeToString(buf[:]))
+}
+
+// archive moves the blob at path, if any, into the versions directory for
+// key. It returns a function that undoes the move on a best-effort basis.
+// b.mu must be held.
+func (b *bucket) archive(key, path string) (undo func(), err error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return func() {}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	xa, err := getAttrs(path)
+	if err != nil {
+		return nil, err
+	}
+	if xa.expired(time.Now()) {
+		// Expired blobs aren't kept as previous versions.
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+		if err := os.Remove(path + attrsExt); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		return func() {}, nil
+	}
+	// Record the derived identifiers so that they stay stable.
+	xa.VersionID = versionID(info, &xa)
+	xa.ETag = etag(info, &xa)
+	vdir := b.versionsPath(key)
+	if err := os.MkdirAll(vdir, 0777); err != nil {
+		return nil, err
+	}
+	vpath := filepath.Join(vdir, xa.VersionID)
+	if err := setAttrs(vpath, xa); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(path, vpath); err != nil {
+		_ = os.Remove(vpath + attrsExt)
+		return nil, err
+	}
+	if err := os.Remove(path + attrsExt); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return func() {
+		_ = os.Rename(vpath+attrsExt, path+attrsExt)
+		_ = os.Rename(vpath, path)
+	}, nil
+}
+
+// previousVersions returns the version IDs of the previous versions of key,
+// oldest first.
+func (b *bucket) previousVersions(key string) ([]string, error) {
+	infos, err := ioutil.ReadDir(b.versionsPath(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	// ReadDir returns the entries sorted by name, and version IDs sort by age.
+	var ids []string
+	for _, info := range infos {
+		if info.IsDir() || strings.HasSuffix(info.Name(), attrsExt) {
+			continue
+		}
+		ids = append(ids, info.Name())
+	}
+	return ids, nil
+}
+
+// ListVersions implements driver.Versioner.
+func (b *bucket) ListVersions(ctx context.Context, key string) ([]*driver.VersionInfo, error) {
+	if !b.opts.Versioning {
+		return nil, errNotImplemented
+	}
+	path, err := b.path(key)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var vs []*driver.VersionInfo
+	add := func(path string, latest bool) error {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		xa, err := getAttrs(path)
+		if err != nil {
+			return err
+		}
+		vs = append(vs, &driver.VersionInfo{
+			VersionID: versionID(info, &xa),
+			ModTime:   info.ModTime(),
+			Size:      info.Size(),
+			MD5:       xa.MD5,
+			ETag:      etag(info, &xa),
+			IsLatest:  latest,
+		})
+		return nil
+	}
+	if info, err := os.Stat(path); err == nil && !info.IsDir() {
+		if err := add(path, true); err != nil {
+			return nil, err
+		}
+	}
+	ids, err := b.previousVersions(key)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(ids) - 1; i >= 0; i-- {
+		if err := add(filepath.Join(b.versionsPath(key), ids[i]), false); err != nil {
+			return nil, err
+		}
+	}
+	return vs, nil
+}
+
+// NewVersionRangeReader implements driver.Versioner.
+func (b *bucket) NewVersionRangeReader(ctx context.Context, key, versionID string, offset, length int64, opts *driver.ReaderOptions) (driver.Reader, error) {
+	if !b.opts.Versioning {
+		return nil, errNotImplemented
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	path, err := b.currentVersionPath(key, versionID)
+	if err != nil {
+		return nil, err
+	}
+	if path == "" {
+		if path, err = b.versionPath(key, versionID); err != nil {
+			return nil, err
+		}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	xa, err := getAttrs(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPreconditions(path, opts.IfMatch, opts.IfNoneMatch); err != nil {
+		return nil, err
+	}
+	return openRange(path, info, &xa, offset, length)
+}
+
+// currentVersionPath returns the path of the blob at key if its current
+// version is id, or "" otherwise. b.mu must be held.
+func (b *bucket) currentVersionPath(key, id string) (string, error) {
+	path, err := b.path(key)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	xa, err := getAttrs(path)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() || id != versionID(info, &xa) {
+		return "", nil
+	}
+	return path, nil
+}
+
+// DeleteVersion implements driver.Versioner.
+func (b *bucket) DeleteVersion(ctx context.Context, key, versionID string) error {
+	if !b.opts.Versioning {
+		return errNotImplemented
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	path, err := b.currentVersionPath(key, versionID)
+	if err != nil {
+		return err
+	}
+	if path != "" {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		if err := os.Remove(path + attrsExt); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		// Promote the next newest version, if any.
+		ids, err := b.previousVersions(key)
+		if err != nil {
+			return err
+		}
+		if n := len(ids); n > 0 {
+			vpath := filepath.Join(b.versionsPath(key), ids[n-1])
+			if err := os.Rename(vpath+attrsExt, path+attrsExt); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			if err := os.Rename(vpath, path); err != nil {
+				return err
+			}
+		} else if b.index != nil {
+			if err := b.index.remove(key); err != nil {
+				return err
+			}
+		}
+	} else {
+		vpath, err := b.versionPath(key, versionID)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(vpath); err != nil {
+			return err
+		}
+		if err := os.Remove(vpath + attrsExt); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	// Clean up the versions directory if it's now empty; this fails harmlessly
+	// if it isn't.
+	_ = os.Remove(b.versionsPath(key))
+	return nil
+}
@@ -0,0 +1,348 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fileblob
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"gocloud.dev/blob/driver"
+)
+
+// maxPendingWatchEvents is the maximum number of events that a watcher holds
+// until they are returned by Next.
+const maxPendingWatchEvents = 10000
+
+var errWatchOverflow = fmt.Errorf("more than %d changes were not read from the watcher", maxPendingWatchEvents)
+
+// fileState is used to detect whether a file has changed.
+type fileState struct {
+	etag    string
+	modTime time.Time
+	size    int64
+}
+
+// watcher implements driver.WatchIterator using fsnotify.
+type watcher struct {
+	b        *bucket
+	prefix   string
+	notifier *fsnotify.Watcher
+	// known holds the state of the files for blobs matching prefix, keyed by
+	// path. It is only accessed by the background goroutine after Watch
+	// returns.
+	known map[string]fileState
+
+	mu sync.Mutex
+	// pending holds the events that haven't been returned by Next yet, and
+	// pendingKeys holds the same events keyed by blob key.
+	pending     []*driver.WatchEvent
+	pendingKeys map[string]*driver.WatchEvent
+	// err is set if the notifier fails, or if pending grows too large; it
+	// is returned by Next once pending is empty.
+	err error
+	// ready is signaled when pending or err changes.
+	ready chan struct{}
+
+	// shutdown tells the background goroutine to exit.
+	shutdown func()
+	// closeCh is used to return any errors from closing the notifier
+	// back to watcher.Close.
+	closeCh chan error
+}
+
+// Watch implements driver.Watcher.
+//
+// Changes are detected using filesystem notifications for the directories
+// that may contain blobs matching prefix, so changes made by other processes
+// are reported as well.
+func (b *bucket) Watch(ctx context.Context, prefix string) (driver.WatchIterator, error) {
+	notifier, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &watcher{
+		b:           b,
+		prefix:      prefix,
+		notifier:    notifier,
+		known:       map[string]fileState{},
+		pendingKeys: map[string]*driver.WatchEvent{},
+		ready:       make(chan struct{}, 1),
+		closeCh:     make(chan error),
+	}
+	// Record the existing blobs, so that later changes to them are reported
+	// as updates.
+	if err := w.addDir(b.dir, false); err != nil {
+		notifier.Close()
+		return nil, err
+	}
+	// Create a ctx for the background goroutine that reads notifications.
+	// The cancel function will be used to shut it down during Close, with the
+	// result being passed back via closeCh.
+	bgctx, cancel := context.WithCancel(context.Background())
+	w.shutdown = cancel
+	go w.watch(bgctx)
+	return w, nil
+}
+
+// watch is run by a background goroutine. It translates notifications into
+// events until ctx is canceled, and then writes the result of closing the
+// notifier to w.closeCh.
+func (w *watcher) watch(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			w.closeCh <- w.notifier.Close()
+			return
+		case event := <-w.notifier.Events:
+			if event.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Remove|fsnotify.Rename) == 0 {
+				continue
+			}
+			w.check(event.Name)
+		case err := <-w.notifier.Errors:
+			w.mu.Lock()
+			if w.err == nil {
+				w.err = err
+			}
+			w.mu.Unlock()
+			w.signal()
+		}
+	}
+}
+
+// key returns the blob key for path, and whether path is a file that may hold
+// a blob; the versions and index directories, attribute files and in-progress
+// writes are excluded.
+func (w *watcher) key(path string) (string, bool) {
+	if path == w.b.dir || !strings.HasPrefix(path, w.b.dir+string(os.PathSeparator)) {
+		return "", false
+	}
+	rel := path[len(w.b.dir)+1:]
+	for _, dir := range []string{versionsDir, indexDir} {
+		if rel == dir || strings.HasPrefix(rel, dir+string(os.PathSeparator)) {
+			return "", false
+		}
+	}
+	if strings.HasSuffix(rel, attrsExt) || isTempFile(filepath.Base(rel)) {
+		return "", false
+	}
+	return unescapeKey(rel), true
+}
+
+// isTempFile reports whether name is a temporary file created by
+// NewTypedWriter.
+func isTempFile(name string) bool {
+	digits := strings.TrimPrefix(name, "fileblob")
+	if len(digits) == len(name) || digits == "" {
+		return false
+	}
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// relevantDir reports whether the directory for key may hold blobs matching
+// w.prefix.
+func (w *watcher) relevantDir(key string) bool {
+	key += "/"
+	return strings.HasPrefix(key, w.prefix) || strings.HasPrefix(w.prefix, key)
+}
+
+// addDir starts watching dir and the relevant directories under it, and
+// records the state of the blobs in them. If emit is true, the blobs are
+// reported as created.
+func (w *watcher) addDir(dir string, emit bool) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// The directory may have been removed already; skip it.
+			return nil
+		}
+		if path != w.b.dir {
+			key, ok := w.key(path)
+			if !ok {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !info.IsDir() {
+				w.checkFile(path, key, info, emit)
+				return nil
+			}
+			if !w.relevantDir(key) {
+				return filepath.SkipDir
+			}
+		}
+		if err := w.notifier.Add(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	})
+}
+
+// check looks at path after a notification about it, and records any
+// resulting events.
+func (w *watcher) check(path string) {
+	key, ok := w.key(path)
+	if !ok {
+		return
+	}
+	// Changes made through the bucket hold b.mu while modifying files;
+	// waiting for it means that an overwrite of a versioned blob, which
+	// briefly moves the old file away, is reported as an update.
+	w.b.mu.Lock()
+	info, err := os.Stat(path)
+	w.b.mu.Unlock()
+	if err == nil {
+		if info.IsDir() {
+			if w.relevantDir(key) {
+				if err := w.addDir(path, true); err != nil {
+					w.mu.Lock()
+					if w.err == nil {
+						w.err = err
+					}
+					w.mu.Unlock()
+					w.signal()
+				}
+			}
+			return
+		}
+		w.checkFile(path, key, info, true)
+		return
+	}
+	if !os.IsNotExist(err) {
+		return
+	}
+	// path was removed; it may have been a blob or a directory of blobs.
+	dirPrefix := path + string(os.PathSeparator)
+	for p := range w.known {
+		if p == path || strings.HasPrefix(p, dirPrefix) {
+			delete(w.known, p)
+			k, _ := w.key(p)
+			w.emit(k, driver.WatchDeleted)
+		}
+	}
+}
+
+// checkFile compares the state of the file at path, holding the blob for
+// key, with the known state, and records an event if it changed and emit is
+// true.
+func (w *watcher) checkFile(path, key string, info os.FileInfo, emit bool) {
+	if !strings.HasPrefix(key, w.prefix) {
+		return
+	}
+	xa, err := getAttrs(path)
+	if err != nil {
+		// Malformed attributes; proceed without them.
+		xa = xattrs{}
+	}
+	st := fileState{etag: etag(info, &xa), modTime: info.ModTime(), size: info.Size()}
+	prev, found := w.known[path]
+	w.known[path] = st
+	if !emit {
+		return
+	}
+	switch {
+	case !found:
+		w.emit(key, driver.WatchCreated)
+	case prev != st:
+		w.emit(key, driver.WatchUpdated)
+	}
+}
+
+// emit adds an event to w.pending, or merges it with a pending event for the
+// same key. Events are dropped once w.err is set.
+func (w *watcher) emit(key string, typ driver.WatchEventType) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return
+	}
+	if e := w.pendingKeys[key]; e != nil {
+		e.Type = coalesce(e.Type, typ)
+		return
+	}
+	if len(w.pending) >= maxPendingWatchEvents {
+		w.err = errWatchOverflow
+	} else {
+		e := &driver.WatchEvent{Key: key, Type: typ}
+		w.pending = append(w.pending, e)
+		w.pendingKeys[key] = e
+	}
+	w.signal()
+}
+
+// coalesce returns the type of a single event that reports a change of type
+// prev followed by one of type next.
+func coalesce(prev, next driver.WatchEventType) driver.WatchEventType {
+	switch {
+	case prev == driver.WatchCreated && next != driver.WatchDeleted:
+		// The blob is still new to the watcher.
+		return driver.WatchCreated
+	case prev == driver.WatchDeleted && next != driver.WatchDeleted:
+		// The blob existed before it was deleted and created again.
+		return driver.WatchUpdated
+	}
+	return next
+}
+
+// signal wakes up a call to Next that is waiting for events.
+func (w *watcher) signal() {
+	select {
+	case w.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Next implements driver.WatchIterator.
+func (w *watcher) Next(ctx context.Context) (*driver.WatchEvent, error) {
+	for {
+		w.mu.Lock()
+		if len(w.pending) > 0 {
+			e := w.pending[0]
+			w.pending[0] = nil
+			w.pending = w.pending[1:]
+			delete(w.pendingKeys, e.Key)
+			w.mu.Unlock()
+			return e, nil
+		}
+		err := w.err
+		w.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-w.ready:
+		}
+	}
+}
+
+// Close implements driver.WatchIterator.
+func (w *watcher) Close() error {
+	// Tell the background goroutine to shut down by canceling its ctx.
+	w.shutdown()
+	// Wait for it to return the result of closing the notifier.
+	return <-w.closeCh
+}
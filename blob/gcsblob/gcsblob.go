@@ -48,6 +48,7 @@ package gcsblob // import "gocloud.dev/blob/gcsblob"
 
 import (
 	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
@@ -221,6 +222,9 @@ type bucket struct {
 
 var emptyBody = ioutil.NopCloser(strings.NewReader(""))
 
+// errNotImplemented is returned for unsupported features, like preconditions.
+var errNotImplemented = errors.New("not implemented")
+
 // reader reads a GCS object. It implements driver.Reader.
 type reader struct {
 	body  io.ReadCloser
@@ -254,6 +258,9 @@ func (b *bucket) ErrorCode(err error) gcerrors.ErrorCode {
 	if err == storage.ErrObjectNotExist {
 		return gcerrors.NotFound
 	}
+	if err == errNotImplemented {
+		return gcerrors.Unimplemented
+	}
 	if gerr, ok := err.(*googleapi.Error); ok {
 		switch gerr.Code {
 		case http.StatusNotFound:
@@ -319,6 +326,8 @@ func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driv
 					ModTime: obj.Updated,
 					Size:    obj.Size,
 					MD5:     obj.MD5,
+					CRC32C:  crc32cBytes(obj.CRC32C),
+					ETag:    obj.Etag,
 					AsFunc:  asFunc,
 				}
 			} else {
@@ -360,6 +369,14 @@ func (b *bucket) ErrorAs(err error, i interface{}) bool {
 	return false
 }
 
+// crc32cBytes returns the big-endian encoding of a CRC32C checksum reported
+// by GCS.
+func crc32cBytes(crc uint32) []byte {
+	p := make([]byte, 4)
+	binary.BigEndian.PutUint32(p, crc)
+	return p
+}
+
 // Attributes implements driver.Attributes.
 func (b *bucket) Attributes(ctx context.Context, key string) (*driver.Attributes, error) {
 	key = escapeKey(key)
@@ -379,6 +396,8 @@ func (b *bucket) Attributes(ctx context.Context, key string) (*driver.Attributes
 		ModTime:            attrs.Updated,
 		Size:               attrs.Size,
 		MD5:                attrs.MD5,
+		CRC32C:             crc32cBytes(attrs.CRC32C),
+		ETag:               attrs.Etag,
 		AsFunc: func(i interface{}) bool {
 			p, ok := i.(*storage.ObjectAttrs)
 			if !ok {
@@ -392,6 +411,9 @@ func (b *bucket) Attributes(ctx context.Context, key string) (*driver.Attributes
 
 // NewRangeReader implements driver.NewRangeReader.
 func (b *bucket) NewRangeReader(ctx context.Context, key string, offset, length int64, opts *driver.ReaderOptions) (driver.Reader, error) {
+	if opts.IfMatch != "" || opts.IfNoneMatch != "" {
+		return nil, errNotImplemented
+	}
 	key = escapeKey(key)
 	bkt := b.client.Bucket(b.name)
 	obj := bkt.Object(key)
@@ -406,6 +428,9 @@ func (b *bucket) NewRangeReader(ctx context.Context, key string, offset, length
 			ContentType: r.ContentType(),
 			ModTime:     modTime,
 			Size:        r.Size(),
+			// The reported encoding is empty if the object was decompressed
+			// when served; see https://cloud.google.com/storage/docs/transcoding.
+			ContentEncoding: r.Attrs.ContentEncoding,
 		},
 		raw: r,
 	}, nil
@@ -433,6 +458,9 @@ func unescapeKey(key string) string {
 
 // NewTypedWriter implements driver.NewTypedWriter.
 func (b *bucket) NewTypedWriter(ctx context.Context, key string, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
+	if opts.IfMatch != "" || opts.IfNoneMatch != "" {
+		return nil, errNotImplemented
+	}
 	key = escapeKey(key)
 	bkt := b.client.Bucket(b.name)
 	obj := bkt.Object(key)
@@ -451,6 +479,10 @@ func (b *bucket) NewTypedWriter(ctx context.Context, key string, contentType str
 		w.ChunkSize = bufferSize(opts.BufferSize)
 		w.Metadata = opts.Metadata
 		w.MD5 = opts.ContentMD5
+		if len(opts.ContentCRC32C) == 4 {
+			w.CRC32C = binary.BigEndian.Uint32(opts.ContentCRC32C)
+			w.SendCRC32C = true
+		}
 		return w
 	}
 
@@ -482,10 +514,21 @@ func (b *bucket) NewTypedWriter(ctx context.Context, key string, contentType str
 
 // Copy implements driver.Copy.
 func (b *bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.CopyOptions) error {
+	if opts.IfMatch != "" || opts.IfNoneMatch != "" {
+		return errNotImplemented
+	}
 	dstKey = escapeKey(dstKey)
 	srcKey = escapeKey(srcKey)
 	bkt := b.client.Bucket(b.name)
 	copier := bkt.Object(dstKey).CopierFrom(bkt.Object(srcKey))
+	if a := opts.Attributes; a != nil {
+		copier.CacheControl = a.CacheControl
+		copier.ContentDisposition = a.ContentDisposition
+		copier.ContentEncoding = a.ContentEncoding
+		copier.ContentLanguage = a.ContentLanguage
+		copier.ContentType = a.ContentType
+		copier.Metadata = a.Metadata
+	}
 	if opts.BeforeCopy != nil {
 		asFunc := func(i interface{}) bool {
 			switch v := i.(type) {
@@ -504,7 +547,10 @@ func (b *bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.C
 }
 
 // Delete implements driver.Delete.
-func (b *bucket) Delete(ctx context.Context, key string) error {
+func (b *bucket) Delete(ctx context.Context, key string, opts *driver.DeleteOptions) error {
+	if opts.IfMatch != "" || opts.IfNoneMatch != "" {
+		return errNotImplemented
+	}
 	key = escapeKey(key)
 	bkt := b.client.Bucket(b.name)
 	obj := bkt.Object(key)
@@ -518,7 +564,8 @@ func (b *bucket) SignedURL(ctx context.Context, key string, dopts *driver.Signed
 	key = escapeKey(key)
 	opts := &storage.SignedURLOptions{
 		Expires:        time.Now().Add(dopts.Expiry),
-		Method:         "GET",
+		Method:         dopts.Method,
+		ContentType:    dopts.ContentType,
 		GoogleAccessID: b.opts.GoogleAccessID,
 		PrivateKey:     b.opts.PrivateKey,
 		SignBytes:      b.opts.SignBytes,
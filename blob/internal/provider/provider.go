@@ -0,0 +1,22 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package provider lets packages that wrap a *blob.Bucket find out which
+// provider it uses, for example to tag their metrics with
+// gocloud.dev/internal/oc.ProviderKey.
+package provider // import "gocloud.dev/blob/internal/provider"
+
+// Name returns the provider name of b, which must be a *blob.Bucket, as
+// reported in its OpenCensus metrics. It is set by package blob.
+var Name func(b interface{}) string
@@ -0,0 +1,143 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blob_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/fileblob"
+	"gocloud.dev/blob/memblob"
+	"gocloud.dev/gcerrors"
+)
+
+func TestListFilters(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "go-cloud-blob-list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	openFileblob := func(t *testing.T, name string, opts *fileblob.Options) *blob.Bucket {
+		if err := os.Mkdir(dir+"/"+name, 0777); err != nil {
+			t.Fatal(err)
+		}
+		b, err := fileblob.OpenBucket(dir+"/"+name, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	keys := []string{
+		"a.txt",
+		"logs/2019-10-15/x.parquet",
+		"logs/2019-10-16/x.csv",
+		"logs/2019-10-16/x.parquet",
+		"logs/2019-10-16/y.parquet",
+		"logs/2019-10-17/x.parquet",
+		"z.parquet",
+	}
+	future := time.Now().Add(time.Hour)
+	for _, test := range []struct {
+		name string
+		open func(t *testing.T, name string) *blob.Bucket
+	}{
+		{"memblob", func(*testing.T, string) *blob.Bucket { return memblob.OpenBucket(nil) }},
+		{"fileblob", func(t *testing.T, name string) *blob.Bucket { return openFileblob(t, name, nil) }},
+		{"fileblobIndex", func(t *testing.T, name string) *blob.Bucket {
+			return openFileblob(t, name, &fileblob.Options{Index: true})
+		}},
+		{"prefixed", func(*testing.T, string) *blob.Bucket {
+			return blob.PrefixedBucket(memblob.OpenBucket(nil), "p/")
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			b := test.open(t, test.name)
+			defer b.Close()
+			// The size of each blob is the length of its key.
+			for _, key := range keys {
+				if err := b.WriteAll(ctx, key, []byte(key), nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for _, lt := range []struct {
+				name string
+				opts *blob.ListOptions
+				want []string
+			}{
+				{"StartAfter", &blob.ListOptions{StartAfter: "logs/2019-10-16/x.csv"}, keys[3:]},
+				{"StartAfterMissingKey", &blob.ListOptions{StartAfter: "logs/2019-10-16/"}, keys[2:]},
+				{
+					"StartAfterDelimiter",
+					&blob.ListOptions{Prefix: "logs/", Delimiter: "/", StartAfter: "logs/2019-10-15/x.parquet"},
+					// The "directory" holding StartAfter sorts before it.
+					[]string{"logs/2019-10-16/", "logs/2019-10-17/"},
+				},
+				{
+					"Match",
+					&blob.ListOptions{Match: "logs/2019-10-16/*.parquet"},
+					[]string{"logs/2019-10-16/x.parquet", "logs/2019-10-16/y.parquet"},
+				},
+				{"MatchNoSlash", &blob.ListOptions{Match: "*.parquet"}, []string{"z.parquet"}},
+				{
+					"MatchDelimiter",
+					&blob.ListOptions{Delimiter: "/", Match: "*.parquet"},
+					// The pattern doesn't apply to "directories".
+					[]string{"logs/", "z.parquet"},
+				},
+				{
+					"MatchRegexp",
+					&blob.ListOptions{MatchRegexp: regexp.MustCompile(`/x\.`)},
+					[]string{"logs/2019-10-15/x.parquet", "logs/2019-10-16/x.csv", "logs/2019-10-16/x.parquet", "logs/2019-10-17/x.parquet"},
+				},
+				{"Size", &blob.ListOptions{MinSize: 9, MaxSize: 21}, []string{"logs/2019-10-16/x.csv", "z.parquet"}},
+				{"MinModTime", &blob.ListOptions{MinModTime: future}, nil},
+				{"MaxModTime", &blob.ListOptions{MaxModTime: future, Match: "*.txt"}, []string{"a.txt"}},
+			} {
+				if got := listKeys(t, b, lt.opts); !cmp.Equal(got, lt.want) {
+					t.Errorf("%s: got %v want %v", lt.name, got, lt.want)
+				}
+			}
+
+			// Paging through filtered results.
+			opts := &blob.ListOptions{Match: "logs/*/*.parquet", StartAfter: "logs/2019-10-15/x.parquet"}
+			var got []string
+			for token := blob.FirstPageToken; len(token) > 0; {
+				objs, next, err := b.ListPage(ctx, token, 1, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, obj := range objs {
+					got = append(got, obj.Key)
+				}
+				token = next
+			}
+			if want := []string{"logs/2019-10-16/x.parquet", "logs/2019-10-16/y.parquet", "logs/2019-10-17/x.parquet"}; !cmp.Equal(got, want) {
+				t.Errorf("ListPage: got %v want %v", got, want)
+			}
+			if _, _, err := b.ListPage(ctx, blob.FirstPageToken, 0, &blob.ListOptions{Match: "["}); gcerrors.Code(err) != gcerrors.InvalidArgument {
+				t.Errorf("ListPage with invalid pattern: got error %v, want InvalidArgument", err)
+			}
+		})
+	}
+}
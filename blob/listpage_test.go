@@ -0,0 +1,139 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blob
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gocloud.dev/blob/driver"
+	"gocloud.dev/gcerrors"
+)
+
+// fakePager implements driver.Bucket. Only ListPaged is implemented, paging
+// through keys with page tokens holding the index of the next key.
+type fakePager struct {
+	driver.Bucket
+	keys []string
+	// opts holds the options of each call to ListPaged.
+	opts []*driver.ListOptions
+}
+
+func (b *fakePager) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driver.ListPage, error) {
+	b.opts = append(b.opts, opts)
+	var keys []string
+	for _, key := range b.keys {
+		if strings.HasPrefix(key, opts.Prefix) {
+			keys = append(keys, key)
+		}
+	}
+	start := 0
+	if opts.PageToken != nil {
+		var err error
+		if start, err = strconv.Atoi(string(opts.PageToken)); err != nil {
+			return nil, err
+		}
+	}
+	end := len(keys)
+	if opts.PageSize > 0 && start+opts.PageSize < end {
+		end = start + opts.PageSize
+	}
+	page := &driver.ListPage{}
+	for _, key := range keys[start:end] {
+		page.Objects = append(page.Objects, &driver.ListObject{Key: key})
+	}
+	if end < len(keys) {
+		page.NextPageToken = []byte(strconv.Itoa(end))
+	}
+	return page, nil
+}
+
+func (b *fakePager) ErrorCode(err error) gcerrors.ErrorCode {
+	return gcerrors.Unknown
+}
+
+func (b *fakePager) Close() error {
+	return nil
+}
+
+func TestListPage(t *testing.T) {
+	ctx := context.Background()
+	drv := &fakePager{keys: []string{"a", "b/1", "b/2", "b/3", "c"}}
+	b := NewBucket(drv)
+
+	// listAll returns the keys in all pages, and checks the page sizes.
+	listAll := func(pageSize, wantPageSize int, opts *ListOptions) []string {
+		t.Helper()
+		var keys []string
+		token := FirstPageToken
+		for len(token) > 0 {
+			objs, next, err := b.ListPage(ctx, token, pageSize, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if wantPageSize > 0 && len(objs) > wantPageSize {
+				t.Errorf("got page of %d results, want at most %d", len(objs), wantPageSize)
+			}
+			for _, obj := range objs {
+				keys = append(keys, obj.Key)
+			}
+			token = next
+		}
+		return keys
+	}
+
+	if got, want := listAll(2, 2, nil), drv.keys; !cmp.Equal(got, want) {
+		t.Errorf("got %v want %v", got, want)
+	}
+	// FirstPageToken is passed to the driver as a nil PageToken.
+	if drv.opts[0].PageToken != nil {
+		t.Errorf("got PageToken %q for the first page, want nil", drv.opts[0].PageToken)
+	}
+	if got := len(drv.opts); got != 3 {
+		t.Errorf("got %d calls to ListPaged, want 3", got)
+	}
+
+	// A negative page size lets the provider choose.
+	if got, want := listAll(-1, 0, nil), drv.keys; !cmp.Equal(got, want) {
+		t.Errorf("got %v want %v", got, want)
+	}
+	if got := drv.opts[len(drv.opts)-1].PageSize; got != 0 {
+		t.Errorf("got PageSize %d, want 0", got)
+	}
+
+	// ListOptions are passed to the driver.
+	if got, want := listAll(1, 1, &ListOptions{Prefix: "b/", Delimiter: "/"}), []string{"b/1", "b/2", "b/3"}; !cmp.Equal(got, want) {
+		t.Errorf("got %v want %v", got, want)
+	}
+	if got := drv.opts[len(drv.opts)-1].Delimiter; got != "/" {
+		t.Errorf("got Delimiter %q, want %q", got, "/")
+	}
+
+	// An empty page token is an error, since it could be mistaken for the
+	// end of the listing.
+	if _, _, err := b.ListPage(ctx, nil, 1, nil); gcerrors.Code(err) != gcerrors.InvalidArgument {
+		t.Errorf("got error %v from ListPage with an empty token, want InvalidArgument", err)
+	}
+
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := b.ListPage(ctx, FirstPageToken, 1, nil); gcerrors.Code(err) != gcerrors.FailedPrecondition {
+		t.Errorf("got error %v from ListPage on a closed bucket, want FailedPrecondition", err)
+	}
+}
@@ -25,18 +25,50 @@
 // As
 //
 // memblob does not support any types for As.
+//
+// Versioning
+//
+// memblob implements driver.Versioner; overwritten and deleted blobs are kept
+// in memory as previous versions until they are removed with
+// blob.Bucket.DeleteVersion.
+//
+// Expiration
+//
+// memblob implements driver.Expirer; blobs written with
+// blob.WriterOptions.ExpiresAt are removed once they expire, without being
+// kept as previous versions.
+//
+// Watching
+//
+// memblob implements driver.Watcher; changes made through the bucket are
+// reported to its watchers immediately. Changes to a blob that haven't been
+// returned by Next yet are reported as a single event. If more than 10000
+// blobs have changes that haven't been returned, the watcher stops reporting
+// changes, and Next returns an error for which gcerrors.Code returns
+// gcerrors.ResourceExhausted.
+//
+// Snapshots
+//
+// Snapshot writes the blobs in a bucket to a directory or a tar archive, and
+// OpenBucketFromSnapshot creates a bucket holding the blobs in one; this is
+// useful for loading test fixtures.
 package memblob // import "gocloud.dev/blob/memblob"
 
 import (
 	"bytes"
 	"context"
 	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"hash"
+	"hash/crc32"
 	"io"
 	"net/url"
+	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -48,9 +80,18 @@ import (
 
 const defaultPageSize = 1000
 
+// maxPendingWatchEvents is the maximum number of events that a watcher holds
+// until they are returned by Next.
+const maxPendingWatchEvents = 10000
+
+// crc32cTable is used to compute the CRC32C checksums of blobs.
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
 var (
-	errNotFound       = errors.New("blob not found")
-	errNotImplemented = errors.New("not implemented")
+	errNotFound           = errors.New("blob not found")
+	errNotImplemented     = errors.New("not implemented")
+	errPreconditionFailed = errors.New("precondition failed")
+	errWatchOverflow      = fmt.Errorf("more than %d changes were not read from the watcher", maxPendingWatchEvents)
 )
 
 func init() {
@@ -63,13 +104,29 @@ const Scheme = "mem"
 
 // URLOpener opens URLs like "mem://".
 //
-// No query parameters are supported.
+// The following query parameters are supported:
+//
+//   - seed: the path of a snapshot to load the bucket from, using
+//     OpenBucketFromSnapshot.
+//
+// Example:
+//
+//  - mem://?seed=/path/to/fixtures.tar
 type URLOpener struct{}
 
 // OpenBucketURL opens a blob.Bucket based on u.
 func (*URLOpener) OpenBucketURL(ctx context.Context, u *url.URL) (*blob.Bucket, error) {
-	for param := range u.Query() {
-		return nil, fmt.Errorf("open bucket %v: invalid query parameter %q", u, param)
+	var seed string
+	for param, values := range u.Query() {
+		switch param {
+		case "seed":
+			seed = values[0]
+		default:
+			return nil, fmt.Errorf("open bucket %v: invalid query parameter %q", u, param)
+		}
+	}
+	if seed != "" {
+		return OpenBucketFromSnapshot(ctx, filepath.FromSlash(seed), nil)
 	}
 	return OpenBucket(nil), nil
 }
@@ -85,12 +142,21 @@ type blobEntry struct {
 type bucket struct {
 	mu    sync.Mutex
 	blobs map[string]*blobEntry
+	// versions holds the previous versions of each key, oldest first.
+	// The ETag of each entry doubles as its version ID.
+	versions map[string][]*blobEntry
+	// gen is incremented on every write, and used to generate ETags.
+	gen int64
+	// watchers holds the open watchers, which are notified of every change.
+	watchers map[*watcher]bool
 }
 
 // openBucket creates a driver.Bucket backed by memory.
 func openBucket(_ *Options) driver.Bucket {
 	return &bucket{
-		blobs: map[string]*blobEntry{},
+		blobs:    map[string]*blobEntry{},
+		versions: map[string][]*blobEntry{},
+		watchers: map[*watcher]bool{},
 	}
 }
 
@@ -109,6 +175,10 @@ func (b *bucket) ErrorCode(err error) gcerrors.ErrorCode {
 		return gcerrors.NotFound
 	case errNotImplemented:
 		return gcerrors.Unimplemented
+	case errPreconditionFailed:
+		return gcerrors.FailedPrecondition
+	case errWatchOverflow:
+		return gcerrors.ResourceExhausted
 	default:
 		return gcerrors.Unknown
 	}
@@ -126,6 +196,10 @@ func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driv
 	if len(opts.PageToken) > 0 {
 		pageToken = string(opts.PageToken)
 	}
+	// StartAfter skips keys in the same way.
+	if opts.StartAfter > pageToken {
+		pageToken = opts.StartAfter
+	}
 	pageSize := opts.PageSize
 	if pageSize == 0 {
 		pageSize = defaultPageSize
@@ -148,12 +222,18 @@ func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driv
 			continue
 		}
 
-		entry := b.blobs[key]
+		entry := b.lookup(key)
+		if entry == nil {
+			continue
+		}
 		obj := &driver.ListObject{
 			Key:     key,
 			ModTime: entry.Attributes.ModTime,
 			Size:    entry.Attributes.Size,
 			MD5:     entry.Attributes.MD5,
+			CRC32C:  entry.Attributes.CRC32C,
+			SHA256:  entry.Attributes.SHA256,
+			ETag:    entry.Attributes.ETag,
 		}
 
 		// If using Delimiter, collapse "directories".
@@ -183,6 +263,10 @@ func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driv
 		if pageToken != "" && obj.Key <= pageToken {
 			continue
 		}
+		// Skip blobs that don't pass the filters.
+		if !opts.Includes(obj) {
+			continue
+		}
 
 		// If we've already got a full page of results, set NextPageToken and return.
 		if len(result.Objects) == pageSize {
@@ -205,8 +289,8 @@ func (b *bucket) Attributes(ctx context.Context, key string) (*driver.Attributes
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	entry, found := b.blobs[key]
-	if !found {
+	entry := b.lookup(key)
+	if entry == nil {
 		return nil, errNotFound
 	}
 	return entry.Attributes, nil
@@ -217,11 +301,18 @@ func (b *bucket) NewRangeReader(ctx context.Context, key string, offset, length
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	entry, found := b.blobs[key]
-	if !found {
+	entry := b.lookup(key)
+	if entry == nil {
 		return nil, errNotFound
 	}
+	if !preconditionsMet(entry, opts.IfMatch, opts.IfNoneMatch) {
+		return nil, errPreconditionFailed
+	}
+	return newReader(entry, offset, length)
+}
 
+// newReader returns a reader for the given range of entry.
+func newReader(entry *blobEntry, offset, length int64) (driver.Reader, error) {
 	r := bytes.NewReader(entry.Content)
 	if offset > 0 {
 		if _, err := r.Seek(offset, io.SeekStart); err != nil {
@@ -238,6 +329,12 @@ func (b *bucket) NewRangeReader(ctx context.Context, key string, offset, length
 			ContentType: entry.Attributes.ContentType,
 			ModTime:     entry.Attributes.ModTime,
 			Size:        entry.Attributes.Size,
+
+			ContentEncoding: entry.Attributes.ContentEncoding,
+			MD5:             entry.Attributes.MD5,
+			CRC32C:          entry.Attributes.CRC32C,
+			SHA256:          entry.Attributes.SHA256,
+			ETag:            entry.Attributes.ETag,
 		},
 	}, nil
 }
@@ -315,8 +412,17 @@ func (w *writer) Close() error {
 		return err
 	}
 
+	w.b.mu.Lock()
+	defer w.b.mu.Unlock()
+
+	if !preconditionsMet(w.b.lookup(w.key), w.opts.IfMatch, w.opts.IfNoneMatch) {
+		return errPreconditionFailed
+	}
 	md5sum := w.md5hash.Sum(nil)
 	content := w.buf.Bytes()
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.Checksum(content, crc32cTable))
+	sha := sha256.Sum256(content)
 	entry := &blobEntry{
 		Content: content,
 		Attributes: &driver.Attributes{
@@ -329,42 +435,384 @@ func (w *writer) Close() error {
 			Size:               int64(len(content)),
 			ModTime:            time.Now(),
 			MD5:                md5sum,
+			CRC32C:             crc,
+			SHA256:             sha[:],
+			ETag:               w.b.nextETag(),
+			ExpiresAt:          w.opts.ExpiresAt,
 		},
 	}
-	w.b.mu.Lock()
-	defer w.b.mu.Unlock()
-	w.b.blobs[w.key] = entry
+	w.b.put(w.key, entry)
 	return nil
 }
 
+// put stores entry at key, keeping the existing entry as a previous version.
+// b.mu must be held.
+func (b *bucket) put(key string, entry *blobEntry) {
+	typ := driver.WatchCreated
+	if b.lookup(key) != nil {
+		typ = driver.WatchUpdated
+	}
+	b.archive(key)
+	b.blobs[key] = entry
+	b.notify(key, typ)
+}
+
+// archive moves the current entry for key, if any, to the previous versions.
+// b.mu must be held.
+func (b *bucket) archive(key string) {
+	if cur := b.lookup(key); cur != nil {
+		b.versions[key] = append(b.versions[key], cur)
+		delete(b.blobs, key)
+	}
+}
+
+// lookup returns the current entry for key, or nil if there is none. If the
+// entry has expired, it is removed, without being kept as a previous version.
+// b.mu must be held.
+func (b *bucket) lookup(key string) *blobEntry {
+	entry := b.blobs[key]
+	if entry != nil && expired(entry, time.Now()) {
+		delete(b.blobs, key)
+		b.notify(key, driver.WatchDeleted)
+		return nil
+	}
+	return entry
+}
+
+// expired reports whether entry has expired at now.
+func expired(entry *blobEntry, now time.Time) bool {
+	t := entry.Attributes.ExpiresAt
+	return !t.IsZero() && !now.Before(t)
+}
+
+// nextETag returns a new, unique ETag. b.mu must be held.
+func (b *bucket) nextETag() string {
+	b.gen++
+	return strconv.FormatInt(b.gen, 10)
+}
+
+// preconditionsMet reports whether entry, which may be nil if the blob
+// doesn't exist, satisfies the ifMatch and ifNoneMatch preconditions.
+func preconditionsMet(entry *blobEntry, ifMatch, ifNoneMatch string) bool {
+	if ifMatch != "" {
+		if entry == nil || (ifMatch != "*" && ifMatch != entry.Attributes.ETag) {
+			return false
+		}
+	}
+	if ifNoneMatch != "" && entry != nil {
+		if ifNoneMatch == "*" || ifNoneMatch == entry.Attributes.ETag {
+			return false
+		}
+	}
+	return true
+}
+
 // Copy implements driver.Copy.
 func (b *bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.CopyOptions) error {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
 	if opts.BeforeCopy != nil {
-		return opts.BeforeCopy(func(interface{}) bool { return false })
+		if err := opts.BeforeCopy(func(interface{}) bool { return false }); err != nil {
+			return err
+		}
 	}
-	v := b.blobs[srcKey]
+	v := b.lookup(srcKey)
 	if v == nil {
 		return errNotFound
 	}
-	b.blobs[dstKey] = v
+	if !preconditionsMet(b.lookup(dstKey), opts.IfMatch, opts.IfNoneMatch) {
+		return errPreconditionFailed
+	}
+	// The copy is a new blob, so it gets its own ModTime and ETag.
+	attrs := *v.Attributes
+	attrs.ModTime = time.Now()
+	attrs.ETag = b.nextETag()
+	if opts.Attributes != nil {
+		setAttributes(&attrs, opts.Attributes)
+	}
+	b.put(dstKey, &blobEntry{Content: v.Content, Attributes: &attrs})
+	return nil
+}
+
+// SetAttributes implements driver.AttributesSetter.
+func (b *bucket) SetAttributes(ctx context.Context, key string, wa *driver.WritableAttributes) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	entry := b.lookup(key)
+	if entry == nil {
+		return errNotFound
+	}
+	// The blob's content doesn't change, but its ETag does, so that
+	// preconditions and caches take the new attributes into account.
+	attrs := *entry.Attributes
+	attrs.ETag = b.nextETag()
+	setAttributes(&attrs, wa)
+	b.put(key, &blobEntry{Content: entry.Content, Attributes: &attrs})
+	return nil
+}
+
+// setAttributes replaces the writable attributes in attrs with wa.
+func setAttributes(attrs *driver.Attributes, wa *driver.WritableAttributes) {
+	md := map[string]string{}
+	for k, v := range wa.Metadata {
+		md[k] = v
+	}
+	attrs.CacheControl = wa.CacheControl
+	attrs.ContentDisposition = wa.ContentDisposition
+	attrs.ContentEncoding = wa.ContentEncoding
+	attrs.ContentLanguage = wa.ContentLanguage
+	attrs.ContentType = wa.ContentType
+	attrs.Metadata = md
+}
+
+// Move implements driver.Mover. The blob keeps its ETag, and its previous
+// versions stay with srcKey.
+func (b *bucket) Move(ctx context.Context, dstKey, srcKey string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	entry := b.lookup(srcKey)
+	if entry == nil {
+		return errNotFound
+	}
+	b.archive(srcKey)
+	b.notify(srcKey, driver.WatchDeleted)
+	b.put(dstKey, entry)
 	return nil
 }
 
 // Delete implements driver.Delete.
-func (b *bucket) Delete(ctx context.Context, key string) error {
+func (b *bucket) Delete(ctx context.Context, key string, opts *driver.DeleteOptions) error {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	if b.blobs[key] == nil {
+	entry := b.lookup(key)
+	if entry == nil {
 		return errNotFound
 	}
-	delete(b.blobs, key)
+	if !preconditionsMet(entry, opts.IfMatch, opts.IfNoneMatch) {
+		return errPreconditionFailed
+	}
+	b.archive(key)
+	b.notify(key, driver.WatchDeleted)
+	return nil
+}
+
+// DeleteMany implements driver.BatchDeleter.
+func (b *bucket) DeleteMany(ctx context.Context, keys []string) driver.DeleteManyError {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, key := range keys {
+		if b.lookup(key) != nil {
+			b.archive(key)
+			b.notify(key, driver.WatchDeleted)
+		}
+	}
+	return nil
+}
+
+// PurgeExpired implements driver.Expirer. Expired blobs are also removed
+// when they are accessed.
+func (b *bucket) PurgeExpired(ctx context.Context) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := 0
+	now := time.Now()
+	for key, entry := range b.blobs {
+		if expired(entry, now) {
+			delete(b.blobs, key)
+			b.notify(key, driver.WatchDeleted)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// ListVersions implements driver.Versioner.
+func (b *bucket) ListVersions(ctx context.Context, key string) ([]*driver.VersionInfo, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var vs []*driver.VersionInfo
+	add := func(entry *blobEntry, latest bool) {
+		vs = append(vs, &driver.VersionInfo{
+			VersionID: entry.Attributes.ETag,
+			ModTime:   entry.Attributes.ModTime,
+			Size:      entry.Attributes.Size,
+			MD5:       entry.Attributes.MD5,
+			ETag:      entry.Attributes.ETag,
+			IsLatest:  latest,
+		})
+	}
+	if cur := b.lookup(key); cur != nil {
+		add(cur, true)
+	}
+	prev := b.versions[key]
+	for i := len(prev) - 1; i >= 0; i-- {
+		add(prev[i], false)
+	}
+	return vs, nil
+}
+
+// NewVersionRangeReader implements driver.Versioner.
+func (b *bucket) NewVersionRangeReader(ctx context.Context, key, versionID string, offset, length int64, opts *driver.ReaderOptions) (driver.Reader, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	entry := b.lookup(key)
+	if entry == nil || entry.Attributes.ETag != versionID {
+		entry = nil
+		for _, v := range b.versions[key] {
+			if v.Attributes.ETag == versionID {
+				entry = v
+				break
+			}
+		}
+	}
+	if entry == nil {
+		return nil, errNotFound
+	}
+	if !preconditionsMet(entry, opts.IfMatch, opts.IfNoneMatch) {
+		return nil, errPreconditionFailed
+	}
+	return newReader(entry, offset, length)
+}
+
+// DeleteVersion implements driver.Versioner.
+func (b *bucket) DeleteVersion(ctx context.Context, key, versionID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	prev := b.versions[key]
+	if cur := b.lookup(key); cur != nil && cur.Attributes.ETag == versionID {
+		// Promote the next newest version, if any.
+		delete(b.blobs, key)
+		if n := len(prev); n > 0 {
+			b.blobs[key] = prev[n-1]
+			prev = prev[:n-1]
+			b.notify(key, driver.WatchUpdated)
+		} else {
+			b.notify(key, driver.WatchDeleted)
+		}
+	} else {
+		i := 0
+		for ; i < len(prev); i++ {
+			if prev[i].Attributes.ETag == versionID {
+				break
+			}
+		}
+		if i == len(prev) {
+			return errNotFound
+		}
+		prev = append(prev[:i:i], prev[i+1:]...)
+	}
+	if len(prev) == 0 {
+		delete(b.versions, key)
+	} else {
+		b.versions[key] = prev
+	}
 	return nil
 }
 
 func (b *bucket) SignedURL(ctx context.Context, key string, opts *driver.SignedURLOptions) (string, error) {
 	return "", errNotImplemented
 }
+
+// Watch implements driver.Watcher.
+func (b *bucket) Watch(ctx context.Context, prefix string) (driver.WatchIterator, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	w := &watcher{b: b, prefix: prefix, pendingKeys: map[string]*driver.WatchEvent{}, ready: make(chan struct{}, 1)}
+	b.watchers[w] = true
+	return w, nil
+}
+
+// notify reports a change to key to the interested watchers. b.mu must be held.
+func (b *bucket) notify(key string, typ driver.WatchEventType) {
+	for w := range b.watchers {
+		if !strings.HasPrefix(key, w.prefix) || w.err != nil {
+			continue
+		}
+		if e := w.pendingKeys[key]; e != nil {
+			e.Type = coalesce(e.Type, typ)
+			continue
+		}
+		if len(w.pending) >= maxPendingWatchEvents {
+			w.err = errWatchOverflow
+		} else {
+			e := &driver.WatchEvent{Key: key, Type: typ}
+			w.pending = append(w.pending, e)
+			w.pendingKeys[key] = e
+		}
+		select {
+		case w.ready <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// coalesce returns the type of a single event that reports a change of type
+// prev followed by one of type next.
+func coalesce(prev, next driver.WatchEventType) driver.WatchEventType {
+	switch {
+	case prev == driver.WatchCreated && next != driver.WatchDeleted:
+		// The blob is still new to the watcher.
+		return driver.WatchCreated
+	case prev == driver.WatchDeleted && next != driver.WatchDeleted:
+		// The blob existed before it was deleted and created again.
+		return driver.WatchUpdated
+	}
+	return next
+}
+
+// watcher implements driver.WatchIterator. Its fields other than b and
+// prefix are protected by b.mu.
+type watcher struct {
+	b      *bucket
+	prefix string
+	// pending holds the events that haven't been returned by Next yet, and
+	// pendingKeys holds the same events keyed by blob key.
+	pending     []*driver.WatchEvent
+	pendingKeys map[string]*driver.WatchEvent
+	// err is set if the watcher held too many events; it is returned by Next
+	// once pending is empty.
+	err error
+	// ready is signaled when pending or err changes.
+	ready chan struct{}
+}
+
+func (w *watcher) Next(ctx context.Context) (*driver.WatchEvent, error) {
+	for {
+		w.b.mu.Lock()
+		if len(w.pending) > 0 {
+			e := w.pending[0]
+			w.pending[0] = nil
+			w.pending = w.pending[1:]
+			delete(w.pendingKeys, e.Key)
+			w.b.mu.Unlock()
+			return e, nil
+		}
+		err := w.err
+		w.b.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-w.ready:
+		}
+	}
+}
+
+func (w *watcher) Close() error {
+	w.b.mu.Lock()
+	defer w.b.mu.Unlock()
+	delete(w.b.watchers, w)
+	return nil
+}
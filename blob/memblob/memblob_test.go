@@ -15,13 +15,22 @@
 package memblob
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	"gocloud.dev/blob"
 	"gocloud.dev/blob/driver"
 	"gocloud.dev/blob/drivertest"
+	"gocloud.dev/gcerrors"
 )
 
 type harness struct{}
@@ -67,3 +76,159 @@ func TestOpenBucketFromURL(t *testing.T) {
 		}
 	}
 }
+
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "memblob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	b := OpenBucket(nil)
+	defer b.Close()
+	for key, opts := range map[string]*blob.WriterOptions{
+		"a.txt":        nil,
+		"dir/b.html":   {ContentType: "text/html", CacheControl: "no-cache", Metadata: map[string]string{"k": "v"}},
+		"dir/sub/c.gz": {Compression: "gzip", ContentLanguage: "en"},
+	} {
+		if err := b.WriteAll(ctx, key, []byte("content of "+key), opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Previous versions are not included.
+	if err := b.WriteAll(ctx, "a.txt", []byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+	check := func(t *testing.T, got *blob.Bucket) {
+		t.Helper()
+		iter := b.List(nil)
+		var n int
+		for {
+			obj, err := iter.Next(ctx)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			n++
+			want, err := b.ReadAll(ctx, obj.Key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, err := got.ReadAll(ctx, obj.Key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(content, want) {
+				t.Errorf("%s: got content %q want %q", obj.Key, content, want)
+			}
+			wantAttrs, err := b.Attributes(ctx, obj.Key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotAttrs, err := got.Attributes(ctx, obj.Key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(gotAttrs, wantAttrs, cmpopts.IgnoreUnexported(blob.Attributes{}), cmpopts.IgnoreFields(blob.Attributes{}, "ETag")); diff != "" {
+				t.Errorf("%s: got attributes diff (-got +want):\n%s", obj.Key, diff)
+			}
+		}
+		if n != 3 {
+			t.Errorf("listed %d blobs, want 3", n)
+		}
+		if vs, err := got.ListVersions(ctx, "a.txt"); err != nil || len(vs) != 1 {
+			t.Errorf("got versions %v, %v, want only the latest", vs, err)
+		}
+	}
+
+	for _, path := range []string{filepath.Join(dir, "fixtures.tar"), filepath.Join(dir, "fixtures")} {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			if err := Snapshot(ctx, b, path); err != nil {
+				t.Fatal(err)
+			}
+			got, err := OpenBucketFromSnapshot(ctx, path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer got.Close()
+			check(t, got)
+
+			got, err = blob.OpenBucket(ctx, "mem://?seed="+filepath.ToSlash(path))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer got.Close()
+			check(t, got)
+		})
+	}
+	// Snapshots aren't written into directories with files in them.
+	if err := Snapshot(ctx, b, filepath.Join(dir, "fixtures")); gcerrors.Code(err) != gcerrors.FailedPrecondition {
+		t.Errorf("got error %v want FailedPrecondition", err)
+	}
+	if _, err := blob.OpenBucket(ctx, "mem://?seed="+filepath.ToSlash(filepath.Join(dir, "missing"))); err == nil {
+		t.Error("got nil error opening a missing snapshot")
+	}
+}
+
+func TestWatchPending(t *testing.T) {
+	ctx := context.Background()
+	b := OpenBucket(nil)
+	defer b.Close()
+	if err := b.WriteAll(ctx, "existing", []byte("x"), nil); err != nil {
+		t.Fatal(err)
+	}
+	iter, err := b.Watch(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iter.Close()
+
+	// Changes to a blob that haven't been read are coalesced.
+	write := func(key string) {
+		t.Helper()
+		if err := b.WriteAll(ctx, key, []byte("x"), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	del := func(key string) {
+		t.Helper()
+		if err := b.Delete(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a")
+	write("a")
+	write("existing")
+	del("existing")
+	write("b")
+	del("b")
+	write("existing")
+	var got []string
+	for i := 0; i < 3; i++ {
+		e, err := iter.Next(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, e.Key+" "+e.Type.String())
+	}
+	want := []string{"a created", "existing updated", "b deleted"}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("got events %v want %v", got, want)
+	}
+
+	// A watcher that holds too many events stops reporting changes.
+	for i := 0; i <= maxPendingWatchEvents; i++ {
+		write(fmt.Sprintf("key%d", i))
+	}
+	for i := 0; i < maxPendingWatchEvents; i++ {
+		if _, err := iter.Next(ctx); err != nil {
+			t.Fatalf("event %d: %v", i, err)
+		}
+	}
+	if _, err := iter.Next(ctx); gcerrors.Code(err) != gcerrors.ResourceExhausted {
+		t.Errorf("got error %v want ResourceExhausted", err)
+	}
+}
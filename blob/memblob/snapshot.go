@@ -0,0 +1,243 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memblob
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/blobarchive"
+	"gocloud.dev/internal/gcerr"
+)
+
+// snapshotAttrsFile is the name of the file that holds the attributes of the
+// blobs in a snapshot directory. It maps each key to its records, as
+// returned by blobarchive.Records.
+const snapshotAttrsFile = ".memblob-attributes.json"
+
+// Snapshot writes the blobs in b, along with their attributes, to path, so
+// that they can be loaded with OpenBucketFromSnapshot. b is usually a memblob
+// bucket, but can be any bucket. Previous versions of blobs are not included.
+//
+// If path ends with ".tar", the snapshot is a tar archive, in the format
+// written by blobarchive.ExportTar. Otherwise, it is a directory holding a
+// file for each blob, named by its key, and a ".memblob-attributes.json"
+// file for their attributes; the directory is created if needed, and must
+// be empty. Directory snapshots can only hold blobs whose keys are valid
+// relative paths, with no key being a "directory" of another one.
+func Snapshot(ctx context.Context, b *blob.Bucket, path string) error {
+	if strings.HasSuffix(path, ".tar") {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		if err := blobarchive.ExportTar(ctx, f, b, ""); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+	return snapshotDir(ctx, b, path)
+}
+
+func snapshotDir(ctx context.Context, b *blob.Bucket, dir string) error {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	if len(infos) > 0 {
+		return gcerr.Newf(gcerr.FailedPrecondition, nil, "memblob: snapshot directory %s is not empty", dir)
+	}
+	attrs := map[string]map[string]string{}
+	iter := b.List(nil)
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if obj.Key == snapshotAttrsFile || obj.Key != path.Clean(obj.Key) || path.IsAbs(obj.Key) || obj.Key == ".." || strings.HasPrefix(obj.Key, "../") {
+			return gcerr.Newf(gcerr.InvalidArgument, nil, "memblob: blob %q can't be stored in a snapshot directory", obj.Key)
+		}
+		a, err := snapshotFile(ctx, b, obj.Key, filepath.Join(dir, filepath.FromSlash(obj.Key)))
+		if err != nil {
+			return err
+		}
+		if recs := blobarchive.Records(a); len(recs) > 0 {
+			attrs[obj.Key] = recs
+		}
+	}
+	data, err := json.MarshalIndent(attrs, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, snapshotAttrsFile), data, 0666)
+}
+
+// snapshotFile writes the blob at key to the file at path, and returns its
+// attributes.
+func snapshotFile(ctx context.Context, b *blob.Bucket, key, path string) (*blob.Attributes, error) {
+	attrs, err := b.Attributes(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	r, err := b.NewReader(ctx, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return nil, err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Chtimes(path, attrs.ModTime, attrs.ModTime); err != nil {
+		return nil, err
+	}
+	return attrs, nil
+}
+
+// OpenBucketFromSnapshot creates a *blob.Bucket backed by memory, holding
+// the blobs in the snapshot at path, which may be written by Snapshot or by
+// hand. The blobs get the attributes recorded in the snapshot, including
+// their modification times.
+//
+// path may be a tar archive, in which case the regular files in it are
+// loaded; see blobarchive.ImportTar. Otherwise, it must be a directory, and
+// the files under it are loaded.
+func OpenBucketFromSnapshot(ctx context.Context, path string, opts *Options) (*blob.Bucket, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	drv := openBucket(opts).(*bucket)
+	b := blob.NewBucket(drv)
+	load := func(key string, r io.Reader, wopts *blob.WriterOptions, modTime time.Time) error {
+		if err := b.Upload(ctx, key, r, &blob.UploadOptions{WriterOptions: wopts}); err != nil {
+			return err
+		}
+		drv.mu.Lock()
+		drv.blobs[key].Attributes.ModTime = modTime
+		drv.mu.Unlock()
+		return nil
+	}
+	if info.IsDir() {
+		err = loadDir(path, load)
+	} else {
+		err = loadTar(path, load)
+	}
+	if err != nil {
+		b.Close()
+		return nil, err
+	}
+	// Blobs that appear more than once in an archive are only loaded once.
+	drv.mu.Lock()
+	drv.versions = map[string][]*blobEntry{}
+	drv.mu.Unlock()
+	return b, nil
+}
+
+// loadFunc writes the blob at key.
+type loadFunc func(key string, r io.Reader, opts *blob.WriterOptions, modTime time.Time) error
+
+func loadTar(path string, load loadFunc) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		// Archives created from a directory often have names like "./a/b".
+		key := strings.TrimLeft(strings.TrimPrefix(hdr.Name, "./"), "/")
+		if key == "" {
+			continue
+		}
+		opts, err := blobarchive.TarWriterOptions(hdr)
+		if err != nil {
+			return err
+		}
+		if err := load(key, tr, opts, hdr.ModTime); err != nil {
+			return err
+		}
+	}
+}
+
+func loadDir(dir string, load loadFunc) error {
+	attrs := map[string]map[string]string{}
+	data, err := ioutil.ReadFile(filepath.Join(dir, snapshotAttrsFile))
+	if err == nil {
+		err = json.Unmarshal(data, &attrs)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	dir = filepath.Clean(dir)
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		key := filepath.ToSlash(path[len(dir)+1:])
+		if key == snapshotAttrsFile {
+			return nil
+		}
+		opts, err := blobarchive.WriterOptions(attrs[key])
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return load(key, f, opts, info.ModTime())
+	})
+}
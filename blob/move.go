@@ -0,0 +1,83 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blob
+
+import (
+	"context"
+	"unicode/utf8"
+
+	"gocloud.dev/blob/driver"
+	"gocloud.dev/gcerrors"
+	"gocloud.dev/internal/gcerr"
+)
+
+// MoveResult describes how Move moved a blob.
+type MoveResult struct {
+	// Atomic is true if the provider renamed the blob atomically. Otherwise,
+	// Move copied the blob to dstKey and then deleted srcKey, so other
+	// readers may have seen the blob at both keys in between.
+	Atomic bool
+}
+
+// Move renames the blob stored at srcKey to dstKey, replacing any blob
+// stored at dstKey.
+//
+// Providers that support it rename the blob atomically, so that readers see
+// either the old blob at dstKey or the moved one. Otherwise, Move copies the
+// blob and then deletes srcKey, which is not atomic, and reports it in the
+// result. If such a Move fails after the copy, the blob may remain at both
+// keys.
+//
+// If the source blob does not exist, Move returns an error for which
+// gcerrors.Code will return gcerrors.NotFound.
+func (b *Bucket) Move(ctx context.Context, dstKey, srcKey string) (_ *MoveResult, err error) {
+	if !utf8.ValidString(srcKey) {
+		return nil, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: Move srcKey must be a valid UTF-8 string: %q", srcKey)
+	}
+	if !utf8.ValidString(dstKey) {
+		return nil, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: Move dstKey must be a valid UTF-8 string: %q", dstKey)
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return nil, errClosed
+	}
+	ctx = b.tracer.Start(ctx, "Move")
+	defer func() { b.tracer.End(ctx, err) }()
+
+	if dstKey == srcKey {
+		// Nothing to do, as long as the blob exists.
+		if _, err := b.b.Attributes(ctx, srcKey); err != nil {
+			return nil, wrapError(b.b, err)
+		}
+		return &MoveResult{Atomic: true}, nil
+	}
+	if m, ok := b.b.(driver.Mover); ok {
+		err := m.Move(ctx, dstKey, srcKey)
+		if err == nil {
+			return &MoveResult{Atomic: true}, nil
+		}
+		if b.b.ErrorCode(err) != gcerrors.Unimplemented {
+			return nil, wrapError(b.b, err)
+		}
+	}
+	if err := b.b.Copy(ctx, dstKey, srcKey, &driver.CopyOptions{}); err != nil {
+		return nil, wrapError(b.b, err)
+	}
+	if err := b.b.Delete(ctx, srcKey, &driver.DeleteOptions{}); err != nil {
+		return nil, wrapError(b.b, err)
+	}
+	return &MoveResult{Atomic: false}, nil
+}
@@ -0,0 +1,116 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blob_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/fileblob"
+	"gocloud.dev/blob/memblob"
+	"gocloud.dev/gcerrors"
+)
+
+func TestMove(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "go-cloud-blob-move")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, test := range []struct {
+		name       string
+		open       func(t *testing.T) *blob.Bucket
+		wantAtomic bool
+	}{
+		{"memblob", func(*testing.T) *blob.Bucket { return memblob.OpenBucket(nil) }, true},
+		{"fileblob", func(t *testing.T) *blob.Bucket {
+			b, err := fileblob.OpenBucket(dir, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return blob.PrefixedBucket(b, "plain/")
+		}, true},
+		// fileblob doesn't support atomic moves when versioning.
+		{"fileblobVersioning", func(t *testing.T) *blob.Bucket {
+			b, err := fileblob.OpenBucket(dir, &fileblob.Options{Versioning: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return blob.PrefixedBucket(b, "versioned/")
+		}, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			b := test.open(t)
+			defer b.Close()
+
+			opts := &blob.WriterOptions{ContentType: "text/plain", Metadata: map[string]string{"a": "1"}}
+			if err := b.WriteAll(ctx, "tmp/output", []byte("new"), opts); err != nil {
+				t.Fatal(err)
+			}
+			if err := b.WriteAll(ctx, "output", []byte("old"), nil); err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := b.Move(ctx, "output", "tmp/output")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Atomic != test.wantAtomic {
+				t.Errorf("got Atomic %v want %v", res.Atomic, test.wantAtomic)
+			}
+			got, err := b.ReadAll(ctx, "output")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != "new" {
+				t.Errorf("got %q want %q", got, "new")
+			}
+			attrs, err := b.Attributes(ctx, "output")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if attrs.ContentType != "text/plain" {
+				t.Errorf("got ContentType %q want %q", attrs.ContentType, "text/plain")
+			}
+			if diff := cmp.Diff(attrs.Metadata, opts.Metadata); diff != "" {
+				t.Errorf("got metadata diff (-got +want):\n%s", diff)
+			}
+			if exists, err := b.Exists(ctx, "tmp/output"); err != nil || exists {
+				t.Errorf("after Move, source exists: %v, %v", exists, err)
+			}
+
+			// Moving a blob to itself does nothing.
+			if _, err := b.Move(ctx, "output", "output"); err != nil {
+				t.Error(err)
+			}
+			if _, err := b.ReadAll(ctx, "output"); err != nil {
+				t.Error(err)
+			}
+
+			if _, err := b.Move(ctx, "output", "missing"); gcerrors.Code(err) != gcerrors.NotFound {
+				t.Errorf("got error %v want NotFound", err)
+			}
+			if _, err := b.Move(ctx, "missing", "missing"); gcerrors.Code(err) != gcerrors.NotFound {
+				t.Errorf("to itself: got error %v want NotFound", err)
+			}
+		})
+	}
+}
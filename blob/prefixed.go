@@ -0,0 +1,222 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blob
+
+import (
+	"context"
+	"strings"
+
+	"gocloud.dev/blob/driver"
+	"gocloud.dev/gcerrors"
+	"gocloud.dev/internal/gcerr"
+)
+
+// PrefixedBucket returns a *Bucket that provides a view of the blobs in b
+// whose keys start with prefix. Keys passed to and returned from the
+// returned bucket are relative to prefix; for example, with prefix "a/",
+// the key "b.txt" refers to the blob "a/b.txt" in b. Blobs outside of prefix
+// can't be accessed through the returned bucket.
+//
+// Closing the returned bucket doesn't close b.
+func PrefixedBucket(b *Bucket, prefix string) *Bucket {
+	return newPrefixedBucket(b, prefix, false)
+}
+
+func newPrefixedBucket(b *Bucket, prefix string, owned bool) *Bucket {
+	pb := NewBucket(&prefixedBucket{base: b.b, prefix: prefix, owned: owned})
+	// Report metrics for the underlying provider.
+	pb.tracer.Provider = b.tracer.Provider
+	return pb
+}
+
+// prefixedBucket implements driver.Bucket by prepending prefix to the keys
+// of another driver.Bucket.
+//
+// prefixedBucket doesn't implement driver.Expirer, since base's
+// PurgeExpired would purge blobs outside of prefix. So base doesn't enforce
+// expiration times, which are recorded in metadata instead, and
+// Bucket.PurgeExpired lists the blobs under prefix to find the expired ones.
+type prefixedBucket struct {
+	base   driver.Bucket
+	prefix string
+	// owned is true if base should be closed with the bucket; this is the
+	// case when it was opened by URLMux.
+	owned bool
+}
+
+// ErrorCode implements driver.ErrorCode.
+func (b *prefixedBucket) ErrorCode(err error) gcerrors.ErrorCode {
+	if e, ok := err.(*gcerr.Error); ok {
+		return e.Code
+	}
+	return b.base.ErrorCode(err)
+}
+
+// As implements driver.As.
+func (b *prefixedBucket) As(i interface{}) bool { return b.base.As(i) }
+
+// ErrorAs implements driver.ErrorAs.
+func (b *prefixedBucket) ErrorAs(err error, i interface{}) bool {
+	return b.base.ErrorAs(err, i)
+}
+
+// Attributes implements driver.Attributes.
+func (b *prefixedBucket) Attributes(ctx context.Context, key string) (*driver.Attributes, error) {
+	return b.base.Attributes(ctx, b.prefix+key)
+}
+
+// ListPaged implements driver.ListPaged.
+func (b *prefixedBucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driver.ListPage, error) {
+	myopts := *opts
+	myopts.Prefix = b.prefix + opts.Prefix
+	if opts.StartAfter != "" {
+		myopts.StartAfter = b.prefix + opts.StartAfter
+	}
+	// The patterns apply to keys relative to prefix; the portable type
+	// applies them to the results.
+	myopts.Match = ""
+	myopts.MatchRegexp = nil
+	page, err := b.base.ListPaged(ctx, &myopts)
+	if err != nil {
+		return nil, err
+	}
+	for _, obj := range page.Objects {
+		obj.Key = strings.TrimPrefix(obj.Key, b.prefix)
+	}
+	return page, nil
+}
+
+// NewRangeReader implements driver.NewRangeReader.
+func (b *prefixedBucket) NewRangeReader(ctx context.Context, key string, offset, length int64, opts *driver.ReaderOptions) (driver.Reader, error) {
+	return b.base.NewRangeReader(ctx, b.prefix+key, offset, length, opts)
+}
+
+// NewTypedWriter implements driver.NewTypedWriter.
+func (b *prefixedBucket) NewTypedWriter(ctx context.Context, key, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
+	return b.base.NewTypedWriter(ctx, b.prefix+key, contentType, opts)
+}
+
+// Copy implements driver.Copy.
+func (b *prefixedBucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.CopyOptions) error {
+	return b.base.Copy(ctx, b.prefix+dstKey, b.prefix+srcKey, opts)
+}
+
+// Delete implements driver.Delete.
+func (b *prefixedBucket) Delete(ctx context.Context, key string, opts *driver.DeleteOptions) error {
+	return b.base.Delete(ctx, b.prefix+key, opts)
+}
+
+// SignedURL implements driver.SignedURL.
+func (b *prefixedBucket) SignedURL(ctx context.Context, key string, opts *driver.SignedURLOptions) (string, error) {
+	return b.base.SignedURL(ctx, b.prefix+key, opts)
+}
+
+// Close implements driver.Close.
+func (b *prefixedBucket) Close() error {
+	if !b.owned {
+		return nil
+	}
+	return b.base.Close()
+}
+
+// SetAttributes implements driver.AttributesSetter, using base's
+// SetAttributes if it supports it.
+func (b *prefixedBucket) SetAttributes(ctx context.Context, key string, attrs *driver.WritableAttributes) error {
+	as, ok := b.base.(driver.AttributesSetter)
+	if !ok {
+		return b.base.Copy(ctx, b.prefix+key, b.prefix+key, &driver.CopyOptions{Attributes: attrs})
+	}
+	return as.SetAttributes(ctx, b.prefix+key, attrs)
+}
+
+// Move implements driver.Mover, using base's Move if it supports it.
+func (b *prefixedBucket) Move(ctx context.Context, dstKey, srcKey string) error {
+	m, ok := b.base.(driver.Mover)
+	if !ok {
+		return errMoveNotSupported
+	}
+	return m.Move(ctx, b.prefix+dstKey, b.prefix+srcKey)
+}
+
+// ListVersions implements driver.Versioner if base does.
+func (b *prefixedBucket) ListVersions(ctx context.Context, key string) ([]*driver.VersionInfo, error) {
+	v, ok := b.base.(driver.Versioner)
+	if !ok {
+		return nil, errVersioningNotSupported
+	}
+	return v.ListVersions(ctx, b.prefix+key)
+}
+
+// NewVersionRangeReader implements driver.Versioner if base does.
+func (b *prefixedBucket) NewVersionRangeReader(ctx context.Context, key, versionID string, offset, length int64, opts *driver.ReaderOptions) (driver.Reader, error) {
+	v, ok := b.base.(driver.Versioner)
+	if !ok {
+		return nil, errVersioningNotSupported
+	}
+	return v.NewVersionRangeReader(ctx, b.prefix+key, versionID, offset, length, opts)
+}
+
+// DeleteVersion implements driver.Versioner if base does.
+func (b *prefixedBucket) DeleteVersion(ctx context.Context, key, versionID string) error {
+	v, ok := b.base.(driver.Versioner)
+	if !ok {
+		return errVersioningNotSupported
+	}
+	return v.DeleteVersion(ctx, b.prefix+key, versionID)
+}
+
+// DeleteMany implements driver.BatchDeleter, using base's batch deletes if
+// it supports them.
+func (b *prefixedBucket) DeleteMany(ctx context.Context, keys []string) driver.DeleteManyError {
+	bd, ok := b.base.(driver.BatchDeleter)
+	if !ok {
+		return deleteManyConcurrently(ctx, b, keys)
+	}
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = b.prefix + key
+	}
+	return bd.DeleteMany(ctx, prefixed)
+}
+
+// Watch implements driver.Watcher if base does.
+func (b *prefixedBucket) Watch(ctx context.Context, prefix string) (driver.WatchIterator, error) {
+	w, ok := b.base.(driver.Watcher)
+	if !ok {
+		return nil, errWatchNotSupported
+	}
+	it, err := w.Watch(ctx, b.prefix+prefix)
+	if err != nil {
+		return nil, err
+	}
+	return &prefixedWatchIterator{WatchIterator: it, prefix: b.prefix}, nil
+}
+
+// prefixedWatchIterator trims prefix from the keys reported by another
+// driver.WatchIterator.
+type prefixedWatchIterator struct {
+	driver.WatchIterator
+	prefix string
+}
+
+// Next implements driver.WatchIterator.
+func (i *prefixedWatchIterator) Next(ctx context.Context) (*driver.WatchEvent, error) {
+	e, err := i.WatchIterator.Next(ctx)
+	if err != nil {
+		return nil, err
+	}
+	e.Key = strings.TrimPrefix(e.Key, i.prefix)
+	return e, nil
+}
@@ -0,0 +1,151 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blob_test
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/memblob"
+	"gocloud.dev/gcerrors"
+)
+
+// listKeys returns the keys of the blobs and directories in b under prefix.
+func listKeys(t *testing.T, b *blob.Bucket, opts *blob.ListOptions) []string {
+	t.Helper()
+	var keys []string
+	iter := b.List(opts)
+	for {
+		obj, err := iter.Next(context.Background())
+		if err == io.EOF {
+			return keys
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, obj.Key)
+	}
+}
+
+func TestPrefixedBucket(t *testing.T) {
+	ctx := context.Background()
+	base := memblob.OpenBucket(nil)
+	defer base.Close()
+	for _, key := range []string{"a/1", "a/2", "a/sub/3", "ab", "b/1"} {
+		if err := base.WriteAll(ctx, key, []byte(key), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b := blob.PrefixedBucket(base, "a/")
+	defer b.Close()
+
+	// Keys are relative to the prefix.
+	got, err := b.ReadAll(ctx, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "a/1" {
+		t.Errorf("got %q want %q", got, "a/1")
+	}
+	if _, err := b.Attributes(ctx, "b/1"); gcerrors.Code(err) != gcerrors.NotFound {
+		t.Errorf("got %v reading a blob outside the prefix, want NotFound error", err)
+	}
+
+	if diff := cmp.Diff(listKeys(t, b, nil), []string{"1", "2", "sub/3"}); diff != "" {
+		t.Errorf("List: %s", diff)
+	}
+	if diff := cmp.Diff(listKeys(t, b, &blob.ListOptions{Delimiter: "/"}), []string{"1", "2", "sub/"}); diff != "" {
+		t.Errorf("List with delimiter: %s", diff)
+	}
+	if diff := cmp.Diff(listKeys(t, b, &blob.ListOptions{Prefix: "s"}), []string{"sub/3"}); diff != "" {
+		t.Errorf("List with prefix: %s", diff)
+	}
+
+	if err := b.WriteAll(ctx, "new", []byte("new"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Copy(ctx, "copy", "new", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Delete(ctx, "1"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a/2", "a/copy", "a/new", "a/sub/3", "ab", "b/1"}
+	if diff := cmp.Diff(listKeys(t, base, nil), want); diff != "" {
+		t.Errorf("base bucket after changes: %s", diff)
+	}
+
+	// Nested prefixes are combined.
+	nested := blob.PrefixedBucket(b, "sub/")
+	defer nested.Close()
+	if diff := cmp.Diff(listKeys(t, nested, nil), []string{"3"}); diff != "" {
+		t.Errorf("nested List: %s", diff)
+	}
+
+	// Closing the prefixed bucket doesn't close the base bucket.
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := base.Attributes(ctx, "ab"); err != nil {
+		t.Errorf("base bucket is no longer usable: %v", err)
+	}
+}
+
+func TestPrefixedBucketURL(t *testing.T) {
+	ctx := context.Background()
+	b, err := blob.OpenBucket(ctx, "mem://?prefix=a/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if err := b.WriteAll(ctx, "key", []byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(listKeys(t, b, nil), []string{"key"}); diff != "" {
+		t.Error(diff)
+	}
+	if _, err := blob.OpenBucket(ctx, "mem://?prefix=a/&param=value"); err == nil {
+		t.Error("got nil error for invalid parameter")
+	}
+}
+
+func TestPrefixedBucketWatch(t *testing.T) {
+	ctx := context.Background()
+	base := memblob.OpenBucket(nil)
+	defer base.Close()
+	b := blob.PrefixedBucket(base, "a/")
+	defer b.Close()
+
+	iter, err := b.Watch(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iter.Close()
+	for _, key := range []string{"b/1", "a/1"} {
+		if err := base.WriteAll(ctx, key, []byte(key), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e, err := iter.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (blob.WatchEvent{Key: "1", Type: blob.WatchCreated}); *e != want {
+		t.Errorf("got %+v want %+v", *e, want)
+	}
+}
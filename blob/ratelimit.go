@@ -0,0 +1,105 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blob
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// maxRateLimitChunk is the largest number of bytes that a rate-limited Reader
+// or Writer transfers between waits.
+const maxRateLimitChunk = 32 * 1024
+
+// A RateLimiter limits the combined rate at which the Readers and Writers it
+// is passed to transfer bytes from and to the provider. It is safe for
+// concurrent use, so a single RateLimiter can cap the bandwidth used by a
+// whole process.
+//
+// Bytes are transferred in chunks of up to 32 KiB, or a tenth of a second's
+// worth if that is smaller, after which the transfer waits until the rate
+// is respected. Providers that buffer data, or read ahead, may briefly
+// exceed the rate.
+type RateLimiter struct {
+	rate  float64 // bytes per second
+	chunk int
+
+	mu sync.Mutex
+	// next is when the bytes transferred so far will have been transferred
+	// at the limited rate.
+	next time.Time
+}
+
+// NewRateLimiter returns a RateLimiter that allows bytesPerSecond bytes per
+// second, which must be positive.
+func NewRateLimiter(bytesPerSecond int64) *RateLimiter {
+	if bytesPerSecond <= 0 {
+		panic("blob: NewRateLimiter bytesPerSecond must be positive")
+	}
+	chunk := bytesPerSecond / 10
+	if chunk > maxRateLimitChunk {
+		chunk = maxRateLimitChunk
+	}
+	if chunk < 1 {
+		chunk = 1
+	}
+	return &RateLimiter{rate: float64(bytesPerSecond), chunk: int(chunk)}
+}
+
+// wait accounts for n bytes that were just transferred, and waits until
+// transferring them respects the rate, or ctx is done.
+func (l *RateLimiter) wait(ctx context.Context, n int) error {
+	if n <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	d := l.next.Sub(now)
+	l.mu.Unlock()
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// write writes p using write in chunks, waiting after each one.
+func (l *RateLimiter) write(ctx context.Context, p []byte, write func([]byte) (int, error)) (int, error) {
+	var n int
+	for n < len(p) {
+		chunk := p[n:]
+		if len(chunk) > l.chunk {
+			chunk = chunk[:l.chunk]
+		}
+		m, err := write(chunk)
+		n += m
+		if err != nil {
+			return n, err
+		}
+		if err := l.wait(ctx, m); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,136 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blob_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/memblob"
+)
+
+func TestProgress(t *testing.T) {
+	ctx := context.Background()
+	b := memblob.OpenBucket(nil)
+	defer b.Close()
+
+	var written int64
+	w, err := b.NewWriter(ctx, "key", &blob.WriterOptions{
+		Progress: func(n int64) { written += n },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := bytes.Repeat([]byte("some text\n"), 1000)
+	for p := content; len(p) > 0; p = p[100:] {
+		if _, err := w.Write(p[:100]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if written != int64(len(content)) {
+		t.Errorf("Writer reported %d bytes written, want %d", written, len(content))
+	}
+	// The content type is still detected.
+	attrs, err := b.Attributes(ctx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "text/plain; charset=utf-8"; attrs.ContentType != want {
+		t.Errorf("got ContentType %q want %q", attrs.ContentType, want)
+	}
+
+	var read int64
+	r, err := b.NewReader(ctx, "key", &blob.ReaderOptions{
+		Progress: func(n int64) { read += n },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := ioutil.ReadAll(r); err != nil {
+		t.Fatal(err)
+	}
+	if read != int64(len(content)) {
+		t.Errorf("Reader reported %d bytes read, want %d", read, len(content))
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	ctx := context.Background()
+	b := memblob.OpenBucket(nil)
+	defer b.Close()
+
+	const rate = 1 << 20
+	content := testContent(100 * 1024)
+	wantMin := 2 * time.Duration(len(content)) * time.Second / rate * 3 / 4
+
+	// Two concurrent writers share the limit.
+	l := blob.NewRateLimiter(rate)
+	start := time.Now()
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = b.WriteAll(ctx, fmt.Sprintf("key%d", i), content, &blob.WriterOptions{RateLimiter: l})
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d < wantMin {
+		t.Errorf("writes took %v, want at least %v", d, wantMin)
+	}
+
+	start = time.Now()
+	for i := 0; i < 2; i++ {
+		r, err := b.NewReader(ctx, fmt.Sprintf("key%d", i), &blob.ReaderOptions{RateLimiter: l})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Errorf("key%d: got %d bytes, want %d", i, len(got), len(content))
+		}
+	}
+	if d := time.Since(start); d < wantMin {
+		t.Errorf("reads took %v, want at least %v", d, wantMin)
+	}
+
+	// Waiting for the limiter stops when the context is done.
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	err := b.WriteAll(ctx, "slow", content, &blob.WriterOptions{RateLimiter: blob.NewRateLimiter(1)})
+	if err == nil {
+		t.Error("got nil error writing with a canceled context")
+	}
+}
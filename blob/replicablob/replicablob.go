@@ -0,0 +1,716 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package replicablob provides a blob implementation that keeps the same
+// blobs in several *blob.Buckets, called replicas; for example, buckets in
+// different regions or clouds. Use OpenBucket to construct a *blob.Bucket.
+//
+// Writes, copies, attribute changes and deletes are sent to all the replicas
+// concurrently, and succeed if they succeed on at least Options.WriteQuorum
+// of them. Replicas where they failed are left out of date; they are not
+// rolled back or repaired, but Options.OnPartialFailure is called so that
+// the failures can be recorded or retried. When fewer than WriteQuorum
+// replicas succeed, the replicas where the operation succeeded keep its
+// effects.
+//
+// Reads, attribute lookups and listings use the first replica, in the order
+// they were passed to OpenBucket, that returns a result other than an
+// error, so they keep working while some replicas are unavailable, or don't
+// have a blob yet. Since a listing's page tokens only make sense to the
+// replica that returned them, later pages are requested from the same
+// replica, without falling back.
+//
+// ETags are those of the replica that returned them, so preconditions on
+// writes, copies and deletes are checked by the first replica only: the
+// operation is sent to the others, without the preconditions, once it has
+// succeeded there.
+//
+// Errors
+//
+// When an operation fails on all replicas, or on too many of them to reach
+// the write quorum, the returned error wraps an *Error, which holds the error
+// from each replica; use blob.Bucket.ErrorAs to get it. Its error code is the
+// code that the replicas' errors have in common; if they differ, it is the
+// first code that isn't NotFound. blob.Bucket.ErrorAs is also forwarded to
+// the replicas, for their errors.
+//
+// URLs
+//
+// For blob.OpenBucket, replicablob registers as a wrapper named "replica";
+// see blob.BucketURLWrapper. For example,
+// "replica+s3://mybucket?replica=gs%3A%2F%2Fmybucket" opens a bucket that
+// replicates blobs in an S3 bucket and a GCS bucket. To customize the URL
+// opener, or for more details on the URL format, see URLOpener.
+// See https://godoc.org/gocloud.dev#hdr-URLs for background information.
+//
+// As
+//
+// replicablob does not support any types for As, except that the As
+// functions of attributes and list results are forwarded to the replica's
+// that returned them.
+package replicablob // import "gocloud.dev/blob/replicablob"
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/driver"
+	"gocloud.dev/gcerrors"
+	"gocloud.dev/internal/gcerr"
+)
+
+func init() {
+	blob.DefaultURLMux().RegisterBucketWrapper(Scheme, &URLOpener{})
+}
+
+// Scheme is the name replicablob registers its URLOpener under on
+// blob.DefaultMux, as a blob.BucketURLWrapper.
+const Scheme = "replica"
+
+// URLOpener opens URLs like "replica+s3://mybucket?replica=gs%3A%2F%2Fb".
+// The bucket opened from the URL is the first replica.
+//
+// The following query parameters are supported, and removed from the URL
+// passed to the first replica's opener:
+//
+//   - replica: the URL of another replica, opened with the same blob.URLMux.
+//     It must be query-escaped. It can be repeated, and is required at least
+//     once.
+//   - quorum: overrides Options.WriteQuorum.
+//
+// The replicas are closed when the returned bucket is closed.
+type URLOpener struct {
+	// Options specifies the default options to pass to OpenBucket.
+	Options Options
+}
+
+// WrapBucketURL implements blob.BucketURLWrapper.
+func (o *URLOpener) WrapBucketURL(ctx context.Context, u *url.URL, openInner func(context.Context, *url.URL) (*blob.Bucket, error)) (*blob.Bucket, error) {
+	opts := o.Options
+	q := u.Query()
+	replicaURLs := q["replica"]
+	if len(replicaURLs) == 0 {
+		return nil, fmt.Errorf("open bucket %v: query parameter \"replica\" is required", u)
+	}
+	if s := q.Get("quorum"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 || n > len(replicaURLs)+1 {
+			return nil, fmt.Errorf("open bucket %v: invalid quorum %q", u, s)
+		}
+		opts.WriteQuorum = n
+	}
+	for _, param := range []string{"replica", "quorum"} {
+		q.Del(param)
+	}
+	firstURL := *u
+	firstURL.RawQuery = q.Encode()
+	urls := []*url.URL{&firstURL}
+	for _, s := range replicaURLs {
+		ru, err := url.Parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("open bucket %v: invalid replica URL: %v", u, err)
+		}
+		urls = append(urls, ru)
+	}
+	var replicas []*blob.Bucket
+	for _, ru := range urls {
+		r, err := openInner(ctx, ru)
+		if err != nil {
+			for _, r := range replicas {
+				r.Close()
+			}
+			return nil, err
+		}
+		replicas = append(replicas, r)
+	}
+	b := openBucket(replicas, &opts)
+	b.owned = true
+	return blob.NewBucket(b), nil
+}
+
+// Options sets options for constructing a *blob.Bucket backed by replicablob.
+type Options struct {
+	// WriteQuorum is the number of replicas that modifications must succeed
+	// on. If 0, or greater than the number of replicas, they must succeed on
+	// all replicas.
+	WriteQuorum int
+
+	// OnPartialFailure, if not nil, is called when a modification succeeds,
+	// but fails on some replicas. err holds the errors.
+	OnPartialFailure func(err *Error)
+}
+
+// Error describes the failure of an operation on some of the replicas.
+type Error struct {
+	// Op is the name of the failed operation, like "Write" or "Delete".
+	Op string
+	// Key is the key of the blob, or the prefix of the listing.
+	Key string
+	// Errs holds the error from each replica, in the order they were passed
+	// to OpenBucket. It is nil for the replicas where the operation
+	// succeeded, or wasn't attempted.
+	Errs []error
+}
+
+func (e *Error) Error() string {
+	var msgs []string
+	for i, err := range e.Errs {
+		if err != nil {
+			msgs = append(msgs, fmt.Sprintf("replica %d: %v", i, err))
+		}
+	}
+	return fmt.Sprintf("replicablob: %s %q failed on %d of %d replicas: %s", e.Op, e.Key, len(msgs), len(e.Errs), strings.Join(msgs, "; "))
+}
+
+// code returns the error code for e; see the package documentation.
+func (e *Error) code() gcerrors.ErrorCode {
+	code, mixed, n := gcerrors.Unknown, false, 0
+	for _, err := range e.Errs {
+		if err == nil {
+			continue
+		}
+		if c := gcerrors.Code(err); n == 0 {
+			code = c
+		} else if c != code {
+			mixed = true
+		}
+		n++
+	}
+	if !mixed {
+		return code
+	}
+	for _, err := range e.Errs {
+		if err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+			return gcerrors.Code(err)
+		}
+	}
+	return code
+}
+
+type bucket struct {
+	replicas []*blob.Bucket
+	opts     *Options
+	quorum   int
+	// owned is true if the replicas should be closed with the bucket.
+	owned bool
+}
+
+// OpenBucket creates a *blob.Bucket that keeps the same blobs in each of
+// replicas, which must not be empty.
+// Closing the returned bucket doesn't close the replicas.
+func OpenBucket(replicas []*blob.Bucket, opts *Options) *blob.Bucket {
+	return blob.NewBucket(openBucket(replicas, opts))
+}
+
+func openBucket(replicas []*blob.Bucket, opts *Options) *bucket {
+	if len(replicas) == 0 {
+		panic("replicablob: no replicas")
+	}
+	if opts == nil {
+		opts = &Options{}
+	}
+	quorum := opts.WriteQuorum
+	if quorum <= 0 || quorum > len(replicas) {
+		quorum = len(replicas)
+	}
+	return &bucket{replicas: replicas, opts: opts, quorum: quorum}
+}
+
+// Close implements driver.Close.
+func (b *bucket) Close() error {
+	if !b.owned {
+		return nil
+	}
+	var err error
+	for _, r := range b.replicas {
+		if rerr := r.Close(); err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
+
+// ErrorCode implements driver.ErrorCode.
+func (b *bucket) ErrorCode(err error) gcerrors.ErrorCode {
+	if e, ok := err.(*Error); ok {
+		return e.code()
+	}
+	return gcerrors.Code(err)
+}
+
+// As implements driver.As.
+func (b *bucket) As(i interface{}) bool { return false }
+
+// ErrorAs implements driver.ErrorAs.
+func (b *bucket) ErrorAs(err error, i interface{}) bool {
+	e, ok := err.(*Error)
+	if !ok {
+		for _, r := range b.replicas {
+			if r.ErrorAs(err, i) {
+				return true
+			}
+		}
+		return false
+	}
+	if p, ok := i.(**Error); ok {
+		*p = e
+		return true
+	}
+	for j, rerr := range e.Errs {
+		if rerr != nil && b.replicas[j].ErrorAs(rerr, i) {
+			return true
+		}
+	}
+	return false
+}
+
+// read calls f with each replica in turn, until it succeeds. It returns the
+// index of the replica that succeeded. If f fails with FailedPrecondition,
+// the error is returned as is; other replicas would compare preconditions
+// with ETags of their own.
+func (b *bucket) read(op, key string, f func(r *blob.Bucket) error) (int, error) {
+	errs := make([]error, len(b.replicas))
+	for i, r := range b.replicas {
+		err := f(r)
+		if err == nil {
+			return i, nil
+		}
+		if gcerrors.Code(err) == gcerrors.FailedPrecondition {
+			return i, err
+		}
+		errs[i] = err
+	}
+	return 0, &Error{Op: op, Key: key, Errs: errs}
+}
+
+// result returns the result of a modification that failed with errs on
+// each replica; see the package documentation.
+func (b *bucket) result(op, key string, errs []error) error {
+	failed := 0
+	for _, err := range errs {
+		if err != nil {
+			failed++
+		}
+	}
+	if failed == 0 {
+		return nil
+	}
+	e := &Error{Op: op, Key: key, Errs: errs}
+	if len(errs)-failed < b.quorum {
+		return e
+	}
+	if b.opts.OnPartialFailure != nil {
+		b.opts.OnPartialFailure(e)
+	}
+	return nil
+}
+
+// modify calls f with each replica and its index concurrently, and returns
+// the result. If checkFirst is true, f is called with the first replica
+// before the others, which are skipped if it fails.
+func (b *bucket) modify(op, key string, checkFirst bool, f func(i int, r *blob.Bucket) error) error {
+	errs := make([]error, len(b.replicas))
+	start := 0
+	if checkFirst {
+		if err := f(0, b.replicas[0]); err != nil {
+			errs[0] = err
+			return &Error{Op: op, Key: key, Errs: errs}
+		}
+		start = 1
+	}
+	var wg sync.WaitGroup
+	for i := start; i < len(b.replicas); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = f(i, b.replicas[i])
+		}(i)
+	}
+	wg.Wait()
+	return b.result(op, key, errs)
+}
+
+// preconditions returns the ETag preconditions to send to replica i. Only the
+// first replica checks them, since ETags from other replicas wouldn't match;
+// modify and writer.Close only modify the others once it has succeeded.
+func preconditions(i int, ifMatch, ifNoneMatch string) (string, string) {
+	if i > 0 {
+		return "", ""
+	}
+	return ifMatch, ifNoneMatch
+}
+
+// ListPaged implements driver.ListPaged. Page tokens are those of the
+// replica that returned the first page, prefixed by its index.
+func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driver.ListPage, error) {
+	lopts := &blob.ListOptions{
+		Prefix:      opts.Prefix,
+		Delimiter:   opts.Delimiter,
+		StartAfter:  opts.StartAfter,
+		Match:       opts.Match,
+		MatchRegexp: opts.MatchRegexp,
+		MinModTime:  opts.MinModTime,
+		MaxModTime:  opts.MaxModTime,
+		MinSize:     opts.MinSize,
+		MaxSize:     opts.MaxSize,
+		BeforeList:  opts.BeforeList,
+	}
+	var objs []*blob.ListObject
+	var next []byte
+	list := func(r *blob.Bucket, pageToken []byte) error {
+		var err error
+		objs, next, err = r.ListPage(ctx, pageToken, opts.PageSize, lopts)
+		return err
+	}
+	var i int
+	if len(opts.PageToken) == 0 {
+		var err error
+		i, err = b.read("List", opts.Prefix, func(r *blob.Bucket) error {
+			return list(r, blob.FirstPageToken)
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		i = int(opts.PageToken[0])
+		if i >= len(b.replicas) {
+			return nil, fmt.Errorf("replicablob: invalid page token %q", opts.PageToken)
+		}
+		if err := list(b.replicas[i], opts.PageToken[1:]); err != nil {
+			return nil, err
+		}
+	}
+	page := &driver.ListPage{}
+	if next != nil {
+		page.NextPageToken = append([]byte{byte(i)}, next...)
+	}
+	for _, obj := range objs {
+		page.Objects = append(page.Objects, &driver.ListObject{
+			Key:     obj.Key,
+			ModTime: obj.ModTime,
+			Size:    obj.Size,
+			MD5:     obj.MD5,
+			CRC32C:  obj.CRC32C,
+			SHA256:  obj.SHA256,
+			ETag:    obj.ETag,
+			IsDir:   obj.IsDir,
+			AsFunc:  obj.As,
+		})
+	}
+	return page, nil
+}
+
+// Attributes implements driver.Attributes.
+func (b *bucket) Attributes(ctx context.Context, key string) (*driver.Attributes, error) {
+	var attrs *blob.Attributes
+	_, err := b.read("Attributes", key, func(r *blob.Bucket) error {
+		var err error
+		attrs, err = r.Attributes(ctx, key)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &driver.Attributes{
+		CacheControl:       attrs.CacheControl,
+		ContentDisposition: attrs.ContentDisposition,
+		ContentEncoding:    attrs.ContentEncoding,
+		ContentLanguage:    attrs.ContentLanguage,
+		ContentType:        attrs.ContentType,
+		Metadata:           attrs.Metadata,
+		ModTime:            attrs.ModTime,
+		Size:               attrs.Size,
+		MD5:                attrs.MD5,
+		CRC32C:             attrs.CRC32C,
+		SHA256:             attrs.SHA256,
+		ETag:               attrs.ETag,
+		ExpiresAt:          attrs.ExpiresAt,
+		AsFunc:             attrs.As,
+	}, nil
+}
+
+// NewRangeReader implements driver.NewRangeReader.
+func (b *bucket) NewRangeReader(ctx context.Context, key string, offset, length int64, opts *driver.ReaderOptions) (driver.Reader, error) {
+	var br *blob.Reader
+	_, err := b.read("NewRangeReader", key, func(r *blob.Bucket) error {
+		var err error
+		br, err = r.NewRangeReader(ctx, key, offset, length, &blob.ReaderOptions{
+			IfMatch:     opts.IfMatch,
+			IfNoneMatch: opts.IfNoneMatch,
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &reader{
+		r: br,
+		attrs: driver.ReaderAttributes{
+			ContentType:     br.ContentType(),
+			ModTime:         br.ModTime(),
+			Size:            br.Size(),
+			ContentEncoding: br.ContentEncoding(),
+			ETag:            br.ETag(),
+		},
+	}, nil
+}
+
+// reader reads a blob from one of the replicas.
+type reader struct {
+	r     *blob.Reader
+	attrs driver.ReaderAttributes
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	return r.r.Read(p)
+}
+
+func (r *reader) Close() error {
+	return r.r.Close()
+}
+
+func (r *reader) Attributes() *driver.ReaderAttributes {
+	return &r.attrs
+}
+
+func (r *reader) As(i interface{}) bool { return false }
+
+// NewTypedWriter implements driver.NewTypedWriter.
+func (b *bucket) NewTypedWriter(ctx context.Context, key string, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
+	w := &writer{
+		b:          b,
+		key:        key,
+		checkFirst: opts.IfMatch != "" || opts.IfNoneMatch != "",
+		ws:         make([]*blob.Writer, len(b.replicas)),
+		cancels:    make([]func(), len(b.replicas)),
+		errs:       make([]error, len(b.replicas)),
+	}
+	for i, r := range b.replicas {
+		// Create a cancelable context so we can abort the write to a replica
+		// on its own.
+		rctx, cancel := context.WithCancel(ctx)
+		ifMatch, ifNoneMatch := preconditions(i, opts.IfMatch, opts.IfNoneMatch)
+		bw, err := r.NewWriter(rctx, key, &blob.WriterOptions{
+			BufferSize:         opts.BufferSize,
+			MaxConcurrency:     opts.MaxConcurrency,
+			CacheControl:       opts.CacheControl,
+			ContentDisposition: opts.ContentDisposition,
+			ContentEncoding:    opts.ContentEncoding,
+			ContentLanguage:    opts.ContentLanguage,
+			ContentType:        contentType,
+			ContentMD5:         opts.ContentMD5,
+			ContentCRC32C:      opts.ContentCRC32C,
+			ContentSHA256:      opts.ContentSHA256,
+			Metadata:           opts.Metadata,
+			IfMatch:            ifMatch,
+			IfNoneMatch:        ifNoneMatch,
+			BeforeWrite:        opts.BeforeWrite,
+		})
+		if err != nil {
+			cancel()
+			w.errs[i] = err
+			continue
+		}
+		w.ws[i], w.cancels[i] = bw, cancel
+	}
+	if err := w.check(); err != nil {
+		w.abort()
+		return nil, err
+	}
+	return w, nil
+}
+
+// writer writes a blob to each replica.
+type writer struct {
+	b          *bucket
+	key        string
+	checkFirst bool
+	ws         []*blob.Writer // nil for replicas that failed
+	cancels    []func()
+	errs       []error
+}
+
+// check returns an error if the write can no longer succeed.
+func (w *writer) check() error {
+	failed := 0
+	for _, err := range w.errs {
+		if err != nil {
+			failed++
+		}
+	}
+	if len(w.errs)-failed < w.b.quorum || (w.checkFirst && w.errs[0] != nil) {
+		return &Error{Op: "Write", Key: w.key, Errs: w.errs}
+	}
+	return nil
+}
+
+// fail aborts the write to replica i, which failed with err.
+func (w *writer) fail(i int, err error) {
+	w.errs[i] = err
+	w.cancels[i]()
+	_ = w.ws[i].Close()
+	w.ws[i] = nil
+}
+
+// abort aborts the writes to all replicas.
+func (w *writer) abort() {
+	for i, bw := range w.ws {
+		if bw != nil {
+			w.cancels[i]()
+			_ = bw.Close()
+			w.ws[i] = nil
+		}
+	}
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	for i, bw := range w.ws {
+		if bw == nil {
+			continue
+		}
+		if _, err := bw.Write(p); err != nil {
+			w.fail(i, err)
+		}
+	}
+	if err := w.check(); err != nil {
+		w.abort()
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *writer) Close() error {
+	start := 0
+	if w.checkFirst {
+		// Preconditions are checked by the first replica.
+		if w.ws[0] != nil {
+			w.errs[0] = w.ws[0].Close()
+			w.cancels[0]()
+			w.ws[0] = nil
+		}
+		if err := w.check(); err != nil {
+			w.abort()
+			return err
+		}
+		start = 1
+	}
+	var wg sync.WaitGroup
+	for i := start; i < len(w.ws); i++ {
+		if w.ws[i] == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w.errs[i] = w.ws[i].Close()
+			w.cancels[i]()
+		}(i)
+	}
+	wg.Wait()
+	return w.b.result("Write", w.key, w.errs)
+}
+
+// Copy implements driver.Copy.
+func (b *bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.CopyOptions) error {
+	checkFirst := opts.IfMatch != "" || opts.IfNoneMatch != ""
+	return b.modify("Copy", dstKey, checkFirst, func(i int, r *blob.Bucket) error {
+		ifMatch, ifNoneMatch := preconditions(i, opts.IfMatch, opts.IfNoneMatch)
+		return r.Copy(ctx, dstKey, srcKey, &blob.CopyOptions{
+			IfMatch:     ifMatch,
+			IfNoneMatch: ifNoneMatch,
+			Attributes:  attributesUpdate(opts.Attributes),
+			BeforeCopy:  opts.BeforeCopy,
+		})
+	})
+}
+
+// SetAttributes implements driver.AttributesSetter.
+func (b *bucket) SetAttributes(ctx context.Context, key string, attrs *driver.WritableAttributes) error {
+	return b.modify("SetAttributes", key, false, func(_ int, r *blob.Bucket) error {
+		return r.SetAttributes(ctx, key, *attributesUpdate(attrs))
+	})
+}
+
+// attributesUpdate returns a blob.AttributesUpdate that replaces the
+// attributes of a blob with attrs.
+func attributesUpdate(attrs *driver.WritableAttributes) *blob.AttributesUpdate {
+	if attrs == nil {
+		return nil
+	}
+	md := attrs.Metadata
+	if md == nil {
+		// A nil Metadata would leave the metadata unchanged.
+		md = map[string]string{}
+	}
+	return &blob.AttributesUpdate{
+		CacheControl:       attrs.CacheControl,
+		ContentDisposition: attrs.ContentDisposition,
+		ContentLanguage:    attrs.ContentLanguage,
+		ContentType:        attrs.ContentType,
+		Metadata:           md,
+	}
+}
+
+// Delete implements driver.Delete. Replicas that don't have the blob count
+// as successes, unless none of the replicas deleted it.
+func (b *bucket) Delete(ctx context.Context, key string, opts *driver.DeleteOptions) error {
+	checkFirst := opts.IfMatch != "" || opts.IfNoneMatch != ""
+	errs := make([]error, len(b.replicas))
+	err := b.modify("Delete", key, checkFirst, func(i int, r *blob.Bucket) error {
+		ifMatch, ifNoneMatch := preconditions(i, opts.IfMatch, opts.IfNoneMatch)
+		errs[i] = r.DeleteWithOptions(ctx, key, &blob.DeleteOptions{
+			IfMatch:     ifMatch,
+			IfNoneMatch: ifNoneMatch,
+		})
+		if gcerrors.Code(errs[i]) == gcerrors.NotFound && (!checkFirst || i > 0) {
+			return nil
+		}
+		return errs[i]
+	})
+	if err != nil {
+		return err
+	}
+	for _, err := range errs {
+		if err == nil {
+			return nil
+		}
+	}
+	return &Error{Op: "Delete", Key: key, Errs: errs}
+}
+
+// SignedURL implements driver.SignedURL. It uses the first replica that
+// supports signing URLs. Only GET URLs are supported, since changes made with
+// PUT or DELETE URLs would only reach that replica.
+func (b *bucket) SignedURL(ctx context.Context, key string, opts *driver.SignedURLOptions) (string, error) {
+	if opts.Method != http.MethodGet {
+		return "", gcerr.Newf(gcerr.Unimplemented, nil, "replicablob: SignedURL only supports the %s method, not %s", http.MethodGet, opts.Method)
+	}
+	var u string
+	_, err := b.read("SignedURL", key, func(r *blob.Bucket) error {
+		var err error
+		u, err = r.SignedURL(ctx, key, &blob.SignedURLOptions{
+			Expiry:      opts.Expiry,
+			Method:      opts.Method,
+			ContentType: opts.ContentType,
+		})
+		return err
+	})
+	return u, err
+}
@@ -0,0 +1,396 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replicablob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/driver"
+	"gocloud.dev/blob/drivertest"
+	"gocloud.dev/blob/fileblob"
+	"gocloud.dev/blob/memblob"
+	"gocloud.dev/gcerrors"
+)
+
+type harness struct {
+	replicas []*blob.Bucket
+}
+
+func newHarness(ctx context.Context, t *testing.T) (drivertest.Harness, error) {
+	return &harness{replicas: []*blob.Bucket{memblob.OpenBucket(nil), memblob.OpenBucket(nil)}}, nil
+}
+
+func (h *harness) HTTPClient() *http.Client {
+	return nil
+}
+
+func (h *harness) MakeDriver(ctx context.Context) (driver.Bucket, error) {
+	return openBucket(h.replicas, nil), nil
+}
+
+func (h *harness) Close() {
+	for _, r := range h.replicas {
+		r.Close()
+	}
+}
+
+func TestConformance(t *testing.T) {
+	drivertest.RunConformanceTests(t, newHarness, nil)
+}
+
+// signingHarness uses a fileblob replica that signs URLs, so that SignedURL
+// is tested, and a memblob replica, whose ETags differ from fileblob's.
+type signingHarness struct {
+	replica *blob.Bucket
+	other   *blob.Bucket
+	dir     string
+	server  *httptest.Server
+}
+
+func newSigningHarness(ctx context.Context, t *testing.T) (drivertest.Harness, error) {
+	dir, err := ioutil.TempDir("", "go-cloud-replicablob")
+	if err != nil {
+		return nil, err
+	}
+	server := httptest.NewUnstartedServer(nil)
+	u, err := url.Parse("http://" + server.Listener.Addr().String())
+	if err != nil {
+		return nil, err
+	}
+	signer := fileblob.NewURLSignerHMAC(u, []byte("secret"))
+	replica, err := fileblob.OpenBucket(dir, &fileblob.Options{URLSigner: signer})
+	if err != nil {
+		return nil, err
+	}
+	server.Config.Handler = fileblob.NewSignedURLHandler(replica, signer)
+	server.Start()
+	return &signingHarness{replica: replica, other: memblob.OpenBucket(nil), dir: dir, server: server}, nil
+}
+
+func (h *signingHarness) HTTPClient() *http.Client {
+	return h.server.Client()
+}
+
+func (h *signingHarness) MakeDriver(ctx context.Context) (driver.Bucket, error) {
+	return openBucket([]*blob.Bucket{h.replica, h.other}, nil), nil
+}
+
+func (h *signingHarness) Close() {
+	h.server.Close()
+	h.replica.Close()
+	h.other.Close()
+	_ = os.RemoveAll(h.dir)
+}
+
+func TestConformanceSigned(t *testing.T) {
+	drivertest.RunConformanceTests(t, newSigningHarness, nil)
+}
+
+// errDown is returned by every operation on a downBucket.
+var errDown = errors.New("replica is down")
+
+// downBucket is a driver.Bucket for a replica that is unavailable.
+type downBucket struct{}
+
+func (downBucket) ErrorCode(error) gcerrors.ErrorCode { return gcerrors.Internal }
+func (downBucket) As(i interface{}) bool              { return false }
+func (downBucket) Close() error                       { return nil }
+
+func (downBucket) ErrorAs(err error, i interface{}) bool {
+	p, ok := i.(*error)
+	if ok {
+		*p = err
+	}
+	return ok
+}
+
+func (downBucket) Attributes(ctx context.Context, key string) (*driver.Attributes, error) {
+	return nil, errDown
+}
+
+func (downBucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driver.ListPage, error) {
+	return nil, errDown
+}
+
+func (downBucket) NewRangeReader(ctx context.Context, key string, offset, length int64, opts *driver.ReaderOptions) (driver.Reader, error) {
+	return nil, errDown
+}
+
+func (downBucket) NewTypedWriter(ctx context.Context, key, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
+	return nil, errDown
+}
+
+func (downBucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.CopyOptions) error {
+	return errDown
+}
+
+func (downBucket) Delete(ctx context.Context, key string, opts *driver.DeleteOptions) error {
+	return errDown
+}
+
+func (downBucket) SignedURL(ctx context.Context, key string, opts *driver.SignedURLOptions) (string, error) {
+	return "", errDown
+}
+
+func listKeys(t *testing.T, b *blob.Bucket) []string {
+	t.Helper()
+	var keys []string
+	iter := b.List(nil)
+	for {
+		obj, err := iter.Next(context.Background())
+		if err == io.EOF {
+			return keys
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, obj.Key)
+	}
+}
+
+func TestWriteQuorum(t *testing.T) {
+	ctx := context.Background()
+	r0, r2 := memblob.OpenBucket(nil), memblob.OpenBucket(nil)
+	defer r0.Close()
+	defer r2.Close()
+	replicas := []*blob.Bucket{r0, blob.NewBucket(downBucket{}), r2}
+
+	var partial []*Error
+	b := OpenBucket(replicas, &Options{
+		WriteQuorum:      2,
+		OnPartialFailure: func(err *Error) { partial = append(partial, err) },
+	})
+	defer b.Close()
+	if err := b.WriteAll(ctx, "key", []byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Copy(ctx, "copy", "key", nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []*blob.Bucket{r0, r2} {
+		if got, err := r.ReadAll(ctx, "copy"); err != nil || string(got) != "hello" {
+			t.Errorf("got %q, %v from a replica, want %q", got, err, "hello")
+		}
+	}
+	if err := b.Delete(ctx, "copy"); err != nil {
+		t.Fatal(err)
+	}
+	if len(partial) != 3 {
+		t.Fatalf("got %d partial failures, want 3", len(partial))
+	}
+	if e := partial[0]; e.Op != "Write" || e.Key != "key" || e.Errs[0] != nil || e.Errs[1] == nil || e.Errs[2] != nil {
+		t.Errorf("got partial failure %v", e)
+	}
+	// Deleting a blob that no replica has fails.
+	if err := b.Delete(ctx, "copy"); gcerrors.Code(err) != gcerrors.Internal {
+		t.Errorf("got error %v want Internal", err)
+	}
+
+	// With a quorum of all replicas, writes fail.
+	b = OpenBucket(replicas, nil)
+	defer b.Close()
+	err := b.WriteAll(ctx, "key2", []byte("hello"), nil)
+	if gcerrors.Code(err) != gcerrors.Internal {
+		t.Fatalf("got error %v want Internal", err)
+	}
+	var e *Error
+	if !b.ErrorAs(err, &e) {
+		t.Fatalf("ErrorAs(%v) failed", err)
+	}
+	if len(e.Errs) != 3 || e.Errs[1] == nil {
+		t.Errorf("got replica errors %v", e.Errs)
+	}
+	// Errors from replicas can be converted with their own ErrorAs.
+	var derr error
+	if !b.ErrorAs(err, &derr) || derr != errDown {
+		t.Errorf("got replica error %v want %v", derr, errDown)
+	}
+}
+
+func TestReadFallback(t *testing.T) {
+	ctx := context.Background()
+	r1, r2 := memblob.OpenBucket(nil), memblob.OpenBucket(nil)
+	defer r1.Close()
+	defer r2.Close()
+	b := OpenBucket([]*blob.Bucket{blob.NewBucket(downBucket{}), r1, r2}, nil)
+	defer b.Close()
+
+	// r1 doesn't have the blob yet.
+	for _, key := range []string{"a", "b"} {
+		if err := r2.WriteAll(ctx, key, []byte(key), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := b.ReadAll(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "a" {
+		t.Errorf("got %q want %q", got, "a")
+	}
+	if _, err := b.Attributes(ctx, "a"); err != nil {
+		t.Error(err)
+	}
+	if _, err := b.Attributes(ctx, "missing"); gcerrors.Code(err) != gcerrors.Internal {
+		t.Errorf("got error %v want Internal", err)
+	}
+	// r1 returns an empty listing.
+	if keys := listKeys(t, b); len(keys) != 0 {
+		t.Errorf("got keys %v want none", keys)
+	}
+
+	// Pages are listed from the replica that returned the first one.
+	b2 := OpenBucket([]*blob.Bucket{blob.NewBucket(downBucket{}), r2, r1}, nil)
+	defer b2.Close()
+	objs, next, err := b2.ListPage(ctx, blob.FirstPageToken, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != 1 || objs[0].Key != "a" {
+		t.Fatalf("got first page %v", objs)
+	}
+	objs, _, err = b2.ListPage(ctx, next, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != 1 || objs[0].Key != "b" {
+		t.Errorf("got second page %v", objs)
+	}
+
+	// Preconditions are checked by the first replica, so writes with
+	// preconditions fail while it is down.
+	b3 := OpenBucket([]*blob.Bucket{blob.NewBucket(downBucket{}), r1, r2}, &Options{WriteQuorum: 1})
+	defer b3.Close()
+	if err := b3.WriteAll(ctx, "c", []byte("c"), &blob.WriterOptions{IfNoneMatch: "*"}); gcerrors.Code(err) != gcerrors.Internal {
+		t.Errorf("got error %v want Internal", err)
+	}
+	if exists, _ := r1.Exists(ctx, "c"); exists {
+		t.Error("write with preconditions went to other replicas")
+	}
+}
+
+func TestOpenBucketFromURL(t *testing.T) {
+	ctx := context.Background()
+	for _, test := range []struct {
+		url     string
+		wantErr bool
+	}{
+		{"replica+mem://?replica=mem%3A%2F%2F", false},
+		{"replica+mem://?replica=mem%3A%2F%2F&replica=mem%3A%2F%2F&quorum=2", false},
+		// No other replicas.
+		{"replica+mem://", true},
+		// Invalid quorum.
+		{"replica+mem://?replica=mem%3A%2F%2F&quorum=3", true},
+		// Invalid replica URL.
+		{"replica+mem://?replica=bad%3A%2F%2F", true},
+		// Parameters that aren't consumed are passed to the first replica.
+		{"replica+mem://?replica=mem%3A%2F%2F&param=value", true},
+	} {
+		b, err := blob.OpenBucket(ctx, test.url)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: got error %v, want error %v", test.url, err, test.wantErr)
+		}
+		if err != nil {
+			continue
+		}
+		if err := b.WriteAll(ctx, "key", []byte("x"), nil); err != nil {
+			t.Errorf("%s: %v", test.url, err)
+		}
+		if err := b.Close(); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestSignedURLOnlyGet(t *testing.T) {
+	ctx := context.Background()
+	b := OpenBucket([]*blob.Bucket{memblob.OpenBucket(nil)}, nil)
+	defer b.Close()
+	for _, method := range []string{http.MethodPut, http.MethodDelete} {
+		_, err := b.SignedURL(ctx, "key", &blob.SignedURLOptions{Method: method})
+		if gcerrors.Code(err) != gcerrors.Unimplemented {
+			t.Errorf("%s: got error %v, want Unimplemented", method, err)
+		}
+	}
+}
+
+func TestPreconditionsDifferentETags(t *testing.T) {
+	ctx := context.Background()
+	r0, r1 := memblob.OpenBucket(nil), memblob.OpenBucket(nil)
+	defer r0.Close()
+	defer r1.Close()
+	// Advance r1's ETags, so that the replicas' ETags for the same blob
+	// differ.
+	if err := r1.WriteAll(ctx, "other", []byte("other"), nil); err != nil {
+		t.Fatal(err)
+	}
+	b := OpenBucket([]*blob.Bucket{r0, r1}, nil)
+	defer b.Close()
+
+	etag := func(r *blob.Bucket, key string) string {
+		t.Helper()
+		attrs, err := r.Attributes(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return attrs.ETag
+	}
+	checkReplicas := func(key, want string) {
+		t.Helper()
+		for i, r := range []*blob.Bucket{r0, r1} {
+			if got, err := r.ReadAll(ctx, key); err != nil || string(got) != want {
+				t.Errorf("replica %d: got %q, %v, want %q", i, got, err, want)
+			}
+		}
+	}
+
+	if err := b.WriteAll(ctx, "key", []byte("v1"), &blob.WriterOptions{IfNoneMatch: "*"}); err != nil {
+		t.Fatal(err)
+	}
+	if etag(r0, "key") == etag(r1, "key") {
+		t.Fatal("replicas have the same ETag")
+	}
+	if err := b.WriteAll(ctx, "key", []byte("v2"), &blob.WriterOptions{IfMatch: etag(b, "key")}); err != nil {
+		t.Fatal(err)
+	}
+	checkReplicas("key", "v2")
+	if err := b.Copy(ctx, "copy", "key", &blob.CopyOptions{IfNoneMatch: "*"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.WriteAll(ctx, "src", []byte("src"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Copy(ctx, "copy", "src", &blob.CopyOptions{IfMatch: etag(b, "copy")}); err != nil {
+		t.Fatal(err)
+	}
+	checkReplicas("copy", "src")
+	if err := b.DeleteWithOptions(ctx, "key", &blob.DeleteOptions{IfMatch: etag(b, "key")}); err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range []*blob.Bucket{r0, r1} {
+		if exists, _ := r.Exists(ctx, "key"); exists {
+			t.Errorf("replica %d still has the deleted blob", i)
+		}
+	}
+}
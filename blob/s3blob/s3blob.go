@@ -69,6 +69,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
@@ -296,11 +297,17 @@ type bucket struct {
 	useLegacyList bool
 }
 
+// errNotImplemented is returned for unsupported features, like preconditions.
+var errNotImplemented = errors.New("not implemented")
+
 func (b *bucket) Close() error {
 	return nil
 }
 
 func (b *bucket) ErrorCode(err error) gcerrors.ErrorCode {
+	if err == errNotImplemented {
+		return gcerrors.Unimplemented
+	}
 	e, ok := err.(awserr.Error)
 	if !ok {
 		return gcerrors.Unknown
@@ -325,6 +332,10 @@ func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driv
 	}
 	if len(opts.PageToken) > 0 {
 		in.ContinuationToken = aws.String(string(opts.PageToken))
+	} else if opts.StartAfter != "" && escapeKey(opts.StartAfter) == opts.StartAfter {
+		// Escaping a key can make it sort after keys that sort after it
+		// unescaped, so an escaped StartAfter is left to the portable type.
+		in.StartAfter = aws.String(opts.StartAfter)
 	}
 	if opts.Prefix != "" {
 		in.Prefix = aws.String(escapeKey(opts.Prefix))
@@ -348,6 +359,7 @@ func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driv
 				ModTime: *obj.LastModified,
 				Size:    *obj.Size,
 				MD5:     eTagToMD5(obj.ETag),
+				ETag:    aws.StringValue(obj.ETag),
 				AsFunc: func(i interface{}) bool {
 					p, ok := i.(*s3.Object)
 					if !ok {
@@ -410,6 +422,10 @@ func (b *bucket) listObjects(ctx context.Context, in *s3.ListObjectsV2Input, opt
 		Prefix:       in.Prefix,
 		RequestPayer: in.RequestPayer,
 	}
+	if legacyIn.Marker == nil {
+		// The Marker is exclusive, like StartAfter.
+		legacyIn.Marker = in.StartAfter
+	}
 	if opts.BeforeList != nil {
 		asFunc := func(i interface{}) bool {
 			p, ok := i.(**s3.ListObjectsInput)
@@ -491,6 +507,7 @@ func (b *bucket) Attributes(ctx context.Context, key string) (*driver.Attributes
 		ModTime:            aws.TimeValue(resp.LastModified),
 		Size:               aws.Int64Value(resp.ContentLength),
 		MD5:                eTagToMD5(resp.ETag),
+		ETag:               aws.StringValue(resp.ETag),
 		AsFunc: func(i interface{}) bool {
 			p, ok := i.(*s3.HeadObjectOutput)
 			if !ok {
@@ -504,6 +521,9 @@ func (b *bucket) Attributes(ctx context.Context, key string) (*driver.Attributes
 
 // NewRangeReader implements driver.NewRangeReader.
 func (b *bucket) NewRangeReader(ctx context.Context, key string, offset, length int64, opts *driver.ReaderOptions) (driver.Reader, error) {
+	if opts.IfMatch != "" || opts.IfNoneMatch != "" {
+		return nil, errNotImplemented
+	}
 	key = escapeKey(key)
 	in := &s3.GetObjectInput{
 		Bucket: aws.String(b.name),
@@ -532,6 +552,8 @@ func (b *bucket) NewRangeReader(ctx context.Context, key string, offset, length
 			ContentType: aws.StringValue(resp.ContentType),
 			ModTime:     aws.TimeValue(resp.LastModified),
 			Size:        getSize(resp),
+
+			ContentEncoding: aws.StringValue(resp.ContentEncoding),
 		},
 		raw: resp,
 	}, nil
@@ -609,27 +631,23 @@ func unescapeKey(key string) string {
 
 // NewTypedWriter implements driver.NewTypedWriter.
 func (b *bucket) NewTypedWriter(ctx context.Context, key string, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
+	if opts.IfMatch != "" || opts.IfNoneMatch != "" {
+		return nil, errNotImplemented
+	}
 	key = escapeKey(key)
 	uploader := s3manager.NewUploaderWithClient(b.client, func(u *s3manager.Uploader) {
 		if opts.BufferSize != 0 {
 			u.PartSize = int64(opts.BufferSize)
 		}
+		if opts.MaxConcurrency != 0 {
+			u.Concurrency = opts.MaxConcurrency
+		}
 	})
-	md := make(map[string]*string, len(opts.Metadata))
-	for k, v := range opts.Metadata {
-		// See the package comments for more details on escaping of metadata
-		// keys & values.
-		k = escape.HexEscape(url.PathEscape(k), func(runes []rune, i int) bool {
-			c := runes[i]
-			return c == '@' || c == ':' || c == '='
-		})
-		md[k] = aws.String(url.PathEscape(v))
-	}
 	req := &s3manager.UploadInput{
 		Bucket:      aws.String(b.name),
 		ContentType: aws.String(contentType),
 		Key:         aws.String(key),
-		Metadata:    md,
+		Metadata:    escapeMetadata(opts.Metadata),
 	}
 	if opts.CacheControl != "" {
 		req.CacheControl = aws.String(opts.CacheControl)
@@ -667,8 +685,26 @@ func (b *bucket) NewTypedWriter(ctx context.Context, key string, contentType str
 	}, nil
 }
 
+// escapeMetadata returns the S3 metadata for md.
+func escapeMetadata(md map[string]string) map[string]*string {
+	s3md := make(map[string]*string, len(md))
+	for k, v := range md {
+		// See the package comments for more details on escaping of metadata
+		// keys & values.
+		k = escape.HexEscape(url.PathEscape(k), func(runes []rune, i int) bool {
+			c := runes[i]
+			return c == '@' || c == ':' || c == '='
+		})
+		s3md[k] = aws.String(url.PathEscape(v))
+	}
+	return s3md
+}
+
 // Copy implements driver.Copy.
 func (b *bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.CopyOptions) error {
+	if opts.IfMatch != "" || opts.IfNoneMatch != "" {
+		return errNotImplemented
+	}
 	dstKey = escapeKey(dstKey)
 	srcKey = escapeKey(srcKey)
 	input := &s3.CopyObjectInput{
@@ -676,6 +712,23 @@ func (b *bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.C
 		CopySource: aws.String(b.name + "/" + srcKey),
 		Key:        aws.String(dstKey),
 	}
+	if a := opts.Attributes; a != nil {
+		input.MetadataDirective = aws.String("REPLACE")
+		input.ContentType = aws.String(a.ContentType)
+		input.Metadata = escapeMetadata(a.Metadata)
+		if a.CacheControl != "" {
+			input.CacheControl = aws.String(a.CacheControl)
+		}
+		if a.ContentDisposition != "" {
+			input.ContentDisposition = aws.String(a.ContentDisposition)
+		}
+		if a.ContentEncoding != "" {
+			input.ContentEncoding = aws.String(a.ContentEncoding)
+		}
+		if a.ContentLanguage != "" {
+			input.ContentLanguage = aws.String(a.ContentLanguage)
+		}
+	}
 	if opts.BeforeCopy != nil {
 		asFunc := func(i interface{}) bool {
 			switch v := i.(type) {
@@ -694,7 +747,10 @@ func (b *bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.C
 }
 
 // Delete implements driver.Delete.
-func (b *bucket) Delete(ctx context.Context, key string) error {
+func (b *bucket) Delete(ctx context.Context, key string, opts *driver.DeleteOptions) error {
+	if opts.IfMatch != "" || opts.IfNoneMatch != "" {
+		return errNotImplemented
+	}
 	if _, err := b.Attributes(ctx, key); err != nil {
 		return err
 	}
@@ -707,12 +763,76 @@ func (b *bucket) Delete(ctx context.Context, key string) error {
 	return err
 }
 
+// maxDeleteObjects is the maximum number of keys in a DeleteObjects request.
+const maxDeleteObjects = 1000
+
+// DeleteMany implements driver.BatchDeleter, using DeleteObjects requests.
+func (b *bucket) DeleteMany(ctx context.Context, keys []string) driver.DeleteManyError {
+	var errs driver.DeleteManyError
+	addErr := func(i int, err error) {
+		errs = append(errs, driver.DeleteManyFailure{Index: i, Err: err})
+	}
+	for start := 0; start < len(keys); start += maxDeleteObjects {
+		end := start + maxDeleteObjects
+		if end > len(keys) {
+			end = len(keys)
+		}
+		// indexes maps the escaped keys in the request to their indexes in keys.
+		indexes := map[string]int{}
+		var objs []*s3.ObjectIdentifier
+		for i := start; i < end; i++ {
+			key := escapeKey(keys[i])
+			indexes[key] = i
+			objs = append(objs, &s3.ObjectIdentifier{Key: aws.String(key)})
+		}
+		out, err := b.client.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(b.name),
+			Delete: &s3.Delete{Objects: objs, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			for i := start; i < end; i++ {
+				addErr(i, err)
+			}
+			continue
+		}
+		for _, e := range out.Errors {
+			i, ok := indexes[aws.StringValue(e.Key)]
+			if !ok || aws.StringValue(e.Code) == "NoSuchKey" {
+				continue
+			}
+			addErr(i, awserr.New(aws.StringValue(e.Code), aws.StringValue(e.Message), nil))
+		}
+	}
+	return errs
+}
+
 func (b *bucket) SignedURL(ctx context.Context, key string, opts *driver.SignedURLOptions) (string, error) {
 	key = escapeKey(key)
-	in := &s3.GetObjectInput{
-		Bucket: aws.String(b.name),
-		Key:    aws.String(key),
+	var req *request.Request
+	switch opts.Method {
+	case http.MethodGet:
+		in := &s3.GetObjectInput{
+			Bucket: aws.String(b.name),
+			Key:    aws.String(key),
+		}
+		req, _ = b.client.GetObjectRequest(in)
+	case http.MethodPut:
+		in := &s3.PutObjectInput{
+			Bucket: aws.String(b.name),
+			Key:    aws.String(key),
+		}
+		if opts.ContentType != "" {
+			in.ContentType = aws.String(opts.ContentType)
+		}
+		req, _ = b.client.PutObjectRequest(in)
+	case http.MethodDelete:
+		in := &s3.DeleteObjectInput{
+			Bucket: aws.String(b.name),
+			Key:    aws.String(key),
+		}
+		req, _ = b.client.DeleteObjectRequest(in)
+	default:
+		return "", fmt.Errorf("unsupported Method %q", opts.Method)
 	}
-	req, _ := b.client.GetObjectRequest(in)
 	return req.Presign(opts.Expiry)
 }
@@ -18,18 +18,24 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/google/go-cmp/cmp"
 	"gocloud.dev/blob"
 	"gocloud.dev/blob/driver"
 	"gocloud.dev/blob/drivertest"
+	"gocloud.dev/blob/memblob"
+	"gocloud.dev/blob/s3gateway"
 	"gocloud.dev/internal/testing/setup"
 )
 
@@ -82,6 +88,125 @@ func TestConformanceUsingLegacyList(t *testing.T) {
 	drivertest.RunConformanceTests(t, newHarnessUsingLegacyList, []drivertest.AsTest{verifyContentLanguage{usingLegacyList: true}})
 }
 
+// gatewayHarness runs the tests against an s3gateway serving a memblob
+// bucket, instead of against S3.
+type gatewayHarness struct {
+	session *session.Session
+	opts    *Options
+	bucket  *blob.Bucket
+	gateway *s3gateway.Handler
+	srv     *httptest.Server
+}
+
+func newGatewayHarness(ctx context.Context, t *testing.T) (drivertest.Harness, error) {
+	return newGatewayHarnessWithOptions(nil)
+}
+
+func newGatewayHarnessUsingLegacyList(ctx context.Context, t *testing.T) (drivertest.Harness, error) {
+	return newGatewayHarnessWithOptions(&Options{UseLegacyList: true})
+}
+
+func newGatewayHarnessWithOptions(opts *Options) (drivertest.Harness, error) {
+	bucket := memblob.OpenBucket(nil)
+	gateway := s3gateway.NewHandler(map[string]*blob.Bucket{bucketName: bucket}, nil)
+	srv := httptest.NewServer(gateway)
+	sess, err := session.NewSession(&aws.Config{
+		Region:           aws.String(region),
+		Endpoint:         aws.String(srv.URL),
+		S3ForcePathStyle: aws.Bool(true),
+		DisableSSL:       aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+	})
+	if err != nil {
+		srv.Close()
+		bucket.Close()
+		return nil, err
+	}
+	return &gatewayHarness{session: sess, opts: opts, bucket: bucket, gateway: gateway, srv: srv}, nil
+}
+
+func (h *gatewayHarness) HTTPClient() *http.Client {
+	return http.DefaultClient
+}
+
+func (h *gatewayHarness) MakeDriver(ctx context.Context) (driver.Bucket, error) {
+	return openBucket(ctx, h.session, bucketName, h.opts)
+}
+
+func (h *gatewayHarness) Close() {
+	h.srv.Close()
+	h.gateway.Close()
+	h.bucket.Close()
+}
+
+func TestConformanceUsingGateway(t *testing.T) {
+	drivertest.RunConformanceTests(t, newGatewayHarness, []drivertest.AsTest{verifyContentLanguage{usingLegacyList: false}})
+}
+
+func TestConformanceUsingGatewayAndLegacyList(t *testing.T) {
+	drivertest.RunConformanceTests(t, newGatewayHarnessUsingLegacyList, []drivertest.AsTest{verifyContentLanguage{usingLegacyList: true}})
+}
+
+// TestDeleteManyUsingGateway tests DeleteMany, which uses DeleteObjects.
+func TestDeleteManyUsingGateway(t *testing.T) {
+	ctx := context.Background()
+	h, err := newGatewayHarness(ctx, t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	drv, err := h.MakeDriver(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := blob.NewBucket(drv)
+	defer b.Close()
+
+	write := func(key string) {
+		if err := h.(*gatewayHarness).bucket.WriteAll(ctx, key, []byte(key), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	remaining := func() []string {
+		var keys []string
+		iter := b.List(nil)
+		for {
+			obj, err := iter.Next(ctx)
+			if err == io.EOF {
+				return keys
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			keys = append(keys, obj.Key)
+		}
+	}
+
+	// More keys than fit in a single DeleteObjects request.
+	keys := []string{"missing"}
+	for i := 0; i < maxDeleteObjects+10; i++ {
+		key := fmt.Sprintf("key%04d", i)
+		write(key)
+		keys = append(keys, key)
+	}
+	if err := b.DeleteMany(ctx, keys); err != nil {
+		t.Fatal(err)
+	}
+	if got := remaining(); len(got) != 0 {
+		t.Errorf("got %d blobs after DeleteMany, want none", len(got))
+	}
+
+	write("dir/a")
+	write("dir/sub/b")
+	write("other")
+	if err := b.DeletePrefix(ctx, "dir/"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := remaining(), []string{"other"}; !cmp.Equal(got, want) {
+		t.Errorf("got %v after DeletePrefix, want %v", got, want)
+	}
+}
+
 func BenchmarkS3blob(b *testing.B) {
 	sess, err := session.NewSession(&aws.Config{
 		Region: aws.String(region),
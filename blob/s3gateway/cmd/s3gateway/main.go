@@ -0,0 +1,93 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// s3gateway serves Go CDK buckets using a subset of the Amazon S3 REST API,
+// so that S3 clients can use them.
+//
+// Usage:
+//
+//   s3gateway [-addr localhost:8080] <name>=<bucket URL>...
+//
+// The gateway doesn't authenticate requests: anyone who can connect to it can
+// read, write and delete blobs. By default, it only listens on localhost.
+//
+// For example, to serve a directory as the S3 bucket "files", and an
+// in-memory bucket as "scratch":
+//
+//   s3gateway files=file:///tmp/files scratch=mem://
+//
+// Clients must use path-style requests; for example, with the AWS CLI:
+//
+//   aws --endpoint-url http://localhost:8080 s3 ls s3://files
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/s3gateway"
+
+	// Import the blob driver packages we want to be able to open.
+	_ "gocloud.dev/blob/azureblob"
+	_ "gocloud.dev/blob/fileblob"
+	_ "gocloud.dev/blob/gcsblob"
+	_ "gocloud.dev/blob/memblob"
+	_ "gocloud.dev/blob/s3blob"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:8080", "address to listen on; requests are not authenticated, so anyone who can connect can read, write and delete blobs")
+	tempDir := flag.String("tempdir", "", "directory for the parts of multipart uploads (default is the system temporary directory)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: s3gateway [flags] <name>=<bucket URL>...\n")
+		flag.PrintDefaults()
+	}
+	log.SetFlags(0)
+	log.SetPrefix("s3gateway: ")
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+	buckets := map[string]*blob.Bucket{}
+	for _, arg := range flag.Args() {
+		i := strings.Index(arg, "=")
+		if i <= 0 {
+			log.Fatalf("invalid argument %q, want <name>=<bucket URL>", arg)
+		}
+		name, bucketURL := arg[:i], arg[i+1:]
+		if buckets[name] != nil {
+			log.Fatalf("duplicate bucket name %q", name)
+		}
+		b, err := blob.OpenBucket(ctx, bucketURL)
+		if err != nil {
+			log.Fatalf("failed to open bucket %q: %v", bucketURL, err)
+		}
+		defer b.Close()
+		buckets[name] = b
+	}
+
+	h := s3gateway.NewHandler(buckets, &s3gateway.Options{TempDir: *tempDir})
+	defer h.Close()
+	log.Printf("serving %d bucket(s) on %s", len(buckets), *addr)
+	log.Fatal(http.ListenAndServe(*addr, h))
+}
@@ -0,0 +1,262 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+
+	"gocloud.dev/blob"
+)
+
+// maxPartNumber is the largest part number allowed by S3.
+const maxPartNumber = 10000
+
+// upload is a multipart upload in progress.
+type upload struct {
+	bucket string
+	key    string
+	opts   *blob.WriterOptions
+
+	mu    sync.Mutex
+	done  bool
+	parts map[int]*part
+}
+
+// part is an uploaded part of a multipart upload, stored in a temporary
+// file.
+type part struct {
+	path string
+	etag string
+}
+
+// abort removes the parts of u.
+func (u *upload) abort() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.done = true
+	var firstErr error
+	for _, p := range u.parts {
+		if err := os.Remove(p.path); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	u.parts = nil
+	return firstErr
+}
+
+var errNoSuchUpload = &apiError{"NoSuchUpload", "The specified upload does not exist. The upload ID may be invalid, or the upload may have been aborted or completed.", http.StatusNotFound}
+
+// lookupUpload returns the upload for the request's uploadId parameter.
+func (h *Handler) lookupUpload(r *http.Request, bucketName, key string) (string, *upload, error) {
+	id := r.URL.Query().Get("uploadId")
+	h.mu.Lock()
+	u := h.uploads[id]
+	h.mu.Unlock()
+	if u == nil || u.bucket != bucketName || u.key != key {
+		return "", nil, errNoSuchUpload
+	}
+	return id, u, nil
+}
+
+// createMultipartUpload implements CreateMultipartUpload.
+func (h *Handler) createMultipartUpload(w http.ResponseWriter, r *http.Request, bucketName, key string) {
+	id := newID()
+	u := &upload{
+		bucket: bucketName,
+		key:    key,
+		opts:   writerOptions(r.Header),
+		parts:  map[int]*part{},
+	}
+	h.mu.Lock()
+	h.uploads[id] = u
+	h.mu.Unlock()
+	writeXML(w, http.StatusOK, &initiateMultipartUploadResult{Bucket: bucketName, Key: key, UploadID: id})
+}
+
+// uploadPart implements UploadPart.
+func (h *Handler) uploadPart(w http.ResponseWriter, r *http.Request, bucketName, key string) {
+	_, u, err := h.lookupUpload(r, bucketName, key)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	n, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if err != nil || n < 1 || n > maxPartNumber {
+		writeError(w, r, &apiError{"InvalidArgument", "Part number must be an integer between 1 and 10000, inclusive.", http.StatusBadRequest})
+		return
+	}
+	if r.Header.Get("X-Amz-Copy-Source") != "" {
+		// UploadPartCopy isn't supported.
+		writeError(w, r, errNotImplemented)
+		return
+	}
+	wantMD5, err := contentMD5(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	f, err := ioutil.TempFile(h.opts.TempDir, "s3gateway")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	hash := md5.New()
+	_, err = io.Copy(io.MultiWriter(f, hash), requestBody(r))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		writeError(w, r, &apiError{"IncompleteBody", err.Error(), http.StatusBadRequest})
+		return
+	}
+	sum := hash.Sum(nil)
+	if wantMD5 != nil && !bytes.Equal(sum, wantMD5) {
+		os.Remove(f.Name())
+		writeError(w, r, errBadDigest)
+		return
+	}
+	p := &part{path: f.Name(), etag: `"` + hex.EncodeToString(sum) + `"`}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.done {
+		os.Remove(p.path)
+		writeError(w, r, errNoSuchUpload)
+		return
+	}
+	if old := u.parts[n]; old != nil {
+		os.Remove(old.path)
+	}
+	u.parts[n] = p
+	w.Header().Set("ETag", p.etag)
+	w.WriteHeader(http.StatusOK)
+}
+
+// completeMultipartUpload implements CompleteMultipartUpload, writing the
+// parts of the upload to the blob.
+func (h *Handler) completeMultipartUpload(w http.ResponseWriter, r *http.Request, bucketName string, b *blob.Bucket, key string) {
+	id, u, err := h.lookupUpload(r, bucketName, key)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var req completeMultipartUpload
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Parts) == 0 {
+		writeError(w, r, errMalformedXML)
+		return
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.done {
+		writeError(w, r, errNoSuchUpload)
+		return
+	}
+	var parts []*part
+	for i, rp := range req.Parts {
+		if i > 0 && rp.PartNumber <= req.Parts[i-1].PartNumber {
+			writeError(w, r, &apiError{"InvalidPartOrder", "The list of parts was not in ascending order. The parts list must be specified in order by part number.", http.StatusBadRequest})
+			return
+		}
+		p := u.parts[rp.PartNumber]
+		if p == nil || (rp.ETag != p.etag && `"`+rp.ETag+`"` != p.etag) {
+			writeError(w, r, &apiError{"InvalidPart", "One or more of the specified parts could not be found. The part may not have been uploaded, or the specified entity tag may not match the part's entity tag.", http.StatusBadRequest})
+			return
+		}
+		parts = append(parts, p)
+	}
+	if err := writeParts(r.Context(), b, key, parts, u.opts); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	attrs, err := b.Attributes(r.Context(), key)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// The upload is complete; parts that weren't used are discarded.
+	u.done = true
+	for _, p := range u.parts {
+		os.Remove(p.path)
+	}
+	u.parts = nil
+	h.mu.Lock()
+	delete(h.uploads, id)
+	h.mu.Unlock()
+
+	writeXML(w, http.StatusOK, &completeMultipartUploadResult{
+		Bucket: bucketName,
+		Key:    key,
+		ETag:   etag(attrs.MD5, attrs.ETag),
+	})
+}
+
+// writeParts writes the concatenation of parts to key in b.
+func writeParts(ctx context.Context, b *blob.Bucket, key string, parts []*part, opts *blob.WriterOptions) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w, err := b.NewWriter(ctx, key, opts)
+	if err != nil {
+		return err
+	}
+	for _, p := range parts {
+		if err := copyFile(w, p.path); err != nil {
+			cancel()
+			_ = w.Close()
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// copyFile copies the contents of the file at path to w.
+func copyFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// abortMultipartUpload implements AbortMultipartUpload.
+func (h *Handler) abortMultipartUpload(w http.ResponseWriter, r *http.Request, bucketName, key string) {
+	id, u, err := h.lookupUpload(r, bucketName, key)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	h.mu.Lock()
+	delete(h.uploads, id)
+	h.mu.Unlock()
+	if err := u.abort(); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,874 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package s3gateway provides an http.Handler that serves a subset of the
+// Amazon S3 REST API, backed by one or more *blob.Buckets. It allows S3
+// clients, like s3blob or the AWS command line tools, to use any blob
+// provider; for example, memblob or fileblob in integration tests.
+//
+// The following operations are supported:
+//  - ListBuckets, HeadBucket and GetBucketLocation
+//  - GetObject (including Range and conditional requests), HeadObject,
+//    PutObject, DeleteObject and CopyObject
+//  - ListObjects and ListObjectsV2, with prefix and delimiter
+//  - DeleteObjects
+//  - CreateMultipartUpload, UploadPart, CompleteMultipartUpload and
+//    AbortMultipartUpload
+// Other requests get a NotImplemented error.
+//
+// Clients must use path-style requests, like
+// "http://localhost:8080/bucket/key"; for s3blob, set S3ForcePathStyle in the
+// aws.Config used to create the session. Requests are not authenticated, so
+// the handler should only be used for local development and testing.
+//
+// The parts of multipart uploads are kept in temporary files until the upload
+// is completed or aborted.
+package s3gateway // import "gocloud.dev/blob/s3gateway"
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+// Options sets options for NewHandler.
+type Options struct {
+	// TempDir is the directory used for the parts of multipart uploads.
+	// Defaults to os.TempDir().
+	TempDir string
+}
+
+// Handler serves the S3 REST API. See the package documentation for
+// details.
+type Handler struct {
+	buckets map[string]*blob.Bucket
+	opts    *Options
+
+	mu      sync.Mutex
+	uploads map[string]*upload
+}
+
+// NewHandler returns a Handler that serves buckets, which maps S3 bucket
+// names to the *blob.Buckets backing them. The Handler doesn't close the
+// buckets.
+func NewHandler(buckets map[string]*blob.Bucket, opts *Options) *Handler {
+	if opts == nil {
+		opts = &Options{}
+	}
+	return &Handler{buckets: buckets, opts: opts, uploads: map[string]*upload{}}
+}
+
+// Close aborts any multipart uploads that are in progress, removing their
+// temporary files.
+func (h *Handler) Close() error {
+	h.mu.Lock()
+	uploads := h.uploads
+	h.uploads = map[string]*upload{}
+	h.mu.Unlock()
+	var firstErr error
+	for _, u := range uploads {
+		if err := u.abort(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	if path == "" {
+		if r.Method != http.MethodGet {
+			writeError(w, r, errNotImplemented)
+			return
+		}
+		h.listBuckets(w, r)
+		return
+	}
+	bucketName, key := path, ""
+	if i := strings.Index(path, "/"); i >= 0 {
+		bucketName, key = path[:i], path[i+1:]
+	}
+	b := h.buckets[bucketName]
+	if b == nil {
+		writeError(w, r, &apiError{"NoSuchBucket", "The specified bucket does not exist.", http.StatusNotFound})
+		return
+	}
+	q := r.URL.Query()
+	if key == "" {
+		switch {
+		case r.Method == http.MethodHead:
+			w.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodGet && hasParam(q, "location"):
+			writeXML(w, http.StatusOK, &locationConstraint{})
+		case r.Method == http.MethodGet && isListRequest(q):
+			h.listObjects(w, r, bucketName, b)
+		case r.Method == http.MethodPost && hasParam(q, "delete"):
+			h.deleteObjects(w, r, b)
+		default:
+			writeError(w, r, errNotImplemented)
+		}
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if hasParam(q, "uploadId") {
+			writeError(w, r, errNotImplemented)
+			return
+		}
+		h.getObject(w, r, b, key)
+	case http.MethodPut:
+		switch {
+		case hasParam(q, "uploadId"):
+			h.uploadPart(w, r, bucketName, key)
+		case r.Header.Get("X-Amz-Copy-Source") != "":
+			h.copyObject(w, r, b, key)
+		default:
+			h.putObject(w, r, b, key)
+		}
+	case http.MethodDelete:
+		if hasParam(q, "uploadId") {
+			h.abortMultipartUpload(w, r, bucketName, key)
+			return
+		}
+		h.deleteObject(w, r, b, key)
+	case http.MethodPost:
+		switch {
+		case hasParam(q, "uploads"):
+			h.createMultipartUpload(w, r, bucketName, key)
+		case hasParam(q, "uploadId"):
+			h.completeMultipartUpload(w, r, bucketName, b, key)
+		default:
+			writeError(w, r, errNotImplemented)
+		}
+	default:
+		writeError(w, r, errNotImplemented)
+	}
+}
+
+// hasParam reports whether q has the parameter name, even if it has no value.
+func hasParam(q url.Values, name string) bool {
+	_, ok := q[name]
+	return ok
+}
+
+// listParams are the query parameters of ListObjects and ListObjectsV2.
+var listParams = map[string]bool{
+	"list-type":          true,
+	"prefix":             true,
+	"delimiter":          true,
+	"max-keys":           true,
+	"marker":             true,
+	"continuation-token": true,
+	"start-after":        true,
+	"encoding-type":      true,
+	"fetch-owner":        true,
+}
+
+// isListRequest reports whether a GET request for a bucket with query q is
+// a ListObjects request, rather than a request for a subresource like
+// "?acl" or "?versions".
+func isListRequest(q url.Values) bool {
+	for name := range q {
+		if !listParams[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// listBuckets implements ListBuckets.
+func (h *Handler) listBuckets(w http.ResponseWriter, r *http.Request) {
+	var names []string
+	for name := range h.buckets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	resp := &listAllMyBucketsResult{Owner: owner{ID: "s3gateway", DisplayName: "s3gateway"}}
+	for _, name := range names {
+		resp.Buckets = append(resp.Buckets, bucketInfo{Name: name, CreationDate: formatTime(time.Time{})})
+	}
+	writeXML(w, http.StatusOK, resp)
+}
+
+// etag returns the S3 ETag for a blob. Like S3, it uses the quoted hex MD5
+// of the blob if it is available, so that clients can use it to verify the
+// content.
+func etag(md5 []byte, blobETag string) string {
+	if len(md5) > 0 {
+		return `"` + hex.EncodeToString(md5) + `"`
+	}
+	if strings.HasPrefix(blobETag, `"`) {
+		return blobETag
+	}
+	return `"` + blobETag + `"`
+}
+
+// formatTime formats t as in S3 XML responses.
+func formatTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+const metadataPrefix = "X-Amz-Meta-"
+
+// setAttributeHeaders sets the response headers for a blob's attributes.
+func setAttributeHeaders(hdr http.Header, attrs *blob.Attributes) {
+	hdr.Set("Content-Type", attrs.ContentType)
+	hdr.Set("Last-Modified", attrs.ModTime.UTC().Format(http.TimeFormat))
+	hdr.Set("ETag", etag(attrs.MD5, attrs.ETag))
+	hdr.Set("Accept-Ranges", "bytes")
+	for name, value := range map[string]string{
+		"Cache-Control":       attrs.CacheControl,
+		"Content-Disposition": attrs.ContentDisposition,
+		"Content-Encoding":    attrs.ContentEncoding,
+		"Content-Language":    attrs.ContentLanguage,
+	} {
+		if value != "" {
+			hdr.Set(name, value)
+		}
+	}
+	for k, v := range attrs.Metadata {
+		hdr.Set(metadataPrefix+k, v)
+	}
+}
+
+// writerOptions returns the WriterOptions for the attributes in the request
+// headers.
+func writerOptions(hdr http.Header) *blob.WriterOptions {
+	opts := &blob.WriterOptions{
+		CacheControl:       hdr.Get("Cache-Control"),
+		ContentDisposition: hdr.Get("Content-Disposition"),
+		ContentEncoding:    removeAWSChunked(hdr.Get("Content-Encoding")),
+		ContentLanguage:    hdr.Get("Content-Language"),
+		ContentType:        hdr.Get("Content-Type"),
+	}
+	if opts.ContentType == "" {
+		// S3's default; otherwise, blob would detect the content type.
+		opts.ContentType = "binary/octet-stream"
+	}
+	for k, vs := range hdr {
+		if strings.HasPrefix(k, metadataPrefix) && len(k) > len(metadataPrefix) {
+			if opts.Metadata == nil {
+				opts.Metadata = map[string]string{}
+			}
+			// S3 metadata keys are case-insensitive, and stored in lower case.
+			opts.Metadata[strings.ToLower(k[len(metadataPrefix):])] = vs[0]
+		}
+	}
+	return opts
+}
+
+// removeAWSChunked removes "aws-chunked", which describes the request
+// body rather than the content, from a Content-Encoding header.
+func removeAWSChunked(enc string) string {
+	var encs []string
+	for _, e := range strings.Split(enc, ",") {
+		if e = strings.TrimSpace(e); e != "" && e != "aws-chunked" {
+			encs = append(encs, e)
+		}
+	}
+	return strings.Join(encs, ",")
+}
+
+// getObject implements GetObject and HeadObject.
+func (h *Handler) getObject(w http.ResponseWriter, r *http.Request, b *blob.Bucket, key string) {
+	ctx := r.Context()
+	attrs, err := b.Attributes(ctx, key)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if status := checkConditions(r.Header, "", attrs); status != 0 {
+		if status == http.StatusNotModified {
+			w.Header().Set("ETag", etag(attrs.MD5, attrs.ETag))
+			w.WriteHeader(status)
+			return
+		}
+		writeError(w, r, &apiError{"PreconditionFailed", "At least one of the pre-conditions you specified did not hold.", status})
+		return
+	}
+	offset, length := int64(0), attrs.Size
+	status := http.StatusOK
+	if rng := r.Header.Get("Range"); rng != "" {
+		var ok bool
+		offset, length, ok = parseRange(rng, attrs.Size)
+		if !ok {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", attrs.Size))
+			writeError(w, r, &apiError{"InvalidRange", "The requested range is not satisfiable.", http.StatusRequestedRangeNotSatisfiable})
+			return
+		}
+		if offset != 0 || length != attrs.Size {
+			status = http.StatusPartialContent
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, attrs.Size))
+		}
+	}
+	setAttributeHeaders(w.Header(), attrs)
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return
+	}
+	reader, err := b.NewRangeReader(ctx, key, offset, length, nil)
+	if err != nil {
+		w.Header().Del("Content-Length")
+		writeError(w, r, err)
+		return
+	}
+	defer reader.Close()
+	w.WriteHeader(status)
+	_, _ = io.Copy(w, reader)
+}
+
+// checkConditions checks the conditional request headers, with the given
+// prefix, against attrs. It returns 0 if the request should proceed, or the
+// status code for the response otherwise.
+func checkConditions(hdr http.Header, prefix string, attrs *blob.Attributes) int {
+	et := etag(attrs.MD5, attrs.ETag)
+	matches := func(list string) bool {
+		for _, e := range strings.Split(list, ",") {
+			if e = strings.TrimSpace(e); e == "*" || e == et || `"`+e+`"` == et {
+				return true
+			}
+		}
+		return false
+	}
+	modTime := attrs.ModTime.Truncate(time.Second)
+	if v := hdr.Get(prefix + "If-Match"); v != "" {
+		if !matches(v) {
+			return http.StatusPreconditionFailed
+		}
+	} else if t, err := http.ParseTime(hdr.Get(prefix + "If-Unmodified-Since")); err == nil && modTime.After(t) {
+		return http.StatusPreconditionFailed
+	}
+	if v := hdr.Get(prefix + "If-None-Match"); v != "" {
+		if matches(v) {
+			if prefix != "" {
+				return http.StatusPreconditionFailed
+			}
+			return http.StatusNotModified
+		}
+	} else if t, err := http.ParseTime(hdr.Get(prefix + "If-Modified-Since")); err == nil && !modTime.After(t) {
+		if prefix != "" {
+			return http.StatusPreconditionFailed
+		}
+		return http.StatusNotModified
+	}
+	return 0
+}
+
+// parseRange parses a Range header for a single range, as supported by S3.
+// Ranges that can't be parsed are ignored, like S3 does; the whole blob is
+// returned for them. ok is false if the range is not satisfiable.
+func parseRange(rng string, size int64) (offset, length int64, ok bool) {
+	spec := strings.TrimPrefix(rng, "bytes=")
+	i := strings.Index(spec, "-")
+	if len(spec) == len(rng) || i < 0 || strings.Contains(spec, ",") {
+		return 0, size, true
+	}
+	first, last := strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+1:])
+	if first == "" {
+		// A suffix range, like "bytes=-5".
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil {
+			return 0, size, true
+		}
+		if n == 0 {
+			return 0, 0, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, true
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return 0, size, true
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, size, true
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	if start >= size {
+		return 0, 0, false
+	}
+	return start, end - start + 1, true
+}
+
+// requestBody returns the content in the body of r, decoding it if it uses
+// the aws-chunked encoding.
+func requestBody(r *http.Request) io.Reader {
+	if strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return &chunkedReader{r: bufio.NewReader(r.Body)}
+	}
+	return r.Body
+}
+
+// chunkedReader decodes the aws-chunked encoding, where the content is sent
+// as a series of chunks, each starting with a line like
+// "<hex size>;chunk-signature=<signature>". The chunk signatures are not
+// verified.
+type chunkedReader struct {
+	r         *bufio.Reader
+	remaining int64
+	done      bool
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if c.done {
+		return 0, io.EOF
+	}
+	if c.remaining == 0 {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return 0, unexpectedEOF(err)
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			// The end of the previous chunk.
+			if line, err = c.r.ReadString('\n'); err != nil {
+				return 0, unexpectedEOF(err)
+			}
+			line = strings.TrimSpace(line)
+		}
+		if i := strings.Index(line, ";"); i >= 0 {
+			line = line[:i]
+		}
+		n, err := strconv.ParseInt(line, 16, 64)
+		if err != nil || n < 0 {
+			return 0, errors.New("invalid aws-chunked encoding")
+		}
+		if n == 0 {
+			// The last chunk; any trailers are ignored.
+			c.done = true
+			return 0, io.EOF
+		}
+		c.remaining = n
+	}
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.remaining -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// contentMD5 returns the decoded Content-MD5 header of r, if any.
+func contentMD5(r *http.Request) ([]byte, error) {
+	v := r.Header.Get("Content-MD5")
+	if v == "" {
+		return nil, nil
+	}
+	sum, err := base64.StdEncoding.DecodeString(v)
+	if err != nil || len(sum) != md5.Size {
+		return nil, &apiError{"InvalidDigest", "The Content-MD5 you specified is not valid.", http.StatusBadRequest}
+	}
+	return sum, nil
+}
+
+var errBadDigest = &apiError{"BadDigest", "The Content-MD5 you specified did not match what we received.", http.StatusBadRequest}
+
+// putObject implements PutObject.
+func (h *Handler) putObject(w http.ResponseWriter, r *http.Request, b *blob.Bucket, key string) {
+	wantMD5, err := contentMD5(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	// Cancel the write if the body can't be read completely, or doesn't match
+	// wantMD5.
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	bw, err := b.NewWriter(ctx, key, writerOptions(r.Header))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	hash := md5.New()
+	if _, err := io.Copy(io.MultiWriter(bw, hash), requestBody(r)); err != nil {
+		cancel()
+		_ = bw.Close()
+		writeError(w, r, &apiError{"IncompleteBody", err.Error(), http.StatusBadRequest})
+		return
+	}
+	if wantMD5 != nil && !bytes.Equal(hash.Sum(nil), wantMD5) {
+		cancel()
+		_ = bw.Close()
+		writeError(w, r, errBadDigest)
+		return
+	}
+	if err := bw.Close(); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	attrs, err := b.Attributes(r.Context(), key)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", etag(attrs.MD5, attrs.ETag))
+	w.WriteHeader(http.StatusOK)
+}
+
+// copyObject implements CopyObject.
+func (h *Handler) copyObject(w http.ResponseWriter, r *http.Request, b *blob.Bucket, key string) {
+	ctx := r.Context()
+	src := r.Header.Get("X-Amz-Copy-Source")
+	if i := strings.Index(src, "?"); i >= 0 {
+		// Copying a specific version isn't supported.
+		writeError(w, r, errNotImplemented)
+		return
+	}
+	if unescaped, err := url.PathUnescape(src); err == nil {
+		src = unescaped
+	}
+	src = strings.TrimPrefix(src, "/")
+	i := strings.Index(src, "/")
+	if i < 0 {
+		writeError(w, r, &apiError{"InvalidArgument", "Copy Source must mention the source bucket and key: sourcebucket/sourcekey.", http.StatusBadRequest})
+		return
+	}
+	srcBucketName, srcKey := src[:i], src[i+1:]
+	srcBucket := h.buckets[srcBucketName]
+	if srcBucket == nil {
+		writeError(w, r, &apiError{"NoSuchBucket", "The specified bucket does not exist.", http.StatusNotFound})
+		return
+	}
+	srcAttrs, err := srcBucket.Attributes(ctx, srcKey)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if status := checkConditions(r.Header, "X-Amz-Copy-Source-", srcAttrs); status != 0 {
+		writeError(w, r, &apiError{"PreconditionFailed", "At least one of the pre-conditions you specified did not hold.", status})
+		return
+	}
+	replace := r.Header.Get("X-Amz-Metadata-Directive") == "REPLACE"
+	if srcBucket == b && !replace {
+		err = b.Copy(ctx, key, srcKey, nil)
+	} else {
+		var opts *blob.WriterOptions
+		if replace {
+			opts = writerOptions(r.Header)
+		} else {
+			opts = &blob.WriterOptions{
+				CacheControl:       srcAttrs.CacheControl,
+				ContentDisposition: srcAttrs.ContentDisposition,
+				ContentEncoding:    srcAttrs.ContentEncoding,
+				ContentLanguage:    srcAttrs.ContentLanguage,
+				ContentType:        srcAttrs.ContentType,
+				Metadata:           srcAttrs.Metadata,
+			}
+		}
+		err = copyBlob(ctx, b, key, srcBucket, srcKey, opts)
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	attrs, err := b.Attributes(ctx, key)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeXML(w, http.StatusOK, &copyObjectResult{
+		LastModified: formatTime(attrs.ModTime),
+		ETag:         etag(attrs.MD5, attrs.ETag),
+	})
+}
+
+// copyBlob copies srcKey in src to dstKey in dst by reading and writing it.
+func copyBlob(ctx context.Context, dst *blob.Bucket, dstKey string, src *blob.Bucket, srcKey string, opts *blob.WriterOptions) error {
+	r, err := src.NewReader(ctx, srcKey, nil)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w, err := dst.NewWriter(ctx, dstKey, opts)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		cancel()
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// deleteObject implements DeleteObject.
+func (h *Handler) deleteObject(w http.ResponseWriter, r *http.Request, b *blob.Bucket, key string) {
+	// Like S3, succeed if the blob doesn't exist.
+	if err := b.Delete(r.Context(), key); err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteObjects implements DeleteObjects, using DeleteMany.
+func (h *Handler) deleteObjects(w http.ResponseWriter, r *http.Request, b *blob.Bucket) {
+	var req deleteRequest
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Objects) == 0 || len(req.Objects) > maxKeys {
+		writeError(w, r, errMalformedXML)
+		return
+	}
+	var keys []string
+	for _, obj := range req.Objects {
+		if obj.VersionID != "" {
+			// Deleting specific versions isn't supported.
+			writeError(w, r, errNotImplemented)
+			return
+		}
+		keys = append(keys, obj.Key)
+	}
+	failed := map[string]bool{}
+	resp := &deleteResult{}
+	err := b.DeleteMany(r.Context(), keys)
+	if dmerr, ok := err.(blob.DeleteManyError); ok {
+		for _, e := range dmerr {
+			failed[e.Key] = true
+			ae := toAPIError(e.Err)
+			resp.Errors = append(resp.Errors, deleteError{Key: e.Key, Code: ae.code, Message: ae.message})
+		}
+	} else if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if !req.Quiet {
+		for _, key := range keys {
+			if !failed[key] {
+				resp.Deleted = append(resp.Deleted, deletedObject{Key: key})
+			}
+		}
+	}
+	writeXML(w, http.StatusOK, resp)
+}
+
+// maxKeys is the maximum number of keys returned by ListObjects, and
+// accepted by DeleteObjects.
+const maxKeys = 1000
+
+// listObjects implements ListObjects and ListObjectsV2.
+//
+// The continuation tokens (and markers) are the last key returned, so listing
+// a page requires listing the blobs before it as well.
+func (h *Handler) listObjects(w http.ResponseWriter, r *http.Request, bucketName string, b *blob.Bucket) {
+	q := r.URL.Query()
+	v2 := q.Get("list-type") == "2"
+	prefix, delimiter := q.Get("prefix"), q.Get("delimiter")
+	limit := maxKeys
+	if v := q.Get("max-keys"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, r, &apiError{"InvalidArgument", "Provided max-keys not an integer or within integer range", http.StatusBadRequest})
+			return
+		}
+		if n < limit {
+			limit = n
+		}
+	}
+	encode := func(s string) string { return s }
+	if q.Get("encoding-type") == "url" {
+		encode = url.QueryEscape
+	}
+	// Only return keys after after.
+	after := q.Get("marker")
+	if v2 {
+		after = q.Get("start-after")
+		if token := q.Get("continuation-token"); token != "" {
+			k, err := base64.RawURLEncoding.DecodeString(token)
+			if err != nil {
+				writeError(w, r, &apiError{"InvalidArgument", "The continuation token provided is incorrect", http.StatusBadRequest})
+				return
+			}
+			after = string(k)
+		}
+	}
+
+	var contents []object
+	var prefixes []commonPrefix
+	var last string
+	truncated := false
+	iter := b.List(&blob.ListOptions{Prefix: prefix, Delimiter: delimiter})
+	for {
+		obj, err := iter.Next(r.Context())
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if obj.Key <= after {
+			continue
+		}
+		if len(contents)+len(prefixes) == limit {
+			truncated = true
+			break
+		}
+		last = obj.Key
+		if obj.IsDir {
+			prefixes = append(prefixes, commonPrefix{Prefix: encode(obj.Key)})
+			continue
+		}
+		contents = append(contents, object{
+			Key:          encode(obj.Key),
+			LastModified: formatTime(obj.ModTime),
+			ETag:         etag(obj.MD5, obj.ETag),
+			Size:         obj.Size,
+			StorageClass: "STANDARD",
+		})
+	}
+
+	if v2 {
+		resp := &listBucketResultV2{
+			Name:              bucketName,
+			Prefix:            encode(prefix),
+			Delimiter:         encode(delimiter),
+			MaxKeys:           limit,
+			KeyCount:          len(contents) + len(prefixes),
+			IsTruncated:       truncated,
+			ContinuationToken: q.Get("continuation-token"),
+			StartAfter:        encode(q.Get("start-after")),
+			Contents:          contents,
+			CommonPrefixes:    prefixes,
+		}
+		if q.Get("encoding-type") == "url" {
+			resp.EncodingType = "url"
+		}
+		if truncated {
+			resp.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+		}
+		writeXML(w, http.StatusOK, resp)
+		return
+	}
+	resp := &listBucketResult{
+		Name:           bucketName,
+		Prefix:         encode(prefix),
+		Delimiter:      encode(delimiter),
+		Marker:         encode(q.Get("marker")),
+		MaxKeys:        limit,
+		IsTruncated:    truncated,
+		Contents:       contents,
+		CommonPrefixes: prefixes,
+	}
+	if q.Get("encoding-type") == "url" {
+		resp.EncodingType = "url"
+	}
+	if truncated {
+		resp.NextMarker = encode(last)
+	}
+	writeXML(w, http.StatusOK, resp)
+}
+
+// newID returns a new random ID.
+func newID() string {
+	var buf [16]byte
+	_, _ = rand.Read(buf[:])
+	return hex.EncodeToString(buf[:])
+}
+
+// apiError is an S3 error response.
+type apiError struct {
+	code    string
+	message string
+	status  int
+}
+
+func (e *apiError) Error() string { return e.code + ": " + e.message }
+
+var errNotImplemented = &apiError{"NotImplemented", "A header or parameter you provided implies functionality that is not implemented.", http.StatusNotImplemented}
+
+var errMalformedXML = &apiError{"MalformedXML", "The XML you provided was not well-formed or did not validate against our published schema.", http.StatusBadRequest}
+
+// toAPIError returns the S3 error for err.
+func toAPIError(err error) *apiError {
+	if e, ok := err.(*apiError); ok {
+		return e
+	}
+	switch gcerrors.Code(err) {
+	case gcerrors.NotFound:
+		return &apiError{"NoSuchKey", "The specified key does not exist.", http.StatusNotFound}
+	case gcerrors.FailedPrecondition:
+		return &apiError{"PreconditionFailed", "At least one of the pre-conditions you specified did not hold.", http.StatusPreconditionFailed}
+	case gcerrors.InvalidArgument:
+		return &apiError{"InvalidArgument", err.Error(), http.StatusBadRequest}
+	case gcerrors.PermissionDenied:
+		return &apiError{"AccessDenied", "Access Denied", http.StatusForbidden}
+	case gcerrors.Unimplemented:
+		return errNotImplemented
+	default:
+		return &apiError{"InternalError", err.Error(), http.StatusInternalServerError}
+	}
+}
+
+// writeError writes an S3 error response for err.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	e := toAPIError(err)
+	if r.Method == http.MethodHead {
+		// Responses to HEAD requests don't have a body.
+		w.WriteHeader(e.status)
+		return
+	}
+	writeXML(w, e.status, &errorResponse{Code: e.code, Message: e.message, Resource: r.URL.Path, RequestID: newID()})
+}
+
+// writeXML writes v as an XML response body.
+func writeXML(w http.ResponseWriter, status int, v interface{}) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	if err := xml.NewEncoder(&buf).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(status)
+	_, _ = w.Write(buf.Bytes())
+}
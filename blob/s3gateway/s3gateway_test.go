@@ -0,0 +1,419 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3gateway
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/google/go-cmp/cmp"
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/memblob"
+	"gocloud.dev/blob/s3blob"
+	"gocloud.dev/gcerrors"
+)
+
+type fixture struct {
+	src, dst *blob.Bucket
+	srv      *httptest.Server
+	sess     *session.Session
+	closers  []func()
+}
+
+// newFixture starts a gateway serving two memblob buckets, "src" and "dst".
+func newFixture(t *testing.T) *fixture {
+	dir, err := ioutil.TempDir("", "s3gateway")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, dst := memblob.OpenBucket(nil), memblob.OpenBucket(nil)
+	h := NewHandler(map[string]*blob.Bucket{"src": src, "dst": dst}, &Options{TempDir: dir})
+	srv := httptest.NewServer(h)
+	f := &fixture{src: src, dst: dst, srv: srv}
+	f.closers = append(f.closers, func() {
+		srv.Close()
+		h.Close()
+		src.Close()
+		dst.Close()
+		os.RemoveAll(dir)
+	})
+	f.sess, err = session.NewSession(&aws.Config{
+		Region:           aws.String("us-west-1"),
+		Endpoint:         aws.String(srv.URL),
+		S3ForcePathStyle: aws.Bool(true),
+		DisableSSL:       aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+	})
+	if err != nil {
+		f.Close()
+		t.Fatal(err)
+	}
+	return f
+}
+
+// open returns the bucket name via the gateway, using s3blob.
+func (f *fixture) open(t *testing.T, name string) *blob.Bucket {
+	b, err := s3blob.OpenBucket(context.Background(), f.sess, name, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.closers = append(f.closers, func() { b.Close() })
+	return b
+}
+
+func (f *fixture) Close() {
+	for i := len(f.closers) - 1; i >= 0; i-- {
+		f.closers[i]()
+	}
+}
+
+func TestMultipartUpload(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	defer f.Close()
+	b := f.open(t, "src")
+
+	// s3blob uses a multipart upload for blobs larger than the part size.
+	data := bytes.Repeat([]byte("0123456789abcdef"), 1<<20)
+	opts := &blob.WriterOptions{
+		BufferSize:  int(s3manager.MinUploadPartSize),
+		ContentType: "application/octet-stream",
+		Metadata:    map[string]string{"foo": "bar"},
+	}
+	if err := b.WriteAll(ctx, "big", data, opts); err != nil {
+		t.Fatal(err)
+	}
+	got, err := f.src.ReadAll(ctx, "big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("got %d bytes, want %d bytes", len(got), len(data))
+	}
+	attrs, err := b.Attributes(ctx, "big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.ContentType != opts.ContentType || !cmp.Equal(attrs.Metadata, opts.Metadata) {
+		t.Errorf("got ContentType %q and Metadata %v, want %q and %v", attrs.ContentType, attrs.Metadata, opts.ContentType, opts.Metadata)
+	}
+}
+
+func TestAbortMultipartUpload(t *testing.T) {
+	f := newFixture(t)
+	defer f.Close()
+	svc := s3.New(f.sess)
+	out, err := svc.CreateMultipartUpload(&s3.CreateMultipartUploadInput{Bucket: aws.String("src"), Key: aws.String("k")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.UploadPart(&s3.UploadPartInput{
+		Bucket:     aws.String("src"),
+		Key:        aws.String("k"),
+		UploadId:   out.UploadId,
+		PartNumber: aws.Int64(1),
+		Body:       strings.NewReader("part"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.AbortMultipartUpload(&s3.AbortMultipartUploadInput{Bucket: aws.String("src"), Key: aws.String("k"), UploadId: out.UploadId}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = svc.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:   aws.String("src"),
+		Key:      aws.String("k"),
+		UploadId: out.UploadId,
+		MultipartUpload: &s3.CompletedMultipartUpload{
+			Parts: []*s3.CompletedPart{{PartNumber: aws.Int64(1), ETag: aws.String("x")}},
+		},
+	})
+	if !isErrorCode(err, "NoSuchUpload") {
+		t.Errorf("got error %v, want NoSuchUpload", err)
+	}
+	if exists, _ := f.src.Exists(context.Background(), "k"); exists {
+		t.Error("blob exists after aborting the upload")
+	}
+}
+
+func TestCompleteMultipartUploadInvalidPart(t *testing.T) {
+	f := newFixture(t)
+	defer f.Close()
+	svc := s3.New(f.sess)
+	out, err := svc.CreateMultipartUpload(&s3.CreateMultipartUploadInput{Bucket: aws.String("src"), Key: aws.String("k")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	part, err := svc.UploadPart(&s3.UploadPartInput{
+		Bucket:     aws.String("src"),
+		Key:        aws.String("k"),
+		UploadId:   out.UploadId,
+		PartNumber: aws.Int64(1),
+		Body:       strings.NewReader("part"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name     string
+		parts    []*s3.CompletedPart
+		wantCode string
+	}{
+		{"WrongETag", []*s3.CompletedPart{{PartNumber: aws.Int64(1), ETag: aws.String(`"wrong"`)}}, "InvalidPart"},
+		{"MissingPart", []*s3.CompletedPart{{PartNumber: aws.Int64(1), ETag: part.ETag}, {PartNumber: aws.Int64(2), ETag: part.ETag}}, "InvalidPart"},
+		{"Order", []*s3.CompletedPart{{PartNumber: aws.Int64(1), ETag: part.ETag}, {PartNumber: aws.Int64(1), ETag: part.ETag}}, "InvalidPartOrder"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := svc.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+				Bucket:          aws.String("src"),
+				Key:             aws.String("k"),
+				UploadId:        out.UploadId,
+				MultipartUpload: &s3.CompletedMultipartUpload{Parts: test.parts},
+			})
+			if !isErrorCode(err, test.wantCode) {
+				t.Errorf("got error %v, want %s", err, test.wantCode)
+			}
+		})
+	}
+}
+
+func isErrorCode(err error, code string) bool {
+	aerr, ok := err.(interface{ Code() string })
+	return ok && aerr.Code() == code
+}
+
+func TestCopyBetweenBuckets(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	defer f.Close()
+	opts := &blob.WriterOptions{ContentType: "text/plain", Metadata: map[string]string{"foo": "bar"}}
+	if err := f.src.WriteAll(ctx, "a/b c", []byte("hello"), opts); err != nil {
+		t.Fatal(err)
+	}
+	svc := s3.New(f.sess)
+	if _, err := svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:     aws.String("dst"),
+		Key:        aws.String("copy"),
+		CopySource: aws.String("src/a/b%20c"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := f.dst.ReadAll(ctx, "copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrs, err := f.dst.Attributes(ctx, "copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello" || attrs.ContentType != opts.ContentType || !cmp.Equal(attrs.Metadata, opts.Metadata) {
+		t.Errorf("got %q with ContentType %q and Metadata %v", got, attrs.ContentType, attrs.Metadata)
+	}
+
+	// Replace the metadata.
+	if _, err := svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:            aws.String("dst"),
+		Key:               aws.String("replaced"),
+		CopySource:        aws.String("src/a/b%20c"),
+		MetadataDirective: aws.String("REPLACE"),
+		ContentType:       aws.String("text/html"),
+		Metadata:          map[string]*string{"Baz": aws.String("qux")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	attrs, err = f.dst.Attributes(ctx, "replaced")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"baz": "qux"}; attrs.ContentType != "text/html" || !cmp.Equal(attrs.Metadata, want) {
+		t.Errorf("got ContentType %q and Metadata %v, want %q and %v", attrs.ContentType, attrs.Metadata, "text/html", want)
+	}
+
+	// A failed precondition on the source.
+	_, err = svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:            aws.String("dst"),
+		Key:               aws.String("copy"),
+		CopySource:        aws.String("src/a/b%20c"),
+		CopySourceIfMatch: aws.String(`"other"`),
+	})
+	if !isErrorCode(err, "PreconditionFailed") {
+		t.Errorf("got error %v, want PreconditionFailed", err)
+	}
+}
+
+func TestListObjectsV2(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	defer f.Close()
+	for _, key := range []string{"a", "dir/b", "dir/c", "dir/sub/d", "e"} {
+		if err := f.src.WriteAll(ctx, key, []byte(key), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	svc := s3.New(f.sess)
+
+	var got []string
+	err := svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket:    aws.String("src"),
+		Delimiter: aws.String("/"),
+		MaxKeys:   aws.Int64(2),
+	}, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, p := range page.CommonPrefixes {
+			got = append(got, *p.Prefix)
+		}
+		for _, o := range page.Contents {
+			got = append(got, *o.Key)
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(got)
+	if want := []string{"a", "dir/", "e"}; !cmp.Equal(got, want) {
+		t.Errorf("got %v want %v", got, want)
+	}
+
+	out, err := svc.ListObjectsV2(&s3.ListObjectsV2Input{
+		Bucket:     aws.String("src"),
+		Prefix:     aws.String("dir/"),
+		StartAfter: aws.String("dir/b"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = nil
+	for _, o := range out.Contents {
+		got = append(got, *o.Key)
+	}
+	if want := []string{"dir/c", "dir/sub/d"}; !cmp.Equal(got, want) || *out.KeyCount != 2 || *out.IsTruncated {
+		t.Errorf("got %v (KeyCount %d, IsTruncated %v) want %v", got, *out.KeyCount, *out.IsTruncated, want)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	ctx := context.Background()
+	f := newFixture(t)
+	defer f.Close()
+	if err := f.src.WriteAll(ctx, "k", []byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name       string
+		method     string
+		path       string
+		header     map[string]string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "NoSuchBucket", path: "/missing/k", wantStatus: http.StatusNotFound, wantBody: "<Code>NoSuchBucket</Code>"},
+		{name: "NoSuchKey", path: "/src/missing", wantStatus: http.StatusNotFound, wantBody: "<Code>NoSuchKey</Code>"},
+		{name: "HeadNoBody", method: http.MethodHead, path: "/src/missing", wantStatus: http.StatusNotFound},
+		{name: "InvalidRange", path: "/src/k", header: map[string]string{"Range": "bytes=10-"}, wantStatus: http.StatusRequestedRangeNotSatisfiable, wantBody: "<Code>InvalidRange</Code>"},
+		{name: "IfMatch", path: "/src/k", header: map[string]string{"If-Match": `"other"`}, wantStatus: http.StatusPreconditionFailed},
+		{name: "BadDigest", method: http.MethodPut, path: "/src/new", header: map[string]string{"Content-MD5": "XUFAKrxLKna5cZ2REBfFkg=="}, body: "wrong", wantStatus: http.StatusBadRequest, wantBody: "<Code>BadDigest</Code>"},
+		{name: "NotImplemented", path: "/src?acl", wantStatus: http.StatusNotImplemented, wantBody: "<Code>NotImplemented</Code>"},
+		{name: "NoSuchUpload", method: http.MethodPut, path: "/src/k?uploadId=x&partNumber=1", wantStatus: http.StatusNotFound, wantBody: "<Code>NoSuchUpload</Code>"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			method := test.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req, err := http.NewRequest(method, f.srv.URL+test.path, strings.NewReader(test.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range test.header {
+				req.Header.Set(k, v)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != test.wantStatus {
+				t.Errorf("got status %d want %d", resp.StatusCode, test.wantStatus)
+			}
+			if !strings.Contains(string(body), test.wantBody) {
+				t.Errorf("got body %q, want it to contain %q", body, test.wantBody)
+			}
+		})
+	}
+	// The failed PUT didn't create the blob.
+	if _, err := f.src.Attributes(ctx, "new"); gcerrors.Code(err) != gcerrors.NotFound {
+		t.Errorf("got error %v, want NotFound", err)
+	}
+}
+
+func TestChunkedReader(t *testing.T) {
+	const body = "5;chunk-signature=abc\r\nhello\r\n6;chunk-signature=def\r\n world\r\n0;chunk-signature=ghi\r\n\r\n"
+	got, err := ioutil.ReadAll(&chunkedReader{r: bufioReader(body)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello world" {
+		t.Errorf("got %q want %q", got, "hello world")
+	}
+
+	_, err = ioutil.ReadAll(&chunkedReader{r: bufioReader("5;chunk-signature=abc\r\nhel")})
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("got error %v for a truncated body, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func bufioReader(s string) *bufio.Reader {
+	return bufio.NewReader(strings.NewReader(s))
+}
+
+func TestParseRange(t *testing.T) {
+	for _, test := range []struct {
+		rng                 string
+		wantOffset, wantLen int64
+		wantOK              bool
+	}{
+		{"bytes=0-4", 0, 5, true},
+		{"bytes=3-", 3, 7, true},
+		{"bytes=-4", 6, 4, true},
+		{"bytes=-20", 0, 10, true},
+		{"bytes=5-100", 5, 5, true},
+		{"bytes=10-", 0, 0, false},
+		{"bytes=0-1,3-4", 0, 10, true},
+		{"items=0-4", 0, 10, true},
+	} {
+		offset, length, ok := parseRange(test.rng, 10)
+		if offset != test.wantOffset || length != test.wantLen || ok != test.wantOK {
+			t.Errorf("%s: got (%d, %d, %v) want (%d, %d, %v)", test.rng, offset, length, ok, test.wantOffset, test.wantLen, test.wantOK)
+		}
+	}
+}
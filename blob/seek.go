@@ -0,0 +1,206 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blob
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+	"gocloud.dev/internal/gcerr"
+	"gocloud.dev/internal/oc"
+)
+
+// readAheadSize is the minimum number of bytes read by ReadAt, and the
+// largest distance Read skips forward after a Seek instead of starting a
+// new range read.
+const readAheadSize = 32 * 1024
+
+var errSeekDecompressing = gcerr.Newf(gcerr.Unimplemented, nil, "blob: Seek and ReadAt are not supported when decompressing")
+
+// Seek implements io.Seeker (https://golang.org/pkg/io/#Seeker).
+//
+// Offsets are relative to the range the Reader was created for, like for an
+// io.SectionReader: offset 0 is the offset passed to NewRangeReader, and
+// io.SeekEnd is relative to the end of the range, or of the blob if the range
+// extends past it. Seeking is cheap; the next Read issues a new range read
+// for the rest of the range if needed.
+//
+// If the provider reports the ETag of the blob when reading it (see
+// Reader.ETag), new range reads fail with an error for which gcerrors.Code
+// returns gcerrors.FailedPrecondition if the blob has changed since the
+// Reader was created. Otherwise, they may return the content of a newer
+// version of the blob.
+//
+// Seek returns an error for which gcerrors.Code returns
+// gcerrors.Unimplemented if the Reader is decompressing the blob.
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	if r.dr != nil {
+		return 0, errSeekDecompressing
+	}
+	switch whence {
+	case io.SeekStart:
+		offset += r.base
+	case io.SeekCurrent:
+		offset += r.off
+	case io.SeekEnd:
+		offset += r.limit
+	default:
+		return 0, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: invalid Seek whence %d", whence)
+	}
+	if offset < r.base {
+		return 0, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: Seek to a negative position (%d)", offset-r.base)
+	}
+	r.off = offset
+	return offset - r.base, nil
+}
+
+// reposition makes r.r return bytes starting at r.off, after a Seek.
+func (r *Reader) reposition() error {
+	// The content is no longer read sequentially, so it can't be verified.
+	r.verify = nil
+	if r.off >= r.limit {
+		return io.EOF
+	}
+	if r.off > r.roff && r.off-r.roff <= readAheadSize {
+		// Skip forward in the current range read.
+		n, err := io.CopyN(ioutil.Discard, readerFunc(r.read), r.off-r.roff)
+		r.roff += n
+		if err == nil {
+			return nil
+		}
+		if err != io.EOF {
+			return err
+		}
+		// The range read ended early; start a new one below.
+	}
+	dr, err := r.open(r.off, r.limit-r.off)
+	if err != nil {
+		return wrapError(r.b, err)
+	}
+	_ = r.r.Close()
+	r.r = dr
+	r.roff = r.off
+	return nil
+}
+
+// ReadAt implements io.ReaderAt (https://golang.org/pkg/io/#ReaderAt). It
+// doesn't affect the offset used by Read and Seek, and can be called
+// concurrently with other ReadAt calls.
+//
+// Offsets are relative to the range the Reader was created for, as for Seek.
+// Each call issues one or more new range reads, except that small reads are
+// served from a buffer holding the bytes that follow the previous small read.
+// Like the range reads started by Seek, they fail if the blob has changed
+// and the provider reports its ETag.
+//
+// ReadAt returns an error for which gcerrors.Code returns
+// gcerrors.Unimplemented if the Reader is decompressing the blob.
+func (r *Reader) ReadAt(p []byte, off int64) (int, error) {
+	if r.dr != nil {
+		return 0, errSeekDecompressing
+	}
+	if off < 0 {
+		return 0, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: ReadAt offset must be non-negative (%d)", off)
+	}
+	off += r.base
+	var n int
+	for n < len(p) && off < r.limit {
+		m, err := r.readAt(p[n:], off)
+		n += m
+		off += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// readAt reads up to len(p) bytes starting at off, which is before r.limit,
+// using the read-ahead buffer for small reads.
+func (r *Reader) readAt(p []byte, off int64) (int, error) {
+	if n := r.ra.copy(p, off); n > 0 {
+		return n, nil
+	}
+	size := int64(len(p))
+	if size < readAheadSize {
+		size = readAheadSize
+	}
+	if off+size > r.limit {
+		size = r.limit - off
+	}
+	dr, err := r.open(off, size)
+	if err != nil {
+		return 0, wrapError(r.b, err)
+	}
+	defer dr.Close()
+	buf := p
+	if size > int64(len(p)) {
+		buf = make([]byte, size)
+	}
+	n, err := io.ReadFull(dr, buf[:size])
+	stats.RecordWithTags(context.Background(), []tag.Mutator{tag.Upsert(oc.ProviderKey, r.provider)},
+		bytesReadMeasure.M(int64(n)))
+	if r.limiter != nil {
+		if werr := r.limiter.wait(r.limitCtx, n); werr != nil {
+			return 0, werr
+		}
+	}
+	if err == io.ErrUnexpectedEOF {
+		// The blob is shorter than expected.
+		err = io.EOF
+	}
+	if len(buf) > len(p) {
+		r.ra.set(buf[:n], off)
+		n = copy(p, buf[:n])
+	}
+	if n > 0 && err == io.EOF {
+		// Report io.EOF from the next call.
+		err = nil
+	}
+	return n, wrapError(r.b, err)
+}
+
+// readAhead holds bytes of a blob read by ReadAt that weren't returned yet.
+type readAhead struct {
+	mu  sync.Mutex
+	off int64
+	buf []byte
+}
+
+// copy copies the bytes starting at off into p, if they are in the buffer,
+// and returns the number of bytes copied.
+func (ra *readAhead) copy(p []byte, off int64) int {
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+	if off < ra.off || off >= ra.off+int64(len(ra.buf)) {
+		return 0
+	}
+	return copy(p, ra.buf[off-ra.off:])
+}
+
+// set replaces the content of the buffer with buf, which starts at off.
+func (ra *readAhead) set(buf []byte, off int64) {
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+	ra.buf = buf
+	ra.off = off
+}
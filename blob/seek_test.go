@@ -0,0 +1,283 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blob_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/memblob"
+	"gocloud.dev/gcerrors"
+)
+
+// testContent returns n bytes of content in which each 4-byte word differs.
+func testContent(n int) []byte {
+	var buf bytes.Buffer
+	for i := 0; buf.Len() < n; i++ {
+		buf.WriteString(string([]byte{byte(i >> 16), byte(i >> 8), byte(i), '.'}))
+	}
+	return buf.Bytes()[:n]
+}
+
+func TestReaderSeek(t *testing.T) {
+	ctx := context.Background()
+	b := memblob.OpenBucket(nil)
+	defer b.Close()
+	content := testContent(100000)
+	if err := b.WriteAll(ctx, "key", content, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name           string
+		offset, length int64
+	}{
+		{"Whole", 0, -1},
+		{"Range", 1000, 90000},
+		{"RangePastEnd", 50000, 100000},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			r, err := b.NewRangeReader(ctx, "key", test.offset, test.length, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			want := content[test.offset:]
+			if test.length >= 0 && test.length < int64(len(want)) {
+				want = want[:test.length]
+			}
+			size := int64(len(want))
+
+			read := func(n int) []byte {
+				t.Helper()
+				p := make([]byte, n)
+				n, err := io.ReadFull(r, p)
+				if err != nil && err != io.ErrUnexpectedEOF {
+					t.Fatal(err)
+				}
+				return p[:n]
+			}
+			seek := func(offset int64, whence int, wantPos int64) {
+				t.Helper()
+				pos, err := r.Seek(offset, whence)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if pos != wantPos {
+					t.Fatalf("Seek(%d, %d): got position %d want %d", offset, whence, pos, wantPos)
+				}
+			}
+
+			if got := read(10); !bytes.Equal(got, want[:10]) {
+				t.Errorf("got %q want %q", got, want[:10])
+			}
+			// Seeking a short distance forward.
+			seek(90, io.SeekCurrent, 100)
+			if got := read(10); !bytes.Equal(got, want[100:110]) {
+				t.Errorf("after short Seek, got %q want %q", got, want[100:110])
+			}
+			// Seeking a long distance forward.
+			seek(40000, io.SeekStart, 40000)
+			if got := read(10); !bytes.Equal(got, want[40000:40010]) {
+				t.Errorf("after long Seek, got %q want %q", got, want[40000:40010])
+			}
+			// Seeking backward.
+			seek(5, io.SeekStart, 5)
+			if got := read(10); !bytes.Equal(got, want[5:15]) {
+				t.Errorf("after backward Seek, got %q want %q", got, want[5:15])
+			}
+			// Seeking relative to the end.
+			seek(-8, io.SeekEnd, size-8)
+			if got := read(100); !bytes.Equal(got, want[size-8:]) {
+				t.Errorf("after Seek from end, got %q want %q", got, want[size-8:])
+			}
+			// Seeking past the end.
+			seek(10, io.SeekEnd, size+10)
+			if n, err := r.Read(make([]byte, 10)); n != 0 || err != io.EOF {
+				t.Errorf("after Seek past end, got %d, %v want 0, io.EOF", n, err)
+			}
+			if _, err := r.Seek(-1, io.SeekStart); gcerrors.Code(err) != gcerrors.InvalidArgument {
+				t.Errorf("Seek to negative position: got error %v want InvalidArgument", err)
+			}
+			// Reading the rest after a Seek.
+			seek(20, io.SeekStart, 20)
+			rest, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(rest, want[20:]) {
+				t.Errorf("after Seek, ReadAll got %d bytes want %d", len(rest), len(want)-20)
+			}
+		})
+	}
+}
+
+func TestReaderReadAt(t *testing.T) {
+	ctx := context.Background()
+	b := memblob.OpenBucket(nil)
+	defer b.Close()
+	content := testContent(100000)
+	if err := b.WriteAll(ctx, "key", content, nil); err != nil {
+		t.Fatal(err)
+	}
+	r, err := b.NewRangeReader(ctx, "key", 10, 90000, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	want := content[10:90010]
+
+	for _, test := range []struct {
+		off, n  int
+		wantN   int
+		wantErr error
+	}{
+		{off: 0, n: 10, wantN: 10},
+		{off: 10, n: 10, wantN: 10}, // from the read-ahead buffer
+		{off: 30000, n: 10000, wantN: 10000},
+		{off: 5, n: 50000, wantN: 50000},
+		{off: 89990, n: 100, wantN: 10, wantErr: io.EOF},
+		{off: 90000, n: 10, wantN: 0, wantErr: io.EOF},
+		{off: 89990, n: 10, wantN: 10},
+	} {
+		p := make([]byte, test.n)
+		n, err := r.ReadAt(p, int64(test.off))
+		if n != test.wantN || err != test.wantErr {
+			t.Errorf("ReadAt(%d bytes, %d): got %d, %v want %d, %v", test.n, test.off, n, err, test.wantN, test.wantErr)
+			continue
+		}
+		if !bytes.Equal(p[:n], want[test.off:test.off+n]) {
+			t.Errorf("ReadAt(%d bytes, %d): got wrong content", test.n, test.off)
+		}
+	}
+	// ReadAt doesn't move the offset used by Read.
+	got := make([]byte, 10)
+	if _, err := io.ReadFull(r, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want[:10]) {
+		t.Errorf("got %q want %q", got, want[:10])
+	}
+}
+
+func TestReaderSeekChangedBlob(t *testing.T) {
+	ctx := context.Background()
+	b := memblob.OpenBucket(nil)
+	defer b.Close()
+	content := testContent(100000)
+	if err := b.WriteAll(ctx, "key", content, nil); err != nil {
+		t.Fatal(err)
+	}
+	r, err := b.NewReader(ctx, "key", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if r.ETag() == "" {
+		t.Fatal("got empty ETag")
+	}
+	if err := b.WriteAll(ctx, "key", testContent(200000)[100000:], nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reads from another range of the blob fail, instead of returning
+	// content from the new version.
+	if _, err := r.Seek(50000, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Read(make([]byte, 10)); gcerrors.Code(err) != gcerrors.FailedPrecondition {
+		t.Errorf("Read after Seek: got error %v, want FailedPrecondition", err)
+	}
+	if _, err := r.ReadAt(make([]byte, 10), 70000); gcerrors.Code(err) != gcerrors.FailedPrecondition {
+		t.Errorf("ReadAt: got error %v, want FailedPrecondition", err)
+	}
+}
+
+func TestReaderOpensZip(t *testing.T) {
+	ctx := context.Background()
+	b := memblob.OpenBucket(nil)
+	defer b.Close()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	files := map[string][]byte{"a.txt": []byte("hello"), "b/c.txt": testContent(50000)}
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.WriteAll(ctx, "archive.zip", buf.Bytes(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := b.NewReader(ctx, "archive.zip", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	zr, err := zip.NewReader(r, r.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != len(files) {
+		t.Fatalf("got %d files want %d", len(zr.File), len(files))
+	}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, files[f.Name]) {
+			t.Errorf("%s: got %d bytes, want %d", f.Name, len(got), len(files[f.Name]))
+		}
+	}
+}
+
+func TestReaderSeekDecompressing(t *testing.T) {
+	ctx := context.Background()
+	b := memblob.OpenBucket(nil)
+	defer b.Close()
+	if err := b.WriteAll(ctx, "key", []byte("hello"), &blob.WriterOptions{Compression: "gzip"}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := b.NewReader(ctx, "key", &blob.ReaderOptions{Decompress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := r.Seek(1, io.SeekStart); gcerrors.Code(err) != gcerrors.Unimplemented {
+		t.Errorf("Seek: got error %v want Unimplemented", err)
+	}
+	if _, err := r.ReadAt(make([]byte, 1), 1); gcerrors.Code(err) != gcerrors.Unimplemented {
+		t.Errorf("ReadAt: got error %v want Unimplemented", err)
+	}
+}
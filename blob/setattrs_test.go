@@ -0,0 +1,159 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blob_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/fileblob"
+	"gocloud.dev/blob/memblob"
+	"gocloud.dev/gcerrors"
+)
+
+func TestSetAttributes(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "go-cloud-blob-setattrs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, test := range []struct {
+		name string
+		open func(t *testing.T) *blob.Bucket
+	}{
+		{"memblob", func(*testing.T) *blob.Bucket { return memblob.OpenBucket(nil) }},
+		{"fileblob", func(t *testing.T) *blob.Bucket {
+			b, err := fileblob.OpenBucket(dir, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return blob.PrefixedBucket(b, "plain/")
+		}},
+		// fileblob implements SetAttributes with Copy when versioning.
+		{"fileblobVersioning", func(t *testing.T) *blob.Bucket {
+			b, err := fileblob.OpenBucket(dir, &fileblob.Options{Versioning: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return blob.PrefixedBucket(b, "versioned/")
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			b := test.open(t)
+			defer b.Close()
+
+			content := []byte("hello world")
+			err := b.WriteAll(ctx, "key", content, &blob.WriterOptions{
+				ContentType:     "text/plain",
+				ContentLanguage: "en",
+				Metadata:        map[string]string{"a": "1"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			before, err := b.Attributes(ctx, "key")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = b.SetAttributes(ctx, "key", blob.AttributesUpdate{
+				CacheControl: "no-cache",
+				ContentType:  "text/html; charset=utf-8",
+				Metadata:     map[string]string{"B": "2"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			after, err := b.Attributes(ctx, "key")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if after.CacheControl != "no-cache" {
+				t.Errorf("got CacheControl %q want %q", after.CacheControl, "no-cache")
+			}
+			if want := "text/html; charset=utf-8"; after.ContentType != want {
+				t.Errorf("got ContentType %q want %q", after.ContentType, want)
+			}
+			if after.ContentLanguage != "en" {
+				t.Errorf("got ContentLanguage %q, want it unchanged", after.ContentLanguage)
+			}
+			if diff := cmp.Diff(after.Metadata, map[string]string{"b": "2"}); diff != "" {
+				t.Errorf("got metadata diff (-got +want):\n%s", diff)
+			}
+			if !bytes.Equal(after.MD5, before.MD5) {
+				t.Errorf("got MD5 %x want %x", after.MD5, before.MD5)
+			}
+			if after.ETag == before.ETag {
+				t.Errorf("got unchanged ETag %q", after.ETag)
+			}
+			got, err := b.ReadAll(ctx, "key")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Errorf("got content %q want %q", got, content)
+			}
+
+			// An update without Metadata keeps it.
+			if err := b.SetAttributes(ctx, "key", blob.AttributesUpdate{ContentDisposition: "inline"}); err != nil {
+				t.Fatal(err)
+			}
+			after, err = b.Attributes(ctx, "key")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if after.ContentDisposition != "inline" || after.Metadata["b"] != "2" {
+				t.Errorf("got ContentDisposition %q, metadata %v", after.ContentDisposition, after.Metadata)
+			}
+
+			// Copy can change the attributes of the copy.
+			err = b.Copy(ctx, "copy", "key", &blob.CopyOptions{
+				Attributes: &blob.AttributesUpdate{ContentType: "application/json", Metadata: map[string]string{}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			copied, err := b.Attributes(ctx, "copy")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if copied.ContentType != "application/json" || len(copied.Metadata) != 0 || copied.CacheControl != "no-cache" {
+				t.Errorf("got copy ContentType %q, CacheControl %q, metadata %v", copied.ContentType, copied.CacheControl, copied.Metadata)
+			}
+
+			for _, test := range []struct {
+				name   string
+				key    string
+				update blob.AttributesUpdate
+				want   gcerrors.ErrorCode
+			}{
+				{"NotFound", "missing", blob.AttributesUpdate{ContentType: "text/plain"}, gcerrors.NotFound},
+				{"BadContentType", "key", blob.AttributesUpdate{ContentType: "not a type"}, gcerrors.InvalidArgument},
+				{"BadMetadata", "key", blob.AttributesUpdate{Metadata: map[string]string{"a": "\xff"}}, gcerrors.InvalidArgument},
+			} {
+				if err := b.SetAttributes(ctx, test.key, test.update); gcerrors.Code(err) != test.want {
+					t.Errorf("%s: got error %v want code %v", test.name, err, test.want)
+				}
+			}
+		})
+	}
+}
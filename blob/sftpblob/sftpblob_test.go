@@ -0,0 +1,315 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sftpblob
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/driver"
+	"gocloud.dev/blob/drivertest"
+	"gocloud.dev/blob/fileblob"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// newPipeClient returns a client connected to an SFTP server running in
+// the process, which serves the local filesystem.
+func newPipeClient(t *testing.T) *sftp.Client {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	server, err := sftp.NewServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{sr, sw})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		// The client's Close waits for the server to close its side.
+		server.Serve()
+		sw.Close()
+	}()
+	client, err := sftp.NewClientPipe(cr, cw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+type harness struct {
+	dir    string
+	client *sftp.Client
+}
+
+func newHarness(ctx context.Context, t *testing.T) (drivertest.Harness, error) {
+	dir, err := ioutil.TempDir("", "go-cloud-sftpblob")
+	if err != nil {
+		return nil, err
+	}
+	return &harness{dir: filepath.ToSlash(dir), client: newPipeClient(t)}, nil
+}
+
+func (h *harness) HTTPClient() *http.Client {
+	return nil
+}
+
+func (h *harness) MakeDriver(ctx context.Context) (driver.Bucket, error) {
+	return openBucket(h.client, h.dir, nil)
+}
+
+func (h *harness) Close() {
+	h.client.Close()
+	_ = os.RemoveAll(h.dir)
+}
+
+func TestConformance(t *testing.T) {
+	drivertest.RunConformanceTests(t, newHarness, []drivertest.AsTest{verifyAs{}})
+}
+
+type verifyAs struct{}
+
+func (verifyAs) Name() string { return "verify As types for sftpblob" }
+
+func (verifyAs) BucketCheck(b *blob.Bucket) error {
+	var c *sftp.Client
+	if !b.As(&c) {
+		return errors.New("Bucket.As failed")
+	}
+	return nil
+}
+func (verifyAs) BeforeWrite(as func(interface{}) bool) error { return nil }
+func (verifyAs) BeforeCopy(as func(interface{}) bool) error  { return nil }
+func (verifyAs) BeforeList(as func(interface{}) bool) error  { return nil }
+func (verifyAs) AttributesCheck(attrs *blob.Attributes) error {
+	return nil
+}
+func (verifyAs) ReaderCheck(r *blob.Reader) error {
+	return nil
+}
+func (verifyAs) ListObjectCheck(o *blob.ListObject) error {
+	return nil
+}
+func (verifyAs) ErrorCheck(b *blob.Bucket, err error) error {
+	// Missing files are reported as os.ErrNotExist, not as a
+	// *sftp.StatusError.
+	var serr *sftp.StatusError
+	if b.ErrorAs(err, &serr) {
+		return errors.New("want ErrorAs to fail")
+	}
+	return nil
+}
+
+// TestFileblobLayout checks that blobs written by sftpblob can be read by
+// fileblob.
+func TestFileblobLayout(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "go-cloud-sftpblob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	client := newPipeClient(t)
+	defer client.Close()
+	b, err := OpenBucket(client, filepath.ToSlash(dir), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	opts := &blob.WriterOptions{ContentType: "text/plain", Metadata: map[string]string{"k": "v"}}
+	if err := b.WriteAll(ctx, "dir/a.txt", []byte("hello"), opts); err != nil {
+		t.Fatal(err)
+	}
+	want, err := b.Attributes(ctx, "dir/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fb, err := fileblob.OpenBucket(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fb.Close()
+	got, err := fb.Attributes(ctx, "dir/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ContentType != want.ContentType || got.Metadata["k"] != "v" || got.ETag != want.ETag || string(got.MD5) != string(want.MD5) {
+		t.Errorf("got fileblob attributes %+v, want %+v", got, want)
+	}
+	// Nothing else is left in the directory.
+	infos, err := ioutil.ReadDir(filepath.Join(dir, "dir"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 {
+		t.Errorf("got %d files, want the blob and its attributes", len(infos))
+	}
+}
+
+// startSSHServer starts an SSH server on a local port, serving the local
+// filesystem over SFTP to the user "user" with the password "pass". It
+// returns the server's address and host key.
+func startSSHServer(t *testing.T) (string, ssh.PublicKey, func()) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() == "user" && string(pass) == "pass" {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("password rejected for %q", c.User())
+		},
+	}
+	config.AddHostKey(signer)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			nc, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveSSH(nc, config)
+		}
+	}()
+	return l.Addr().String(), signer.PublicKey(), func() { l.Close() }
+}
+
+// serveSSH serves the "sftp" subsystem on nc.
+func serveSSH(nc net.Conn, config *ssh.ServerConfig) {
+	conn, chans, reqs, err := ssh.NewServerConn(nc, config)
+	if err != nil {
+		nc.Close()
+		return
+	}
+	defer conn.Close()
+	go ssh.DiscardRequests(reqs)
+	for nch := range chans {
+		if nch.ChannelType() != "session" {
+			nch.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		ch, chReqs, err := nch.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range chReqs {
+				// The payload of a subsystem request is the subsystem's name,
+				// as an SSH string.
+				ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if !ok {
+					continue
+				}
+				go func() {
+					defer ch.Close()
+					server, err := sftp.NewServer(ch)
+					if err != nil {
+						return
+					}
+					server.Serve()
+				}()
+			}
+		}()
+	}
+}
+
+func TestOpenBucketFromURL(t *testing.T) {
+	addr, hostKey, stop := startSSHServer(t)
+	defer stop()
+	dir, err := ioutil.TempDir("", "go-cloud-sftpblob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	knownHosts := filepath.Join(dir, "known_hosts")
+	if err := ioutil.WriteFile(knownHosts, []byte(knownhosts.Line([]string{addr}, hostKey)+"\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	otherHosts := filepath.Join(dir, "other_hosts")
+	if err := ioutil.WriteFile(otherHosts, nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	bucketDir := filepath.Join(dir, "bucket")
+	if err := os.Mkdir(bucketDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(bucketDir, "myfile.txt"), []byte("hello world"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	base := "sftp://user:pass@" + addr + filepath.ToSlash(bucketDir)
+	ctx := context.Background()
+	for _, test := range []struct {
+		url     string
+		wantErr bool
+	}{
+		// OK, checking the host key.
+		{base + "?known_hosts=" + url.QueryEscape(knownHosts), false},
+		// OK, ignoring the host key.
+		{base + "?insecure_ignore_host_key=true", false},
+		// Unknown host key.
+		{base + "?known_hosts=" + url.QueryEscape(otherHosts), true},
+		// Wrong password.
+		{"sftp://user:wrong@" + addr + filepath.ToSlash(bucketDir) + "?insecure_ignore_host_key=true", true},
+		// Missing directory.
+		{base + "/missing?insecure_ignore_host_key=true", true},
+		// Invalid parameter.
+		{base + "?param=value", true},
+		// Invalid value for insecure_ignore_host_key.
+		{base + "?insecure_ignore_host_key=maybe", true},
+	} {
+		b, err := blob.OpenBucket(ctx, test.url)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: got error %v, want error %v", test.url, err, test.wantErr)
+		}
+		if err != nil {
+			continue
+		}
+		got, err := b.ReadAll(ctx, "myfile.txt")
+		if err != nil {
+			t.Errorf("%s: %v", test.url, err)
+		} else if string(got) != "hello world" {
+			t.Errorf("%s: got %q want %q", test.url, got, "hello world")
+		}
+		if err := b.Close(); err != nil {
+			t.Error(err)
+		}
+	}
+}
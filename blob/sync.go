@@ -0,0 +1,267 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blob
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"gocloud.dev/internal/gcerr"
+)
+
+// CopyBetween copies the blob stored at srcKey in src to dstKey in dst. The
+// buckets may use different providers; the blob's content is streamed from
+// src to dst. The blob's ContentType, ContentEncoding, CacheControl,
+// ContentDisposition, ContentLanguage and Metadata are preserved, and the
+// checksums that src reports for it are used to verify the copy.
+//
+// If dst and src are the same *Bucket, CopyBetween uses Copy.
+//
+// If the source blob does not exist, CopyBetween returns an error for which
+// gcerrors.Code will return gcerrors.NotFound. If the destination blob
+// already exists, it is overwritten.
+func CopyBetween(ctx context.Context, dst, src *Bucket, dstKey, srcKey string) error {
+	if dst == src {
+		return dst.Copy(ctx, dstKey, srcKey, nil)
+	}
+	attrs, err := src.Attributes(ctx, srcKey)
+	if err != nil {
+		return err
+	}
+	r, err := src.NewReader(ctx, srcKey, nil)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	opts := &WriterOptions{
+		CacheControl:       attrs.CacheControl,
+		ContentDisposition: attrs.ContentDisposition,
+		ContentEncoding:    attrs.ContentEncoding,
+		ContentLanguage:    attrs.ContentLanguage,
+		ContentType:        attrs.ContentType,
+		Metadata:           attrs.Metadata,
+	}
+	// Some providers transform the content when it is read, like GCS does for
+	// gzip-encoded blobs; the checksums only apply to the stored content.
+	if r.Size() == attrs.Size {
+		opts.ContentMD5 = attrs.MD5
+		opts.ContentCRC32C = attrs.CRC32C
+		opts.ContentSHA256 = attrs.SHA256
+	}
+
+	// Create a cancelable context so we can abort the write if reading fails.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w, err := dst.NewWriter(ctx, dstKey, opts)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		cancel() // cancel before Close cancels the write
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// SyncOptions sets options for Sync.
+type SyncOptions struct {
+	// DryRun, if true, makes Sync report the blobs it would copy and delete
+	// without copying or deleting them.
+	DryRun bool
+
+	// DeleteExtraneous, if true, makes Sync delete the blobs under the
+	// destination prefix that don't exist under the source prefix.
+	DeleteExtraneous bool
+
+	// Concurrency is the maximum number of blobs that are copied
+	// concurrently. If 0, DefaultTransferConcurrency is used.
+	Concurrency int
+}
+
+// SyncResult describes the changes made by Sync. Keys are relative to the
+// prefixes passed to Sync, and sorted.
+type SyncResult struct {
+	// Copied holds the keys of the blobs that were copied.
+	Copied []string
+	// Deleted holds the keys of the blobs that were deleted from the
+	// destination.
+	Deleted []string
+	// Unchanged is the number of blobs that were already up to date.
+	Unchanged int
+}
+
+// Sync makes the blobs under dstPrefix in dst mirror the blobs under
+// srcPrefix in src, using CopyBetween to copy each blob whose key starts with
+// srcPrefix to the same key with dstPrefix instead. A nil SyncOptions is
+// treated the same as the zero value.
+//
+// A blob is only copied if it is missing or out of date in dst. A
+// destination blob is up to date if it has the same size as the source
+// blob, and either the same MD5 hash or, if either bucket doesn't report MD5
+// hashes, a modification time that isn't before the source blob's.
+//
+// The prefixes must not overlap if dst and src are the same *Bucket.
+//
+// Sync stops at the first error, and returns it with a SyncResult
+// describing the changes that were already made.
+func Sync(ctx context.Context, dst, src *Bucket, dstPrefix, srcPrefix string, opts *SyncOptions) (*SyncResult, error) {
+	if dst == src && (strings.HasPrefix(dstPrefix, srcPrefix) || strings.HasPrefix(srcPrefix, dstPrefix)) {
+		return nil, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: Sync prefixes %q and %q in the same bucket must not overlap", dstPrefix, srcPrefix)
+	}
+	if opts == nil {
+		opts = &SyncOptions{}
+	}
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultTransferConcurrency
+	}
+	res := &SyncResult{}
+
+	// Collect the existing destination blobs, by their relative keys.
+	existing := map[string]*ListObject{}
+	iter := dst.List(&ListOptions{Prefix: dstPrefix})
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return res, err
+		}
+		existing[strings.TrimPrefix(obj.Key, dstPrefix)] = obj
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	keys := make(chan string)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range keys {
+				if err := CopyBetween(ctx, dst, src, dstPrefix+key, srcPrefix+key); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+					cancel()
+					continue
+				}
+				mu.Lock()
+				res.Copied = append(res.Copied, key)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	// Copy the source blobs that are missing or out of date.
+	var listErr error
+	iter = src.List(&ListOptions{Prefix: srcPrefix})
+feed:
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			listErr = err
+			break
+		}
+		key := strings.TrimPrefix(obj.Key, srcPrefix)
+		dobj := existing[key]
+		delete(existing, key)
+		if dobj != nil && upToDate(dobj, obj) {
+			res.Unchanged++
+			continue
+		}
+		if opts.DryRun {
+			res.Copied = append(res.Copied, key)
+			continue
+		}
+		select {
+		case keys <- key:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(keys)
+	wg.Wait()
+	sort.Strings(res.Copied)
+	if firstErr != nil {
+		return res, firstErr
+	}
+	if listErr != nil {
+		return res, listErr
+	}
+	if err := ctx.Err(); err != nil {
+		return res, err
+	}
+
+	// The destination blobs that are left don't exist in src.
+	if !opts.DeleteExtraneous || len(existing) == 0 {
+		return res, nil
+	}
+	var extraneous []string
+	for key := range existing {
+		extraneous = append(extraneous, key)
+	}
+	sort.Strings(extraneous)
+	if opts.DryRun {
+		res.Deleted = extraneous
+		return res, nil
+	}
+	dstKeys := make([]string, len(extraneous))
+	for i, key := range extraneous {
+		dstKeys[i] = dstPrefix + key
+	}
+	err := dst.DeleteMany(ctx, dstKeys)
+	failed := map[string]bool{}
+	if dmerr, ok := err.(DeleteManyError); ok {
+		for _, e := range dmerr {
+			failed[strings.TrimPrefix(e.Key, dstPrefix)] = true
+		}
+	} else if err != nil {
+		return res, err
+	}
+	for _, key := range extraneous {
+		if !failed[key] {
+			res.Deleted = append(res.Deleted, key)
+		}
+	}
+	return res, err
+}
+
+// upToDate reports whether dst, a blob in the destination of Sync, doesn't
+// need to be replaced by src.
+func upToDate(dst, src *ListObject) bool {
+	if dst.Size != src.Size {
+		return false
+	}
+	if len(dst.MD5) > 0 && len(src.MD5) > 0 {
+		return bytes.Equal(dst.MD5, src.MD5)
+	}
+	return !dst.ModTime.Before(src.ModTime)
+}
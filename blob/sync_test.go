@@ -0,0 +1,168 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blob_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/fileblob"
+	"gocloud.dev/blob/memblob"
+	"gocloud.dev/gcerrors"
+)
+
+func TestCopyBetween(t *testing.T) {
+	ctx := context.Background()
+	src := memblob.OpenBucket(nil)
+	defer src.Close()
+	dir, err := ioutil.TempDir("", "go-cloud-blob-copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dst, err := fileblob.OpenBucket(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	opts := &blob.WriterOptions{
+		CacheControl:       "no-cache",
+		ContentDisposition: "inline",
+		ContentEncoding:    "identity",
+		ContentLanguage:    "nl",
+		ContentType:        "text/plain",
+		Metadata:           map[string]string{"foo": "bar"},
+	}
+	if err := src.WriteAll(ctx, "a", []byte("hello"), opts); err != nil {
+		t.Fatal(err)
+	}
+	if err := blob.CopyBetween(ctx, dst, src, "b", "a"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := dst.ReadAll(ctx, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello" {
+		t.Errorf("got %q want %q", got, "hello")
+	}
+	srcAttrs, err := src.Attributes(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dstAttrs, err := dst.Attributes(ctx, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct{ name, got, want string }{
+		{"CacheControl", dstAttrs.CacheControl, opts.CacheControl},
+		{"ContentDisposition", dstAttrs.ContentDisposition, opts.ContentDisposition},
+		{"ContentEncoding", dstAttrs.ContentEncoding, opts.ContentEncoding},
+		{"ContentLanguage", dstAttrs.ContentLanguage, opts.ContentLanguage},
+		{"ContentType", dstAttrs.ContentType, opts.ContentType},
+	} {
+		if c.got != c.want {
+			t.Errorf("%s: got %q want %q", c.name, c.got, c.want)
+		}
+	}
+	if !cmp.Equal(dstAttrs.Metadata, opts.Metadata) {
+		t.Errorf("Metadata: got %v want %v", dstAttrs.Metadata, opts.Metadata)
+	}
+	if !cmp.Equal(dstAttrs.MD5, srcAttrs.MD5) {
+		t.Errorf("MD5: got %x want %x", dstAttrs.MD5, srcAttrs.MD5)
+	}
+
+	if err := blob.CopyBetween(ctx, dst, src, "b", "missing"); gcerrors.Code(err) != gcerrors.NotFound {
+		t.Errorf("got error %v for a missing blob, want NotFound", err)
+	}
+}
+
+func TestSync(t *testing.T) {
+	ctx := context.Background()
+	src := memblob.OpenBucket(nil)
+	defer src.Close()
+	dst := memblob.OpenBucket(nil)
+	defer dst.Close()
+
+	write := func(b *blob.Bucket, key, content string) {
+		t.Helper()
+		if err := b.WriteAll(ctx, key, []byte(content), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(src, "src/a", "a")
+	write(src, "src/dir/b", "b")
+	write(src, "src/c", "c")
+	write(src, "other", "other")
+	write(dst, "dst/c", "old c")
+	write(dst, "dst/extra", "extra")
+	write(dst, "keep", "keep")
+
+	// A dry run reports the changes without making them.
+	opts := &blob.SyncOptions{DryRun: true, DeleteExtraneous: true}
+	res, err := blob.Sync(ctx, dst, src, "dst/", "src/", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &blob.SyncResult{Copied: []string{"a", "c", "dir/b"}, Deleted: []string{"extra"}}
+	if !cmp.Equal(res, want) {
+		t.Errorf("dry run: got %+v want %+v", res, want)
+	}
+	if got, want := listKeys(t, dst, nil), []string{"dst/c", "dst/extra", "keep"}; !cmp.Equal(got, want) {
+		t.Errorf("after dry run, got keys %v want %v", got, want)
+	}
+
+	// Without DeleteExtraneous, extra blobs are kept.
+	res, err = blob.Sync(ctx, dst, src, "dst/", "src/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = &blob.SyncResult{Copied: []string{"a", "c", "dir/b"}}
+	if !cmp.Equal(res, want) {
+		t.Errorf("got %+v want %+v", res, want)
+	}
+	got, err := dst.ReadAll(ctx, "dst/c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "c" {
+		t.Errorf("got %q want %q", got, "c")
+	}
+
+	// Only changed blobs are copied.
+	write(src, "src/a", "new a")
+	opts = &blob.SyncOptions{DeleteExtraneous: true}
+	res, err = blob.Sync(ctx, dst, src, "dst/", "src/", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = &blob.SyncResult{Copied: []string{"a"}, Deleted: []string{"extra"}, Unchanged: 2}
+	if !cmp.Equal(res, want) {
+		t.Errorf("got %+v want %+v", res, want)
+	}
+	if got, want := listKeys(t, dst, nil), []string{"dst/a", "dst/c", "dst/dir/b", "keep"}; !cmp.Equal(got, want) {
+		t.Errorf("got keys %v want %v", got, want)
+	}
+
+	// Prefixes in the same bucket can't overlap.
+	if _, err := blob.Sync(ctx, src, src, "src/copy/", "src/", nil); gcerrors.Code(err) != gcerrors.InvalidArgument {
+		t.Errorf("got error %v for overlapping prefixes, want InvalidArgument", err)
+	}
+}
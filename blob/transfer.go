@@ -0,0 +1,330 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blob
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	gax "github.com/googleapis/gax-go"
+	"gocloud.dev/gcerrors"
+	"gocloud.dev/internal/gcerr"
+)
+
+const (
+	// DefaultTransferPartSize is the default part size for Download and
+	// Upload.
+	DefaultTransferPartSize = 8 * 1024 * 1024
+	// DefaultTransferConcurrency is the default number of parts that Download
+	// and Upload transfer concurrently.
+	DefaultTransferConcurrency = 5
+	// DefaultTransferMaxRetries is the default number of times Download and
+	// Upload retry a failed transfer.
+	DefaultTransferMaxRetries = 3
+)
+
+// DownloadOptions sets options for Download.
+type DownloadOptions struct {
+	// PartSize is the size in bytes of the ranges of the blob that are read
+	// concurrently. If 0, DefaultTransferPartSize is used.
+	PartSize int64
+
+	// Concurrency is the maximum number of ranges that are read concurrently.
+	// If 0, DefaultTransferConcurrency is used.
+	Concurrency int
+
+	// MaxRetries is the number of times reading a range is retried after it
+	// fails. If 0, DefaultTransferMaxRetries is used; if negative, failed
+	// ranges are not retried.
+	MaxRetries int
+
+	// ReaderOptions are passed to NewRangeReader for each range. Their
+	// Progress callback may be called concurrently, and is called again for
+	// ranges that are retried. Unless IfMatch is set to a specific ETag,
+	// Download sets it to the blob's ETag.
+	ReaderOptions *ReaderOptions
+}
+
+// Download reads the blob stored at key into w, reading ranges of the blob
+// concurrently. The blob is written to w at offsets starting at 0.
+// A nil DownloadOptions is treated the same as the zero value.
+//
+// If the blob is modified while Download is in progress, Download returns an
+// error for which gcerrors.Code will return gcerrors.FailedPrecondition.
+// The ranges are read with ReaderOptions.IfMatch set to the blob's ETag; for
+// providers that don't support it, a modification is only detected if it
+// changes the blob's size or modification time.
+// If Download returns an error, some ranges may have been written to w.
+func (b *Bucket) Download(ctx context.Context, key string, w io.WriterAt, opts *DownloadOptions) error {
+	if opts == nil {
+		opts = &DownloadOptions{}
+	}
+	partSize := opts.PartSize
+	if partSize <= 0 {
+		partSize = DefaultTransferPartSize
+	}
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultTransferConcurrency
+	}
+	attrs, err := b.Attributes(ctx, key)
+	if err != nil {
+		return err
+	}
+	if n := (attrs.Size + partSize - 1) / partSize; n < int64(concurrency) {
+		concurrency = int(n)
+	}
+	d := &downloader{b: b, key: key, w: w, attrs: attrs, unpinned: opts.ReaderOptions}
+	d.pinned = d.unpinned
+	if attrs.ETag != "" && (d.unpinned == nil || d.unpinned.IfMatch == "" || d.unpinned.IfMatch == "*") {
+		ropts := ReaderOptions{}
+		if d.unpinned != nil {
+			ropts = *d.unpinned
+		}
+		ropts.IfMatch = attrs.ETag
+		d.pinned = &ropts
+	} else {
+		d.noConditions = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	offsets := make(chan int64)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, partSize)
+			for off := range offsets {
+				if ctx.Err() != nil {
+					// Another part failed; drain the remaining offsets.
+					continue
+				}
+				length := partSize
+				if off+length > attrs.Size {
+					length = attrs.Size - off
+				}
+				err := withRetries(ctx, opts.MaxRetries, func() error {
+					return d.downloadRange(ctx, off, buf[:length])
+				})
+				if err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+					cancel()
+				}
+			}
+		}()
+	}
+feed:
+	for off := int64(0); off < attrs.Size; off += partSize {
+		select {
+		case offsets <- off:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(offsets)
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	// Report cancellation of the caller's context, if we stopped early.
+	return ctx.Err()
+}
+
+// downloader reads the ranges of a blob for Download.
+type downloader struct {
+	b     *Bucket
+	key   string
+	w     io.WriterAt
+	attrs *Attributes
+	// pinned are the ReaderOptions with IfMatch set to attrs.ETag, and
+	// unpinned those passed to Download.
+	pinned, unpinned *ReaderOptions
+	// noConditions is set to 1, atomically, if the reads can't be pinned to
+	// attrs.ETag.
+	noConditions int32
+}
+
+// downloadRange reads len(buf) bytes of the blob starting at off, and writes
+// them to d.w. It fails if the blob no longer matches d.attrs.
+func (d *downloader) downloadRange(ctx context.Context, off int64, buf []byte) error {
+	r, err := d.newRangeReader(ctx, off, int64(len(buf)))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return err
+	}
+	_, err = d.w.WriteAt(buf, off)
+	return err
+}
+
+// newRangeReader opens a reader for a range of the blob, pinned to
+// d.attrs.ETag if possible.
+func (d *downloader) newRangeReader(ctx context.Context, off, length int64) (*Reader, error) {
+	if atomic.LoadInt32(&d.noConditions) == 0 {
+		r, err := d.b.NewRangeReader(ctx, d.key, off, length, d.pinned)
+		if gcerrors.Code(err) != gcerrors.Unimplemented {
+			return r, err
+		}
+		// The provider doesn't support conditional reads.
+		atomic.StoreInt32(&d.noConditions, 1)
+	}
+	r, err := d.b.NewRangeReader(ctx, d.key, off, length, d.unpinned)
+	if err != nil {
+		return nil, err
+	}
+	// Detect modifications as well as we can without an ETag.
+	attrs := d.attrs
+	if r.Size() != attrs.Size || (!attrs.ModTime.IsZero() && !r.ModTime().IsZero() && !r.ModTime().Equal(attrs.ModTime)) {
+		r.Close()
+		return nil, gcerr.Newf(gcerr.FailedPrecondition, nil, "blob: %q was modified during Download", d.key)
+	}
+	return r, nil
+}
+
+// UploadOptions sets options for Upload.
+type UploadOptions struct {
+	// PartSize is the size in bytes of the chunks that are uploaded in a
+	// single request, for providers that upload blobs in parts. It overrides
+	// WriterOptions.BufferSize. If 0, DefaultTransferPartSize is used.
+	PartSize int
+
+	// Concurrency is the maximum number of chunks that are uploaded
+	// concurrently, for providers that upload blobs in parts. It overrides
+	// WriterOptions.MaxConcurrency. If 0, DefaultTransferConcurrency is used.
+	Concurrency int
+
+	// MaxRetries is the number of times the upload is retried after it fails.
+	// Since a blob is written as a single stream, retrying requires reading
+	// r again from the start, so r must implement io.Seeker; if it doesn't,
+	// Upload fails when MaxRetries is positive. If 0, DefaultTransferMaxRetries
+	// is used if r implements io.Seeker, and failed uploads are not retried
+	// otherwise. If negative, failed uploads are not retried.
+	MaxRetries int
+
+	// WriterOptions are passed to NewWriter.
+	WriterOptions *WriterOptions
+}
+
+// Upload writes the contents of r to the blob stored at key, using a single
+// Writer. Provider implementations that upload blobs in parts upload chunks
+// of opts.PartSize, up to opts.Concurrency of them concurrently; see
+// WriterOptions.BufferSize and WriterOptions.MaxConcurrency. Others, like
+// memblob and fileblob, write the blob as a stream.
+// A nil UploadOptions is treated the same as the zero value.
+//
+// If the upload fails and r implements io.Seeker, r is rewound to its
+// original offset and the whole upload is retried; see
+// UploadOptions.MaxRetries.
+func (b *Bucket) Upload(ctx context.Context, key string, r io.Reader, opts *UploadOptions) error {
+	if opts == nil {
+		opts = &UploadOptions{}
+	}
+	var wopts WriterOptions
+	if opts.WriterOptions != nil {
+		wopts = *opts.WriterOptions
+	}
+	wopts.BufferSize = opts.PartSize
+	if wopts.BufferSize <= 0 {
+		wopts.BufferSize = DefaultTransferPartSize
+	}
+	wopts.MaxConcurrency = opts.Concurrency
+	if wopts.MaxConcurrency <= 0 {
+		wopts.MaxConcurrency = DefaultTransferConcurrency
+	}
+
+	maxRetries := -1
+	seeker, ok := r.(io.Seeker)
+	var start int64
+	if ok {
+		var err error
+		if start, err = seeker.Seek(0, io.SeekCurrent); err == nil {
+			maxRetries = opts.MaxRetries
+		} else {
+			ok = false
+		}
+	}
+	if !ok && opts.MaxRetries > 0 {
+		return gcerr.Newf(gcerr.InvalidArgument, nil, "blob: Upload can't retry since the reader can't be rewound; set UploadOptions.MaxRetries to 0 or less")
+	}
+	attempt := 0
+	return withRetries(ctx, maxRetries, func() error {
+		if attempt++; attempt > 1 {
+			if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+				return err
+			}
+		}
+		return b.upload(ctx, key, r, &wopts)
+	})
+}
+
+// upload writes the contents of r to key in a single Writer.
+func (b *Bucket) upload(ctx context.Context, key string, r io.Reader, opts *WriterOptions) error {
+	// Create a cancelable context so we can abort the write if reading fails.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w, err := b.NewWriter(ctx, key, opts)
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyBuffer(w, r, make([]byte, opts.BufferSize)); err != nil {
+		cancel() // cancel before Close cancels the write
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// transferBackoff is the backoff between the retries of withRetries.
+var transferBackoff = gax.Backoff{Initial: 100 * time.Millisecond, Max: 10 * time.Second, Multiplier: 2}
+
+// withRetries calls f until it succeeds, returns an error that isn't worth
+// retrying, or has been retried maxRetries times. It waits before each retry,
+// with exponential backoff and jitter; if ctx is done while waiting, it
+// returns the last error from f.
+func withRetries(ctx context.Context, maxRetries int, f func() error) error {
+	if maxRetries == 0 {
+		maxRetries = DefaultTransferMaxRetries
+	}
+	bo := transferBackoff
+	for i := 0; ; i++ {
+		err := f()
+		if err == nil || i >= maxRetries || ctx.Err() != nil {
+			return err
+		}
+		switch gcerrors.Code(err) {
+		case gcerrors.NotFound, gcerrors.FailedPrecondition, gcerrors.InvalidArgument,
+			gcerrors.PermissionDenied, gcerrors.Unimplemented, gcerrors.Canceled:
+			return err
+		}
+		if gax.Sleep(ctx, bo.Pause()) != nil {
+			return err
+		}
+	}
+}
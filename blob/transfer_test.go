@@ -0,0 +1,311 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blob
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"gocloud.dev/blob/driver"
+	"gocloud.dev/gcerrors"
+)
+
+var (
+	errFakeModified     = errors.New("fake: ETag doesn't match")
+	errFakeNoConditions = errors.New("fake: conditional reads are not supported")
+)
+
+// fakeTransferBucket implements driver.Bucket for a single blob, with
+// Attributes, NewRangeReader and NewTypedWriter. The first failReads range
+// reads and failWrites writes fail.
+type fakeTransferBucket struct {
+	driver.Bucket
+
+	mu         sync.Mutex
+	data       []byte
+	modTime    time.Time
+	etag       string
+	failReads  int
+	failWrites int
+	reads      int
+	// If set, range readers report a different size than Attributes, and
+	// reads with IfMatch fail.
+	modified bool
+	// If set, reads with IfMatch fail, but range readers report the same
+	// size as Attributes.
+	replaced bool
+	// If set, reads with IfMatch are rejected as unimplemented.
+	noConditions bool
+}
+
+func (b *fakeTransferBucket) Attributes(ctx context.Context, key string) (*driver.Attributes, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return &driver.Attributes{Size: int64(len(b.data)), ModTime: b.modTime, ETag: b.etag}, nil
+}
+
+func (b *fakeTransferBucket) NewRangeReader(ctx context.Context, key string, offset, length int64, opts *driver.ReaderOptions) (driver.Reader, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.reads++
+	if b.failReads > 0 {
+		b.failReads--
+		return nil, errFake
+	}
+	if opts.IfMatch != "" {
+		if b.noConditions {
+			return nil, errFakeNoConditions
+		}
+		if b.modified || b.replaced || opts.IfMatch != b.etag {
+			return nil, errFakeModified
+		}
+	}
+	size := int64(len(b.data))
+	if b.modified {
+		size++
+	}
+	return &fakeTransferReader{
+		r:     bytes.NewReader(b.data[offset : offset+length]),
+		attrs: driver.ReaderAttributes{Size: size, ModTime: b.modTime},
+	}, nil
+}
+
+func (b *fakeTransferBucket) NewTypedWriter(ctx context.Context, key string, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
+	return &fakeTransferWriter{ctx: ctx, b: b}, nil
+}
+
+func (b *fakeTransferBucket) ErrorCode(err error) gcerrors.ErrorCode {
+	switch err {
+	case errFakeModified:
+		return gcerrors.FailedPrecondition
+	case errFakeNoConditions:
+		return gcerrors.Unimplemented
+	}
+	return gcerrors.Unknown
+}
+
+type fakeTransferReader struct {
+	driver.Reader
+	r     io.Reader
+	attrs driver.ReaderAttributes
+}
+
+func (r *fakeTransferReader) Read(p []byte) (int, error)           { return r.r.Read(p) }
+func (r *fakeTransferReader) Close() error                         { return nil }
+func (r *fakeTransferReader) Attributes() *driver.ReaderAttributes { return &r.attrs }
+
+type fakeTransferWriter struct {
+	ctx context.Context
+	b   *fakeTransferBucket
+	buf bytes.Buffer
+}
+
+func (w *fakeTransferWriter) Write(p []byte) (int, error) { return w.buf.Write(p) }
+
+func (w *fakeTransferWriter) Close() error {
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	w.b.mu.Lock()
+	defer w.b.mu.Unlock()
+	if w.b.failWrites > 0 {
+		w.b.failWrites--
+		return errFake
+	}
+	w.b.data = w.buf.Bytes()
+	return nil
+}
+
+// writerAtBuffer is an in-memory io.WriterAt.
+type writerAtBuffer struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (w *writerAtBuffer) WriteAt(p []byte, off int64) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if n := int(off) + len(p); n > len(w.buf) {
+		w.buf = append(w.buf, make([]byte, n-len(w.buf))...)
+	}
+	copy(w.buf[off:], p)
+	return len(p), nil
+}
+
+func TestDownload(t *testing.T) {
+	const partSize = 10
+	ctx := context.Background()
+
+	for _, size := range []int{0, 1, partSize - 1, partSize, partSize + 1, 10*partSize + 3} {
+		data := make([]byte, size)
+		for i := range data {
+			data[i] = byte(i)
+		}
+		drv := &fakeTransferBucket{data: data, modTime: time.Now(), etag: "etag"}
+		b := NewBucket(drv)
+		var w writerAtBuffer
+		if err := b.Download(ctx, "key", &w, &DownloadOptions{PartSize: partSize, Concurrency: 3}); err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if !bytes.Equal(w.buf, data) {
+			t.Errorf("size %d: got %v want %v", size, w.buf, data)
+		}
+		if want := (size + partSize - 1) / partSize; drv.reads != want {
+			t.Errorf("size %d: got %d range reads want %d", size, drv.reads, want)
+		}
+	}
+}
+
+func TestDownloadRetries(t *testing.T) {
+	ctx := context.Background()
+	data := []byte("0123456789abcdefghij")
+
+	tests := []struct {
+		description string
+		failReads   int
+		maxRetries  int
+		wantErr     bool
+	}{
+		{"default retries", 3, 0, false},
+		{"not enough retries", 3, 1, true},
+		{"no retries", 1, -1, true},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			drv := &fakeTransferBucket{data: data, failReads: test.failReads}
+			b := NewBucket(drv)
+			var w writerAtBuffer
+			// With a single worker, the first part absorbs all of the failures.
+			err := b.Download(ctx, "key", &w, &DownloadOptions{PartSize: 5, Concurrency: 1, MaxRetries: test.maxRetries})
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v want error %v", err, test.wantErr)
+			}
+			if err == nil && !bytes.Equal(w.buf, data) {
+				t.Errorf("got %q want %q", w.buf, data)
+			}
+		})
+	}
+}
+
+func TestDownloadModified(t *testing.T) {
+	tests := []struct {
+		description string
+		drv         *fakeTransferBucket
+		wantReads   int
+	}{
+		{"size changed", &fakeTransferBucket{modified: true}, 1},
+		{"ETag changed", &fakeTransferBucket{etag: "etag", replaced: true}, 1},
+		{"size changed, ETag changed", &fakeTransferBucket{etag: "etag", modified: true}, 1},
+		// The conditional read is rejected, and the unconditional one finds
+		// that the size changed.
+		{"size changed, no conditional reads", &fakeTransferBucket{etag: "etag", modified: true, noConditions: true}, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			drv := test.drv
+			drv.data = []byte("hello world")
+			b := NewBucket(drv)
+			err := b.Download(context.Background(), "key", &writerAtBuffer{}, &DownloadOptions{PartSize: 4, Concurrency: 1})
+			if gcerrors.Code(err) != gcerrors.FailedPrecondition {
+				t.Errorf("got %v want FailedPrecondition error", err)
+			}
+			// Modification errors are not retried.
+			if drv.reads != test.wantReads {
+				t.Errorf("got %d reads want %d", drv.reads, test.wantReads)
+			}
+		})
+	}
+}
+
+func TestDownloadNoConditions(t *testing.T) {
+	data := []byte("0123456789abcdefghij")
+	drv := &fakeTransferBucket{data: data, etag: "etag", noConditions: true}
+	b := NewBucket(drv)
+	var w writerAtBuffer
+	if err := b.Download(context.Background(), "key", &w, &DownloadOptions{PartSize: 5, Concurrency: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(w.buf, data) {
+		t.Errorf("got %q want %q", w.buf, data)
+	}
+	// Only the first read is tried with IfMatch.
+	if want := 5; drv.reads != want {
+		t.Errorf("got %d reads want %d", drv.reads, want)
+	}
+}
+
+func TestWithRetriesBackoff(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	calls := 0
+	start := time.Now()
+	err := withRetries(ctx, 100, func() error {
+		calls++
+		return errFake
+	})
+	if err != errFake {
+		t.Errorf("got error %v want %v", err, errFake)
+	}
+	// The retries wait between calls, but stop waiting when ctx is done.
+	if calls > 10 {
+		t.Errorf("got %d calls, want the retries to be spaced out", calls)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("withRetries took %v after ctx was done", d)
+	}
+}
+
+func TestUpload(t *testing.T) {
+	ctx := context.Background()
+	data := []byte("hello world")
+
+	tests := []struct {
+		description string
+		r           io.Reader
+		maxRetries  int
+		failWrites  int
+		wantErr     bool
+	}{
+		{"works", bytes.NewReader(data), 0, 0, false},
+		{"seeker is retried", bytes.NewReader(data), 0, 2, false},
+		{"non-seeker is not retried", ioutil.NopCloser(bytes.NewReader(data)), 0, 1, true},
+		{"non-seeker with MaxRetries fails", ioutil.NopCloser(bytes.NewReader(data)), 2, 0, true},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			drv := &fakeTransferBucket{failWrites: test.failWrites}
+			b := NewBucket(drv)
+			err := b.Upload(ctx, "key", test.r, &UploadOptions{PartSize: 4, MaxRetries: test.maxRetries, WriterOptions: &WriterOptions{ContentType: "text/plain"}})
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v want error %v", err, test.wantErr)
+			}
+			if test.maxRetries > 0 && gcerrors.Code(err) != gcerrors.InvalidArgument {
+				t.Errorf("got error %v, want InvalidArgument", err)
+			}
+			if err == nil {
+				if diff := cmp.Diff(drv.data, data); diff != "" {
+					t.Errorf("got %q want %q", drv.data, data)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blob
+
+import (
+	"context"
+	"sync"
+	"unicode/utf8"
+
+	"gocloud.dev/blob/driver"
+	"gocloud.dev/internal/gcerr"
+)
+
+// WatchEventType is the type of change reported by a WatchEvent.
+type WatchEventType int
+
+const (
+	// WatchCreated means that a blob was created.
+	WatchCreated = WatchEventType(driver.WatchCreated)
+	// WatchUpdated means that an existing blob was overwritten.
+	WatchUpdated = WatchEventType(driver.WatchUpdated)
+	// WatchDeleted means that a blob was deleted.
+	WatchDeleted = WatchEventType(driver.WatchDeleted)
+)
+
+// String returns a name for t, like "created".
+func (t WatchEventType) String() string {
+	switch t {
+	case WatchCreated:
+		return "created"
+	case WatchUpdated:
+		return "updated"
+	case WatchDeleted:
+		return "deleted"
+	}
+	return "unknown"
+}
+
+// WatchEvent describes a change to a blob, as returned by WatchIterator.Next.
+type WatchEvent struct {
+	// Key is the key of the blob that changed.
+	Key string
+	// Type is the type of the change.
+	Type WatchEventType
+}
+
+// WatchIterator reports changes to blobs. It is returned by Bucket.Watch.
+type WatchIterator struct {
+	b driver.Bucket
+	w driver.WatchIterator
+
+	mu     sync.Mutex
+	closed bool
+}
+
+var errWatchIteratorClosed = gcerr.Newf(gcerr.FailedPrecondition, nil, "blob: WatchIterator has been closed")
+
+// Next blocks until the next change is available and returns it.
+// If ctx is done, Next returns ctx.Err().
+// Other errors are generally not recoverable; the caller should Close the
+// WatchIterator and call Bucket.Watch again if needed.
+// Next should not be called concurrently from multiple goroutines.
+func (i *WatchIterator) Next(ctx context.Context) (*WatchEvent, error) {
+	i.mu.Lock()
+	closed := i.closed
+	i.mu.Unlock()
+	if closed {
+		return nil, errWatchIteratorClosed
+	}
+	e, err := i.w.Next(ctx)
+	if err != nil {
+		return nil, wrapError(i.b, err)
+	}
+	return &WatchEvent{Key: e.Key, Type: WatchEventType(e.Type)}, nil
+}
+
+// Close stops watching for changes. It must be called when done with the
+// WatchIterator.
+func (i *WatchIterator) Close() error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.closed {
+		return errWatchIteratorClosed
+	}
+	i.closed = true
+	return wrapError(i.b, i.w.Close())
+}
+
+var errWatchNotSupported = gcerr.Newf(gcerr.Unimplemented, nil, "blob: Watch is not supported by this provider")
+
+// Watch returns a WatchIterator that reports when blobs whose keys start with
+// prefix are created, updated or deleted, starting when Watch returns.
+// Changes made through other Buckets or by other processes are reported if
+// the provider is notified of them.
+//
+// Changes to different blobs may be reported out of order, and several
+// quick changes to the same blob may be reported as a single event.
+// If Next isn't called often enough to keep up with the changes, the
+// provider may stop reporting them, in which case Next returns an error for
+// which gcerrors.Code returns gcerrors.ResourceExhausted.
+//
+// If the provider implementation does not support watching for changes, Watch
+// returns an error for which gcerrors.Code returns gcerrors.Unimplemented.
+//
+// The caller must call Close on the returned WatchIterator when done.
+func (b *Bucket) Watch(ctx context.Context, prefix string) (_ *WatchIterator, err error) {
+	if !utf8.ValidString(prefix) {
+		return nil, gcerr.Newf(gcerr.InvalidArgument, nil, "blob: Watch prefix must be a valid UTF-8 string: %q", prefix)
+	}
+	w, ok := b.b.(driver.Watcher)
+	if !ok {
+		return nil, errWatchNotSupported
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return nil, errClosed
+	}
+	ctx = b.tracer.Start(ctx, "Watch")
+	defer func() { b.tracer.End(ctx, err) }()
+
+	dw, err := w.Watch(ctx, prefix)
+	if err != nil {
+		return nil, wrapError(b.b, err)
+	}
+	return &WatchIterator{b: b.b, w: dw}, nil
+}
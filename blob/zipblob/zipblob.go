@@ -0,0 +1,400 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package zipblob provides a read-only blob implementation backed by a zip
+// archive. Use OpenBucket or OpenFile to construct a *blob.Bucket.
+//
+// Each file in the archive is a blob whose key is the file's name;
+// directories are omitted. Attributes stored by blobarchive.ExportZip are
+// reported for the blobs; otherwise, the content type is guessed from the
+// file's extension. Writes, copies and deletes return an error for which
+// gcerrors.Code returns gcerrors.Unimplemented.
+//
+// URLs
+//
+// For blob.OpenBucket zipblob registers for the scheme "zip".
+// To customize the URL opener, or for more details on the URL format,
+// see URLOpener.
+// See https://godoc.org/gocloud.dev#hdr-URLs for background information.
+//
+// As
+//
+// zipblob exposes the following types for As:
+//  - Bucket: *zip.Reader
+//  - Attributes: *zip.FileHeader
+package zipblob // import "gocloud.dev/blob/zipblob"
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/blobarchive"
+	"gocloud.dev/blob/driver"
+	"gocloud.dev/gcerrors"
+)
+
+const defaultPageSize = 1000
+
+var (
+	errNotFound           = errors.New("blob not found")
+	errReadOnly           = errors.New("zip buckets are read-only")
+	errNotImplemented     = errors.New("not implemented")
+	errPreconditionFailed = errors.New("precondition failed")
+)
+
+func init() {
+	blob.DefaultURLMux().RegisterBucket(Scheme, &URLOpener{})
+}
+
+// Scheme is the URL scheme zipblob registers its URLOpener under on
+// blob.DefaultMux.
+const Scheme = "zip"
+
+// URLOpener opens zip bucket URLs like "zip:///path/to/archive.zip", using
+// OpenFile. The path is interpreted as for "file" URLs; see
+// gocloud.dev/blob/fileblob.
+//
+// No query parameters are supported.
+type URLOpener struct{}
+
+// OpenBucketURL opens a blob.Bucket based on u.
+func (*URLOpener) OpenBucketURL(ctx context.Context, u *url.URL) (*blob.Bucket, error) {
+	for param := range u.Query() {
+		return nil, fmt.Errorf("open bucket %v: invalid query parameter %q", u, param)
+	}
+	p := u.Path
+	if os.PathSeparator != '/' {
+		p = strings.TrimPrefix(p, "/")
+	}
+	return OpenFile(filepath.FromSlash(p), nil)
+}
+
+// Options sets options for constructing a *blob.Bucket backed by a zip
+// archive.
+type Options struct{}
+
+// entry is a blob in the archive.
+type entry struct {
+	f     *zip.File
+	attrs driver.Attributes
+}
+
+type bucket struct {
+	zr      *zip.Reader
+	r       io.ReaderAt
+	entries map[string]*entry
+	keys    []string // sorted
+	closer  io.Closer
+}
+
+// openBucket creates a driver.Bucket that reads the zip archive held in the
+// size bytes of r. closer, if not nil, is closed with the bucket.
+func openBucket(r io.ReaderAt, size int64, closer io.Closer) (driver.Bucket, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	b := &bucket{zr: zr, r: r, entries: map[string]*entry{}, closer: closer}
+	for _, f := range zr.File {
+		if strings.HasSuffix(f.Name, "/") {
+			continue
+		}
+		opts, err := blobarchive.ZipWriterOptions(&f.FileHeader)
+		if err != nil {
+			return nil, err
+		}
+		ct := opts.ContentType
+		if ct == "" {
+			if ct = mime.TypeByExtension(path.Ext(f.Name)); ct == "" {
+				ct = "application/octet-stream"
+			}
+		}
+		if _, ok := b.entries[f.Name]; !ok {
+			b.keys = append(b.keys, f.Name)
+		}
+		// As when extracting the archive, later files replace earlier ones
+		// with the same name.
+		b.entries[f.Name] = &entry{
+			f: f,
+			attrs: driver.Attributes{
+				CacheControl:       opts.CacheControl,
+				ContentDisposition: opts.ContentDisposition,
+				ContentEncoding:    opts.ContentEncoding,
+				ContentLanguage:    opts.ContentLanguage,
+				ContentType:        ct,
+				Metadata:           opts.Metadata,
+				ModTime:            f.Modified,
+				Size:               int64(f.UncompressedSize64),
+				MD5:                opts.ContentMD5,
+				CRC32C:             opts.ContentCRC32C,
+				SHA256:             opts.ContentSHA256,
+				ETag:               fmt.Sprintf("%08x", f.CRC32),
+			},
+		}
+	}
+	sort.Strings(b.keys)
+	return b, nil
+}
+
+// OpenBucket creates a *blob.Bucket that reads the zip archive held in the
+// size bytes of r. A *blob.Reader can be passed as r to read an archive
+// stored in another bucket.
+func OpenBucket(r io.ReaderAt, size int64, opts *Options) (*blob.Bucket, error) {
+	drv, err := openBucket(r, size, nil)
+	if err != nil {
+		return nil, err
+	}
+	return blob.NewBucket(drv), nil
+}
+
+// OpenFile creates a *blob.Bucket that reads the zip archive stored in the
+// file at path. The file is closed when the bucket is closed.
+func OpenFile(path string, opts *Options) (*blob.Bucket, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	drv, err := openBucket(f, info.Size(), f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return blob.NewBucket(drv), nil
+}
+
+// Close implements driver.Close.
+func (b *bucket) Close() error {
+	if b.closer == nil {
+		return nil
+	}
+	return b.closer.Close()
+}
+
+// ErrorCode implements driver.ErrorCode.
+func (b *bucket) ErrorCode(err error) gcerrors.ErrorCode {
+	switch err {
+	case errNotFound:
+		return gcerrors.NotFound
+	case errReadOnly, errNotImplemented:
+		return gcerrors.Unimplemented
+	case errPreconditionFailed:
+		return gcerrors.FailedPrecondition
+	case zip.ErrChecksum:
+		return gcerrors.DataLoss
+	default:
+		return gcerrors.Unknown
+	}
+}
+
+// As implements driver.As.
+func (b *bucket) As(i interface{}) bool {
+	p, ok := i.(**zip.Reader)
+	if !ok {
+		return false
+	}
+	*p = b.zr
+	return true
+}
+
+// ErrorAs implements driver.ErrorAs.
+func (b *bucket) ErrorAs(err error, i interface{}) bool { return false }
+
+// ListPaged implements driver.ListPaged.
+func (b *bucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driver.ListPage, error) {
+	// pageToken is a returned NextPageToken, set below; it's the last key of the
+	// previous page.
+	pageToken := string(opts.PageToken)
+	pageSize := opts.PageSize
+	if pageSize == 0 {
+		pageSize = defaultPageSize
+	}
+
+	// If opts.Delimiter != "", lastPrefix contains the last "directory" key we
+	// added. It is used to avoid adding it again; all files in this "directory"
+	// are collapsed to the single directory entry.
+	var lastPrefix string
+	var result driver.ListPage
+	for _, key := range b.keys[sort.SearchStrings(b.keys, opts.Prefix):] {
+		if !strings.HasPrefix(key, opts.Prefix) {
+			break
+		}
+		attrs := &b.entries[key].attrs
+		obj := &driver.ListObject{
+			Key:     key,
+			ModTime: attrs.ModTime,
+			Size:    attrs.Size,
+			MD5:     attrs.MD5,
+			CRC32C:  attrs.CRC32C,
+			SHA256:  attrs.SHA256,
+			ETag:    attrs.ETag,
+		}
+		// If using Delimiter, collapse "directories".
+		if opts.Delimiter != "" {
+			keyWithoutPrefix := key[len(opts.Prefix):]
+			if idx := strings.Index(keyWithoutPrefix, opts.Delimiter); idx != -1 {
+				prefix := opts.Prefix + keyWithoutPrefix[0:idx+len(opts.Delimiter)]
+				if prefix == lastPrefix {
+					continue
+				}
+				obj = &driver.ListObject{
+					Key:   prefix,
+					IsDir: true,
+				}
+				lastPrefix = prefix
+			}
+		}
+		// If there's a pageToken, skip anything before it.
+		if pageToken != "" && obj.Key <= pageToken {
+			continue
+		}
+		// If we've already got a full page of results, set NextPageToken and return.
+		if len(result.Objects) == pageSize {
+			result.NextPageToken = []byte(result.Objects[pageSize-1].Key)
+			return &result, nil
+		}
+		result.Objects = append(result.Objects, obj)
+	}
+	return &result, nil
+}
+
+// Attributes implements driver.Attributes.
+func (b *bucket) Attributes(ctx context.Context, key string) (*driver.Attributes, error) {
+	e := b.entries[key]
+	if e == nil {
+		return nil, errNotFound
+	}
+	attrs := e.attrs
+	attrs.AsFunc = func(i interface{}) bool {
+		p, ok := i.(**zip.FileHeader)
+		if !ok {
+			return false
+		}
+		*p = &e.f.FileHeader
+		return true
+	}
+	return &attrs, nil
+}
+
+// NewRangeReader implements driver.NewRangeReader.
+func (b *bucket) NewRangeReader(ctx context.Context, key string, offset, length int64, opts *driver.ReaderOptions) (driver.Reader, error) {
+	e := b.entries[key]
+	if e == nil {
+		return nil, errNotFound
+	}
+	if opts.IfMatch != "" && opts.IfMatch != "*" && opts.IfMatch != e.attrs.ETag {
+		return nil, errPreconditionFailed
+	}
+	if opts.IfNoneMatch != "" && (opts.IfNoneMatch == "*" || opts.IfNoneMatch == e.attrs.ETag) {
+		return nil, errPreconditionFailed
+	}
+	size := e.attrs.Size
+	if offset > size {
+		offset = size
+	}
+	if length < 0 || offset+length > size {
+		length = size - offset
+	}
+	r := &reader{
+		attrs: driver.ReaderAttributes{
+			ContentType:     e.attrs.ContentType,
+			ModTime:         e.attrs.ModTime,
+			Size:            size,
+			ContentEncoding: e.attrs.ContentEncoding,
+			MD5:             e.attrs.MD5,
+			CRC32C:          e.attrs.CRC32C,
+			SHA256:          e.attrs.SHA256,
+			ETag:            e.attrs.ETag,
+		},
+	}
+	if e.f.Method == zip.Store {
+		// Stored content can be read directly from the archive.
+		start, err := e.f.DataOffset()
+		if err != nil {
+			return nil, err
+		}
+		r.r = io.NewSectionReader(b.r, start+offset, length)
+		return r, nil
+	}
+	rc, err := e.f.Open()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(ioutil.Discard, rc, offset); err != nil {
+		rc.Close()
+		return nil, err
+	}
+	r.r = io.LimitReader(rc, length)
+	r.c = rc
+	return r, nil
+}
+
+type reader struct {
+	r     io.Reader
+	c     io.Closer // if not nil, closed with the reader
+	attrs driver.ReaderAttributes
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	return r.r.Read(p)
+}
+
+func (r *reader) Close() error {
+	if r.c == nil {
+		return nil
+	}
+	return r.c.Close()
+}
+
+func (r *reader) Attributes() *driver.ReaderAttributes {
+	return &r.attrs
+}
+
+func (r *reader) As(i interface{}) bool { return false }
+
+// NewTypedWriter implements driver.NewTypedWriter. It is not supported.
+func (b *bucket) NewTypedWriter(ctx context.Context, key string, contentType string, opts *driver.WriterOptions) (driver.Writer, error) {
+	return nil, errReadOnly
+}
+
+// Copy implements driver.Copy. It is not supported.
+func (b *bucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.CopyOptions) error {
+	return errReadOnly
+}
+
+// Delete implements driver.Delete. It is not supported.
+func (b *bucket) Delete(ctx context.Context, key string, opts *driver.DeleteOptions) error {
+	return errReadOnly
+}
+
+// SignedURL implements driver.SignedURL. It is not supported.
+func (b *bucket) SignedURL(ctx context.Context, key string, opts *driver.SignedURLOptions) (string, error) {
+	return "", errNotImplemented
+}
@@ -0,0 +1,242 @@
+// Copyright 2019 The Go Cloud Development Kit Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zipblob
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/blobarchive"
+	"gocloud.dev/blob/memblob"
+	"gocloud.dev/gcerrors"
+)
+
+// testContent returns n bytes of content that doesn't repeat.
+func testContent(n int) []byte {
+	p := make([]byte, n)
+	for i := range p {
+		p[i] = byte(i % 251)
+	}
+	return p
+}
+
+// newTestArchive returns a zip archive exported from a bucket, followed by
+// a file added by other tools.
+func newTestArchive(t *testing.T) []byte {
+	ctx := context.Background()
+	b := memblob.OpenBucket(nil)
+	defer b.Close()
+	for key, opts := range map[string]*blob.WriterOptions{
+		"a.txt":     {ContentType: "text/plain", Metadata: map[string]string{"k": "v"}},
+		"dir/b.bin": nil,
+		"dir/c.gz":  {Compression: "gzip"},
+	} {
+		if err := b.WriteAll(ctx, key, testContent(100000), opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := blobarchive.ExportZip(ctx, &buf, b, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// Append a file, rewriting the archive.
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	zw := zip.NewWriter(&out)
+	for _, f := range zr.File {
+		fw, err := zw.CreateHeader(&f.FileHeader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = io.Copy(fw, rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := zw.Create("dir/sub/"); err != nil {
+		t.Fatal(err)
+	}
+	fw, err := zw.Create("dir/sub/d.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fw.Write([]byte("<p>hi</p>")); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func TestZipBucket(t *testing.T) {
+	ctx := context.Background()
+	archive := newTestArchive(t)
+	b, err := OpenBucket(bytes.NewReader(archive), int64(len(archive)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	list := func(opts *blob.ListOptions) []string {
+		t.Helper()
+		var keys []string
+		iter := b.List(opts)
+		for {
+			obj, err := iter.Next(ctx)
+			if err == io.EOF {
+				return keys
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			keys = append(keys, obj.Key)
+		}
+	}
+	if diff := cmp.Diff(list(nil), []string{"a.txt", "dir/b.bin", "dir/c.gz", "dir/sub/d.html"}); diff != "" {
+		t.Errorf("got keys diff (-got +want):\n%s", diff)
+	}
+	if diff := cmp.Diff(list(&blob.ListOptions{Prefix: "dir/", Delimiter: "/"}), []string{"dir/b.bin", "dir/c.gz", "dir/sub/"}); diff != "" {
+		t.Errorf("got keys with delimiter diff (-got +want):\n%s", diff)
+	}
+	page, _, err := b.ListPage(ctx, blob.FirstPageToken, 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 2 {
+		t.Errorf("got page of %d objects, want 2", len(page))
+	}
+
+	content := testContent(100000)
+	for _, key := range []string{"a.txt", "dir/b.bin"} {
+		got, err := b.ReadAll(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Errorf("%s: got %d bytes, want %d", key, len(got), len(content))
+		}
+		r, err := b.NewRangeReader(ctx, key, 5000, 10, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err = ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, content[5000:5010]) {
+			t.Errorf("%s: got range %v want %v", key, got, content[5000:5010])
+		}
+	}
+	// The compressed blob was stored, and can still be decompressed.
+	r, err := b.NewReader(ctx, "dir/c.gz", &blob.ReaderOptions{Decompress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("dir/c.gz: got %d bytes, want %d", len(got), len(content))
+	}
+
+	attrs, err := b.Attributes(ctx, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs.ContentType != "text/plain" || attrs.Metadata["k"] != "v" || attrs.Size != int64(len(content)) || len(attrs.MD5) == 0 {
+		t.Errorf("got attributes %+v", attrs)
+	}
+	var fh *zip.FileHeader
+	if !attrs.As(&fh) || fh.Name != "a.txt" {
+		t.Errorf("Attributes.As failed")
+	}
+	attrs, err = b.Attributes(ctx, "dir/sub/d.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "text/html; charset=utf-8"; attrs.ContentType != want {
+		t.Errorf("got ContentType %q want %q", attrs.ContentType, want)
+	}
+
+	if _, err := b.NewReader(ctx, "a.txt", &blob.ReaderOptions{IfNoneMatch: attrs.ETag}); err != nil {
+		t.Errorf("IfNoneMatch with another blob's ETag: %v", err)
+	}
+	if _, err := b.Attributes(ctx, "missing"); gcerrors.Code(err) != gcerrors.NotFound {
+		t.Errorf("got error %v want NotFound", err)
+	}
+	if err := b.WriteAll(ctx, "new", []byte("x"), nil); gcerrors.Code(err) != gcerrors.Unimplemented {
+		t.Errorf("WriteAll: got error %v want Unimplemented", err)
+	}
+	if err := b.Delete(ctx, "a.txt"); gcerrors.Code(err) != gcerrors.Unimplemented {
+		t.Errorf("Delete: got error %v want Unimplemented", err)
+	}
+}
+
+func TestOpenBucketFromURL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-cloud-zipblob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "archive.zip")
+	if err := ioutil.WriteFile(path, newTestArchive(t), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	for _, test := range []struct {
+		url     string
+		wantErr bool
+	}{
+		{"zip://" + filepath.ToSlash(path), false},
+		{"zip://" + filepath.ToSlash(path) + "?param=value", true},
+		{"zip://" + filepath.ToSlash(filepath.Join(dir, "missing.zip")), true},
+	} {
+		b, err := blob.OpenBucket(ctx, test.url)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: got error %v, want error %v", test.url, err, test.wantErr)
+		}
+		if err != nil {
+			continue
+		}
+		if _, err := b.ReadAll(ctx, "a.txt"); err != nil {
+			t.Errorf("%s: %v", test.url, err)
+		}
+		if err := b.Close(); err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -66,6 +66,9 @@ const (
 
 	// The operation timed out.
 	DeadlineExceeded ErrorCode = gcerr.DeadlineExceeded
+
+	// Data was lost or corrupted.
+	DataLoss ErrorCode = gcerr.DataLoss
 )
 
 // Code returns the ErrorCode of err if it, or some error it wraps, is an *Error.
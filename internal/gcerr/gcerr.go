@@ -69,6 +69,9 @@ const (
 
 	// The operation timed out.
 	DeadlineExceeded ErrorCode = 11
+
+	// Data was lost or corrupted.
+	DataLoss ErrorCode = 12
 )
 
 // When adding a new error code, try to use the names defined in google.golang.org/grpc/codes.
@@ -176,6 +179,8 @@ func GRPCCode(err error) ErrorCode {
 		return Canceled
 	case codes.DeadlineExceeded:
 		return DeadlineExceeded
+	case codes.DataLoss:
+		return DataLoss
 	default:
 		return Unknown
 	}
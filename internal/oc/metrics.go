@@ -48,6 +48,18 @@ func mustKey(name string) tag.Key {
 	return k
 }
 
+// CountView returns a view named pkg+"/"+name that sums m by provider.
+// Measurements must be recorded with a ProviderKey tag.
+func CountView(pkg, name, description string, m *stats.Int64Measure) *view.View {
+	return &view.View{
+		Name:        pkg + "/" + name,
+		Measure:     m,
+		Description: description,
+		TagKeys:     []tag.Key{ProviderKey},
+		Aggregation: view.Sum(),
+	}
+}
+
 // Views returns the views supported by Go CDK APIs.
 func Views(pkg string, latencyMeasure *stats.Float64Measure) []*view.View {
 	return []*view.View{